@@ -0,0 +1,388 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lukaszbudnik/migrator/common"
+	"github.com/lukaszbudnik/migrator/config"
+	"github.com/lukaszbudnik/migrator/coordinator"
+	"github.com/lukaszbudnik/migrator/metrics"
+	"github.com/lukaszbudnik/migrator/types"
+)
+
+const (
+	// DefaultConfigFile defines default file name of migrator configuration file
+	DefaultConfigFile = "migrator.yaml"
+
+	outputText = "text"
+	outputJSON = "json"
+
+	// exit codes returned by Run
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+// command is a migrator CLI subcommand
+type command struct {
+	description string
+	run         func(*commandContext) int
+}
+
+// commandContext holds parsed flags and dependencies of a running command
+type commandContext struct {
+	ctx         context.Context
+	stdout      io.Writer
+	stderr      io.Writer
+	coordinator coordinator.Coordinator
+	output      string
+	versionName string
+	tenant      string
+	metadata    types.TenantMetadata
+	selector    *types.TenantSelector
+	action      types.Action
+	dryRun      bool
+}
+
+var commands = map[string]command{
+	"apply":         {"creates new version and applies all pending source migrations", runApply},
+	"dry-run":       {"same as apply but the DB transaction is rolled back", runDryRun},
+	"create-tenant": {"creates new tenant and applies all tenant migrations", runCreateTenant},
+	"status":        {"prints health checks, tenants and the latest DB version", runStatus},
+	"verify":        {"verifies checksums of source migrations against applied DB migrations", runVerify},
+}
+
+// IsCommand returns true if passed name is a migrator CLI subcommand
+func IsCommand(name string) bool {
+	_, ok := commands[name]
+	return ok
+}
+
+// Usage returns description of all migrator CLI subcommands
+func Usage() string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	sb.WriteString("Commands:\n")
+	for _, name := range names {
+		sb.WriteString(fmt.Sprintf("  %-14s %v\n", name, commands[name].description))
+	}
+	return sb.String()
+}
+
+// Run runs migrator CLI subcommand, args[0] is the subcommand name and the rest are its flags
+// results are written to stdout, errors to stderr, the returned value is the process exit code
+func Run(ctx context.Context, args []string, stdout, stderr io.Writer, newCoordinator coordinator.Factory) int {
+	if len(args) == 0 || !IsCommand(args[0]) {
+		fmt.Fprint(stderr, Usage())
+		return exitUsage
+	}
+	name := args[0]
+	cmd := commands[name]
+
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	buf := new(bytes.Buffer)
+	flags.SetOutput(buf)
+
+	var (
+		configFile string
+		action     string
+	)
+	cc := &commandContext{stdout: stdout, stderr: stderr}
+
+	flags.StringVar(&configFile, "configFile", DefaultConfigFile, "path to migrator configuration yaml file")
+	flags.StringVar(&cc.output, "output", outputText, "output format: text or json")
+	switch name {
+	case "apply", "dry-run", "create-tenant":
+		flags.StringVar(&cc.versionName, "version-name", "", "name of the version to be created (required)")
+		flags.StringVar(&action, "action", types.ActionApply.String(), "Apply to apply migrations or Sync to only record them in DB")
+		if name != "dry-run" {
+			flags.BoolVar(&cc.dryRun, "dry-run", false, "roll back the DB transaction instead of committing it")
+		}
+		if name == "create-tenant" {
+			flags.StringVar(&cc.tenant, "tenant", "", "name of the tenant to be created (required)")
+			flags.Func("metadata", "tenant metadata as key=value, can be repeated", cc.addMetadata)
+		} else {
+			flags.Func("tenants", "comma separated names of tenants to which tenant migrations are applied", cc.selectTenantNames)
+			flags.Func("tenant-glob", "glob pattern matched against names of tenants to which tenant migrations are applied", cc.selectTenantGlob)
+			flags.Func("tenant-regex", "regular expression matched against names of tenants to which tenant migrations are applied", cc.selectTenantRegex)
+			flags.Func("tenant-metadata", "tenant metadata as key=value of tenants to which tenant migrations are applied, can be repeated", cc.selectTenantMetadata)
+		}
+	}
+
+	if err := flags.Parse(args[1:]); err != nil {
+		fmt.Fprint(stderr, buf.String())
+		return exitUsage
+	}
+
+	if cc.output != outputText && cc.output != outputJSON {
+		fmt.Fprintf(stderr, "Unknown output format: %v\n", cc.output)
+		return exitUsage
+	}
+	if flags.Lookup("action") != nil {
+		if err := cc.action.UnmarshalGraphQL(action); err != nil {
+			fmt.Fprintf(stderr, "Unknown action: %v\n", action)
+			return exitUsage
+		}
+	}
+	if flags.Lookup("version-name") != nil && cc.versionName == "" {
+		fmt.Fprintf(stderr, "Flag -version-name is required by %v command\n", name)
+		return exitUsage
+	}
+	if flags.Lookup("tenant") != nil && cc.tenant == "" {
+		fmt.Fprintf(stderr, "Flag -tenant is required by %v command\n", name)
+		return exitUsage
+	}
+
+	cfg, err := config.FromFile(configFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error reading config file: %v\n", err)
+		return exitFailure
+	}
+
+	cc.ctx = context.WithValue(ctx, common.LogLevelKey{}, cfg.LogLevel)
+	cc.ctx = context.WithValue(cc.ctx, common.RequestIDKey{}, fmt.Sprintf("cli-%d", time.Now().UnixNano()))
+
+	cc.coordinator = newCoordinator(cc.ctx, cfg, metrics.NewNoop())
+	defer cc.coordinator.Dispose()
+
+	return cmd.run(cc)
+}
+
+// addMetadata parses key=value value of -metadata flag
+func (cc *commandContext) addMetadata(value string) error {
+	pair := strings.SplitN(value, "=", 2)
+	if len(pair) != 2 || pair[0] == "" {
+		return fmt.Errorf("expected key=value, got %v", value)
+	}
+	if cc.metadata == nil {
+		cc.metadata = types.TenantMetadata{}
+	}
+	cc.metadata[pair[0]] = pair[1]
+	return nil
+}
+
+// tenantSelector returns tenant selector of the command, it is created when the first tenant selector flag is parsed
+func (cc *commandContext) tenantSelector() *types.TenantSelector {
+	if cc.selector == nil {
+		cc.selector = &types.TenantSelector{}
+	}
+	return cc.selector
+}
+
+// selectTenantNames parses value of -tenants flag
+func (cc *commandContext) selectTenantNames(value string) error {
+	names := []string{}
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	cc.tenantSelector().Names = &names
+	return nil
+}
+
+// selectTenantGlob parses value of -tenant-glob flag
+func (cc *commandContext) selectTenantGlob(value string) error {
+	cc.tenantSelector().Glob = &value
+	return nil
+}
+
+// selectTenantRegex parses value of -tenant-regex flag
+func (cc *commandContext) selectTenantRegex(value string) error {
+	cc.tenantSelector().Regex = &value
+	return nil
+}
+
+// selectTenantMetadata parses key=value value of -tenant-metadata flag
+func (cc *commandContext) selectTenantMetadata(value string) error {
+	pair := strings.SplitN(value, "=", 2)
+	if len(pair) != 2 || pair[0] == "" {
+		return fmt.Errorf("expected key=value, got %v", value)
+	}
+	selector := cc.tenantSelector()
+	if selector.Metadata == nil {
+		selector.Metadata = &types.TenantMetadata{}
+	}
+	(*selector.Metadata)[pair[0]] = pair[1]
+	return nil
+}
+
+func runApply(cc *commandContext) int {
+	results, err := cc.coordinator.CreateVersion(cc.versionName, cc.action, cc.dryRun, cc.selector)
+	if err != nil {
+		return cc.printError(err)
+	}
+	return cc.printResults(results)
+}
+
+func runDryRun(cc *commandContext) int {
+	cc.dryRun = true
+	return runApply(cc)
+}
+
+func runCreateTenant(cc *commandContext) int {
+	results, err := cc.coordinator.CreateTenant(cc.versionName, cc.action, cc.dryRun, types.Tenant{Name: cc.tenant, Metadata: cc.metadata})
+	if err != nil {
+		return cc.printError(err)
+	}
+	return cc.printResults(results)
+}
+
+func runStatus(cc *commandContext) int {
+	health := cc.coordinator.HealthCheck()
+
+	status := struct {
+		Health        types.HealthResponse `json:"health"`
+		Tenants       []types.Tenant       `json:"tenants"`
+		LatestVersion *types.Version       `json:"latestVersion,omitempty"`
+	}{Health: health}
+
+	// DB and source migrations cannot be queried when migrator is down
+	if health.Status == types.HealthStatusUp {
+		tenants, err := cc.coordinator.GetTenants()
+		if err != nil {
+			return cc.printError(err)
+		}
+		status.Tenants = tenants
+
+		versions, err := cc.coordinator.GetVersions()
+		if err != nil {
+			return cc.printError(err)
+		}
+		// versions are sorted by ID in descending order
+		if len(versions) > 0 {
+			status.LatestVersion = &versions[0]
+			status.LatestVersion.DBMigrations = nil
+		}
+	}
+
+	if cc.output == outputJSON {
+		cc.printJSON(status)
+	} else {
+		fmt.Fprintf(cc.stdout, "Status: %v\n", health.Status)
+		for _, check := range health.Checks {
+			if check.Data != nil {
+				fmt.Fprintf(cc.stdout, "  %v: %v (%v)\n", check.Name, check.Status, check.Data.Details)
+			} else {
+				fmt.Fprintf(cc.stdout, "  %v: %v\n", check.Name, check.Status)
+			}
+		}
+		if health.Status == types.HealthStatusUp {
+			fmt.Fprintf(cc.stdout, "Tenants: %v\n", len(status.Tenants))
+			if status.LatestVersion != nil {
+				fmt.Fprintf(cc.stdout, "Latest version: %v (ID: %v, created: %v)\n", status.LatestVersion.Name, status.LatestVersion.ID, status.LatestVersion.Created.Format(time.RFC3339))
+			} else {
+				fmt.Fprintln(cc.stdout, "Latest version: none")
+			}
+		}
+	}
+
+	if health.Status != types.HealthStatusUp {
+		return exitFailure
+	}
+	return exitOK
+}
+
+func runVerify(cc *commandContext) int {
+	verified, offendingMigrations, err := cc.coordinator.VerifySourceMigrationsCheckSums()
+	if err != nil {
+		return cc.printError(err)
+	}
+
+	if cc.output == outputJSON {
+		cc.printJSON(struct {
+			Verified            bool              `json:"verified"`
+			OffendingMigrations []types.Migration `json:"offendingMigrations"`
+		}{verified, offendingMigrations})
+	} else if verified {
+		fmt.Fprintln(cc.stdout, "Source migrations verified, checksums match applied DB migrations")
+	} else {
+		fmt.Fprintln(cc.stdout, "Source migrations modified after they were applied:")
+		for _, m := range offendingMigrations {
+			fmt.Fprintf(cc.stdout, "  %v (checksum: %v)\n", m.File, m.CheckSum)
+		}
+	}
+
+	if !verified {
+		return exitFailure
+	}
+	return exitOK
+}
+
+// printResults prints results and returns exit code, failure is returned when migrating any tenant failed
+func (cc *commandContext) printResults(results *types.CreateResults) int {
+	exitCode := exitOK
+	for _, tenantResult := range results.Summary.TenantResults {
+		if tenantResult.Outcome != types.TenantOutcomeSucceeded {
+			exitCode = exitFailure
+		}
+	}
+
+	if cc.output == outputJSON {
+		cc.printJSON(results)
+		return exitCode
+	}
+
+	summary := results.Summary
+	if results.Version != nil {
+		fmt.Fprintf(cc.stdout, "Version: %v (ID: %v)\n", results.Version.Name, results.Version.ID)
+	} else {
+		fmt.Fprintln(cc.stdout, "Version: none, no migrations to apply")
+	}
+	if cc.dryRun {
+		fmt.Fprintln(cc.stdout, "Dry run: true")
+	}
+	fmt.Fprintf(cc.stdout, "Started at: %v\n", summary.StartedAt.Format(time.RFC3339))
+	fmt.Fprintf(cc.stdout, "Duration: %.3fs\n", summary.Duration)
+	fmt.Fprintf(cc.stdout, "Tenants: %v\n", summary.Tenants)
+	fmt.Fprintf(cc.stdout, "Single migrations: %v\n", summary.SingleMigrations)
+	fmt.Fprintf(cc.stdout, "Tenant migrations: %v (total: %v)\n", summary.TenantMigrations, summary.TenantMigrationsTotal)
+	fmt.Fprintf(cc.stdout, "Single scripts: %v\n", summary.SingleScripts)
+	fmt.Fprintf(cc.stdout, "Tenant scripts: %v (total: %v)\n", summary.TenantScripts, summary.TenantScriptsTotal)
+	fmt.Fprintf(cc.stdout, "Migrations grand total: %v\n", summary.MigrationsGrandTotal)
+	fmt.Fprintf(cc.stdout, "Scripts grand total: %v\n", summary.ScriptsGrandTotal)
+	for _, tenantResult := range summary.TenantResults {
+		if tenantResult.Error != nil {
+			fmt.Fprintf(cc.stdout, "Tenant %v: %v (%v)\n", tenantResult.Name, tenantResult.Outcome, *tenantResult.Error)
+		} else if tenantResult.Outcome != types.TenantOutcomeSucceeded {
+			fmt.Fprintf(cc.stdout, "Tenant %v: %v\n", tenantResult.Name, tenantResult.Outcome)
+		}
+	}
+	return exitCode
+}
+
+// printError prints error and returns failure exit code
+// in JSON mode the error is printed to stdout using the same format as GraphQL errors
+func (cc *commandContext) printError(err error) int {
+	if cc.output == outputJSON {
+		errorMsg := map[string]interface{}{"message": err.Error()}
+		if e, ok := err.(interface{ Extensions() map[string]interface{} }); ok {
+			errorMsg["extensions"] = e.Extensions()
+		}
+		cc.printJSON(map[string]interface{}{"errors": []interface{}{errorMsg}})
+	} else {
+		fmt.Fprintf(cc.stderr, "Error: %v\n", err)
+	}
+	return exitFailure
+}
+
+func (cc *commandContext) printJSON(v interface{}) {
+	encoder := json.NewEncoder(cc.stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		fmt.Fprintf(cc.stderr, "Error encoding JSON output: %v\n", err)
+	}
+}
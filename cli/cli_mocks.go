@@ -0,0 +1,134 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/graph-gophers/graphql-go"
+
+	"github.com/lukaszbudnik/migrator/config"
+	"github.com/lukaszbudnik/migrator/coordinator"
+	"github.com/lukaszbudnik/migrator/metrics"
+	"github.com/lukaszbudnik/migrator/types"
+)
+
+type mockedCoordinator struct {
+	fail            bool
+	down            bool
+	checkSumsBroken bool
+	tenantFailed    bool
+	disposed        bool
+	dryRun          bool
+	action          types.Action
+	versionName     string
+	tenant          types.Tenant
+	selector        *types.TenantSelector
+}
+
+// newMockedCoordinatorFactory returns coordinator factory which always returns passed mocked coordinator
+// tests use the returned mocked coordinator to assert arguments passed by CLI commands
+func newMockedCoordinatorFactory(m *mockedCoordinator) coordinator.Factory {
+	return func(ctx context.Context, config *config.Config, metrics metrics.Metrics) coordinator.Coordinator {
+		return m
+	}
+}
+
+func (m *mockedCoordinator) Dispose() {
+	m.disposed = true
+}
+
+func (m *mockedCoordinator) CreateTenant(versionName string, action types.Action, dryRun bool, tenant types.Tenant) (*types.CreateResults, error) {
+	m.versionName, m.action, m.dryRun, m.tenant = versionName, action, dryRun, tenant
+	if m.fail {
+		return nil, &types.MigrationError{File: "tenants/202002180000.sql", Schema: tenant.Name, DBErrorCode: "42P01", Err: errors.New("relation \"abc\" does not exist")}
+	}
+	return m.createResults(versionName, 1), nil
+}
+
+func (m *mockedCoordinator) CreateVersion(versionName string, action types.Action, dryRun bool, selector *types.TenantSelector) (*types.CreateResults, error) {
+	m.versionName, m.action, m.dryRun, m.selector = versionName, action, dryRun, selector
+	if m.fail {
+		return nil, &types.LockTimeoutError{Timeout: 30 * time.Second}
+	}
+	results := m.createResults(versionName, 3)
+	if m.tenantFailed {
+		message := "SQL migration tenants/202002180000.sql failed for schema def with error: trouble maker"
+		results.Summary.TenantResults = []types.TenantResult{{Name: "abc", Outcome: types.TenantOutcomeSucceeded}, {Name: "def", Outcome: types.TenantOutcomeFailed, Error: &message}, {Name: "ghi", Outcome: types.TenantOutcomeSkipped}}
+	}
+	return results, nil
+}
+
+func (m *mockedCoordinator) createResults(versionName string, tenants int32) *types.CreateResults {
+	started := graphql.Time{Time: time.Date(2016, 02, 22, 16, 41, 1, 0, time.UTC)}
+	summary := &types.Summary{StartedAt: started, Duration: 0.5, Tenants: tenants, SingleMigrations: 1, TenantMigrations: 2, TenantMigrationsTotal: 2 * tenants, MigrationsGrandTotal: 1 + 2*tenants}
+	return &types.CreateResults{Summary: summary, Version: &types.Version{ID: 12, Name: versionName, Created: started}}
+}
+
+func (m *mockedCoordinator) RollbackVersion(int32, string, bool) (*types.CreateResults, error) {
+	return nil, nil
+}
+
+func (m *mockedCoordinator) Plan(*types.TenantSelector) (*types.Plan, error) {
+	return nil, nil
+}
+
+func (m *mockedCoordinator) GetTenantStatus(string) (*types.TenantStatus, error) {
+	return nil, nil
+}
+
+func (m *mockedCoordinator) GetSourceMigrations(_ *coordinator.SourceMigrationFilters) ([]types.Migration, error) {
+	return []types.Migration{}, nil
+}
+
+func (m *mockedCoordinator) GetSourceMigrationByFile(file string) (*types.Migration, error) {
+	return nil, nil
+}
+
+func (m *mockedCoordinator) RefreshSourceMigrations() ([]types.Migration, error) {
+	return []types.Migration{}, nil
+}
+
+func (m *mockedCoordinator) GetDBMigrationByID(ID int32) (*types.DBMigration, error) {
+	return nil, nil
+}
+
+func (m *mockedCoordinator) GetTenants() ([]types.Tenant, error) {
+	if m.fail {
+		return nil, &types.DBError{Message: "failed to query tenants", DBErrorCode: "42P01", Err: errors.New("relation \"migrator.migrator_tenants\" does not exist")}
+	}
+	return []types.Tenant{{Name: "a"}, {Name: "b"}, {Name: "c"}}, nil
+}
+
+func (m *mockedCoordinator) GetVersions() ([]types.Version, error) {
+	created := graphql.Time{Time: time.Date(2016, 02, 22, 16, 41, 1, 0, time.UTC)}
+	return []types.Version{{ID: 2, Name: "v2", Created: created}, {ID: 1, Name: "v1", Created: created}}, nil
+}
+
+func (m *mockedCoordinator) GetVersionsByFile(file string) ([]types.Version, error) {
+	return []types.Version{}, nil
+}
+
+func (m *mockedCoordinator) GetVersionByID(ID int32) (*types.Version, error) {
+	return nil, nil
+}
+
+func (m *mockedCoordinator) VerifySourceMigrationsCheckSums() (bool, []types.Migration, error) {
+	if m.fail {
+		return false, nil, &types.SourceError{Location: "/migrations", Err: errors.New("no such file or directory")}
+	}
+	if m.checkSumsBroken {
+		m1 := types.Migration{Name: "201602220000.sql", SourceDir: "source", File: "source/201602220000.sql", MigrationType: types.MigrationTypeSingleMigration, Contents: "select abc", CheckSum: "123"}
+		return false, []types.Migration{m1}, nil
+	}
+	return true, nil, nil
+}
+
+func (m *mockedCoordinator) HealthCheck() types.HealthResponse {
+	if m.down {
+		checks := []types.HealthChecks{{Name: "DB", Status: types.HealthStatusDown, Data: &types.HealthData{Details: "connection refused"}}, {Name: "Loader", Status: types.HealthStatusUp}}
+		return types.HealthResponse{Status: types.HealthStatusDown, Checks: checks}
+	}
+	checks := []types.HealthChecks{{Name: "DB", Status: types.HealthStatusUp}, {Name: "Loader", Status: types.HealthStatusUp}}
+	return types.HealthResponse{Status: types.HealthStatusUp, Checks: checks}
+}
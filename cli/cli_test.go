@@ -0,0 +1,261 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lukaszbudnik/migrator/types"
+)
+
+const (
+	configFile = "../test/migrator-postgresql.yaml"
+)
+
+func runCommand(m *mockedCoordinator, args ...string) (int, string, string) {
+	stdout := new(bytes.Buffer)
+	stderr := new(bytes.Buffer)
+	code := Run(context.TODO(), args, stdout, stderr, newMockedCoordinatorFactory(m))
+	return code, stdout.String(), stderr.String()
+}
+
+func TestIsCommand(t *testing.T) {
+	assert.True(t, IsCommand("apply"))
+	assert.True(t, IsCommand("dry-run"))
+	assert.True(t, IsCommand("create-tenant"))
+	assert.True(t, IsCommand("status"))
+	assert.True(t, IsCommand("verify"))
+	assert.False(t, IsCommand("-configFile"))
+	assert.False(t, IsCommand("serve"))
+}
+
+func TestUnknownCommand(t *testing.T) {
+	code, _, stderr := runCommand(&mockedCoordinator{}, "serve")
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "create-tenant")
+}
+
+func TestApply(t *testing.T) {
+	m := &mockedCoordinator{}
+	code, stdout, _ := runCommand(m, "apply", "-configFile", configFile, "--version-name", "v1")
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "v1", m.versionName)
+	assert.Equal(t, types.ActionApply, m.action)
+	assert.False(t, m.dryRun)
+	assert.True(t, m.disposed)
+	assert.Contains(t, stdout, "Version: v1 (ID: 12)")
+	assert.Contains(t, stdout, "Migrations grand total: 7")
+}
+
+func TestApplySyncJSON(t *testing.T) {
+	m := &mockedCoordinator{}
+	code, stdout, _ := runCommand(m, "apply", "-configFile", configFile, "--version-name", "v1", "--action", "Sync", "--output", "json")
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, types.ActionSync, m.action)
+
+	var results map[string]map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(stdout), &results))
+	assert.Equal(t, float64(3), results["summary"]["tenants"])
+	assert.Equal(t, "v1", results["version"]["name"])
+}
+
+func TestApplyError(t *testing.T) {
+	m := &mockedCoordinator{fail: true}
+	code, stdout, stderr := runCommand(m, "apply", "-configFile", configFile, "--version-name", "v1")
+	assert.Equal(t, exitFailure, code)
+	assert.Empty(t, stdout)
+	assert.Contains(t, stderr, "could not acquire migrator lock within 30s")
+	assert.True(t, m.disposed)
+}
+
+func TestApplyErrorJSON(t *testing.T) {
+	m := &mockedCoordinator{fail: true}
+	code, stdout, _ := runCommand(m, "apply", "-configFile", configFile, "--version-name", "v1", "--output", "json")
+	assert.Equal(t, exitFailure, code)
+
+	var response struct {
+		Errors []struct {
+			Message    string                 `json:"message"`
+			Extensions map[string]interface{} `json:"extensions"`
+		} `json:"errors"`
+	}
+	assert.Nil(t, json.Unmarshal([]byte(stdout), &response))
+	assert.Len(t, response.Errors, 1)
+	assert.Equal(t, string(types.ErrorCodeLockTimeout), response.Errors[0].Extensions["code"])
+}
+
+func TestApplyMissingVersionName(t *testing.T) {
+	code, _, stderr := runCommand(&mockedCoordinator{}, "apply", "-configFile", configFile)
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "-version-name is required")
+}
+
+func TestApplyUnknownAction(t *testing.T) {
+	code, _, stderr := runCommand(&mockedCoordinator{}, "apply", "-configFile", configFile, "--version-name", "v1", "--action", "Drop")
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "Unknown action: Drop")
+}
+
+func TestApplyUnknownOutput(t *testing.T) {
+	code, _, stderr := runCommand(&mockedCoordinator{}, "apply", "-configFile", configFile, "--version-name", "v1", "--output", "xml")
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "Unknown output format: xml")
+}
+
+func TestApplyUnknownFlag(t *testing.T) {
+	code, _, stderr := runCommand(&mockedCoordinator{}, "apply", "--tenant", "abc")
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "flag provided but not defined: -tenant")
+}
+
+func TestApplyConfigFileNotFound(t *testing.T) {
+	code, _, stderr := runCommand(&mockedCoordinator{}, "apply", "-configFile", "abc.yaml", "--version-name", "v1")
+	assert.Equal(t, exitFailure, code)
+	assert.Contains(t, stderr, "Error reading config file")
+}
+
+func TestDryRun(t *testing.T) {
+	m := &mockedCoordinator{}
+	code, stdout, _ := runCommand(m, "dry-run", "-configFile", configFile, "--version-name", "v1")
+	assert.Equal(t, exitOK, code)
+	assert.True(t, m.dryRun)
+	assert.Contains(t, stdout, "Dry run: true")
+}
+
+func TestCreateTenant(t *testing.T) {
+	m := &mockedCoordinator{}
+	code, stdout, _ := runCommand(m, "create-tenant", "-configFile", configFile, "--version-name", "v1", "--tenant", "abc", "--dry-run")
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, types.Tenant{Name: "abc"}, m.tenant)
+	assert.True(t, m.dryRun)
+	assert.Contains(t, stdout, "Tenants: 1")
+}
+
+func TestCreateTenantMetadata(t *testing.T) {
+	m := &mockedCoordinator{}
+	code, _, _ := runCommand(m, "create-tenant", "-configFile", configFile, "--version-name", "v1", "--tenant", "abc", "--metadata", "tier=premium", "--metadata", "region=eu-west-1")
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, types.Tenant{Name: "abc", Metadata: types.TenantMetadata{"tier": "premium", "region": "eu-west-1"}}, m.tenant)
+
+	code, _, stderr := runCommand(&mockedCoordinator{}, "create-tenant", "-configFile", configFile, "--version-name", "v1", "--tenant", "abc", "--metadata", "premium")
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "expected key=value, got premium")
+}
+
+func TestApplyTenantSelector(t *testing.T) {
+	m := &mockedCoordinator{}
+	code, _, _ := runCommand(m, "apply", "-configFile", configFile, "--version-name", "v1", "--tenants", "abc, def", "--tenant-glob", "a*", "--tenant-regex", "^a", "--tenant-metadata", "tier=premium")
+	assert.Equal(t, exitOK, code)
+	names, glob, regex := []string{"abc", "def"}, "a*", "^a"
+	assert.Equal(t, &types.TenantSelector{Names: &names, Glob: &glob, Regex: &regex, Metadata: &types.TenantMetadata{"tier": "premium"}}, m.selector)
+
+	// all tenants are selected when no selector flag is passed
+	m = &mockedCoordinator{}
+	code, _, _ = runCommand(m, "dry-run", "-configFile", configFile, "--version-name", "v1")
+	assert.Equal(t, exitOK, code)
+	assert.Nil(t, m.selector)
+
+	code, _, stderr := runCommand(&mockedCoordinator{}, "apply", "-configFile", configFile, "--version-name", "v1", "--tenant-metadata", "premium")
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "expected key=value, got premium")
+}
+
+func TestCreateTenantMissingTenant(t *testing.T) {
+	code, _, stderr := runCommand(&mockedCoordinator{}, "create-tenant", "-configFile", configFile, "--version-name", "v1")
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "-tenant is required")
+}
+
+func TestCreateTenantError(t *testing.T) {
+	m := &mockedCoordinator{fail: true}
+	code, _, stderr := runCommand(m, "create-tenant", "-configFile", configFile, "--version-name", "v1", "--tenant", "abc")
+	assert.Equal(t, exitFailure, code)
+	assert.Contains(t, stderr, "SQL migration tenants/202002180000.sql failed for schema abc")
+}
+
+func TestStatus(t *testing.T) {
+	code, stdout, _ := runCommand(&mockedCoordinator{}, "status", "-configFile", configFile)
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, stdout, "Status: UP")
+	assert.Contains(t, stdout, "Tenants: 3")
+	assert.Contains(t, stdout, "Latest version: v2 (ID: 2")
+}
+
+func TestStatusDown(t *testing.T) {
+	code, stdout, _ := runCommand(&mockedCoordinator{down: true}, "status", "-configFile", configFile)
+	assert.Equal(t, exitFailure, code)
+	assert.Contains(t, stdout, "Status: DOWN")
+	assert.Contains(t, stdout, "DB: DOWN (connection refused)")
+	assert.NotContains(t, stdout, "Tenants")
+}
+
+func TestStatusJSON(t *testing.T) {
+	code, stdout, _ := runCommand(&mockedCoordinator{}, "status", "-configFile", configFile, "--output", "json")
+	assert.Equal(t, exitOK, code)
+
+	var status struct {
+		Health        types.HealthResponse `json:"health"`
+		Tenants       []types.Tenant       `json:"tenants"`
+		LatestVersion *types.Version       `json:"latestVersion"`
+	}
+	assert.Nil(t, json.Unmarshal([]byte(stdout), &status))
+	assert.Equal(t, types.HealthStatusUp, status.Health.Status)
+	assert.Len(t, status.Tenants, 3)
+	assert.Equal(t, int32(2), status.LatestVersion.ID)
+}
+
+func TestStatusError(t *testing.T) {
+	code, _, stderr := runCommand(&mockedCoordinator{fail: true}, "status", "-configFile", configFile)
+	assert.Equal(t, exitFailure, code)
+	assert.Contains(t, stderr, "failed to query tenants")
+}
+
+func TestVerify(t *testing.T) {
+	code, stdout, _ := runCommand(&mockedCoordinator{}, "verify", "-configFile", configFile)
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, stdout, "Source migrations verified")
+}
+
+func TestVerifyOffendingMigrations(t *testing.T) {
+	code, stdout, _ := runCommand(&mockedCoordinator{checkSumsBroken: true}, "verify", "-configFile", configFile)
+	assert.Equal(t, exitFailure, code)
+	assert.Contains(t, stdout, "source/201602220000.sql (checksum: 123)")
+}
+
+func TestVerifyOffendingMigrationsJSON(t *testing.T) {
+	code, stdout, _ := runCommand(&mockedCoordinator{checkSumsBroken: true}, "verify", "-configFile", configFile, "--output", "json")
+	assert.Equal(t, exitFailure, code)
+
+	var verify struct {
+		Verified            bool              `json:"verified"`
+		OffendingMigrations []types.Migration `json:"offendingMigrations"`
+	}
+	assert.Nil(t, json.Unmarshal([]byte(stdout), &verify))
+	assert.False(t, verify.Verified)
+	assert.Len(t, verify.OffendingMigrations, 1)
+}
+
+func TestVerifyError(t *testing.T) {
+	code, _, stderr := runCommand(&mockedCoordinator{fail: true}, "verify", "-configFile", configFile)
+	assert.Equal(t, exitFailure, code)
+	assert.Contains(t, stderr, "could not read source migrations from /migrations")
+}
+
+func TestApplyTenantFailed(t *testing.T) {
+	m := &mockedCoordinator{tenantFailed: true}
+	code, stdout, _ := runCommand(m, "apply", "-configFile", configFile, "--version-name", "v1")
+	assert.Equal(t, exitFailure, code)
+	assert.Contains(t, stdout, "Tenant def: Failed (SQL migration tenants/202002180000.sql failed for schema def with error: trouble maker)")
+	assert.Contains(t, stdout, "Tenant ghi: Skipped")
+	assert.NotContains(t, stdout, "Tenant abc")
+}
+
+func TestApplyTenantFailedJSON(t *testing.T) {
+	m := &mockedCoordinator{tenantFailed: true}
+	code, stdout, _ := runCommand(m, "apply", "-configFile", configFile, "--version-name", "v1", "--output", "json")
+	assert.Equal(t, exitFailure, code)
+	assert.Contains(t, stdout, `"outcome": "Failed"`)
+}
@@ -21,6 +21,19 @@ type RequestIDKey struct{}
 // LogLevel
 type LogLevelKey struct{}
 
+// ProgressKey is used together with context for setting/getting ProgressFunc
+type ProgressKey struct{}
+
+// ProgressFunc is notified about number of applied and total migrations of a long running operation
+type ProgressFunc func(applied, total int32)
+
+// ReportProgress calls ProgressFunc set in context, if there is no ProgressFunc the call is a no-op
+func ReportProgress(ctx context.Context, applied, total int32) {
+	if progress, ok := ctx.Value(ProgressKey{}).(ProgressFunc); ok {
+		progress(applied, total)
+	}
+}
+
 // LogError logs error message
 func LogError(ctx context.Context, format string, a ...interface{}) string {
 	return logLevel(ctx, errorLevel, format, a...)
@@ -94,3 +94,16 @@ func TestShouldLogMessage(t *testing.T) {
 	assert.False(t, shouldLogMessage(panicLevel, errorLevel))
 	assert.True(t, shouldLogMessage(panicLevel, panicLevel))
 }
+
+func TestReportProgress(t *testing.T) {
+	var applied, total int32
+	ctx := context.WithValue(newTestContext(), ProgressKey{}, ProgressFunc(func(a, t int32) {
+		applied, total = a, t
+	}))
+	ReportProgress(ctx, 2, 10)
+	assert.Equal(t, int32(2), applied)
+	assert.Equal(t, int32(10), total)
+
+	// no ProgressFunc in context
+	ReportProgress(newTestContext(), 3, 10)
+}
@@ -1,34 +1,81 @@
 package config
 
 import (
+	"encoding/base64"
 	"os"
+	"path"
 	"reflect"
+	"regexp"
 	"strings"
+	"time"
 
 	"gopkg.in/go-playground/validator.v9"
 	"gopkg.in/yaml.v3"
 )
 
+var isColumnName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`).MatchString
+
 // Config represents Migrator's yaml configuration file
 type Config struct {
-	BaseLocation      string   `yaml:"baseLocation" validate:"required"`
-	Driver            string   `yaml:"driver" validate:"required"`
-	DataSource        string   `yaml:"dataSource" validate:"required"`
-	TenantSelectSQL   string   `yaml:"tenantSelectSQL,omitempty"`
-	TenantInsertSQL   string   `yaml:"tenantInsertSQL,omitempty"`
-	SchemaPlaceHolder string   `yaml:"schemaPlaceHolder,omitempty"`
-	SingleMigrations  []string `yaml:"singleMigrations" validate:"min=1"`
-	TenantMigrations  []string `yaml:"tenantMigrations,omitempty"`
-	SingleScripts     []string `yaml:"singleScripts,omitempty"`
-	TenantScripts     []string `yaml:"tenantScripts,omitempty"`
-	Port              string   `yaml:"port,omitempty"`
-	PathPrefix        string   `yaml:"pathPrefix,omitempty"`
-	WebHookURL        string   `yaml:"webHookURL,omitempty"`
-	WebHookHeaders    []string `yaml:"webHookHeaders,omitempty"`
-	WebHookTemplate   string   `yaml:"webHookTemplate,omitempty"`
-	LogLevel          string   `yaml:"logLevel,omitempty" validate:"logLevel"`
+	BaseLocation          string            `yaml:"baseLocation" validate:"required"`
+	Driver                string            `yaml:"driver" validate:"required"`
+	DataSource            string            `yaml:"dataSource" validate:"required"`
+	TenantSelectSQL       string            `yaml:"tenantSelectSQL,omitempty"`
+	TenantInsertSQL       string            `yaml:"tenantInsertSQL,omitempty"`
+	TenantMetadataColumns []string          `yaml:"tenantMetadataColumns,omitempty" validate:"dive,columnName"`
+	SchemaPlaceHolder     string            `yaml:"schemaPlaceHolder,omitempty"`
+	SingleMigrations      []string          `yaml:"singleMigrations" validate:"min=1"`
+	TenantMigrations      []string          `yaml:"tenantMigrations,omitempty"`
+	SingleScripts         []string          `yaml:"singleScripts,omitempty"`
+	TenantScripts         []string          `yaml:"tenantScripts,omitempty"`
+	IncludePatterns       []string          `yaml:"includePatterns,omitempty" validate:"dive,pattern"`
+	ExcludePatterns       []string          `yaml:"excludePatterns,omitempty" validate:"dive,pattern"`
+	Recursive             bool              `yaml:"recursive,omitempty"`
+	SignaturePublicKeys   []string          `yaml:"signaturePublicKeys,omitempty" validate:"dive,signaturePublicKey"`
+	TemplateRendering     bool              `yaml:"templateRendering,omitempty"`
+	TemplateVariables     map[string]string `yaml:"templateVariables,omitempty"`
+	TemplateEnv           []string          `yaml:"templateEnv,omitempty"`
+	Port                  string            `yaml:"port,omitempty"`
+	PathPrefix            string            `yaml:"pathPrefix,omitempty"`
+	WebHookURL            string            `yaml:"webHookURL,omitempty"`
+	WebHookHeaders        []string          `yaml:"webHookHeaders,omitempty"`
+	WebHookTemplate       string            `yaml:"webHookTemplate,omitempty"`
+	LogLevel              string            `yaml:"logLevel,omitempty" validate:"logLevel"`
+	LockTimeout           string            `yaml:"lockTimeout,omitempty" validate:"lockTimeout"`
+	TenantConcurrency     int               `yaml:"tenantConcurrency,omitempty" validate:"min=0"`
+	TenantFailurePolicy   string            `yaml:"tenantFailurePolicy,omitempty" validate:"tenantFailurePolicy"`
+	TransactionScope      string            `yaml:"transactionScope,omitempty" validate:"transactionScope"`
+	ArchiveVerification   string            `yaml:"archiveVerification,omitempty" validate:"archiveVerification"`
+	S3Endpoint            string            `yaml:"s3Endpoint,omitempty"`
+	S3Region              string            `yaml:"s3Region,omitempty"`
+	S3ForcePathStyle      bool              `yaml:"s3ForcePathStyle,omitempty"`
+	S3Profile             string            `yaml:"s3Profile,omitempty"`
+	S3Concurrency         int               `yaml:"s3Concurrency,omitempty" validate:"min=0"`
+	GCSEndpoint           string            `yaml:"gcsEndpoint,omitempty"`
+	GCSAnonymous          bool              `yaml:"gcsAnonymous,omitempty"`
+	GCSConcurrency        int               `yaml:"gcsConcurrency,omitempty" validate:"min=0"`
+	SourceCacheTTL        string            `yaml:"sourceCacheTTL,omitempty" validate:"sourceCacheTTL"`
+	HTTPHeaders           []string          `yaml:"httpHeaders,omitempty"`
+	HTTPTimeout           string            `yaml:"httpTimeout,omitempty" validate:"httpTimeout"`
 }
 
+const (
+	// TenantFailurePolicyStop stops migrating remaining tenants when migrating a tenant failed
+	TenantFailurePolicyStop = "stop"
+	// TenantFailurePolicyContinue continues migrating remaining tenants when migrating a tenant failed
+	TenantFailurePolicyContinue = "continue"
+	// TransactionScopeVersion applies all migrations of a version in a single transaction
+	TransactionScopeVersion = "version"
+	// TransactionScopeTenant applies migrations of every tenant in its own transaction
+	TransactionScopeTenant = "tenant"
+	// TransactionScopeMigration applies every tenant migration to every tenant in its own transaction
+	TransactionScopeMigration = "migration"
+	// ArchiveVerificationChecksum verifies archive against detached checksum file (baseLocation with .sha256 suffix)
+	ArchiveVerificationChecksum = "checksum"
+	// ArchiveVerificationManifest verifies every file in archive against SHA256SUMS manifest at the root of the archive
+	ArchiveVerificationManifest = "manifest"
+)
+
 func (config Config) String() string {
 	c, _ := yaml.Marshal(config)
 	return strings.TrimSpace(string(c))
@@ -53,9 +100,7 @@ func FromBytes(contents []byte) (*Config, error) {
 		return nil, err
 	}
 
-	validate := validator.New()
-	validate.RegisterValidation("logLevel", validateLogLevel)
-	if err := validate.Struct(config); err != nil {
+	if err := Validate(&config); err != nil {
 		return nil, err
 	}
 
@@ -64,6 +109,22 @@ func FromBytes(contents []byte) (*Config, error) {
 	return &config, nil
 }
 
+// Validate validates config, it is used when config is not read from YAML, for example when migrator is embedded in Go application
+func Validate(config *Config) error {
+	validate := validator.New()
+	validate.RegisterValidation("logLevel", validateLogLevel)
+	validate.RegisterValidation("lockTimeout", validateDuration)
+	validate.RegisterValidation("sourceCacheTTL", validateDuration)
+	validate.RegisterValidation("httpTimeout", validateDuration)
+	validate.RegisterValidation("pattern", validatePattern)
+	validate.RegisterValidation("columnName", validateColumnName)
+	validate.RegisterValidation("signaturePublicKey", validateSignaturePublicKey)
+	validate.RegisterValidation("tenantFailurePolicy", validateTenantFailurePolicy)
+	validate.RegisterValidation("transactionScope", validateTransactionScope)
+	validate.RegisterValidation("archiveVerification", validateArchiveVerification)
+	return validate.Struct(config)
+}
+
 func substituteEnvVariables(config *Config) {
 	val := reflect.ValueOf(config).Elem()
 	for i := 0; i < val.NumField(); i++ {
@@ -81,6 +142,11 @@ func substituteEnvVariables(config *Config) {
 					ss[i] = substituteEnvVariable(ss[i])
 				}
 				valueField.Set(reflect.ValueOf(ss))
+			case reflect.Map:
+				m := valueField.Interface().(map[string]string)
+				for k, v := range m {
+					m[k] = substituteEnvVariable(v)
+				}
 			}
 		}
 	}
@@ -100,3 +166,61 @@ func validateLogLevel(fl validator.FieldLevel) bool {
 	value := fl.Field().String()
 	return value == "" || value == "DEBUG" || value == "INFO" || value == "ERROR" || value == "PANIC"
 }
+
+// validateDuration checks that value is empty or a positive Go duration
+func validateDuration(fl validator.FieldLevel) bool {
+	value := fl.Field().String()
+	if value == "" {
+		return true
+	}
+	duration, err := time.ParseDuration(value)
+	return err == nil && duration > 0
+}
+
+// validatePattern checks that value is a valid glob pattern, see path.Match
+func validatePattern(fl validator.FieldLevel) bool {
+	_, err := path.Match(fl.Field().String(), "")
+	return err == nil
+}
+
+// validateColumnName checks that value is a column name which can be used in SQL statements without quoting
+// name, id, and created are columns of default tenants table
+func validateColumnName(fl validator.FieldLevel) bool {
+	value := fl.Field().String()
+	switch strings.ToLower(value) {
+	case "name", "id", "created":
+		return false
+	}
+	return isColumnName(value)
+}
+
+// validateSignaturePublicKey checks if value is signer:publicKey, public key is base64 encoded raw ed25519 public key (32 bytes)
+// or minisign public key (42 bytes), keys are parsed by loader
+func validateSignaturePublicKey(fl validator.FieldLevel) bool {
+	value := fl.Field().String()
+	// env variables are substituted after config is validated
+	if strings.Contains(value, "${") {
+		return true
+	}
+	i := strings.LastIndex(value, ":")
+	if i < 1 {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value[i+1:]))
+	return err == nil && (len(decoded) == 32 || len(decoded) == 42)
+}
+
+func validateTenantFailurePolicy(fl validator.FieldLevel) bool {
+	value := fl.Field().String()
+	return value == "" || value == TenantFailurePolicyStop || value == TenantFailurePolicyContinue
+}
+
+func validateTransactionScope(fl validator.FieldLevel) bool {
+	value := fl.Field().String()
+	return value == "" || value == TransactionScopeVersion || value == TransactionScopeTenant || value == TransactionScopeMigration
+}
+
+func validateArchiveVerification(fl validator.FieldLevel) bool {
+	value := fl.Field().String()
+	return value == "" || value == ArchiveVerificationChecksum || value == ArchiveVerificationManifest
+}
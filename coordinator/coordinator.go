@@ -3,7 +3,10 @@ package coordinator
 import (
 	"context"
 	"fmt"
+	"path"
+	"path/filepath"
 	"reflect"
+	"regexp"
 
 	"github.com/lukaszbudnik/migrator/common"
 	"github.com/lukaszbudnik/migrator/config"
@@ -24,16 +27,20 @@ type SourceMigrationFilters struct {
 
 // Coordinator interface abstracts all operations performed by migrator
 type Coordinator interface {
-	GetTenants() []types.Tenant
-	GetVersions() []types.Version
-	GetVersionsByFile(string) []types.Version
+	GetTenants() ([]types.Tenant, error)
+	GetVersions() ([]types.Version, error)
+	GetVersionsByFile(string) ([]types.Version, error)
 	GetVersionByID(int32) (*types.Version, error)
 	GetDBMigrationByID(int32) (*types.DBMigration, error)
-	GetSourceMigrations(*SourceMigrationFilters) []types.Migration
+	GetSourceMigrations(*SourceMigrationFilters) ([]types.Migration, error)
 	GetSourceMigrationByFile(string) (*types.Migration, error)
-	VerifySourceMigrationsCheckSums() (bool, []types.Migration)
-	CreateVersion(string, types.Action, bool) *types.CreateResults
-	CreateTenant(string, types.Action, bool, string) *types.CreateResults
+	RefreshSourceMigrations() ([]types.Migration, error)
+	VerifySourceMigrationsCheckSums() (bool, []types.Migration, error)
+	CreateVersion(string, types.Action, bool, *types.TenantSelector) (*types.CreateResults, error)
+	CreateTenant(string, types.Action, bool, types.Tenant) (*types.CreateResults, error)
+	RollbackVersion(int32, string, bool) (*types.CreateResults, error)
+	Plan(*types.TenantSelector) (*types.Plan, error)
+	GetTenantStatus(string) (*types.TenantStatus, error)
 	HealthCheck() types.HealthResponse
 	Dispose()
 }
@@ -67,15 +74,48 @@ func New(ctx context.Context, config *config.Config, metrics metrics.Metrics, ne
 	return coordinator
 }
 
-func (c *coordinator) GetTenants() []types.Tenant {
+// Options contains optional dependencies of Coordinator created by NewFromConfig
+type Options struct {
+	// Metrics defaults to metrics which discard all values
+	Metrics metrics.Metrics
+	// Loader defaults to loader.New which selects loader based on baseLocation
+	// use loader.NewFSFactory to load migrations from fs.FS, for example embed.FS
+	Loader loader.Factory
+	// Notifier defaults to notifications.New
+	Notifier notifications.Factory
+}
+
+// NewFromConfig validates config and creates instance of Coordinator
+// it is used to embed migrator in Go applications, neither YAML config file nor HTTP server is required
+// the returned Coordinator must be disposed using Dispose
+func NewFromConfig(ctx context.Context, cfg *config.Config, options Options) (Coordinator, error) {
+	if err := config.Validate(cfg); err != nil {
+		return nil, err
+	}
+	if options.Metrics == nil {
+		options.Metrics = metrics.NewNoop()
+	}
+	if options.Loader == nil {
+		options.Loader = loader.New
+	}
+	if options.Notifier == nil {
+		options.Notifier = notifications.New
+	}
+	if ctx.Value(common.LogLevelKey{}) == nil {
+		ctx = context.WithValue(ctx, common.LogLevelKey{}, cfg.LogLevel)
+	}
+	return New(ctx, cfg, options.Metrics, db.New, options.Loader, options.Notifier), nil
+}
+
+func (c *coordinator) GetTenants() ([]types.Tenant, error) {
 	return c.connector.GetTenants()
 }
 
-func (c *coordinator) GetVersions() []types.Version {
+func (c *coordinator) GetVersions() ([]types.Version, error) {
 	return c.connector.GetVersions()
 }
 
-func (c *coordinator) GetVersionsByFile(file string) []types.Version {
+func (c *coordinator) GetVersionsByFile(file string) ([]types.Version, error) {
 	return c.connector.GetVersionsByFile(file)
 }
 
@@ -83,14 +123,20 @@ func (c *coordinator) GetVersionByID(ID int32) (*types.Version, error) {
 	return c.connector.GetVersionByID(ID)
 }
 
-func (c *coordinator) GetSourceMigrations(filters *SourceMigrationFilters) []types.Migration {
-	allSourceMigrations := c.loader.GetSourceMigrations()
+func (c *coordinator) GetSourceMigrations(filters *SourceMigrationFilters) ([]types.Migration, error) {
+	allSourceMigrations, err := c.loader.GetSourceMigrations()
+	if err != nil {
+		return nil, err
+	}
 	filteredMigrations := c.filterMigrations(allSourceMigrations, filters)
-	return filteredMigrations
+	return filteredMigrations, nil
 }
 
 func (c *coordinator) GetSourceMigrationByFile(file string) (*types.Migration, error) {
-	allSourceMigrations := c.loader.GetSourceMigrations()
+	allSourceMigrations, err := c.loader.GetSourceMigrations()
+	if err != nil {
+		return nil, err
+	}
 	filters := SourceMigrationFilters{
 		File: &file,
 	}
@@ -101,11 +147,20 @@ func (c *coordinator) GetSourceMigrationByFile(file string) (*types.Migration, e
 	return &filteredMigrations[0], nil
 }
 
+// RefreshSourceMigrations drops cached source migrations and reads them again
+// when source migrations are not cached (sourceCacheTTL is not set) they are simply read
+func (c *coordinator) RefreshSourceMigrations() ([]types.Migration, error) {
+	if refresher, ok := c.loader.(loader.Refresher); ok {
+		return refresher.Refresh()
+	}
+	return c.loader.GetSourceMigrations()
+}
+
 func (c *coordinator) GetDBMigrationByID(ID int32) (*types.DBMigration, error) {
 	return c.connector.GetDBMigrationByID(ID)
 }
 
-func (c *coordinator) GetAppliedMigrations() []types.DBMigration {
+func (c *coordinator) GetAppliedMigrations() ([]types.DBMigration, error) {
 	return c.connector.GetAppliedMigrations()
 }
 
@@ -114,9 +169,16 @@ func (c *coordinator) GetAppliedMigrations() []types.DBMigration {
 // returns bool indicating if offending (i.e., modified) disk migrations were found
 // if bool is false the function returns a slice of offending migrations
 // if bool is true the slice of effending migrations is empty
-func (c *coordinator) VerifySourceMigrationsCheckSums() (bool, []types.Migration) {
-	sourceMigrations := c.GetSourceMigrations(nil)
-	appliedMigrations := c.GetAppliedMigrations()
+// error is returned if source or applied DB migrations could not be read
+func (c *coordinator) VerifySourceMigrationsCheckSums() (bool, []types.Migration, error) {
+	sourceMigrations, err := c.GetSourceMigrations(nil)
+	if err != nil {
+		return false, nil, err
+	}
+	appliedMigrations, err := c.GetAppliedMigrations()
+	if err != nil {
+		return false, nil, err
+	}
 
 	flattenedAppliedMigration := c.flattenAppliedMigrations(appliedMigrations)
 
@@ -133,39 +195,150 @@ func (c *coordinator) VerifySourceMigrationsCheckSums() (bool, []types.Migration
 			result = false
 		}
 	}
-	return result, offendingMigrations
+	return result, offendingMigrations, nil
 }
 
-func (c *coordinator) CreateVersion(versionName string, action types.Action, dryRun bool) *types.CreateResults {
-	sourceMigrations := c.GetSourceMigrations(nil)
-	appliedMigrations := c.GetAppliedMigrations()
+func (c *coordinator) CreateVersion(versionName string, action types.Action, dryRun bool, selector *types.TenantSelector) (*types.CreateResults, error) {
+	migrationsToApply, err := c.getMigrationsToApply(selector)
+	if err != nil {
+		return nil, err
+	}
 
-	migrationsToApply := c.computeMigrationsToApply(sourceMigrations, appliedMigrations)
-	common.LogInfo(c.ctx, "Found migrations to apply: %d", len(migrationsToApply))
+	if err := c.verifySignatures(migrationsToApply); err != nil {
+		return nil, err
+	}
 
-	summary, version := c.connector.CreateVersion(versionName, action, migrationsToApply, dryRun)
+	summary, version, err := c.connector.CreateVersion(versionName, action, migrationsToApply, dryRun)
+	if err != nil {
+		return nil, err
+	}
 
 	c.recordVersionMetrics(summary)
 
 	c.sendNotification(summary)
 
-	return &types.CreateResults{Summary: summary, Version: version}
+	return &types.CreateResults{Summary: summary, Version: version}, nil
 }
 
-func (c *coordinator) CreateTenant(versionName string, action types.Action, dryRun bool, tenant string) *types.CreateResults {
-	sourceMigrations := c.GetSourceMigrations(nil)
+func (c *coordinator) CreateTenant(versionName string, action types.Action, dryRun bool, tenant types.Tenant) (*types.CreateResults, error) {
+	sourceMigrations, err := c.GetSourceMigrations(nil)
+	if err != nil {
+		return nil, err
+	}
 
 	// filter only tenant schemas
 	migrationsToApply := c.filterTenantMigrations(sourceMigrations)
 	common.LogInfo(c.ctx, "Migrations to apply for new tenant: %d", len(migrationsToApply))
 
-	summary, version := c.connector.CreateTenant(tenant, versionName, action, migrationsToApply, dryRun)
+	if err := c.verifySignatures(migrationsToApply); err != nil {
+		return nil, err
+	}
+
+	summary, version, err := c.connector.CreateTenant(tenant, versionName, action, migrationsToApply, dryRun)
+	if err != nil {
+		return nil, err
+	}
 
 	c.recordTenantMetrics(summary)
 
 	c.sendNotification(summary)
 
-	return &types.CreateResults{Summary: summary, Version: version}
+	return &types.CreateResults{Summary: summary, Version: version}, nil
+}
+
+// RollbackVersion runs down migrations of the version with given ID and records them as a new version
+func (c *coordinator) RollbackVersion(ID int32, versionName string, dryRun bool) (*types.CreateResults, error) {
+	summary, version, err := c.connector.RollbackVersion(ID, versionName, dryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	common.LogInfo(c.ctx, "Rolled back version: %d", ID)
+
+	c.metrics.IncrementGaugeValue("versions_rolled_back", []string{})
+
+	c.sendNotification(summary)
+
+	return &types.CreateResults{Summary: summary, Version: version}, nil
+}
+
+// Plan returns migrations which CreateVersion would apply together with schemas and rendered SQL, no migration is executed
+func (c *coordinator) Plan(selector *types.TenantSelector) (*types.Plan, error) {
+	migrationsToApply, err := c.getMigrationsToApply(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.connector.Plan(migrationsToApply)
+}
+
+// getMigrationsToApply returns source migrations which are not yet applied, tenant migrations and scripts are applied only to tenants selected by passed selector
+func (c *coordinator) getMigrationsToApply(selector *types.TenantSelector) ([]types.Migration, error) {
+	sourceMigrations, err := c.GetSourceMigrations(nil)
+	if err != nil {
+		return nil, err
+	}
+	appliedMigrations, err := c.GetAppliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	tenants, err := c.GetTenants()
+	if err != nil {
+		return nil, err
+	}
+	selectedTenants, err := c.selectTenants(tenants, selector)
+	if err != nil {
+		return nil, err
+	}
+
+	migrationsToApply := c.computeMigrationsToApply(sourceMigrations, appliedMigrations, tenants, selectedTenants)
+	common.LogInfo(c.ctx, "Found migrations to apply: %d", len(migrationsToApply))
+
+	return migrationsToApply, nil
+}
+
+// GetTenantStatus returns tenant migrations applied to passed tenant and tenant migrations pending for it
+// pending migrations are computed the same way as in CreateVersion which applies them
+func (c *coordinator) GetTenantStatus(name string) (*types.TenantStatus, error) {
+	tenants, err := c.GetTenants()
+	if err != nil {
+		return nil, err
+	}
+	var tenant *types.Tenant
+	for i := range tenants {
+		if tenants[i].Name == name {
+			tenant = &tenants[i]
+			break
+		}
+	}
+	if tenant == nil {
+		return nil, fmt.Errorf("tenant not found: %v", name)
+	}
+
+	sourceMigrations, err := c.GetSourceMigrations(nil)
+	if err != nil {
+		return nil, err
+	}
+	appliedMigrations, err := c.GetAppliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	status := &types.TenantStatus{Name: tenant.Name, Metadata: tenant.Metadata, PendingMigrations: []types.Migration{}}
+	for _, m := range appliedMigrations {
+		if m.MigrationType == types.MigrationTypeTenantMigration && m.Schema == tenant.Name {
+			status.AppliedMigrations++
+		}
+	}
+	for _, m := range c.computeMigrationsToApply(sourceMigrations, appliedMigrations, tenants, []types.Tenant{*tenant}) {
+		if m.MigrationType == types.MigrationTypeTenantMigration {
+			m.Tenants = nil
+			status.PendingMigrations = append(status.PendingMigrations, m)
+		}
+	}
+	status.Lag = int32(len(status.PendingMigrations))
+
+	return status, nil
 }
 
 func (c *coordinator) HealthCheck() types.HealthResponse {
@@ -203,7 +376,7 @@ func (c *coordinator) flattenAppliedMigrations(appliedMigrations []types.DBMigra
 	var flattened []types.Migration
 	var previousMigration types.Migration
 	for i, m := range appliedMigrations {
-		if i == 0 || m.Migration != previousMigration {
+		if i == 0 || !reflect.DeepEqual(m.Migration, previousMigration) {
 			flattened = append(flattened, m.Migration)
 			previousMigration = m.Migration
 		}
@@ -236,34 +409,160 @@ func (c *coordinator) intersect(sourceMigrations []types.Migration, flattenedApp
 	return intersect
 }
 
-// difference returns the elements on disk which are not yet in DB
-// the exceptions are MigrationTypeSingleScript and MigrationTypeTenantScript which are always run
-func (c *coordinator) difference(sourceMigrations []types.Migration, flattenedAppliedMigrations []types.Migration) []types.Migration {
-	// key is Migration.File
-	existsInDB := map[string]bool{}
-	for _, m := range flattenedAppliedMigrations {
-		if m.MigrationType != types.MigrationTypeSingleScript && m.MigrationType != types.MigrationTypeTenantScript {
-			existsInDB[m.File] = true
+// computeMigrationsToApply computes which source migrations should be applied to DB based on migrations already present in DB
+// migrations are compared per (file, schema) pair, tenant migrations are applied only to selected tenants which do not have them yet
+// so tenants restored from backups or added outside of migrator are brought up to date
+// scripts are always applied, tenant scripts to all selected tenants, Tenants of migrations applied to all tenants are left nil
+func (c *coordinator) computeMigrationsToApply(sourceMigrations []types.Migration, appliedMigrations []types.DBMigration, tenants []types.Tenant, selectedTenants []types.Tenant) []types.Migration {
+	// key is Migration.File, value is set of schemas
+	appliedToSchemas := map[string]map[string]bool{}
+	for _, m := range appliedMigrations {
+		if appliedToSchemas[m.File] == nil {
+			appliedToSchemas[m.File] = map[string]bool{}
 		}
+		appliedToSchemas[m.File][m.Schema] = true
 	}
-	diff := []types.Migration{}
+
+	common.LogInfo(c.ctx, "Number of applied DB migration files: %d", len(appliedToSchemas))
+
+	out := []types.Migration{}
 	for _, m := range sourceMigrations {
-		if _, ok := existsInDB[m.File]; !ok {
-			diff = append(diff, m)
+		switch m.MigrationType {
+		case types.MigrationTypeTenantMigration:
+			var pendingTenants []string
+			for _, t := range selectedTenants {
+				if !appliedToSchemas[m.File][t.Name] {
+					pendingTenants = append(pendingTenants, t.Name)
+				}
+			}
+			if pendingTenants == nil {
+				continue
+			}
+			if len(pendingTenants) < len(tenants) {
+				m.Tenants = pendingTenants
+			}
+			out = append(out, m)
+		case types.MigrationTypeTenantScript:
+			if len(selectedTenants) < len(tenants) {
+				m.Tenants = tenantNames(selectedTenants)
+			}
+			out = append(out, m)
+		case types.MigrationTypeSingleScript:
+			out = append(out, m)
+		default:
+			// single migrations are applied to schema named after their source directory, see db.getMigrationSchemas
+			if !appliedToSchemas[m.File][filepath.Base(m.SourceDir)] {
+				out = append(out, m)
+			}
 		}
 	}
-	return diff
+	return out
 }
 
-// computeMigrationsToApply computes which source migrations should be applied to DB based on migrations already present in DB
-func (c *coordinator) computeMigrationsToApply(sourceMigrations []types.Migration, appliedMigrations []types.DBMigration) []types.Migration {
-	flattenedAppliedMigrations := c.flattenAppliedMigrations(appliedMigrations)
+// selectTenants returns tenants selected by passed selector, nil selector selects all tenants
+// error is returned when selector is invalid, references unknown tenant, or does not select any tenant
+func (c *coordinator) selectTenants(tenants []types.Tenant, selector *types.TenantSelector) ([]types.Tenant, error) {
+	if selector == nil {
+		return tenants, nil
+	}
 
-	len := len(flattenedAppliedMigrations)
-	common.LogInfo(c.ctx, "Number of flattened DB migrations: %d", len)
+	var names map[string]bool
+	if selector.Names != nil {
+		names = map[string]bool{}
+		for _, name := range *selector.Names {
+			names[name] = true
+		}
+		for _, name := range *selector.Names {
+			if !containsTenant(tenants, name) {
+				return nil, &types.TenantSelectorError{Reason: fmt.Sprintf("tenant %v not found", name)}
+			}
+		}
+	}
+	if selector.Glob != nil {
+		if _, err := path.Match(*selector.Glob, ""); err != nil {
+			return nil, &types.TenantSelectorError{Reason: fmt.Sprintf("invalid glob %v: %v", *selector.Glob, err)}
+		}
+	}
+	var regex *regexp.Regexp
+	if selector.Regex != nil {
+		var err error
+		if regex, err = regexp.Compile(*selector.Regex); err != nil {
+			return nil, &types.TenantSelectorError{Reason: fmt.Sprintf("invalid regex %v: %v", *selector.Regex, err)}
+		}
+	}
 
-	out := c.difference(sourceMigrations, flattenedAppliedMigrations)
-	return out
+	selected := []types.Tenant{}
+	for _, t := range tenants {
+		if names != nil && !names[t.Name] {
+			continue
+		}
+		if selector.Glob != nil {
+			if matched, _ := path.Match(*selector.Glob, t.Name); !matched {
+				continue
+			}
+		}
+		if regex != nil && !regex.MatchString(t.Name) {
+			continue
+		}
+		if selector.Metadata != nil && !c.matchTenantMetadata(t, *selector.Metadata) {
+			continue
+		}
+		selected = append(selected, t)
+	}
+
+	if len(selected) == 0 {
+		return nil, &types.TenantSelectorError{Reason: "no tenant matches the selector"}
+	}
+	common.LogInfo(c.ctx, "Selected tenants: %d", len(selected))
+
+	return selected, nil
+}
+
+// matchTenantMetadata returns true if tenant metadata contains all passed metadata
+func (c *coordinator) matchTenantMetadata(tenant types.Tenant, metadata types.TenantMetadata) bool {
+	for key, value := range metadata {
+		if tenantValue, ok := tenant.Metadata[key]; !ok || tenantValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+func containsTenant(tenants []types.Tenant, name string) bool {
+	for _, t := range tenants {
+		if t.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func tenantNames(tenants []types.Tenant) []string {
+	names := make([]string, len(tenants))
+	for i, t := range tenants {
+		names[i] = t.Name
+	}
+	return names
+}
+
+// verifySignatures returns error when signaturePublicKeys is set and any of migrations to apply was not verified by loader
+// migrations loaded by custom loaders which do not verify signatures are refused too
+func (c *coordinator) verifySignatures(migrations []types.Migration) error {
+	if c.config == nil || len(c.config.SignaturePublicKeys) == 0 {
+		return nil
+	}
+	for _, m := range migrations {
+		if m.Signer != nil && m.SignatureError == "" {
+			continue
+		}
+		reason := m.SignatureError
+		if reason == "" {
+			reason = "migration is not signed"
+		}
+		common.LogError(c.ctx, "Refusing to apply migration %v: %v", m.File, reason)
+		return &types.UnverifiedMigrationError{File: m.File, Reason: reason}
+	}
+	return nil
 }
 
 // filterTenantMigrations returns only migrations which are of type MigrationTypeTenantSchema
@@ -3,6 +3,7 @@ package coordinator
 import (
 	"context"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/graph-gophers/graphql-go"
@@ -18,7 +19,7 @@ import (
 type mockedDiskLoader struct {
 }
 
-func (m *mockedDiskLoader) GetSourceMigrations() []types.Migration {
+func (m *mockedDiskLoader) GetSourceMigrations() ([]types.Migration, error) {
 	// 5 migrations in total
 	// 4 migrations with type MigrationTypeSingleMigration
 	// 3 migrations with sourceDir source and type MigrationTypeSingleMigration
@@ -30,7 +31,7 @@ func (m *mockedDiskLoader) GetSourceMigrations() []types.Migration {
 	m3 := types.Migration{Name: "201602220001.sql", SourceDir: "config", File: "config/201602220001.sql", MigrationType: types.MigrationTypeSingleMigration, Contents: "select def"}
 	m4 := types.Migration{Name: "201602220002.sql", SourceDir: "source", File: "source/201602220002.sql", MigrationType: types.MigrationTypeSingleMigration, Contents: "select def"}
 	m5 := types.Migration{Name: "201602220003.sql", SourceDir: "tenant", File: "tenant/201602220003.sql", MigrationType: types.MigrationTypeTenantMigration, Contents: "select def"}
-	return []types.Migration{m1, m2, m3, m4, m5}
+	return []types.Migration{m1, m2, m3, m4, m5}, nil
 }
 
 func (m *mockedDiskLoader) HealthCheck() error {
@@ -53,6 +54,18 @@ func newMockedDiskLoaderHealthCheckError(_ context.Context, _ *config.Config) lo
 	return &mockedDiskLoaderHealthCheckError{}
 }
 
+type mockedDiskLoaderSourceError struct {
+	mockedDiskLoader
+}
+
+func (m *mockedDiskLoaderSourceError) GetSourceMigrations() ([]types.Migration, error) {
+	return nil, &types.SourceError{Location: "source", Err: errors.New("trouble maker")}
+}
+
+func newMockedDiskLoaderSourceError(_ context.Context, _ *config.Config) loader.Loader {
+	return &mockedDiskLoaderSourceError{}
+}
+
 type mockedNotifier struct {
 	returnError bool
 }
@@ -75,9 +88,9 @@ func newErrorMockedNotifier(_ context.Context, _ *config.Config) notifications.N
 type mockedBrokenCheckSumDiskLoader struct {
 }
 
-func (m *mockedBrokenCheckSumDiskLoader) GetSourceMigrations() []types.Migration {
+func (m *mockedBrokenCheckSumDiskLoader) GetSourceMigrations() ([]types.Migration, error) {
 	m1 := types.Migration{Name: "201602220000.sql", SourceDir: "source", File: "source/201602220000.sql", MigrationType: types.MigrationTypeSingleMigration, Contents: "select abc", CheckSum: "xxx"}
-	return []types.Migration{m1}
+	return []types.Migration{m1}, nil
 }
 
 func (m *mockedBrokenCheckSumDiskLoader) HealthCheck() error {
@@ -91,10 +104,10 @@ func newBrokenCheckSumMockedDiskLoader(_ context.Context, _ *config.Config) load
 type mockedDifferentScriptCheckSumMockedDiskLoader struct {
 }
 
-func (m *mockedDifferentScriptCheckSumMockedDiskLoader) GetSourceMigrations() []types.Migration {
+func (m *mockedDifferentScriptCheckSumMockedDiskLoader) GetSourceMigrations() ([]types.Migration, error) {
 	m1 := types.Migration{Name: "201602220000.sql", SourceDir: "source", File: "source/201602220000.sql", MigrationType: types.MigrationTypeSingleMigration, Contents: "select abc"}
 	m2 := types.Migration{Name: "recreate-indexes.sql", SourceDir: "tenants-scripts", File: "tenants-scripts/recreate-indexes.sql", MigrationType: types.MigrationTypeTenantScript, Contents: "select abc", CheckSum: "sha256-1"}
-	return []types.Migration{m1, m2}
+	return []types.Migration{m1, m2}, nil
 }
 
 func (m *mockedDifferentScriptCheckSumMockedDiskLoader) HealthCheck() error {
@@ -111,31 +124,46 @@ type mockedConnector struct {
 func (m *mockedConnector) Dispose() {
 }
 
-func (m *mockedConnector) CreateTenant(string, string, types.Action, []types.Migration, bool) (*types.Summary, *types.Version) {
-	return &types.Summary{}, &types.Version{}
+func (m *mockedConnector) CreateTenant(types.Tenant, string, types.Action, []types.Migration, bool) (*types.Summary, *types.Version, error) {
+	return &types.Summary{}, &types.Version{}, nil
 }
 
-func (m *mockedConnector) CreateVersion(string, types.Action, []types.Migration, bool) (*types.Summary, *types.Version) {
-	return &types.Summary{}, &types.Version{}
+func (m *mockedConnector) CreateVersion(string, types.Action, []types.Migration, bool) (*types.Summary, *types.Version, error) {
+	return &types.Summary{}, &types.Version{}, nil
 }
 
-func (m *mockedConnector) GetTenants() []types.Tenant {
+func (m *mockedConnector) RollbackVersion(ID int32, versionName string, dryRun bool) (*types.Summary, *types.Version, error) {
+	if ID != 12 {
+		return nil, nil, fmt.Errorf("version not found ID: %v", ID)
+	}
+	return &types.Summary{VersionID: 123}, &types.Version{ID: 123, Name: versionName}, nil
+}
+
+func (m *mockedConnector) Plan(migrations []types.Migration) (*types.Plan, error) {
+	plan := &types.Plan{Tenants: 3, Migrations: []types.PlannedMigration{}}
+	for _, m := range migrations {
+		plan.Migrations = append(plan.Migrations, types.PlannedMigration{Migration: m})
+	}
+	return plan, nil
+}
+
+func (m *mockedConnector) GetTenants() ([]types.Tenant, error) {
 	a := types.Tenant{Name: "a"}
 	b := types.Tenant{Name: "b"}
 	c := types.Tenant{Name: "c"}
-	return []types.Tenant{a, b, c}
+	return []types.Tenant{a, b, c}, nil
 }
 
-func (m *mockedConnector) GetVersions() []types.Version {
+func (m *mockedConnector) GetVersions() ([]types.Version, error) {
 	a := types.Version{ID: 12, Name: "a", Created: graphql.Time{Time: time.Now().AddDate(0, 0, -2)}}
 	b := types.Version{ID: 121, Name: "bb", Created: graphql.Time{Time: time.Now().AddDate(0, 0, -1)}}
 	c := types.Version{ID: 122, Name: "ccc", Created: graphql.Time{Time: time.Now()}}
-	return []types.Version{a, b, c}
+	return []types.Version{a, b, c}, nil
 }
 
-func (m *mockedConnector) GetVersionsByFile(file string) []types.Version {
+func (m *mockedConnector) GetVersionsByFile(file string) ([]types.Version, error) {
 	a := types.Version{ID: 12, Name: "a", Created: graphql.Time{Time: time.Now().AddDate(0, 0, -2)}}
-	return []types.Version{a}
+	return []types.Version{a}, nil
 }
 
 func (m *mockedConnector) GetVersionByID(ID int32) (*types.Version, error) {
@@ -143,11 +171,11 @@ func (m *mockedConnector) GetVersionByID(ID int32) (*types.Version, error) {
 	return &a, nil
 }
 
-func (m *mockedConnector) GetAppliedMigrations() []types.DBMigration {
+func (m *mockedConnector) GetAppliedMigrations() ([]types.DBMigration, error) {
 	m1 := types.Migration{Name: "201602220000.sql", SourceDir: "source", File: "source/201602220000.sql", MigrationType: types.MigrationTypeSingleMigration, Contents: "select abc"}
 	d1 := time.Date(2016, 02, 22, 16, 41, 1, 123, time.UTC)
 	ms := []types.DBMigration{{Migration: m1, Schema: "source", Created: graphql.Time{Time: d1}}}
-	return ms
+	return ms, nil
 }
 
 func (m *mockedConnector) GetDBMigrationByID(ID int32) (*types.DBMigration, error) {
@@ -177,17 +205,45 @@ func newMockedConnectorHealthCheckError(context.Context, *config.Config) db.Conn
 	return &mockedConnectorHealthCheckError{}
 }
 
+type mockedConnectorDBError struct {
+	mockedConnector
+}
+
+func (m *mockedConnectorDBError) GetAppliedMigrations() ([]types.DBMigration, error) {
+	return nil, &types.DBError{Message: "Could not query DB migrations", Err: errors.New("trouble maker")}
+}
+
+func newMockedConnectorDBError(context.Context, *config.Config) db.Connector {
+	return &mockedConnectorDBError{}
+}
+
+type mockedConnectorLockError struct {
+	mockedConnector
+}
+
+func (m *mockedConnectorLockError) CreateTenant(types.Tenant, string, types.Action, []types.Migration, bool) (*types.Summary, *types.Version, error) {
+	return nil, nil, &types.LockTimeoutError{Timeout: 30 * time.Second}
+}
+
+func (m *mockedConnectorLockError) CreateVersion(string, types.Action, []types.Migration, bool) (*types.Summary, *types.Version, error) {
+	return nil, nil, &types.LockTimeoutError{Timeout: 30 * time.Second}
+}
+
+func newMockedConnectorLockError(context.Context, *config.Config) db.Connector {
+	return &mockedConnectorLockError{}
+}
+
 type mockedDifferentScriptCheckSumMockedConnector struct {
 	mockedConnector
 }
 
-func (m *mockedDifferentScriptCheckSumMockedConnector) GetAppliedMigrations() []types.DBMigration {
+func (m *mockedDifferentScriptCheckSumMockedConnector) GetAppliedMigrations() ([]types.DBMigration, error) {
 	m1 := types.Migration{Name: "201602220000.sql", SourceDir: "source", File: "source/201602220000.sql", MigrationType: types.MigrationTypeSingleMigration, Contents: "select abc"}
 	d1 := time.Date(2016, 02, 22, 16, 41, 1, 123, time.UTC)
 	m2 := types.Migration{Name: "recreate-indexes.sql", SourceDir: "tenants-scripts", File: "tenants-scripts/recreate-indexes.sql", MigrationType: types.MigrationTypeTenantScript, Contents: "select abc", CheckSum: "sha256-2"}
 	d2 := time.Date(2016, 02, 22, 16, 41, 1, 456, time.UTC)
 	ms := []types.DBMigration{{Migration: m1, Schema: "source", Created: graphql.Time{Time: d1}}, {Migration: m2, Schema: "customer1", Created: graphql.Time{Time: d2}}}
-	return ms
+	return ms, nil
 }
 
 func newDifferentScriptCheckSumMockedConnector(context.Context, *config.Config) db.Connector {
@@ -2,12 +2,19 @@ package coordinator
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	"github.com/graph-gophers/graphql-go"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/lukaszbudnik/migrator/config"
+	"github.com/lukaszbudnik/migrator/loader"
 	"github.com/lukaszbudnik/migrator/types"
 )
 
@@ -95,13 +102,15 @@ func TestComputeMigrationsToApply(t *testing.T) {
 	diskMigrations := []types.Migration{mdef1, mdef2, mdef3, mdef4, mdef5, mdef6, mdef7}
 	dbMigrations := []types.DBMigration{{Migration: mdef1, Schema: "a", Created: graphql.Time{Time: time.Now()}}, {Migration: mdef2, Schema: "abc", Created: graphql.Time{Time: time.Now()}}, {Migration: mdef2, Schema: "def", Created: graphql.Time{Time: time.Now()}}, {Migration: mdef5, Schema: "e", Created: graphql.Time{Time: time.Now()}}, {Migration: mdef6, Schema: "f", Created: graphql.Time{Time: time.Now()}}, {Migration: mdef7, Schema: "abc", Created: graphql.Time{Time: time.Now()}}, {Migration: mdef7, Schema: "def", Created: graphql.Time{Time: time.Now()}}}
 
+	tenants := []types.Tenant{{Name: "abc"}, {Name: "def"}}
+
 	coordinator := &coordinator{
 		ctx:       context.TODO(),
 		connector: newMockedConnector(context.TODO(), nil),
 		loader:    newMockedDiskLoader(context.TODO(), nil),
 		notifier:  newMockedNotifier(context.TODO(), nil),
 	}
-	migrations := coordinator.computeMigrationsToApply(diskMigrations, dbMigrations)
+	migrations := coordinator.computeMigrationsToApply(diskMigrations, dbMigrations, tenants, tenants)
 
 	// that should be 5 now...
 	assert.Len(t, migrations, 5)
@@ -139,13 +148,15 @@ func TestComputeMigrationsToApplyDifferentTimestamps(t *testing.T) {
 	diskMigrations := []types.Migration{mdef1, mdef2, mdef3, dev1, dev1p1, dev1p2, dev2, dev2p}
 	dbMigrations := []types.DBMigration{{Migration: mdef1, Schema: "abc", Created: graphql.Time{Time: time.Now()}}, {Migration: mdef1, Schema: "def", Created: graphql.Time{Time: time.Now()}}, {Migration: mdef2, Schema: "public", Created: graphql.Time{Time: time.Now()}}, {Migration: mdef3, Schema: "public", Created: graphql.Time{Time: time.Now()}}, {Migration: dev2, Schema: "abc", Created: graphql.Time{Time: time.Now()}}, {Migration: dev2, Schema: "def", Created: graphql.Time{Time: time.Now()}}, {Migration: dev2p, Schema: "public", Created: graphql.Time{Time: time.Now()}}}
 
+	tenants := []types.Tenant{{Name: "abc"}, {Name: "def"}}
+
 	coordinator := &coordinator{
 		ctx:       context.TODO(),
 		connector: newMockedConnector(context.TODO(), nil),
 		loader:    newMockedDiskLoader(context.TODO(), nil),
 		notifier:  newMockedNotifier(context.TODO(), nil),
 	}
-	migrations := coordinator.computeMigrationsToApply(diskMigrations, dbMigrations)
+	migrations := coordinator.computeMigrationsToApply(diskMigrations, dbMigrations, tenants, tenants)
 
 	assert.Len(t, migrations, 3)
 
@@ -218,23 +229,54 @@ func TestIntersect(t *testing.T) {
 func TestVerifySourceMigrationsCheckSumsOK(t *testing.T) {
 	coordinator := New(context.TODO(), nil, newNoopMetrics(), newMockedConnector, newMockedDiskLoader, newMockedNotifier)
 	defer coordinator.Dispose()
-	verified, offendingMigrations := coordinator.VerifySourceMigrationsCheckSums()
+	verified, offendingMigrations, err := coordinator.VerifySourceMigrationsCheckSums()
+	assert.Nil(t, err)
 	assert.True(t, verified)
 	assert.Empty(t, offendingMigrations)
 }
 
+type mockedRefresherLoader struct {
+	mockedDiskLoader
+	refreshed bool
+}
+
+func (m *mockedRefresherLoader) Refresh() ([]types.Migration, error) {
+	m.refreshed = true
+	return m.GetSourceMigrations()
+}
+
+func TestRefreshSourceMigrations(t *testing.T) {
+	coordinator := New(context.TODO(), nil, newNoopMetrics(), newMockedConnector, newMockedDiskLoader, newMockedNotifier)
+	defer coordinator.Dispose()
+	migrations, err := coordinator.RefreshSourceMigrations()
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 5)
+
+	refresher := &mockedRefresherLoader{}
+	coordinator = New(context.TODO(), nil, newNoopMetrics(), newMockedConnector, func(context.Context, *config.Config) loader.Loader { return refresher }, newMockedNotifier)
+	defer coordinator.Dispose()
+	migrations, err = coordinator.RefreshSourceMigrations()
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 5)
+	assert.True(t, refresher.refreshed)
+}
+
 func TestVerifySourceMigrationsCheckSumsKO(t *testing.T) {
 	coordinator := New(context.TODO(), nil, newNoopMetrics(), newMockedConnector, newBrokenCheckSumMockedDiskLoader, newMockedNotifier)
 	defer coordinator.Dispose()
-	verified, offendingMigrations := coordinator.VerifySourceMigrationsCheckSums()
+	verified, offendingMigrations, err := coordinator.VerifySourceMigrationsCheckSums()
+	assert.Nil(t, err)
 	assert.False(t, verified)
-	assert.Equal(t, coordinator.GetSourceMigrations(nil)[0], offendingMigrations[0])
+	sourceMigrations, err := coordinator.GetSourceMigrations(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, sourceMigrations[0], offendingMigrations[0])
 }
 
 func TestVerifySourceMigrationsAndScriptsCheckSumsOK(t *testing.T) {
 	coordinator := New(context.TODO(), nil, newNoopMetrics(), newDifferentScriptCheckSumMockedConnector, newDifferentScriptCheckSumMockedDiskLoader, newMockedNotifier)
 	defer coordinator.Dispose()
-	verified, offendingMigrations := coordinator.VerifySourceMigrationsCheckSums()
+	verified, offendingMigrations, err := coordinator.VerifySourceMigrationsCheckSums()
+	assert.Nil(t, err)
 	assert.True(t, verified)
 	assert.Empty(t, offendingMigrations)
 }
@@ -242,7 +284,8 @@ func TestVerifySourceMigrationsAndScriptsCheckSumsOK(t *testing.T) {
 func TestGetTenants(t *testing.T) {
 	coordinator := New(context.TODO(), nil, newNoopMetrics(), newMockedConnector, newMockedDiskLoader, newMockedNotifier)
 	defer coordinator.Dispose()
-	tenants := coordinator.GetTenants()
+	tenants, err := coordinator.GetTenants()
+	assert.Nil(t, err)
 	a := types.Tenant{Name: "a"}
 	b := types.Tenant{Name: "b"}
 	c := types.Tenant{Name: "c"}
@@ -252,7 +295,8 @@ func TestGetTenants(t *testing.T) {
 func TestGetVersions(t *testing.T) {
 	coordinator := New(context.TODO(), nil, newNoopMetrics(), newMockedConnector, newMockedDiskLoader, newMockedNotifier)
 	defer coordinator.Dispose()
-	versions := coordinator.GetVersions()
+	versions, err := coordinator.GetVersions()
+	assert.Nil(t, err)
 
 	assert.Equal(t, int32(12), versions[0].ID)
 	assert.Equal(t, int32(121), versions[1].ID)
@@ -270,7 +314,8 @@ func TestGetVersionByID(t *testing.T) {
 func TestGetVersionsByFile(t *testing.T) {
 	coordinator := New(context.TODO(), nil, newNoopMetrics(), newMockedConnector, newMockedDiskLoader, newMockedNotifier)
 	defer coordinator.Dispose()
-	versions := coordinator.GetVersionsByFile("tenants/abc.sql")
+	versions, err := coordinator.GetVersionsByFile("tenants/abc.sql")
+	assert.Nil(t, err)
 
 	assert.Equal(t, int32(12), versions[0].ID)
 }
@@ -308,7 +353,8 @@ func TestGetSourceMigrationsFilterMigrationType(t *testing.T) {
 	filters := SourceMigrationFilters{
 		MigrationType: &migrationType,
 	}
-	migrations := coordinator.GetSourceMigrations(&filters)
+	migrations, err := coordinator.GetSourceMigrations(&filters)
+	assert.Nil(t, err)
 	assert.True(t, len(migrations) == 4)
 }
 
@@ -321,7 +367,8 @@ func TestGetSourceMigrationsFilterMigrationTypeSourceDir(t *testing.T) {
 		MigrationType: &migrationType,
 		SourceDir:     &sourceDir,
 	}
-	migrations := coordinator.GetSourceMigrations(&filters)
+	migrations, err := coordinator.GetSourceMigrations(&filters)
+	assert.Nil(t, err)
 	assert.True(t, len(migrations) == 3)
 }
 
@@ -334,7 +381,8 @@ func TestGetSourceMigrationsFilterMigrationTypeName(t *testing.T) {
 		MigrationType: &migrationType,
 		Name:          &name,
 	}
-	migrations := coordinator.GetSourceMigrations(&filters)
+	migrations, err := coordinator.GetSourceMigrations(&filters)
+	assert.Nil(t, err)
 	assert.True(t, len(migrations) == 2)
 }
 
@@ -345,28 +393,175 @@ func TestGetSourceMigrationsFilterFile(t *testing.T) {
 	filters := SourceMigrationFilters{
 		File: &file,
 	}
-	migrations := coordinator.GetSourceMigrations(&filters)
+	migrations, err := coordinator.GetSourceMigrations(&filters)
+	assert.Nil(t, err)
 	assert.True(t, len(migrations) == 1)
 }
 
 func TestCreateVersion(t *testing.T) {
 	coordinator := New(context.TODO(), nil, newNoopMetrics(), newMockedConnector, newMockedDiskLoader, newErrorMockedNotifier)
 	defer coordinator.Dispose()
-	results := coordinator.CreateVersion("commit-sha", types.ActionApply, false)
+	results, err := coordinator.CreateVersion("commit-sha", types.ActionApply, false, nil)
+	assert.Nil(t, err)
 	assert.NotNil(t, results)
 	assert.NotNil(t, results.Summary)
 	assert.NotNil(t, results.Version)
 }
 
+func TestCreateVersionLockError(t *testing.T) {
+	coordinator := New(context.TODO(), nil, newNoopMetrics(), newMockedConnectorLockError, newMockedDiskLoader, newErrorMockedNotifier)
+	defer coordinator.Dispose()
+	results, err := coordinator.CreateVersion("commit-sha", types.ActionApply, false, nil)
+	assert.Nil(t, results)
+	assert.Contains(t, err.Error(), "could not acquire migrator lock")
+}
+
+func TestCreateVersionSourceError(t *testing.T) {
+	coordinator := New(context.TODO(), nil, newNoopMetrics(), newMockedConnector, newMockedDiskLoaderSourceError, newErrorMockedNotifier)
+	defer coordinator.Dispose()
+	results, err := coordinator.CreateVersion("commit-sha", types.ActionApply, false, nil)
+	assert.Nil(t, results)
+	var sourceErr *types.SourceError
+	assert.True(t, errors.As(err, &sourceErr))
+	assert.Equal(t, "source", sourceErr.Location)
+}
+
+func TestCreateVersionDBError(t *testing.T) {
+	coordinator := New(context.TODO(), nil, newNoopMetrics(), newMockedConnectorDBError, newMockedDiskLoader, newErrorMockedNotifier)
+	defer coordinator.Dispose()
+	results, err := coordinator.CreateVersion("commit-sha", types.ActionApply, false, nil)
+	assert.Nil(t, results)
+	var dbErr *types.DBError
+	assert.True(t, errors.As(err, &dbErr))
+	assert.Equal(t, "Could not query DB migrations: trouble maker", err.Error())
+}
+
+// mockedSignedLoader returns migrations signed by release-bot except for migration with unsigned index
+type mockedSignedLoader struct {
+	mockedDiskLoader
+	unsigned       int
+	signatureError string
+}
+
+func (m *mockedSignedLoader) GetSourceMigrations() ([]types.Migration, error) {
+	migrations, _ := m.mockedDiskLoader.GetSourceMigrations()
+	signer := "release-bot"
+	for i := range migrations {
+		migrations[i].Signer = &signer
+	}
+	migrations[m.unsigned].Signer = nil
+	migrations[m.unsigned].SignatureError = m.signatureError
+	return migrations, nil
+}
+
+func TestCreateVersionAndTenantSignatureError(t *testing.T) {
+	cfg := &config.Config{SignaturePublicKeys: []string{"release-bot:11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="}}
+
+	tests := []struct {
+		newLoader loader.Factory
+		file      string
+		reason    string
+	}{
+		// migrations loaded by loaders which do not verify signatures are refused
+		{newMockedDiskLoader, "source/201602220001.sql", "migration is not signed"},
+		{func(context.Context, *config.Config) loader.Loader {
+			return &mockedSignedLoader{unsigned: 4, signatureError: "signature does not match any of signature public keys"}
+		}, "tenant/201602220003.sql", "signature does not match any of signature public keys"},
+	}
+
+	for _, test := range tests {
+		coordinator := New(context.TODO(), cfg, newNoopMetrics(), newMockedConnector, test.newLoader, newErrorMockedNotifier)
+		defer coordinator.Dispose()
+
+		results, err := coordinator.CreateVersion("commit-sha", types.ActionApply, false, nil)
+		assert.Nil(t, results)
+		var signatureErr *types.UnverifiedMigrationError
+		assert.True(t, errors.As(err, &signatureErr))
+		assert.Equal(t, test.file, signatureErr.File)
+		assert.Equal(t, test.reason, signatureErr.Reason)
+
+		results, err = coordinator.CreateTenant("commit-sha", types.ActionApply, false, types.Tenant{Name: "NewTenant"})
+		assert.Nil(t, results)
+		assert.True(t, errors.As(err, &signatureErr))
+	}
+}
+
+func TestCreateVersionSigned(t *testing.T) {
+	cfg := &config.Config{SignaturePublicKeys: []string{"release-bot:11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="}}
+	signedLoader := &mockedSignedLoader{unsigned: 0}
+
+	coordinator := New(context.TODO(), cfg, newNoopMetrics(), newMockedConnector, func(context.Context, *config.Config) loader.Loader { return signedLoader }, newErrorMockedNotifier)
+	defer coordinator.Dispose()
+
+	// source/201602220000.sql is not signed but it is already applied
+	results, err := coordinator.CreateVersion("commit-sha", types.ActionApply, false, nil)
+	assert.Nil(t, err)
+	assert.NotNil(t, results.Version)
+}
+
+func TestVerifySourceMigrationsCheckSumsSourceError(t *testing.T) {
+	coordinator := New(context.TODO(), nil, newNoopMetrics(), newMockedConnector, newMockedDiskLoaderSourceError, newErrorMockedNotifier)
+	defer coordinator.Dispose()
+	verified, offendingMigrations, err := coordinator.VerifySourceMigrationsCheckSums()
+	assert.False(t, verified)
+	assert.Nil(t, offendingMigrations)
+	assert.Equal(t, "could not read source migrations from source: trouble maker", err.Error())
+}
+
 func TestCreateTenant(t *testing.T) {
 	coordinator := New(context.TODO(), nil, newNoopMetrics(), newMockedConnector, newMockedDiskLoader, newErrorMockedNotifier)
 	defer coordinator.Dispose()
-	results := coordinator.CreateTenant("commit-sha", types.ActionSync, true, "NewTenant")
+	results, err := coordinator.CreateTenant("commit-sha", types.ActionSync, true, types.Tenant{Name: "NewTenant"})
+	assert.Nil(t, err)
 	assert.NotNil(t, results)
 	assert.NotNil(t, results.Summary)
 	assert.NotNil(t, results.Version)
 }
 
+func TestCreateTenantLockError(t *testing.T) {
+	coordinator := New(context.TODO(), nil, newNoopMetrics(), newMockedConnectorLockError, newMockedDiskLoader, newErrorMockedNotifier)
+	defer coordinator.Dispose()
+	results, err := coordinator.CreateTenant("commit-sha", types.ActionSync, true, types.Tenant{Name: "NewTenant"})
+	assert.Nil(t, results)
+	assert.Contains(t, err.Error(), "could not acquire migrator lock")
+}
+
+func TestRollbackVersion(t *testing.T) {
+	coordinator := New(context.TODO(), nil, newNoopMetrics(), newMockedConnector, newMockedDiskLoader, newErrorMockedNotifier)
+	defer coordinator.Dispose()
+	results, err := coordinator.RollbackVersion(12, "rollback", false)
+	assert.Nil(t, err)
+	assert.NotNil(t, results.Summary)
+	assert.Equal(t, "rollback", results.Version.Name)
+}
+
+func TestRollbackVersionError(t *testing.T) {
+	coordinator := New(context.TODO(), nil, newNoopMetrics(), newMockedConnector, newMockedDiskLoader, newErrorMockedNotifier)
+	defer coordinator.Dispose()
+	results, err := coordinator.RollbackVersion(13, "rollback", false)
+	assert.Nil(t, results)
+	assert.Equal(t, "version not found ID: 13", err.Error())
+}
+
+func TestPlan(t *testing.T) {
+	coordinator := New(context.TODO(), nil, newNoopMetrics(), newMockedConnector, newMockedDiskLoader, newErrorMockedNotifier)
+	defer coordinator.Dispose()
+	plan, err := coordinator.Plan(nil)
+	assert.Nil(t, err)
+	// source/201602220000.sql was already applied
+	assert.Len(t, plan.Migrations, 4)
+	assert.Equal(t, "source/201602220001.sql", plan.Migrations[0].File)
+	assert.Equal(t, "tenant/201602220003.sql", plan.Migrations[3].File)
+}
+
+func TestPlanDBError(t *testing.T) {
+	coordinator := New(context.TODO(), nil, newNoopMetrics(), newMockedConnectorDBError, newMockedDiskLoader, newErrorMockedNotifier)
+	defer coordinator.Dispose()
+	plan, err := coordinator.Plan(nil)
+	assert.Nil(t, plan)
+	assert.Equal(t, "Could not query DB migrations: trouble maker", err.Error())
+}
+
 func TestHealthCheckDBAndLoaderOK(t *testing.T) {
 	coordinator := New(context.TODO(), nil, newNoopMetrics(), newMockedConnector, newMockedDiskLoader, newErrorMockedNotifier)
 	defer coordinator.Dispose()
@@ -399,3 +594,313 @@ func TestHealthCheckLoaderKO(t *testing.T) {
 	assert.Equal(t, "Loader", healthResponse.Checks[1].Name)
 	assert.Equal(t, types.HealthStatusDown, healthResponse.Checks[1].Status)
 }
+
+// coordinator created from config reads migrations using passed loader and applies them to SQLite database
+func TestNewFromConfig(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/ref/201602160001.sql":     {Data: []byte("create table ref_roles (id integer primary key, name text)")},
+		"migrations/tenants/201602160002.sql": {Data: []byte("create table {schema}_settings (k integer, v text)")},
+	}
+	cfg := &config.Config{
+		BaseLocation:     "migrations",
+		Driver:           "sqlite",
+		DataSource:       fmt.Sprintf("file:%v?_foreign_keys=1", filepath.Join(t.TempDir(), "migrator.db")),
+		SingleMigrations: []string{"ref"},
+		TenantMigrations: []string{"tenants"},
+	}
+
+	coordinator, err := NewFromConfig(context.Background(), cfg, Options{Loader: loader.NewFSFactory(fsys)})
+	assert.Nil(t, err)
+	defer coordinator.Dispose()
+
+	results, err := coordinator.CreateTenant("v1", types.ActionApply, false, types.Tenant{Name: "abc"})
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), results.Summary.TenantMigrationsTotal)
+
+	results, err = coordinator.CreateVersion("v2", types.ActionApply, false, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), results.Summary.SingleMigrations)
+	assert.Equal(t, int32(0), results.Summary.TenantMigrationsTotal)
+	assert.Len(t, results.Version.DBMigrations, 1)
+	assert.Equal(t, "migrations/ref/201602160001.sql", results.Version.DBMigrations[0].File)
+
+	verified, _, err := coordinator.VerifySourceMigrationsCheckSums()
+	assert.Nil(t, err)
+	assert.True(t, verified)
+}
+
+func TestNewFromConfigInvalidConfig(t *testing.T) {
+	cfg := &config.Config{
+		BaseLocation:     "migrations",
+		Driver:           "sqlite",
+		SingleMigrations: []string{"ref"},
+	}
+
+	coordinator, err := NewFromConfig(context.Background(), cfg, Options{})
+	assert.Nil(t, coordinator)
+	assert.Contains(t, err.Error(), "Error:Field validation for 'DataSource' failed on the 'required' tag")
+}
+
+func TestSelectTenants(t *testing.T) {
+	coordinator := &coordinator{
+		ctx:       context.TODO(),
+		connector: newMockedConnector(context.TODO(), nil),
+		loader:    newMockedDiskLoader(context.TODO(), nil),
+		notifier:  newMockedNotifier(context.TODO(), nil),
+	}
+	tenants := []types.Tenant{{Name: "abc", Metadata: types.TenantMetadata{"tier": "premium"}}, {Name: "abd"}, {Name: "def", Metadata: types.TenantMetadata{"tier": "premium"}}}
+
+	selected, err := coordinator.selectTenants(tenants, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, tenants, selected)
+
+	names, glob, regex := []string{"def", "abc"}, "ab*", "d$"
+	selected, err = coordinator.selectTenants(tenants, &types.TenantSelector{Names: &names})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"abc", "def"}, tenantNames(selected))
+
+	selected, err = coordinator.selectTenants(tenants, &types.TenantSelector{Glob: &glob})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"abc", "abd"}, tenantNames(selected))
+
+	selected, err = coordinator.selectTenants(tenants, &types.TenantSelector{Regex: &regex})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"abd"}, tenantNames(selected))
+
+	selected, err = coordinator.selectTenants(tenants, &types.TenantSelector{Metadata: &types.TenantMetadata{"tier": "premium"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"abc", "def"}, tenantNames(selected))
+
+	// all criteria must match
+	selected, err = coordinator.selectTenants(tenants, &types.TenantSelector{Glob: &glob, Metadata: &types.TenantMetadata{"tier": "premium"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"abc"}, tenantNames(selected))
+
+	unknown, invalidGlob, invalidRegex := []string{"abc", "xyz"}, "[a", "(a"
+	for selector, expected := range map[*types.TenantSelector]string{
+		{Names: &unknown}:                           "invalid tenant selector: tenant xyz not found",
+		{Glob: &invalidGlob}:                        "invalid tenant selector: invalid glob [a: syntax error in pattern",
+		{Regex: &invalidRegex}:                      "invalid tenant selector: invalid regex (a: error parsing regexp: missing closing ): `(a`",
+		{Regex: &regex, Glob: &glob, Names: &names}: "invalid tenant selector: no tenant matches the selector",
+	} {
+		_, err = coordinator.selectTenants(tenants, selector)
+		assert.IsType(t, &types.TenantSelectorError{}, err)
+		assert.Equal(t, expected, err.Error())
+	}
+}
+
+func TestComputeMigrationsToApplySelectedTenants(t *testing.T) {
+	m1 := types.Migration{Name: "a", SourceDir: "tenants", File: "tenants/a", MigrationType: types.MigrationTypeTenantMigration}
+	m2 := types.Migration{Name: "b", SourceDir: "tenants", File: "tenants/b", MigrationType: types.MigrationTypeTenantMigration}
+	m3 := types.Migration{Name: "c", SourceDir: "tenants-scripts", File: "tenants-scripts/c", MigrationType: types.MigrationTypeTenantScript}
+
+	// m1 was applied to canary tenant abc only
+	dbMigrations := []types.DBMigration{{Migration: m1, Schema: "abc", Created: graphql.Time{Time: time.Now()}}}
+	tenants := []types.Tenant{{Name: "abc"}, {Name: "def"}, {Name: "ghi"}}
+
+	coordinator := &coordinator{
+		ctx:       context.TODO(),
+		connector: newMockedConnector(context.TODO(), nil),
+		loader:    newMockedDiskLoader(context.TODO(), nil),
+		notifier:  newMockedNotifier(context.TODO(), nil),
+	}
+
+	// remaining tenants pick up m1, new migrations are applied to all tenants
+	migrations := coordinator.computeMigrationsToApply([]types.Migration{m1, m2, m3}, dbMigrations, tenants, tenants)
+	assert.Len(t, migrations, 3)
+	assert.Equal(t, []string{"def", "ghi"}, migrations[0].Tenants)
+	assert.Nil(t, migrations[1].Tenants)
+	assert.Nil(t, migrations[2].Tenants)
+
+	// migrations and scripts are applied only to selected tenants
+	migrations = coordinator.computeMigrationsToApply([]types.Migration{m1, m2, m3}, dbMigrations, tenants, tenants[:1])
+	assert.Len(t, migrations, 2)
+	assert.Equal(t, m2.File, migrations[0].File)
+	assert.Equal(t, []string{"abc"}, migrations[0].Tenants)
+	assert.Equal(t, []string{"abc"}, migrations[1].Tenants)
+}
+
+// tenant selector applies tenant migrations to canary tenant only, the next version rolls them out to remaining tenants
+func TestCreateVersionCanary(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/ref/201602160001.sql":     {Data: []byte("create table ref_roles (id integer primary key, name text)")},
+		"migrations/tenants/201602160001.sql": {Data: []byte("create table {schema}_users (id integer primary key)")},
+	}
+	cfg := &config.Config{
+		BaseLocation:     "migrations",
+		Driver:           "sqlite",
+		DataSource:       fmt.Sprintf("file:%v?_foreign_keys=1", filepath.Join(t.TempDir(), "migrator.db")),
+		SingleMigrations: []string{"ref"},
+		TenantMigrations: []string{"tenants"},
+	}
+
+	coordinator, err := NewFromConfig(context.Background(), cfg, Options{Loader: loader.NewFSFactory(fsys)})
+	assert.Nil(t, err)
+	defer coordinator.Dispose()
+
+	for _, name := range []string{"abc", "def", "ghi"} {
+		_, err = coordinator.CreateTenant("create "+name, types.ActionApply, false, types.Tenant{Name: name})
+		assert.Nil(t, err)
+	}
+
+	fsys["migrations/tenants/201602160002.sql"] = &fstest.MapFile{Data: []byte("create table {schema}_settings (k integer, v text)")}
+	_, err = coordinator.RefreshSourceMigrations()
+	assert.Nil(t, err)
+
+	names := []string{"def"}
+	results, err := coordinator.CreateVersion("canary", types.ActionApply, false, &types.TenantSelector{Names: &names})
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), results.Summary.Tenants)
+	assert.Equal(t, int32(1), results.Summary.TenantMigrationsTotal)
+	assert.Equal(t, int32(1), results.Summary.SingleMigrations)
+	assert.Len(t, results.Version.DBMigrations, 2)
+	assert.Equal(t, "def", results.Version.DBMigrations[1].Schema)
+
+	plan, err := coordinator.Plan(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"abc", "ghi"}, plan.Migrations[0].Schemas)
+
+	results, err = coordinator.CreateVersion("rollout", types.ActionApply, false, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), results.Summary.Tenants)
+	assert.Equal(t, int32(2), results.Summary.TenantMigrationsTotal)
+
+	plan, err = coordinator.Plan(nil)
+	assert.Nil(t, err)
+	assert.Len(t, plan.Migrations, 0)
+}
+
+func TestGetTenantStatusNotFound(t *testing.T) {
+	coordinator := &coordinator{
+		ctx:       context.TODO(),
+		connector: newMockedConnector(context.TODO(), nil),
+		loader:    newMockedDiskLoader(context.TODO(), nil),
+		notifier:  newMockedNotifier(context.TODO(), nil),
+	}
+
+	status, err := coordinator.GetTenantStatus("xyz")
+	assert.Nil(t, status)
+	assert.Equal(t, "tenant not found: xyz", err.Error())
+}
+
+// tenants missing migrations applied to other tenants are reported by tenant status and catch up in the next version
+func TestCreateVersionCatchUp(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/ref/201602160001.sql":     {Data: []byte("create table ref_roles (id integer primary key, name text)")},
+		"migrations/tenants/201602160002.sql": {Data: []byte("create table {schema}_settings (k integer, v text)")},
+		"migrations/tenants/201602160003.sql": {Data: []byte("create table {schema}_users (id integer primary key)")},
+	}
+	dataSource := fmt.Sprintf("file:%v?_foreign_keys=1", filepath.Join(t.TempDir(), "migrator.db"))
+	cfg := &config.Config{
+		BaseLocation:     "migrations",
+		Driver:           "sqlite",
+		DataSource:       dataSource,
+		SingleMigrations: []string{"ref"},
+		TenantMigrations: []string{"tenants"},
+	}
+
+	coordinator, err := NewFromConfig(context.Background(), cfg, Options{Loader: loader.NewFSFactory(fsys)})
+	assert.Nil(t, err)
+	defer coordinator.Dispose()
+
+	_, err = coordinator.CreateTenant("create abc", types.ActionApply, false, types.Tenant{Name: "abc"})
+	assert.Nil(t, err)
+	_, err = coordinator.CreateVersion("v1", types.ActionApply, false, nil)
+	assert.Nil(t, err)
+
+	// tenant restored from backup without its latest migration and tenant added outside of migrator
+	db, err := sql.Open("sqlite3", dataSource)
+	assert.Nil(t, err)
+	defer db.Close()
+	_, err = db.Exec("delete from migrator_migrations where db_schema = 'abc' and filename = 'migrations/tenants/201602160003.sql'")
+	assert.Nil(t, err)
+	_, err = db.Exec("drop table abc_users")
+	assert.Nil(t, err)
+	_, err = db.Exec("insert into migrator_tenants (name) values ('def')")
+	assert.Nil(t, err)
+
+	status, err := coordinator.GetTenantStatus("abc")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), status.AppliedMigrations)
+	assert.Equal(t, int32(1), status.Lag)
+	assert.Equal(t, "migrations/tenants/201602160003.sql", status.PendingMigrations[0].File)
+
+	status, err = coordinator.GetTenantStatus("def")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(0), status.AppliedMigrations)
+	assert.Equal(t, int32(2), status.Lag)
+
+	results, err := coordinator.CreateVersion("catch up", types.ActionApply, false, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(0), results.Summary.SingleMigrations)
+	assert.Equal(t, int32(3), results.Summary.TenantMigrationsTotal)
+
+	for _, name := range []string{"abc", "def"} {
+		status, err = coordinator.GetTenantStatus(name)
+		assert.Nil(t, err)
+		assert.Equal(t, int32(2), status.AppliedMigrations)
+		assert.Equal(t, int32(0), status.Lag)
+		assert.Len(t, status.PendingMigrations, 0)
+	}
+}
+
+// migrations of the tenant which failed stay pending and only that tenant is migrated by the next version
+func TestCreateVersionRetryFailedTenant(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/ref/201602160001.sql":     {Data: []byte("create table ref_roles (id integer primary key, name text)")},
+		"migrations/tenants/201602160001.sql": {Data: []byte("create table {schema}_users (id integer primary key)")},
+	}
+	dataSource := fmt.Sprintf("file:%v?_foreign_keys=1", filepath.Join(t.TempDir(), "migrator.db"))
+	cfg := &config.Config{
+		BaseLocation:        "migrations",
+		Driver:              "sqlite",
+		DataSource:          dataSource,
+		SingleMigrations:    []string{"ref"},
+		TenantMigrations:    []string{"tenants"},
+		TransactionScope:    config.TransactionScopeTenant,
+		TenantFailurePolicy: config.TenantFailurePolicyContinue,
+	}
+
+	coordinator, err := NewFromConfig(context.Background(), cfg, Options{Loader: loader.NewFSFactory(fsys)})
+	assert.Nil(t, err)
+	defer coordinator.Dispose()
+
+	for _, name := range []string{"abc", "def", "ghi"} {
+		_, err = coordinator.CreateTenant("create "+name, types.ActionApply, false, types.Tenant{Name: name})
+		assert.Nil(t, err)
+	}
+
+	// new tenant migration fails for def only
+	db, err := sql.Open("sqlite3", dataSource)
+	assert.Nil(t, err)
+	defer db.Close()
+	_, err = db.Exec("create table def_settings (k integer)")
+	assert.Nil(t, err)
+	fsys["migrations/tenants/201602160002.sql"] = &fstest.MapFile{Data: []byte("create table {schema}_settings (k integer, v text)")}
+	_, err = coordinator.RefreshSourceMigrations()
+	assert.Nil(t, err)
+
+	results, err := coordinator.CreateVersion("v1", types.ActionApply, false, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, types.TenantOutcomeSucceeded, results.Summary.TenantResults[0].Outcome)
+	assert.Equal(t, types.TenantOutcomeFailed, results.Summary.TenantResults[1].Outcome)
+	assert.Equal(t, types.TenantOutcomeSucceeded, results.Summary.TenantResults[2].Outcome)
+
+	// only migrations of the failed tenant are pending
+	plan, err := coordinator.Plan(nil)
+	assert.Nil(t, err)
+	assert.Len(t, plan.Migrations, 1)
+	assert.Equal(t, "migrations/tenants/201602160002.sql", plan.Migrations[0].File)
+	assert.Equal(t, []string{"def"}, plan.Migrations[0].Schemas)
+
+	_, err = db.Exec("drop table def_settings")
+	assert.Nil(t, err)
+	results, err = coordinator.CreateVersion("v2", types.ActionApply, false, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), results.Summary.Tenants)
+	assert.Equal(t, int32(1), results.Summary.TenantMigrationsTotal)
+
+	plan, err = coordinator.Plan(nil)
+	assert.Nil(t, err)
+	assert.Len(t, plan.Migrations, 0)
+}
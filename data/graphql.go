@@ -1,7 +1,13 @@
 package data
 
 import (
+	"context"
+	"errors"
+
+	"github.com/graph-gophers/graphql-go"
+
 	"github.com/lukaszbudnik/migrator/coordinator"
+	"github.com/lukaszbudnik/migrator/jobs"
 	"github.com/lukaszbudnik/migrator/types"
 )
 
@@ -25,7 +31,15 @@ enum Action {
   // importing source migrations from a legacy tool or synchronising tenant migrations when tenant was created using external tool
   Sync
 }
+enum JobState {
+  // job is waiting for other jobs to finish
+  Queued
+  Running
+  Succeeded
+  Failed
+}
 scalar Time
+scalar TenantMetadata
 interface Migration {
   name: String!
   migrationType: MigrationType!
@@ -41,6 +55,10 @@ type SourceMigration implements Migration {
   file: String!
   contents: String!
   checkSum: String!
+  // SHA of the commit source migration was loaded from, set only when source migrations are loaded from git repository
+  sourceCommit: String
+  // signer of the public key which verified detached signature of source migration, set only when signaturePublicKeys is configured
+  signer: String
 }
 type DBMigration implements Migration {
   id: Int!
@@ -52,15 +70,21 @@ type DBMigration implements Migration {
   checkSum: String!
   schema: String!
   created: Time!
+  // SHA of the commit migration was loaded from, set only when migration was loaded from git repository
+  sourceCommit: String
 }
 type Tenant {
   name: String!
+  // values of tenantMetadataColumns (or additional columns returned by custom tenantSelectSQL)
+  metadata: TenantMetadata!
 }
 type Version {
   id: Int!
   name: String!
   created: Time!
   dbMigrations: [DBMigration!]!
+  // SHA of the commit migrations were loaded from, set only when migrations were loaded from git repository
+  sourceCommit: String
 }
 input SourceMigrationFilters {
   name: String
@@ -68,23 +92,49 @@ input SourceMigrationFilters {
   file: String
   migrationType: MigrationType
 }
+input TenantSelector {
+  // names of tenants
+  names: [String!]
+  // glob pattern matched against tenant names, for example: canary-*
+  glob: String
+  // regular expression matched against tenant names, for example: ^eu-
+  regex: String
+  // tenants whose metadata contains all passed key/value pairs
+  metadata: TenantMetadata
+}
 input VersionInput {
   versionName: String!
   action: Action = Apply
   dryRun: Boolean = false
+  // tenant migrations and scripts are applied only to selected tenants, all set criteria must match
+  // remaining tenants pick up tenant migrations on the next createVersion
+  tenants: TenantSelector
+  // when true the operation is run in background and only job is returned, use job(id: ID!) to poll its state
+  async: Boolean = false
 }
 input TenantInput {
   tenantName: String!
+  // JSON object with string values, keys must be listed in tenantMetadataColumns
+  metadata: TenantMetadata
   versionName: String!
   action: Action = Apply
   dryRun: Boolean = false
+  // when true the operation is run in background and only job is returned, use job(id: ID!) to poll its state
+  async: Boolean = false
+}
+input RollbackVersionInput {
+  // id of the version to rollback
+  id: Int!
+  // name of the new version which records executed down migrations
+  versionName: String!
+  dryRun: Boolean = false
 }
 type Summary {
   // date time operation started
   startedAt: Time!
   // how long the operation took in seconds
   duration: Float!
-  // number of tenants in the system
+  // number of tenants migrations were applied to, all tenants unless tenant migrations and scripts were applied only to selected tenants
   tenants: Int!
   // number of loaded and applied single schema migrations
   singleMigrations: Int!
@@ -102,10 +152,91 @@ type Summary {
   tenantScriptsTotal: Int!
   // sum of singleScripts and tenantScriptsTotal
   scriptsGrandTotal: Int!
+  // outcome of migrating every tenant, empty when all tenants are migrated in the version transaction (see transactionScope config option)
+  tenantResults: [TenantResult!]!
+}
+enum TenantOutcome {
+  Succeeded
+  // migrating tenant failed, failed transaction was rolled back
+  Failed
+  // tenant was not migrated because migrating another tenant failed and tenantFailurePolicy is stop
+  Skipped
+}
+type TenantResult {
+  name: String!
+  outcome: TenantOutcome!
+  error: String
 }
 type CreateResults {
-  summary: Summary!
+  // summary is null when operation is run asynchronously
+  summary: Summary
   version: Version
+  // job is set only when operation is run asynchronously
+  job: Job
+}
+type PlannedStatement {
+  schema: String!
+  // migration contents with schema placeholder replaced by schema
+  sql: String!
+}
+type PlannedMigration implements Migration {
+  name: String!
+  migrationType: MigrationType!
+  sourceDir: String!
+  file: String!
+  contents: String!
+  checkSum: String!
+  sourceCommit: String
+  signer: String
+  // schemas migration would be applied to, single schema migrations and scripts have exactly one schema
+  schemas: [String!]!
+  // SQL which would be executed for every schema
+  statements: [PlannedStatement!]!
+  // true when migration is applied outside of a transaction (see -- migrator:no-transaction directive)
+  noTransaction: Boolean!
+}
+type Plan {
+  // number of tenants pending migrations would be applied to, all tenants unless tenant migrations and scripts target only selected tenants
+  tenants: Int!
+  // counters have the same meaning as in Summary
+  singleMigrations: Int!
+  tenantMigrations: Int!
+  tenantMigrationsTotal: Int!
+  migrationsGrandTotal: Int!
+  singleScripts: Int!
+  tenantScripts: Int!
+  tenantScriptsTotal: Int!
+  scriptsGrandTotal: Int!
+  // pending migrations and scripts in the order they would be applied
+  migrations: [PlannedMigration!]!
+}
+type TenantStatus {
+  name: String!
+  metadata: TenantMetadata!
+  // number of tenant migrations applied to tenant schema
+  appliedMigrations: Int!
+  // tenant migrations not yet applied to tenant schema in the order they would be applied
+  pendingMigrations: [SourceMigration!]!
+  // number of pending tenant migrations, 0 when tenant is up to date
+  lag: Int!
+}
+type Job {
+  id: ID!
+  // createVersion or createTenant
+  operation: String!
+  state: JobState!
+  created: Time!
+  started: Time
+  finished: Time
+  // number of migrations and scripts applied so far to all schemas
+  migrationsApplied: Int!
+  // number of migrations and scripts to be applied to all schemas, set once job is running
+  migrationsTotal: Int!
+  // results of the operation, set when job succeeded
+  results: CreateResults
+  // error message and error code (see Errors section in the documentation), set when job failed
+  error: String
+  errorCode: String
 }
 type Query {
   // returns array of SourceMigration objects
@@ -134,24 +265,44 @@ type Query {
   dbMigration(id: Int!): DBMigration
   // returns array of Tenant objects
   tenants(): [Tenant!]!
+  // returns migrations and scripts which createVersion would apply, together with their target schemas and SQL
+  // nothing is executed, unlike createVersion with dryRun: true this operation does not start a transaction
+  // tenants selects tenants the same way as tenants of VersionInput
+  plan(tenants: TenantSelector): Plan!
+  // returns tenant migrations applied to and pending for a single tenant
+  // pending tenant migrations are applied by the next createVersion
+  tenantStatus(name: String!): TenantStatus
+  // returns a single asynchronous Job
+  // id is the unique identifier of a job returned by createVersion or createTenant mutations run with async: true
+  job(id: ID!): Job
+  // returns array of asynchronous Job objects, the most recent jobs first
+  // jobs are kept in memory, restarting migrator removes all jobs
+  jobs(): [Job!]!
 }
 type Mutation {
   // creates new DB version by applying all eligible DB migrations & scripts
   createVersion(input: VersionInput!): CreateResults!
   // creates new tenant by applying only tenant-specific DB migrations & scripts, also creates new DB version
   createTenant(input: TenantInput!): CreateResults!
+  // rolls back DB version by running its down migrations in reverse order for every schema, also creates new DB version
+  // scripts are not rolled back, migrations without down migrations cannot be rolled back
+  // only the latest version with applied migrations can be rolled back, the rolled back version is kept for audit
+  rollbackVersion(input: RollbackVersionInput!): CreateResults!
+  // drops source migrations cached by migrator (see sourceCacheTTL) and reads them again, returns all source migrations
+  refreshSourceMigrations(): [SourceMigration!]!
 }
 `
 
 // RootResolver is resolver for all the migrator data
+// JobManager is optional, without it asynchronous operations are not supported
 type RootResolver struct {
 	Coordinator coordinator.Coordinator
+	JobManager  jobs.Manager
 }
 
 // Tenants resolves all tenants
 func (r *RootResolver) Tenants() ([]types.Tenant, error) {
-	tenants := r.Coordinator.GetTenants()
-	return tenants, nil
+	return r.Coordinator.GetTenants()
 }
 
 // Versions resoves all versions, optionally can return versions with specific source migration (file is the identifier for source migrations)
@@ -159,9 +310,9 @@ func (r *RootResolver) Versions(args struct {
 	File *string
 }) ([]types.Version, error) {
 	if args.File != nil {
-		return r.Coordinator.GetVersionsByFile(*args.File), nil
+		return r.Coordinator.GetVersionsByFile(*args.File)
 	}
-	return r.Coordinator.GetVersions(), nil
+	return r.Coordinator.GetVersions()
 }
 
 // Version resolves version by ID
@@ -175,8 +326,7 @@ func (r *RootResolver) Version(args struct {
 func (r *RootResolver) SourceMigrations(args struct {
 	Filters *coordinator.SourceMigrationFilters
 }) ([]types.Migration, error) {
-	sourceMigrations := r.Coordinator.GetSourceMigrations(args.Filters)
-	return sourceMigrations, nil
+	return r.Coordinator.GetSourceMigrations(args.Filters)
 }
 
 // SourceMigration resolves source migration by its file name
@@ -186,6 +336,11 @@ func (r *RootResolver) SourceMigration(args struct {
 	return r.Coordinator.GetSourceMigrationByFile(args.File)
 }
 
+// RefreshSourceMigrations drops cached source migrations and reads them again
+func (r *RootResolver) RefreshSourceMigrations() ([]types.Migration, error) {
+	return r.Coordinator.RefreshSourceMigrations()
+}
+
 // DBMigration resolves DB migration by ID
 func (r *RootResolver) DBMigration(args struct {
 	ID int32
@@ -193,18 +348,79 @@ func (r *RootResolver) DBMigration(args struct {
 	return r.Coordinator.GetDBMigrationByID(args.ID)
 }
 
-// CreateVersion creates new DB version
-func (r *RootResolver) CreateVersion(args struct {
+// Plan resolves migrations which would be applied when creating new version
+func (r *RootResolver) Plan(args struct {
+	Tenants *types.TenantSelector
+}) (*types.Plan, error) {
+	return r.Coordinator.Plan(args.Tenants)
+}
+
+// TenantStatus resolves applied and pending tenant migrations of a tenant
+func (r *RootResolver) TenantStatus(args struct {
+	Name string
+}) (*types.TenantStatus, error) {
+	return r.Coordinator.GetTenantStatus(args.Name)
+}
+
+// Job resolves asynchronous job by ID
+func (r *RootResolver) Job(args struct {
+	ID graphql.ID
+}) *types.Job {
+	if r.JobManager == nil {
+		return nil
+	}
+	return r.JobManager.GetJob(args.ID)
+}
+
+// Jobs resolves all asynchronous jobs
+func (r *RootResolver) Jobs() []types.Job {
+	if r.JobManager == nil {
+		return []types.Job{}
+	}
+	return r.JobManager.GetJobs()
+}
+
+// CreateVersion creates new DB version, when async is set the operation is run in background
+func (r *RootResolver) CreateVersion(ctx context.Context, args struct {
 	Input types.VersionInput
 }) (*types.CreateResults, error) {
-	results := r.Coordinator.CreateVersion(args.Input.VersionName, args.Input.Action, args.Input.DryRun)
-	return results, nil
+	input := args.Input
+	if input.Async {
+		return r.submit(ctx, "createVersion", func(c coordinator.Coordinator) (*types.CreateResults, error) {
+			return c.CreateVersion(input.VersionName, input.Action, input.DryRun, input.Tenants)
+		})
+	}
+	return r.Coordinator.CreateVersion(input.VersionName, input.Action, input.DryRun, input.Tenants)
 }
 
-// CreateTenant creates new tenant
-func (r *RootResolver) CreateTenant(args struct {
+// CreateTenant creates new tenant, when async is set the operation is run in background
+func (r *RootResolver) CreateTenant(ctx context.Context, args struct {
 	Input types.TenantInput
 }) (*types.CreateResults, error) {
-	results := r.Coordinator.CreateTenant(args.Input.VersionName, args.Input.Action, args.Input.DryRun, args.Input.TenantName)
-	return results, nil
+	input := args.Input
+	tenant := types.Tenant{Name: input.TenantName}
+	if input.Metadata != nil {
+		tenant.Metadata = *input.Metadata
+	}
+	if input.Async {
+		return r.submit(ctx, "createTenant", func(c coordinator.Coordinator) (*types.CreateResults, error) {
+			return c.CreateTenant(input.VersionName, input.Action, input.DryRun, tenant)
+		})
+	}
+	return r.Coordinator.CreateTenant(input.VersionName, input.Action, input.DryRun, tenant)
+}
+
+func (r *RootResolver) submit(ctx context.Context, name string, operation jobs.Operation) (*types.CreateResults, error) {
+	if r.JobManager == nil {
+		return nil, errors.New("asynchronous operations are not supported")
+	}
+	job := r.JobManager.Submit(ctx, name, operation)
+	return &types.CreateResults{Job: &job}, nil
+}
+
+// RollbackVersion rolls back DB version
+func (r *RootResolver) RollbackVersion(args struct {
+	Input types.RollbackVersionInput
+}) (*types.CreateResults, error) {
+	return r.Coordinator.RollbackVersion(args.Input.ID, args.Input.VersionName, args.Input.DryRun)
 }
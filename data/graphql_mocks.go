@@ -1,11 +1,15 @@
 package data
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"strings"
 	"time"
 
 	"github.com/graph-gophers/graphql-go"
 	"github.com/lukaszbudnik/migrator/coordinator"
+	"github.com/lukaszbudnik/migrator/jobs"
 	"github.com/lukaszbudnik/migrator/types"
 )
 
@@ -19,18 +23,73 @@ func (m *mockedCoordinator) safeString(value *string) string {
 	return *value
 }
 
-func (m *mockedCoordinator) CreateTenant(string, types.Action, bool, string) *types.CreateResults {
+func (m *mockedCoordinator) CreateTenant(versionName string, action types.Action, dryRun bool, tenant types.Tenant) (*types.CreateResults, error) {
+	if versionName == "locked" {
+		return nil, &types.LockTimeoutError{Timeout: 30 * time.Second}
+	}
+	for key := range tenant.Metadata {
+		if key != "tier" {
+			return nil, fmt.Errorf("unknown tenant metadata: %v", key)
+		}
+	}
 	version, _ := m.GetVersionByID(0)
-	return &types.CreateResults{Summary: &types.Summary{}, Version: version}
+	return &types.CreateResults{Summary: &types.Summary{}, Version: version}, nil
 }
 
-func (m *mockedCoordinator) CreateVersion(string, types.Action, bool) *types.CreateResults {
+func (m *mockedCoordinator) CreateVersion(versionName string, action types.Action, dryRun bool, selector *types.TenantSelector) (*types.CreateResults, error) {
+	if versionName == "locked" {
+		return nil, &types.LockTimeoutError{Timeout: 30 * time.Second}
+	}
+	if versionName == "broken" {
+		return nil, &types.MigrationError{File: "tenants/202002180000.sql", Schema: "abc", DBErrorCode: "42P01", Err: errors.New(`relation "abc.xyz" does not exist`)}
+	}
+	if versionName == "partial" {
+		message := `SQL migration tenants/202002180000.sql failed for schema def with error: relation "def.xyz" does not exist`
+		tenantResults := []types.TenantResult{{Name: "abc", Outcome: types.TenantOutcomeSucceeded}, {Name: "def", Outcome: types.TenantOutcomeFailed, Error: &message}, {Name: "ghi", Outcome: types.TenantOutcomeSkipped}}
+		return &types.CreateResults{Summary: &types.Summary{Tenants: 3, TenantResults: tenantResults}}, nil
+	}
+	if selector != nil && selector.Names != nil {
+		return &types.CreateResults{Summary: &types.Summary{Tenants: int32(len(*selector.Names))}}, nil
+	}
 	// re-use mocked version from GetVersionByID...
 	version, _ := m.GetVersionByID(0)
-	return &types.CreateResults{Summary: &types.Summary{}, Version: version}
+	return &types.CreateResults{Summary: &types.Summary{}, Version: version}, nil
+}
+
+func (m *mockedCoordinator) RollbackVersion(ID int32, versionName string, dryRun bool) (*types.CreateResults, error) {
+	if ID != 12 {
+		return nil, fmt.Errorf("version not found ID: %v", ID)
+	}
+	version := &types.Version{ID: 123, Name: versionName, Created: graphql.Time{Time: time.Now()}}
+	return &types.CreateResults{Summary: &types.Summary{VersionID: 123, SingleMigrations: 1}, Version: version}, nil
+}
+
+func (m *mockedCoordinator) Plan(selector *types.TenantSelector) (*types.Plan, error) {
+	m1 := types.Migration{Name: "201602220003.sql", SourceDir: "tenant", File: "tenant/201602220003.sql", MigrationType: types.MigrationTypeTenantMigration, Contents: "create table {schema}.abc (id int)"}
+	statements := []types.PlannedStatement{{Schema: "a", SQL: "create table a.abc (id int)"}, {Schema: "b", SQL: "create table b.abc (id int)"}}
+	if selector != nil && selector.Glob != nil {
+		if *selector.Glob != "a*" {
+			return nil, &types.TenantSelectorError{Reason: "no tenant matches the selector"}
+		}
+		statements = statements[:1]
+	}
+	schemas := []string{}
+	for _, s := range statements {
+		schemas = append(schemas, s.Schema)
+	}
+	planned := types.PlannedMigration{Migration: m1, Schemas: schemas, Statements: statements}
+	return &types.Plan{Tenants: int32(len(schemas)), TenantMigrations: 1, TenantMigrationsTotal: int32(len(schemas)), MigrationsGrandTotal: int32(len(schemas)), Migrations: []types.PlannedMigration{planned}}, nil
+}
+
+func (m *mockedCoordinator) GetTenantStatus(name string) (*types.TenantStatus, error) {
+	if name != "b" {
+		return nil, fmt.Errorf("tenant not found: %v", name)
+	}
+	m1 := types.Migration{Name: "201602220003.sql", SourceDir: "tenant", File: "tenant/201602220003.sql", MigrationType: types.MigrationTypeTenantMigration, Contents: "create table {schema}.abc (id int)"}
+	return &types.TenantStatus{Name: name, AppliedMigrations: 2, PendingMigrations: []types.Migration{m1}, Lag: 1}, nil
 }
 
-func (m *mockedCoordinator) GetSourceMigrations(filters *coordinator.SourceMigrationFilters) []types.Migration {
+func (m *mockedCoordinator) GetSourceMigrations(filters *coordinator.SourceMigrationFilters) ([]types.Migration, error) {
 
 	if filters == nil {
 		m1 := types.Migration{Name: "201602220000.sql", SourceDir: "source", File: "source/201602220000.sql", MigrationType: types.MigrationTypeSingleMigration, Contents: "select abc"}
@@ -38,41 +97,51 @@ func (m *mockedCoordinator) GetSourceMigrations(filters *coordinator.SourceMigra
 		m3 := types.Migration{Name: "201602220001.sql", SourceDir: "config", File: "config/201602220001.sql", MigrationType: types.MigrationTypeSingleMigration, Contents: "select def"}
 		m4 := types.Migration{Name: "201602220002.sql", SourceDir: "source", File: "source/201602220002.sql", MigrationType: types.MigrationTypeSingleMigration, Contents: "select def"}
 		m5 := types.Migration{Name: "201602220003.sql", SourceDir: "tenant", File: "tenant/201602220003.sql", MigrationType: types.MigrationTypeTenantMigration, Contents: "select def"}
-		return []types.Migration{m1, m2, m3, m4, m5}
+		return []types.Migration{m1, m2, m3, m4, m5}, nil
+	}
+
+	if filters.SourceDir != nil && *filters.SourceDir == "unreadable" {
+		return nil, &types.SourceError{Location: "unreadable", Err: errors.New("permission denied")}
 	}
 
 	m1 := types.Migration{Name: m.safeString(filters.Name), SourceDir: m.safeString(filters.SourceDir), File: m.safeString(filters.File), MigrationType: types.MigrationTypeSingleMigration, Contents: "select abc"}
-	return []types.Migration{m1}
+	return []types.Migration{m1}, nil
 }
 
 func (m *mockedCoordinator) GetSourceMigrationByFile(file string) (*types.Migration, error) {
 	i := strings.Index(file, "/")
 	sourceDir := file[:i]
 	name := file[i+1:]
-	m1 := types.Migration{Name: name, SourceDir: sourceDir, File: file, MigrationType: types.MigrationTypeSingleMigration, Contents: "select abc"}
+	sourceCommit := "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+	signer := "release-bot"
+	m1 := types.Migration{Name: name, SourceDir: sourceDir, File: file, MigrationType: types.MigrationTypeSingleMigration, Contents: "select abc", SourceCommit: &sourceCommit, Signer: &signer}
 	return &m1, nil
 }
 
+func (m *mockedCoordinator) RefreshSourceMigrations() ([]types.Migration, error) {
+	return m.GetSourceMigrations(nil)
+}
+
 func (m *mockedCoordinator) Dispose() {
 }
 
-func (m *mockedCoordinator) GetTenants() []types.Tenant {
-	a := types.Tenant{Name: "a"}
+func (m *mockedCoordinator) GetTenants() ([]types.Tenant, error) {
+	a := types.Tenant{Name: "a", Metadata: types.TenantMetadata{"tier": "premium"}}
 	b := types.Tenant{Name: "b"}
 	c := types.Tenant{Name: "c"}
-	return []types.Tenant{a, b, c}
+	return []types.Tenant{a, b, c}, nil
 }
 
-func (m *mockedCoordinator) GetVersions() []types.Version {
+func (m *mockedCoordinator) GetVersions() ([]types.Version, error) {
 	a := types.Version{ID: 12, Name: "a", Created: graphql.Time{Time: time.Now().AddDate(0, 0, -2)}}
 	b := types.Version{ID: 121, Name: "bb", Created: graphql.Time{Time: time.Now().AddDate(0, 0, -1)}}
 	c := types.Version{ID: 122, Name: "ccc", Created: graphql.Time{Time: time.Now()}}
-	return []types.Version{a, b, c}
+	return []types.Version{a, b, c}, nil
 }
 
-func (m *mockedCoordinator) GetVersionsByFile(file string) []types.Version {
+func (m *mockedCoordinator) GetVersionsByFile(file string) ([]types.Version, error) {
 	a := types.Version{ID: 12, Name: "a", Created: graphql.Time{Time: time.Now().AddDate(0, 0, -2)}}
-	return []types.Version{a}
+	return []types.Version{a}, nil
 }
 
 func (m *mockedCoordinator) GetVersionByID(ID int32) (*types.Version, error) {
@@ -96,8 +165,8 @@ func (m *mockedCoordinator) GetVersionByID(ID int32) (*types.Version, error) {
 }
 
 // not used in GraphQL
-func (m *mockedCoordinator) GetAppliedMigrations() []types.DBMigration {
-	return []types.DBMigration{}
+func (m *mockedCoordinator) GetAppliedMigrations() ([]types.DBMigration, error) {
+	return []types.DBMigration{}, nil
 }
 
 func (m *mockedCoordinator) GetDBMigrationByID(ID int32) (*types.DBMigration, error) {
@@ -107,10 +176,48 @@ func (m *mockedCoordinator) GetDBMigrationByID(ID int32) (*types.DBMigration, er
 	return &db, nil
 }
 
-func (m *mockedCoordinator) VerifySourceMigrationsCheckSums() (bool, []types.Migration) {
-	return true, nil
+func (m *mockedCoordinator) VerifySourceMigrationsCheckSums() (bool, []types.Migration, error) {
+	return true, nil, nil
 }
 
 func (m *mockedCoordinator) HealthCheck() types.HealthResponse {
 	return types.HealthResponse{Status: types.HealthStatusUp, Checks: []types.HealthChecks{}}
 }
+
+// mockedJobManager runs operations synchronously, Submit returns Queued job but the stored job is already finished
+type mockedJobManager struct {
+	jobs []types.Job
+}
+
+func (m *mockedJobManager) Submit(ctx context.Context, name string, operation jobs.Operation) types.Job {
+	id := graphql.ID(fmt.Sprintf("job-%v", len(m.jobs)+1))
+	created := graphql.Time{Time: time.Now()}
+	job := types.Job{ID: id, Operation: name, State: types.JobStateQueued, Created: created}
+
+	finished := job
+	finished.Started, finished.Finished = &created, &created
+	results, err := operation(&mockedCoordinator{})
+	if err != nil {
+		message, code := err.Error(), fmt.Sprint(err.(*types.LockTimeoutError).Extensions()["code"])
+		finished.State, finished.Error, finished.ErrorCode = types.JobStateFailed, &message, &code
+	} else {
+		finished.State, finished.Results = types.JobStateSucceeded, results
+		finished.MigrationsApplied, finished.MigrationsTotal = 3, 3
+	}
+	m.jobs = append([]types.Job{finished}, m.jobs...)
+
+	return job
+}
+
+func (m *mockedJobManager) GetJob(id graphql.ID) *types.Job {
+	for _, job := range m.jobs {
+		if job.ID == id {
+			return &job
+		}
+	}
+	return nil
+}
+
+func (m *mockedJobManager) GetJobs() []types.Job {
+	return m.jobs
+}
@@ -8,6 +8,9 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/graph-gophers/graphql-go"
+
+	"github.com/lukaszbudnik/migrator/coordinator"
+	"github.com/lukaszbudnik/migrator/types"
 )
 
 func TestTenants(t *testing.T) {
@@ -20,6 +23,7 @@ func TestTenants(t *testing.T) {
 	query := `query Tenants {
       tenants {
         name
+        metadata
       }
     }`
 	variables := map[string]interface{}{}
@@ -28,8 +32,11 @@ func TestTenants(t *testing.T) {
 	jsonMap := make(map[string]interface{})
 	err := json.Unmarshal(resp.Data, &jsonMap)
 	assert.Nil(t, err)
-	results := len(jsonMap["tenants"].([]interface{}))
-	assert.Equal(t, 3, results)
+	tenants := jsonMap["tenants"].([]interface{})
+	assert.Equal(t, 3, len(tenants))
+	assert.Equal(t, map[string]interface{}{"tier": "premium"}, tenants[0].(map[string]interface{})["metadata"])
+	// tenants without metadata return empty object
+	assert.Equal(t, map[string]interface{}{}, tenants[1].(map[string]interface{})["metadata"])
 }
 
 func TestVersions(t *testing.T) {
@@ -301,7 +308,9 @@ func TestSourceMigration(t *testing.T) {
 	      name,
 	      migrationType,
 	      sourceDir,
-	    	file
+	    	file,
+	      sourceCommit,
+	      signer
 	    }
   }`
 	variables := map[string]interface{}{
@@ -317,11 +326,36 @@ func TestSourceMigration(t *testing.T) {
 	assert.NotNil(t, "201602220001.sql", results["name"])
 	assert.NotNil(t, "SingleMigration", results["migrationType"])
 	assert.NotNil(t, "config", results["sourceDir"])
-	// we return only 4 fields in above query others should be nil
+	assert.Equal(t, "4b825dc642cb6eb9a060e54bf8d69288fbee4904", results["sourceCommit"])
+	assert.Equal(t, "release-bot", results["signer"])
+	// we return only 6 fields in above query others should be nil
 	assert.Nil(t, results["contents"])
 	assert.Nil(t, results["checkSum"])
 }
 
+func TestRefreshSourceMigrations(t *testing.T) {
+	ctx := context.Background()
+
+	opts := []graphql.SchemaOpt{graphql.UseFieldResolvers()}
+	schema := graphql.MustParseSchema(SchemaDefinition, &RootResolver{Coordinator: &mockedCoordinator{}}, opts...)
+
+	opName := "RefreshSourceMigrations"
+	query := `mutation RefreshSourceMigrations {
+  refreshSourceMigrations {
+    file
+  }
+}`
+
+	resp := schema.Exec(ctx, query, opName, nil)
+	assert.Nil(t, resp.Errors)
+	jsonMap := make(map[string]interface{})
+	err := json.Unmarshal(resp.Data, &jsonMap)
+	assert.Nil(t, err)
+	results := jsonMap["refreshSourceMigrations"].([]interface{})
+	assert.Len(t, results, 5)
+	assert.Equal(t, "source/201602220000.sql", results[0].(map[string]interface{})["file"])
+}
+
 func TestDBMigration(t *testing.T) {
 	ctx := context.Background()
 
@@ -634,3 +668,637 @@ func TestCreateTenantNonDefaultParams(t *testing.T) {
 	// we return only 4 fields in above query others should be nil including duration
 	assert.Nil(t, summary["duration"])
 }
+
+func TestCreateTenantMetadata(t *testing.T) {
+	ctx := context.Background()
+
+	opts := []graphql.SchemaOpt{graphql.UseFieldResolvers()}
+	schema := graphql.MustParseSchema(SchemaDefinition, &RootResolver{Coordinator: &mockedCoordinator{}}, opts...)
+
+	opName := "CreateTenant"
+	query := `mutation CreateTenant($input: TenantInput!) {
+  createTenant(input: $input) {
+    version {
+      id
+    }
+  }
+}`
+	variables := map[string]interface{}{
+		"input": map[string]interface{}{
+			"versionName": "commit-sha",
+			"tenantName":  "new-tenant",
+			"metadata":    map[string]interface{}{"tier": "premium"},
+		},
+	}
+
+	resp := schema.Exec(ctx, query, opName, variables)
+	assert.Nil(t, resp.Errors)
+
+	// metadata is passed to coordinator
+	variables["input"].(map[string]interface{})["metadata"] = map[string]interface{}{"plan": "premium"}
+	resp = schema.Exec(ctx, query, opName, variables)
+	assert.NotNil(t, resp.Errors)
+	assert.Equal(t, "unknown tenant metadata: plan", resp.Errors[0].Message)
+
+	// metadata values must be strings
+	variables["input"].(map[string]interface{})["metadata"] = map[string]interface{}{"tier": 1}
+	resp = schema.Exec(ctx, query, opName, variables)
+	assert.NotNil(t, resp.Errors)
+}
+
+func TestCreateVersionLockError(t *testing.T) {
+	ctx := context.Background()
+
+	opts := []graphql.SchemaOpt{graphql.UseFieldResolvers()}
+	schema := graphql.MustParseSchema(SchemaDefinition, &RootResolver{Coordinator: &mockedCoordinator{}}, opts...)
+
+	opName := "CreateVersion"
+	query := `mutation CreateVersion($input: VersionInput!) {
+  createVersion(input: $input) {
+    version {
+      id
+    }
+  }
+}`
+	variables := map[string]interface{}{
+		"input": map[string]interface{}{
+			"versionName": "locked",
+		},
+	}
+
+	resp := schema.Exec(ctx, query, opName, variables)
+	assert.NotNil(t, resp.Errors)
+	assert.Equal(t, "could not acquire migrator lock within 30s, another migrator operation is in progress", resp.Errors[0].Message)
+	assert.Equal(t, types.ErrorCodeLockTimeout, resp.Errors[0].Extensions["code"])
+	assert.Equal(t, "30s", resp.Errors[0].Extensions["timeout"])
+}
+
+func TestCreateTenantLockError(t *testing.T) {
+	ctx := context.Background()
+
+	opts := []graphql.SchemaOpt{graphql.UseFieldResolvers()}
+	schema := graphql.MustParseSchema(SchemaDefinition, &RootResolver{Coordinator: &mockedCoordinator{}}, opts...)
+
+	opName := "CreateTenant"
+	query := `mutation CreateTenant($input: TenantInput!) {
+  createTenant(input: $input) {
+    version {
+      id
+    }
+  }
+}`
+	variables := map[string]interface{}{
+		"input": map[string]interface{}{
+			"versionName": "locked",
+			"tenantName":  "new-tenant",
+		},
+	}
+
+	resp := schema.Exec(ctx, query, opName, variables)
+	assert.NotNil(t, resp.Errors)
+	assert.Equal(t, "could not acquire migrator lock within 30s, another migrator operation is in progress", resp.Errors[0].Message)
+	assert.Equal(t, types.ErrorCodeLockTimeout, resp.Errors[0].Extensions["code"])
+	assert.Equal(t, "30s", resp.Errors[0].Extensions["timeout"])
+}
+
+func TestCreateVersionMigrationError(t *testing.T) {
+	ctx := context.Background()
+
+	opts := []graphql.SchemaOpt{graphql.UseFieldResolvers()}
+	schema := graphql.MustParseSchema(SchemaDefinition, &RootResolver{Coordinator: &mockedCoordinator{}}, opts...)
+
+	opName := "CreateVersion"
+	query := `mutation CreateVersion($input: VersionInput!) {
+  createVersion(input: $input) {
+    version {
+      id
+    }
+  }
+}`
+	variables := map[string]interface{}{
+		"input": map[string]interface{}{
+			"versionName": "broken",
+		},
+	}
+
+	resp := schema.Exec(ctx, query, opName, variables)
+	assert.NotNil(t, resp.Errors)
+
+	// clients receive error details in extensions
+	jsonResp, err := json.Marshal(resp)
+	assert.Nil(t, err)
+	jsonMap := make(map[string]interface{})
+	err = json.Unmarshal(jsonResp, &jsonMap)
+	assert.Nil(t, err)
+	respErrors := jsonMap["errors"].([]interface{})
+	extensions := respErrors[0].(map[string]interface{})["extensions"].(map[string]interface{})
+	assert.Equal(t, "MIGRATION_FAILED", extensions["code"])
+	assert.Equal(t, "tenants/202002180000.sql", extensions["file"])
+	assert.Equal(t, "abc", extensions["schema"])
+	assert.Equal(t, "42P01", extensions["dbErrorCode"])
+}
+
+func TestSourceMigrationsSourceError(t *testing.T) {
+	ctx := context.Background()
+
+	opts := []graphql.SchemaOpt{graphql.UseFieldResolvers()}
+	schema := graphql.MustParseSchema(SchemaDefinition, &RootResolver{Coordinator: &mockedCoordinator{}}, opts...)
+
+	opName := "SourceMigrations"
+	query := `query SourceMigrations($filters: SourceMigrationFilters) {
+	    sourceMigrations(filters: $filters) {
+	      file
+	    }
+  }`
+	variables := map[string]interface{}{
+		"filters": map[string]interface{}{
+			"sourceDir": "unreadable",
+		},
+	}
+
+	resp := schema.Exec(ctx, query, opName, variables)
+	assert.NotNil(t, resp.Errors)
+	assert.Equal(t, "could not read source migrations from unreadable: permission denied", resp.Errors[0].Message)
+	assert.Equal(t, types.ErrorCodeSourceUnreadable, resp.Errors[0].Extensions["code"])
+	assert.Equal(t, "unreadable", resp.Errors[0].Extensions["location"])
+}
+
+func TestRollbackVersion(t *testing.T) {
+	ctx := context.Background()
+
+	opts := []graphql.SchemaOpt{graphql.UseFieldResolvers()}
+	schema := graphql.MustParseSchema(SchemaDefinition, &RootResolver{Coordinator: &mockedCoordinator{}}, opts...)
+
+	opName := "RollbackVersion"
+	query := `mutation RollbackVersion($input: RollbackVersionInput!) {
+  rollbackVersion(input: $input) {
+    version {
+      id,
+      name,
+    }
+    summary {
+      singleMigrations
+    }
+  }
+}`
+	variables := map[string]interface{}{
+		"input": map[string]interface{}{
+			"id":          12,
+			"versionName": "rollback commit-sha",
+			"dryRun":      true,
+		},
+	}
+
+	resp := schema.Exec(ctx, query, opName, variables)
+	assert.Nil(t, resp.Errors)
+	jsonMap := make(map[string]interface{})
+	err := json.Unmarshal(resp.Data, &jsonMap)
+	assert.Nil(t, err)
+	results := jsonMap["rollbackVersion"].(map[string]interface{})
+
+	version := results["version"].(map[string]interface{})
+	assert.Equal(t, float64(123), version["id"])
+	assert.Equal(t, "rollback commit-sha", version["name"])
+	summary := results["summary"].(map[string]interface{})
+	assert.Equal(t, float64(1), summary["singleMigrations"])
+}
+
+func TestRollbackVersionError(t *testing.T) {
+	ctx := context.Background()
+
+	opts := []graphql.SchemaOpt{graphql.UseFieldResolvers()}
+	schema := graphql.MustParseSchema(SchemaDefinition, &RootResolver{Coordinator: &mockedCoordinator{}}, opts...)
+
+	opName := "RollbackVersion"
+	query := `mutation RollbackVersion($input: RollbackVersionInput!) {
+  rollbackVersion(input: $input) {
+    version {
+      id
+    }
+  }
+}`
+	variables := map[string]interface{}{
+		"input": map[string]interface{}{
+			"id":          13,
+			"versionName": "rollback commit-sha",
+		},
+	}
+
+	resp := schema.Exec(ctx, query, opName, variables)
+	assert.NotNil(t, resp.Errors)
+	assert.Equal(t, "version not found ID: 13", resp.Errors[0].Message)
+}
+
+func TestCreateVersionAsync(t *testing.T) {
+	ctx := context.Background()
+
+	opts := []graphql.SchemaOpt{graphql.UseFieldResolvers()}
+	schema := graphql.MustParseSchema(SchemaDefinition, &RootResolver{Coordinator: &mockedCoordinator{}, JobManager: &mockedJobManager{}}, opts...)
+
+	opName := "CreateVersion"
+	query := `mutation CreateVersion($input: VersionInput!) {
+  createVersion(input: $input) {
+    summary {
+      tenants
+    }
+    job {
+      id
+      operation
+      state
+    }
+  }
+}`
+	variables := map[string]interface{}{
+		"input": map[string]interface{}{
+			"versionName": "commit-sha",
+			"async":       true,
+		},
+	}
+
+	resp := schema.Exec(ctx, query, opName, variables)
+	assert.Nil(t, resp.Errors)
+	jsonMap := make(map[string]interface{})
+	err := json.Unmarshal(resp.Data, &jsonMap)
+	assert.Nil(t, err)
+	results := jsonMap["createVersion"].(map[string]interface{})
+
+	// summary is returned only for synchronous operations
+	assert.Nil(t, results["summary"])
+	job := results["job"].(map[string]interface{})
+	assert.Equal(t, "job-1", job["id"])
+	assert.Equal(t, "createVersion", job["operation"])
+	assert.Equal(t, "Queued", job["state"])
+}
+
+func TestCreateTenantAsync(t *testing.T) {
+	ctx := context.Background()
+
+	opts := []graphql.SchemaOpt{graphql.UseFieldResolvers()}
+	schema := graphql.MustParseSchema(SchemaDefinition, &RootResolver{Coordinator: &mockedCoordinator{}, JobManager: &mockedJobManager{}}, opts...)
+
+	opName := "CreateTenant"
+	query := `mutation CreateTenant($input: TenantInput!) {
+  createTenant(input: $input) {
+    job {
+      id
+      operation
+      state
+    }
+  }
+}`
+	variables := map[string]interface{}{
+		"input": map[string]interface{}{
+			"versionName": "commit-sha",
+			"tenantName":  "new_tenant",
+			"async":       true,
+		},
+	}
+
+	resp := schema.Exec(ctx, query, opName, variables)
+	assert.Nil(t, resp.Errors)
+	jsonMap := make(map[string]interface{})
+	err := json.Unmarshal(resp.Data, &jsonMap)
+	assert.Nil(t, err)
+	job := jsonMap["createTenant"].(map[string]interface{})["job"].(map[string]interface{})
+	assert.Equal(t, "createTenant", job["operation"])
+	assert.Equal(t, "Queued", job["state"])
+}
+
+func TestCreateVersionAsyncNotSupported(t *testing.T) {
+	ctx := context.Background()
+
+	opts := []graphql.SchemaOpt{graphql.UseFieldResolvers()}
+	schema := graphql.MustParseSchema(SchemaDefinition, &RootResolver{Coordinator: &mockedCoordinator{}}, opts...)
+
+	opName := "CreateVersion"
+	query := `mutation CreateVersion($input: VersionInput!) {
+  createVersion(input: $input) {
+    job {
+      id
+    }
+  }
+}`
+	variables := map[string]interface{}{
+		"input": map[string]interface{}{
+			"versionName": "commit-sha",
+			"async":       true,
+		},
+	}
+
+	resp := schema.Exec(ctx, query, opName, variables)
+	assert.Len(t, resp.Errors, 1)
+	assert.Equal(t, "asynchronous operations are not supported", resp.Errors[0].Message)
+}
+
+func TestJobs(t *testing.T) {
+	ctx := context.Background()
+
+	jobManager := &mockedJobManager{}
+	jobManager.Submit(ctx, "createVersion", func(c coordinator.Coordinator) (*types.CreateResults, error) {
+		return c.CreateVersion("commit-sha", types.ActionApply, false, nil)
+	})
+	jobManager.Submit(ctx, "createVersion", func(c coordinator.Coordinator) (*types.CreateResults, error) {
+		return c.CreateVersion("locked", types.ActionApply, false, nil)
+	})
+
+	opts := []graphql.SchemaOpt{graphql.UseFieldResolvers()}
+	schema := graphql.MustParseSchema(SchemaDefinition, &RootResolver{Coordinator: &mockedCoordinator{}, JobManager: jobManager}, opts...)
+
+	opName := "Jobs"
+	query := `query Jobs($id: ID!) {
+  job(id: $id) {
+    id
+    state
+    started
+    finished
+    migrationsApplied
+    migrationsTotal
+    results {
+      version {
+        name
+      }
+    }
+    error
+  }
+  jobs {
+    id
+    state
+    errorCode
+  }
+}`
+	variables := map[string]interface{}{
+		"id": "job-1",
+	}
+
+	resp := schema.Exec(ctx, query, opName, variables)
+	assert.Nil(t, resp.Errors)
+	jsonMap := make(map[string]interface{})
+	err := json.Unmarshal(resp.Data, &jsonMap)
+	assert.Nil(t, err)
+
+	job := jsonMap["job"].(map[string]interface{})
+	assert.Equal(t, "job-1", job["id"])
+	assert.Equal(t, "Succeeded", job["state"])
+	assert.NotNil(t, job["started"])
+	assert.NotNil(t, job["finished"])
+	assert.Equal(t, float64(3), job["migrationsApplied"])
+	assert.Equal(t, float64(3), job["migrationsTotal"])
+	assert.Nil(t, job["error"])
+	version := job["results"].(map[string]interface{})["version"].(map[string]interface{})
+	assert.NotNil(t, version["name"])
+
+	jobs := jsonMap["jobs"].([]interface{})
+	assert.Len(t, jobs, 2)
+	failed := jobs[0].(map[string]interface{})
+	assert.Equal(t, "job-2", failed["id"])
+	assert.Equal(t, "Failed", failed["state"])
+	assert.Equal(t, "LOCK_TIMEOUT", failed["errorCode"])
+}
+
+func TestJobNotFound(t *testing.T) {
+	ctx := context.Background()
+
+	opts := []graphql.SchemaOpt{graphql.UseFieldResolvers()}
+	schema := graphql.MustParseSchema(SchemaDefinition, &RootResolver{Coordinator: &mockedCoordinator{}}, opts...)
+
+	opName := "Job"
+	query := `query Job {
+  job(id: "abc") {
+    id
+  }
+  jobs {
+    id
+  }
+}`
+	variables := map[string]interface{}{}
+
+	resp := schema.Exec(ctx, query, opName, variables)
+	assert.Nil(t, resp.Errors)
+	jsonMap := make(map[string]interface{})
+	err := json.Unmarshal(resp.Data, &jsonMap)
+	assert.Nil(t, err)
+	assert.Nil(t, jsonMap["job"])
+	assert.Empty(t, jsonMap["jobs"])
+}
+
+func TestCreateVersionTenantResults(t *testing.T) {
+	ctx := context.Background()
+
+	opts := []graphql.SchemaOpt{graphql.UseFieldResolvers()}
+	schema := graphql.MustParseSchema(SchemaDefinition, &RootResolver{Coordinator: &mockedCoordinator{}}, opts...)
+
+	opName := "CreateVersion"
+	query := `mutation CreateVersion($input: VersionInput!) {
+  createVersion(input: $input) {
+    summary {
+      tenants
+      tenantResults {
+        name
+        outcome
+        error
+      }
+    }
+  }
+}`
+	variables := map[string]interface{}{
+		"input": map[string]interface{}{
+			"versionName": "partial",
+		},
+	}
+
+	resp := schema.Exec(ctx, query, opName, variables)
+	assert.Nil(t, resp.Errors)
+	jsonMap := make(map[string]interface{})
+	err := json.Unmarshal(resp.Data, &jsonMap)
+	assert.Nil(t, err)
+	summary := jsonMap["createVersion"].(map[string]interface{})["summary"].(map[string]interface{})
+	tenantResults := summary["tenantResults"].([]interface{})
+	assert.Len(t, tenantResults, 3)
+	assert.Equal(t, map[string]interface{}{"name": "abc", "outcome": "Succeeded", "error": nil}, tenantResults[0])
+	assert.Equal(t, "Failed", tenantResults[1].(map[string]interface{})["outcome"])
+	assert.Contains(t, tenantResults[1].(map[string]interface{})["error"], `relation "def.xyz" does not exist`)
+	assert.Equal(t, "Skipped", tenantResults[2].(map[string]interface{})["outcome"])
+}
+
+func TestPlan(t *testing.T) {
+	ctx := context.Background()
+
+	opts := []graphql.SchemaOpt{graphql.UseFieldResolvers()}
+	schema := graphql.MustParseSchema(SchemaDefinition, &RootResolver{Coordinator: &mockedCoordinator{}}, opts...)
+
+	opName := "Plan"
+	query := `query Plan {
+  plan {
+    tenants
+    tenantMigrationsTotal
+    migrationsGrandTotal
+    migrations {
+      file
+      migrationType
+      schemas
+      statements {
+        schema
+        sql
+      }
+      noTransaction
+    }
+  }
+}`
+	variables := map[string]interface{}{}
+
+	resp := schema.Exec(ctx, query, opName, variables)
+	assert.Nil(t, resp.Errors)
+	var result struct {
+		Plan struct {
+			Tenants               int32
+			TenantMigrationsTotal int32
+			MigrationsGrandTotal  int32
+			Migrations            []struct {
+				File          string
+				MigrationType string
+				Schemas       []string
+				Statements    []types.PlannedStatement
+				NoTransaction bool
+			}
+		}
+	}
+	err := json.Unmarshal(resp.Data, &result)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), result.Plan.Tenants)
+	assert.Equal(t, int32(2), result.Plan.TenantMigrationsTotal)
+	assert.Equal(t, int32(2), result.Plan.MigrationsGrandTotal)
+	assert.Len(t, result.Plan.Migrations, 1)
+	assert.Equal(t, "tenant/201602220003.sql", result.Plan.Migrations[0].File)
+	assert.Equal(t, "TenantMigration", result.Plan.Migrations[0].MigrationType)
+	assert.Equal(t, []string{"a", "b"}, result.Plan.Migrations[0].Schemas)
+	assert.Equal(t, types.PlannedStatement{Schema: "b", SQL: "create table b.abc (id int)"}, result.Plan.Migrations[0].Statements[1])
+	assert.False(t, result.Plan.Migrations[0].NoTransaction)
+}
+
+func TestPlanTenantSelector(t *testing.T) {
+	ctx := context.Background()
+
+	opts := []graphql.SchemaOpt{graphql.UseFieldResolvers()}
+	schema := graphql.MustParseSchema(SchemaDefinition, &RootResolver{Coordinator: &mockedCoordinator{}}, opts...)
+
+	opName := "Plan"
+	query := `query Plan($tenants: TenantSelector) {
+  plan(tenants: $tenants) {
+    tenants
+    migrations {
+      schemas
+    }
+  }
+}`
+	variables := map[string]interface{}{
+		"tenants": map[string]interface{}{"glob": "a*"},
+	}
+
+	resp := schema.Exec(ctx, query, opName, variables)
+	assert.Nil(t, resp.Errors)
+	var result struct {
+		Plan struct {
+			Tenants    int32
+			Migrations []struct {
+				Schemas []string
+			}
+		}
+	}
+	err := json.Unmarshal(resp.Data, &result)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), result.Plan.Tenants)
+	assert.Equal(t, []string{"a"}, result.Plan.Migrations[0].Schemas)
+
+	variables["tenants"] = map[string]interface{}{"glob": "z*"}
+	resp = schema.Exec(ctx, query, opName, variables)
+	assert.NotNil(t, resp.Errors)
+	assert.Equal(t, "invalid tenant selector: no tenant matches the selector", resp.Errors[0].Message)
+	assert.Equal(t, types.ErrorCodeTenantSelectorInvalid, resp.Errors[0].Extensions["code"])
+}
+
+func TestCreateVersionTenantSelector(t *testing.T) {
+	ctx := context.Background()
+
+	opts := []graphql.SchemaOpt{graphql.UseFieldResolvers()}
+	schema := graphql.MustParseSchema(SchemaDefinition, &RootResolver{Coordinator: &mockedCoordinator{}}, opts...)
+
+	opName := "CreateVersion"
+	query := `mutation CreateVersion($input: VersionInput!) {
+  createVersion(input: $input) {
+    summary {
+      tenants
+    }
+  }
+}`
+	variables := map[string]interface{}{
+		"input": map[string]interface{}{
+			"versionName": "canary",
+			"tenants":     map[string]interface{}{"names": []interface{}{"a", "b"}},
+		},
+	}
+
+	resp := schema.Exec(ctx, query, opName, variables)
+	assert.Nil(t, resp.Errors)
+	var result struct {
+		CreateVersion struct {
+			Summary struct {
+				Tenants int32
+			}
+		}
+	}
+	err := json.Unmarshal(resp.Data, &result)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), result.CreateVersion.Summary.Tenants)
+}
+
+func TestTenantStatus(t *testing.T) {
+	ctx := context.Background()
+
+	opts := []graphql.SchemaOpt{graphql.UseFieldResolvers()}
+	schema := graphql.MustParseSchema(SchemaDefinition, &RootResolver{Coordinator: &mockedCoordinator{}}, opts...)
+
+	opName := "TenantStatus"
+	query := `query TenantStatus($name: String!) {
+  tenantStatus(name: $name) {
+    name
+    metadata
+    appliedMigrations
+    pendingMigrations {
+      file
+      migrationType
+    }
+    lag
+  }
+}`
+	variables := map[string]interface{}{
+		"name": "b",
+	}
+
+	resp := schema.Exec(ctx, query, opName, variables)
+	assert.Nil(t, resp.Errors)
+	var result struct {
+		TenantStatus struct {
+			Name              string
+			Metadata          map[string]string
+			AppliedMigrations int32
+			PendingMigrations []struct {
+				File          string
+				MigrationType string
+			}
+			Lag int32
+		}
+	}
+	err := json.Unmarshal(resp.Data, &result)
+	assert.Nil(t, err)
+	assert.Equal(t, "b", result.TenantStatus.Name)
+	assert.Equal(t, map[string]string{}, result.TenantStatus.Metadata)
+	assert.Equal(t, int32(2), result.TenantStatus.AppliedMigrations)
+	assert.Equal(t, int32(1), result.TenantStatus.Lag)
+	assert.Equal(t, "tenant/201602220003.sql", result.TenantStatus.PendingMigrations[0].File)
+	assert.Equal(t, "TenantMigration", result.TenantStatus.PendingMigrations[0].MigrationType)
+
+	variables["name"] = "xyz"
+	resp = schema.Exec(ctx, query, opName, variables)
+	assert.NotNil(t, resp.Errors)
+	assert.Equal(t, "tenant not found: xyz", resp.Errors[0].Message)
+}
@@ -2,11 +2,14 @@ package db
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
 	"fmt"
 	"path/filepath"
 	"sort"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/graph-gophers/graphql-go"
@@ -18,14 +21,16 @@ import (
 
 // Connector interface abstracts all DB operations performed by migrator
 type Connector interface {
-	GetTenants() []types.Tenant
-	GetVersions() []types.Version
-	GetVersionsByFile(file string) []types.Version
+	GetTenants() ([]types.Tenant, error)
+	GetVersions() ([]types.Version, error)
+	GetVersionsByFile(file string) ([]types.Version, error)
 	GetVersionByID(ID int32) (*types.Version, error)
 	GetDBMigrationByID(ID int32) (*types.DBMigration, error)
-	GetAppliedMigrations() []types.DBMigration
-	CreateVersion(string, types.Action, []types.Migration, bool) (*types.Summary, *types.Version)
-	CreateTenant(string, string, types.Action, []types.Migration, bool) (*types.Summary, *types.Version)
+	GetAppliedMigrations() ([]types.DBMigration, error)
+	CreateVersion(string, types.Action, []types.Migration, bool) (*types.Summary, *types.Version, error)
+	CreateTenant(types.Tenant, string, types.Action, []types.Migration, bool) (*types.Summary, *types.Version, error)
+	RollbackVersion(int32, string, bool) (*types.Summary, *types.Version, error)
+	Plan([]types.Migration) (*types.Plan, error)
 	HealthCheck() error
 	Dispose()
 }
@@ -37,6 +42,8 @@ type baseConnector struct {
 	dialect     dialect
 	db          *sql.DB
 	initialised bool
+	// templateEnv are environment variables allowed by templateEnv config property, see templateData
+	templateEnv map[string]string
 }
 
 // Factory is a factory method for creating Loader instance
@@ -45,7 +52,7 @@ type Factory func(context.Context, *config.Config) Connector
 // New constructs Connector instance based on the passed Config
 func New(ctx context.Context, config *config.Config) Connector {
 	dialect := newDialect(config)
-	connector := &baseConnector{ctx, config, dialect, nil, false}
+	connector := &baseConnector{ctx, config, dialect, nil, false, templateEnv(config)}
 	return connector
 }
 
@@ -55,6 +62,8 @@ const (
 	migratorMigrationsTable  = "migrator_migrations"
 	migratorVersionsTable    = "migrator_versions"
 	defaultSchemaPlaceHolder = "{schema}"
+	migratorLockName         = "migrator"
+	defaultLockTimeout       = 30 * time.Second
 )
 
 // init initialises migrator by making sure proper schema/table are created
@@ -65,50 +74,83 @@ func (bc *baseConnector) init() error {
 	if bc.db == nil {
 		db, err := sql.Open(bc.config.Driver, bc.config.DataSource)
 		if err != nil {
-			return fmt.Errorf("failed to open connection to DB: %v", err.Error())
+			return &types.DBUnreachableError{Err: err}
 		}
 		bc.db = db
 
 		if err := bc.db.Ping(); err != nil {
-			return fmt.Errorf("failed to connect to database: %v", err)
+			return &types.DBUnreachableError{Err: err}
 		}
 	}
 
 	tx, err := bc.db.Begin()
 	if err != nil {
-		return fmt.Errorf("could not start DB transaction: %v", err)
+		return bc.newDBError("could not start DB transaction", err)
 	}
 
 	// make sure migrator schema exists
 	createSchema := bc.dialect.GetCreateSchemaSQL(migratorSchema)
 	if _, err := bc.db.Exec(createSchema); err != nil {
-		return fmt.Errorf("could not create migrator schema: %v", err)
+		return bc.newDBError("could not create migrator schema", err)
 	}
 
 	// make sure migrations table exists
 	createMigrationsTable := bc.dialect.GetCreateMigrationsTableSQL()
 	if _, err := bc.db.Exec(createMigrationsTable); err != nil {
-		return fmt.Errorf("could not create migrations table: %v", err)
+		return bc.newDBError("could not create migrations table", err)
 	}
 
 	// make sure versions table exists
 	createVersionsTableSQLs := bc.dialect.GetCreateVersionsTableSQL()
 	for _, createVersionsTableSQL := range createVersionsTableSQLs {
 		if _, err := bc.db.Exec(createVersionsTableSQL); err != nil {
-			return fmt.Errorf("could not create versions table: %v", err)
+			return bc.newDBError("could not create versions table", err)
 		}
 	}
 
+	// make sure migrations table has down contents column
+	addDownContentsColumnSQLs := bc.dialect.GetAddDownContentsColumnSQL()
+	for _, addDownContentsColumnSQL := range addDownContentsColumnSQLs {
+		if _, err := bc.db.Exec(addDownContentsColumnSQL); err != nil {
+			return bc.newDBError("could not add down contents column", err)
+		}
+	}
+
+	// make sure migrations table has rendered contents column
+	addRenderedContentsColumnSQLs := bc.dialect.GetAddRenderedContentsColumnSQL()
+	for _, addRenderedContentsColumnSQL := range addRenderedContentsColumnSQLs {
+		if _, err := bc.db.Exec(addRenderedContentsColumnSQL); err != nil {
+			return bc.newDBError("could not add rendered contents column", err)
+		}
+	}
+	if err := bc.dialect.EnsureColumn(bc.db, migratorMigrationsTable, "rendered_contents", "text"); err != nil {
+		return bc.newDBError("could not add rendered contents column", err)
+	}
+
+	// make sure migrations table has source commit column
+	addSourceCommitColumnSQLs := bc.dialect.GetAddSourceCommitColumnSQL()
+	for _, addSourceCommitColumnSQL := range addSourceCommitColumnSQLs {
+		if _, err := bc.db.Exec(addSourceCommitColumnSQL); err != nil {
+			return bc.newDBError("could not add source commit column", err)
+		}
+	}
+	if err := bc.dialect.EnsureColumn(bc.db, migratorMigrationsTable, "source_commit", "varchar(64)"); err != nil {
+		return bc.newDBError("could not add source commit column", err)
+	}
+
 	// if using default migrator tenants table make sure it exists
 	if bc.config.TenantSelectSQL == "" {
 		createTenantsTable := bc.dialect.GetCreateTenantsTableSQL()
 		if _, err := bc.db.Exec(createTenantsTable); err != nil {
-			return fmt.Errorf("could not create default tenants table: %v", err)
+			return bc.newDBError("could not create default tenants table", err)
+		}
+		if err := bc.addTenantMetadataColumns(); err != nil {
+			return err
 		}
 	}
 
 	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("could not commit transaction: %v", err)
+		return bc.newDBError("could not commit transaction", err)
 	}
 
 	bc.initialised = true
@@ -116,10 +158,29 @@ func (bc *baseConnector) init() error {
 	return nil
 }
 
-func (bc *baseConnector) initOrPanic() {
-	if err := bc.init(); err != nil {
-		panic(fmt.Sprintf("Error initialising migrator: %v", err))
+// addTenantMetadataColumns makes sure default tenants table has all tenant metadata columns
+func (bc *baseConnector) addTenantMetadataColumns() error {
+	for _, column := range bc.config.TenantMetadataColumns {
+		for _, addTenantMetadataColumnSQL := range bc.dialect.GetAddTenantMetadataColumnSQL(column) {
+			if _, err := bc.db.Exec(addTenantMetadataColumnSQL); err != nil {
+				return bc.newDBError("could not add tenant metadata column", err)
+			}
+		}
+		if err := bc.dialect.EnsureColumn(bc.db, migratorTenantsTable, column, "varchar(200)"); err != nil {
+			return bc.newDBError("could not add tenant metadata column", err)
+		}
 	}
+	return nil
+}
+
+// newDBError wraps passed DB error together with its DB-specific error code
+func (bc *baseConnector) newDBError(message string, err error) error {
+	return &types.DBError{Message: message, DBErrorCode: bc.dialect.GetErrorCode(err), Err: err}
+}
+
+// newMigrationError wraps passed SQL migration error together with its DB-specific error code
+func (bc *baseConnector) newMigrationError(file, schema string, err error) *types.MigrationError {
+	return &types.MigrationError{File: file, Schema: schema, DBErrorCode: bc.dialect.GetErrorCode(err), Err: err}
 }
 
 // Dispose closes all resources allocated by connector
@@ -135,14 +196,16 @@ func (bc *baseConnector) getTenantSelectSQL() string {
 	if bc.config.TenantSelectSQL != "" {
 		tenantSelectSQL = bc.config.TenantSelectSQL
 	} else {
-		tenantSelectSQL = bc.dialect.GetTenantSelectSQL()
+		tenantSelectSQL = bc.dialect.GetTenantSelectSQL(bc.config.TenantMetadataColumns)
 	}
 	return tenantSelectSQL
 }
 
 // GetTenants returns a list of all DB tenants
-func (bc *baseConnector) GetTenants() []types.Tenant {
-	bc.initOrPanic()
+func (bc *baseConnector) GetTenants() ([]types.Tenant, error) {
+	if err := bc.init(); err != nil {
+		return nil, err
+	}
 
 	tenantSelectSQL := bc.getTenantSelectSQL()
 
@@ -150,43 +213,65 @@ func (bc *baseConnector) GetTenants() []types.Tenant {
 
 	rows, err := bc.db.Query(tenantSelectSQL)
 	if err != nil {
-		panic(fmt.Sprintf("Could not query tenants: %v", err))
+		return nil, bc.newDBError("Could not query tenants", err)
 	}
 	defer rows.Close()
 
+	// the first column is tenant name, all other columns are tenant metadata
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, bc.newDBError("Could not read tenants", err)
+	}
+
 	for rows.Next() {
-		var name string
-		if err = rows.Scan(&name); err != nil {
-			panic(fmt.Sprintf("Could not read tenants: %v", err))
+		values := make([]sql.NullString, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return nil, bc.newDBError("Could not read tenants", err)
+		}
+		tenant := types.Tenant{Name: values[0].String}
+		if len(columns) > 1 {
+			tenant.Metadata = types.TenantMetadata{}
+			// null values are returned as empty strings so that templates can reference all metadata columns
+			for i := 1; i < len(columns); i++ {
+				tenant.Metadata[columns[i]] = values[i].String
+			}
 		}
-		tenants = append(tenants, types.Tenant{Name: name})
+		tenants = append(tenants, tenant)
 	}
 
-	return tenants
+	return tenants, nil
 }
 
-func (bc *baseConnector) GetVersions() []types.Version {
-	bc.initOrPanic()
+func (bc *baseConnector) GetVersions() ([]types.Version, error) {
+	if err := bc.init(); err != nil {
+		return nil, err
+	}
 
 	versionsSelectSQL := bc.dialect.GetVersionsSelectSQL()
 
 	rows, err := bc.db.Query(versionsSelectSQL)
 	if err != nil {
-		panic(fmt.Sprintf("Could not query versions: %v", err))
+		return nil, bc.newDBError("Could not query versions", err)
 	}
 	defer rows.Close()
 
 	return bc.readVersions(rows)
 }
 
-func (bc *baseConnector) GetVersionsByFile(file string) []types.Version {
-	bc.initOrPanic()
+func (bc *baseConnector) GetVersionsByFile(file string) ([]types.Version, error) {
+	if err := bc.init(); err != nil {
+		return nil, err
+	}
 
 	versionsSelectSQL := bc.dialect.GetVersionsByFileSQL()
 
 	rows, err := bc.db.Query(versionsSelectSQL, file)
 	if err != nil {
-		panic(fmt.Sprintf("Could not query versions: %v", err))
+		return nil, bc.newDBError("Could not query versions", err)
 	}
 	defer rows.Close()
 
@@ -194,19 +279,24 @@ func (bc *baseConnector) GetVersionsByFile(file string) []types.Version {
 }
 
 func (bc *baseConnector) GetVersionByID(ID int32) (*types.Version, error) {
-	bc.initOrPanic()
+	if err := bc.init(); err != nil {
+		return nil, err
+	}
 
 	versionsSelectSQL := bc.dialect.GetVersionByIDSQL()
 
 	rows, err := bc.db.Query(versionsSelectSQL, ID)
 	if err != nil {
-		panic(fmt.Sprintf("Could not query versions: %v", err))
+		return nil, bc.newDBError("Could not query versions", err)
 	}
 	defer rows.Close()
 
 	// readVersions is generic and returns a slice of Version objects
 	// we are querying by ID and are interested in only the first one
-	versions := bc.readVersions(rows)
+	versions, err := bc.readVersions(rows)
+	if err != nil {
+		return nil, err
+	}
 
 	if len(versions) == 0 {
 		return nil, fmt.Errorf("version not found ID: %v", ID)
@@ -215,58 +305,71 @@ func (bc *baseConnector) GetVersionByID(ID int32) (*types.Version, error) {
 	return &versions[0], nil
 }
 
-func (bc *baseConnector) getVersionByIDInTx(tx *sql.Tx, ID int32) *types.Version {
+func (bc *baseConnector) getVersionByIDInTx(tx *sql.Tx, ID int32) (*types.Version, error) {
 	versionsSelectSQL := bc.dialect.GetVersionByIDSQL()
 
 	rows, err := tx.Query(versionsSelectSQL, ID)
 	if err != nil {
-		panic(fmt.Sprintf("Could not query versions: %v", err))
+		return nil, bc.newDBError("Could not query versions", err)
 	}
 	defer rows.Close()
 
 	// readVersions is generic and returns a slice of Version objects
 	// we are querying by ID and are interested in only the first one
-	versions := bc.readVersions(rows)
+	versions, err := bc.readVersions(rows)
+	if err != nil {
+		return nil, err
+	}
 
 	// when running in transaction version must be found
 	if len(versions) == 0 {
-		panic(fmt.Sprintf("Version not found ID: %v", ID))
+		return nil, fmt.Errorf("Version not found ID: %v", ID)
 	}
 
-	return &versions[0]
+	return &versions[0], nil
 }
 
-func (bc *baseConnector) readVersions(rows *sql.Rows) []types.Version {
+func (bc *baseConnector) readVersions(rows *sql.Rows) ([]types.Version, error) {
 	versions := []types.Version{}
 	versionsMap := map[int64]*types.Version{}
 
 	for rows.Next() {
 		var (
-			vid           int64
-			vname         string
-			vcreated      time.Time
-			mid           int64
-			name          string
-			sourceDir     string
-			filename      string
-			migrationType types.MigrationType
-			schema        string
-			created       time.Time
-			contents      string
-			checksum      string
+			vid      int64
+			vname    string
+			vcreated time.Time
+			// version without migrations (possible when all tenants failed) has null migration columns
+			mid           *int64
+			name          *string
+			sourceDir     *string
+			filename      *string
+			migrationType *types.MigrationType
+			schema        *string
+			created       *time.Time
+			contents      *string
+			checksum      *string
+			sourceCommit  *string
 		)
 
-		if err := rows.Scan(&vid, &vname, &vcreated, &mid, &name, &sourceDir, &filename, &migrationType, &schema, &created, &contents, &checksum); err != nil {
-			panic(fmt.Sprintf("Could not read versions: %v", err))
+		if err := rows.Scan(&vid, &vname, &vcreated, &mid, &name, &sourceDir, &filename, &migrationType, &schema, &created, &contents, &checksum, &sourceCommit); err != nil {
+			return nil, bc.newDBError("Could not read versions", err)
 		}
 		if versionsMap[vid] == nil {
-			version := types.Version{ID: int32(vid), Name: vname, Created: graphql.Time{Time: vcreated}}
+			version := types.Version{ID: int32(vid), Name: vname, Created: graphql.Time{Time: vcreated}, DBMigrations: []types.DBMigration{}}
 			versionsMap[vid] = &version
 		}
 
+		if mid == nil {
+			continue
+		}
+
 		version := versionsMap[vid]
-		migration := types.Migration{Name: name, SourceDir: sourceDir, File: filename, MigrationType: migrationType, Contents: contents, CheckSum: checksum}
-		version.DBMigrations = append(version.DBMigrations, types.DBMigration{Migration: migration, ID: int32(mid), Schema: schema, Created: graphql.Time{Time: created}})
+		migration := types.Migration{Name: stringValue(name), SourceDir: stringValue(sourceDir), File: stringValue(filename), MigrationType: *migrationType, Contents: stringValue(contents), CheckSum: stringValue(checksum), SourceCommit: sourceCommit}
+		version.DBMigrations = append(version.DBMigrations, types.DBMigration{Migration: migration, ID: int32(*mid), Schema: stringValue(schema), Created: graphql.Time{Time: *created}})
+		// all migrations of a version are loaded together so they share the same source commit
+		if version.SourceCommit == nil {
+			version.SourceCommit = sourceCommit
+		}
 	}
 
 	// map to versions
@@ -277,17 +380,19 @@ func (bc *baseConnector) readVersions(rows *sql.Rows) []types.Version {
 	sort.Slice(versions, func(i, j int) bool {
 		return versions[i].ID > versions[j].ID
 	})
-	return versions
+	return versions, nil
 }
 
 func (bc *baseConnector) GetDBMigrationByID(ID int32) (*types.DBMigration, error) {
-	bc.initOrPanic()
+	if err := bc.init(); err != nil {
+		return nil, err
+	}
 
 	query := bc.dialect.GetMigrationByIDSQL()
 
 	rows, err := bc.db.Query(query, ID)
 	if err != nil {
-		panic(fmt.Sprintf("Could not query DB migrations: %v", err.Error()))
+		return nil, bc.newDBError("Could not query DB migrations", err)
 	}
 	defer rows.Close()
 
@@ -305,19 +410,22 @@ func (bc *baseConnector) GetDBMigrationByID(ID int32) (*types.DBMigration, error
 		created       time.Time
 		contents      string
 		checksum      string
+		sourceCommit  *string
 	)
-	if err = rows.Scan(&id, &name, &sourceDir, &filename, &migrationType, &schema, &created, &contents, &checksum); err != nil {
-		panic(fmt.Sprintf("Could not read DB migration: %v", err.Error()))
+	if err = rows.Scan(&id, &name, &sourceDir, &filename, &migrationType, &schema, &created, &contents, &checksum, &sourceCommit); err != nil {
+		return nil, bc.newDBError("Could not read DB migration", err)
 	}
-	m := types.Migration{Name: name, SourceDir: sourceDir, File: filename, MigrationType: migrationType, Contents: contents, CheckSum: checksum}
+	m := types.Migration{Name: name, SourceDir: sourceDir, File: filename, MigrationType: migrationType, Contents: contents, CheckSum: checksum, SourceCommit: sourceCommit}
 	db := types.DBMigration{Migration: m, ID: int32(id), Schema: schema, Created: graphql.Time{Time: created}}
 
 	return &db, nil
 }
 
 // GetAppliedMigrations returns a list of all applied DB migrations
-func (bc *baseConnector) GetAppliedMigrations() []types.DBMigration {
-	bc.initOrPanic()
+func (bc *baseConnector) GetAppliedMigrations() ([]types.DBMigration, error) {
+	if err := bc.init(); err != nil {
+		return nil, err
+	}
 
 	query := bc.dialect.GetMigrationSelectSQL()
 
@@ -325,7 +433,7 @@ func (bc *baseConnector) GetAppliedMigrations() []types.DBMigration {
 
 	rows, err := bc.db.Query(query)
 	if err != nil {
-		panic(fmt.Sprintf("Could not query DB migrations: %v", err.Error()))
+		return nil, bc.newDBError("Could not query DB migrations", err)
 	}
 	defer rows.Close()
 
@@ -339,108 +447,598 @@ func (bc *baseConnector) GetAppliedMigrations() []types.DBMigration {
 			created       time.Time
 			contents      string
 			checksum      string
+			sourceCommit  *string
 		)
-		if err = rows.Scan(&name, &sourceDir, &filename, &migrationType, &schema, &created, &contents, &checksum); err != nil {
-			panic(fmt.Sprintf("Could not read DB migration: %v", err.Error()))
+		if err = rows.Scan(&name, &sourceDir, &filename, &migrationType, &schema, &created, &contents, &checksum, &sourceCommit); err != nil {
+			return nil, bc.newDBError("Could not read DB migration", err)
 		}
-		mdef := types.Migration{Name: name, SourceDir: sourceDir, File: filename, MigrationType: migrationType, Contents: contents, CheckSum: checksum}
+		mdef := types.Migration{Name: name, SourceDir: sourceDir, File: filename, MigrationType: migrationType, Contents: contents, CheckSum: checksum, SourceCommit: sourceCommit}
 		dbMigrations = append(dbMigrations, types.DBMigration{Migration: mdef, Schema: schema, Created: graphql.Time{Time: created}})
 	}
-	return dbMigrations
+	if err = rows.Err(); err != nil {
+		return nil, bc.newDBError("Could not read DB migrations", err)
+	}
+	return skipRolledBackMigrations(dbMigrations), nil
+}
+
+// skipRolledBackMigrations removes migrations rolled back by RollbackVersion and down migrations recorded by it
+// rolled back versions are kept for audit, every down migration recorded for a schema cancels the oldest migration applied to it
+func skipRolledBackMigrations(dbMigrations []types.DBMigration) []types.DBMigration {
+	type fileSchema struct {
+		file   string
+		schema string
+	}
+	rolledBack := map[fileSchema]int{}
+	applied := map[fileSchema][]int{}
+	for i, m := range dbMigrations {
+		if file, isDown := types.UpMigrationFile(m.File); isDown {
+			rolledBack[fileSchema{file, m.Schema}]++
+		} else {
+			key := fileSchema{m.File, m.Schema}
+			applied[key] = append(applied[key], i)
+		}
+	}
+
+	skipped := map[int]bool{}
+	for i := range dbMigrations {
+		skipped[i] = true
+	}
+	for key, indexes := range applied {
+		sort.SliceStable(indexes, func(a, b int) bool {
+			return dbMigrations[indexes[a]].Created.Time.Before(dbMigrations[indexes[b]].Created.Time)
+		})
+		for n, i := range indexes {
+			skipped[i] = n < rolledBack[key]
+		}
+	}
+
+	out := []types.DBMigration{}
+	for i, m := range dbMigrations {
+		if !skipped[i] {
+			out = append(out, m)
+		}
+	}
+	return out
 }
 
 // CreateVersion creates new DB version and applies passed migrations
-func (bc *baseConnector) CreateVersion(versionName string, action types.Action, migrations []types.Migration, dryRun bool) (*types.Summary, *types.Version) {
+// migrator's DB-level lock is held for the whole operation, error is returned if lock cannot be acquired
+func (bc *baseConnector) CreateVersion(versionName string, action types.Action, migrations []types.Migration, dryRun bool) (*types.Summary, *types.Version, error) {
 	if len(migrations) == 0 {
 		return &types.Summary{
 			StartedAt: graphql.Time{Time: time.Now()},
 			Duration:  0,
-		}, nil
+		}, nil, nil
+	}
+
+	if err := bc.init(); err != nil {
+		return nil, nil, err
 	}
 
-	tenants := bc.GetTenants()
+	unlock, err := bc.lock()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unlock()
 
-	tx, err := bc.db.Begin()
+	tenants, err := bc.GetTenants()
 	if err != nil {
-		panic(fmt.Sprintf("Could not start transaction: %v", err.Error()))
+		return nil, nil, err
 	}
+	// tenant migrations and scripts can be applied only to some tenants, see types.Migration.Tenants
+	tenants = getVersionTenants(migrations, tenants)
 
-	defer func() {
-		r := recover()
-		if r == nil {
-			if dryRun {
-				common.LogInfo(bc.ctx, "Running in dry-run mode, calling rollback")
-				tx.Rollback()
-			} else {
-				common.LogInfo(bc.ctx, "Running %v, committing transaction", action)
-				if err := tx.Commit(); err != nil {
-					panic(fmt.Sprintf("Could not commit transaction: %v", err.Error()))
+	if err := bc.renderMigrations(migrations, tenants); err != nil {
+		return nil, nil, err
+	}
+
+	if scope := bc.getTransactionScope(); scope != config.TransactionScopeVersion {
+		// tenant transactions reference version which must be committed first, dry-run falls back to a single transaction
+		if !dryRun {
+			return bc.createVersionPerTenant(versionName, action, tenants, migrations, scope)
+		}
+		common.LogInfo(bc.ctx, "Running in dry-run mode, all tenants are migrated in a single transaction")
+	}
+
+	if !dryRun && containsNoTransactionMigrations(migrations) {
+		return bc.createVersionInSegments(versionName, action, tenants, migrations, nil)
+	}
+
+	var (
+		results *types.Summary
+		version *types.Version
+	)
+
+	err = bc.inTx(action, dryRun, func(tx *sql.Tx) error {
+		var err error
+		if results, err = bc.applyMigrationsInTx(tx, versionName, action, tenants, migrations); err != nil {
+			return err
+		}
+		version, err = bc.getVersionByIDInTx(tx, results.VersionID)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return results, version, nil
+}
+
+// createVersionPerTenant creates new DB version and applies single schema migrations and scripts in the version transaction
+// once the version is committed tenant migrations and scripts are applied concurrently using passed transaction scope
+// failed tenants are reported in summary's TenantResults, error is returned only when the version transaction failed
+func (bc *baseConnector) createVersionPerTenant(versionName string, action types.Action, tenants []types.Tenant, migrations []types.Migration, scope string) (*types.Summary, *types.Version, error) {
+	results := &types.Summary{
+		StartedAt: graphql.Time{Time: time.Now()},
+		Tenants:   int32(len(tenants)),
+	}
+
+	var singleMigrations, tenantMigrations []types.Migration
+	for _, m := range migrations {
+		if m.MigrationType == types.MigrationTypeTenantMigration || m.MigrationType == types.MigrationTypeTenantScript {
+			tenantMigrations = append(tenantMigrations, m)
+		} else {
+			singleMigrations = append(singleMigrations, m)
+		}
+	}
+
+	insertMigrationSQL := bc.dialect.GetMigrationInsertSQL()
+	insert, err := bc.db.Prepare(insertMigrationSQL)
+	if err != nil {
+		return nil, nil, bc.newDBError("Could not create prepared statement for migration", err)
+	}
+
+	progress := bc.newProgress(tenants, migrations)
+
+	var versionID int64
+	err = bc.applyMigrationsInSegments(action, insert, &versionID, tenants, singleMigrations, results, progress, func(tx *sql.Tx) error {
+		var err error
+		versionID, err = bc.insertVersionInTx(tx, versionName)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	results.VersionID = int32(versionID)
+
+	if len(tenantMigrations) > 0 {
+		bc.applyTenantMigrationsConcurrently(insert, versionID, action, tenants, tenantMigrations, results, progress, scope)
+	}
+
+	results.Duration = time.Since(results.StartedAt.Time).Seconds()
+	results.MigrationsGrandTotal = results.TenantMigrationsTotal + results.SingleMigrations
+	results.ScriptsGrandTotal = results.TenantScriptsTotal + results.SingleScripts
+
+	version, err := bc.GetVersionByID(results.VersionID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return results, version, nil
+}
+
+// applyTenantMigrationsConcurrently applies tenant migrations and scripts using a pool of tenant concurrency workers
+// depending on tenant failure policy remaining tenants are either skipped or migrated when migrating a tenant failed
+func (bc *baseConnector) applyTenantMigrationsConcurrently(insert *sql.Stmt, versionID int64, action types.Action, tenants []types.Tenant, migrations []types.Migration, results *types.Summary, progress *progress, scope string) {
+	stopOnFailure := bc.config.TenantFailurePolicy != config.TenantFailurePolicyContinue
+	concurrency := bc.getTenantConcurrency()
+
+	tenantResults := make([]types.TenantResult, len(tenants))
+	for i, t := range tenants {
+		tenantResults[i] = types.TenantResult{Name: t.Name, Outcome: types.TenantOutcomeSkipped}
+	}
+
+	// tenant migrations and scripts counts are the same for all tenants
+	for _, m := range migrations {
+		if m.MigrationType == types.MigrationTypeTenantMigration {
+			results.TenantMigrations++
+		} else {
+			results.TenantScripts++
+		}
+	}
+
+	var (
+		mutex   sync.Mutex
+		failed  bool
+		wg      sync.WaitGroup
+		indexes = make(chan int)
+	)
+
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				tenant := tenants[i]
+				tenantSummary, err := bc.migrateTenant(insert, versionID, action, tenant, migrations, progress, scope)
+
+				mutex.Lock()
+				if err != nil {
+					message := err.Error()
+					tenantResults[i] = types.TenantResult{Name: tenant.Name, Outcome: types.TenantOutcomeFailed, Error: &message}
+					failed = true
+				} else {
+					tenantResults[i].Outcome = types.TenantOutcomeSucceeded
 				}
+				results.TenantMigrationsTotal += tenantSummary.TenantMigrationsTotal
+				results.TenantScriptsTotal += tenantSummary.TenantScriptsTotal
+				mutex.Unlock()
 			}
-		} else {
-			common.LogInfo(bc.ctx, "Recovered in CreateVersion. Transaction rollback.")
-			tx.Rollback()
-			panic(r)
+		}()
+	}
+
+	for i := range tenants {
+		mutex.Lock()
+		stop := failed && stopOnFailure
+		mutex.Unlock()
+		if stop {
+			common.LogError(bc.ctx, "Migrating tenant failed, skipping remaining tenants")
+			break
 		}
-	}()
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	results.TenantResults = tenantResults
+}
 
-	results := bc.applyMigrationsInTx(tx, versionName, action, tenants, migrations)
-	version := bc.getVersionByIDInTx(tx, results.VersionID)
+// migrateTenant applies tenant migrations and scripts to passed tenant
+// tenant transaction scope applies all of them in a single transaction, migration transaction scope applies each in its own transaction
+// returned summary counts only committed migrations and scripts, in migration transaction scope these are the ones applied before the failed one
+func (bc *baseConnector) migrateTenant(insert *sql.Stmt, versionID int64, action types.Action, tenant types.Tenant, migrations []types.Migration, progress *progress, scope string) (*types.Summary, error) {
+	tenants := []types.Tenant{tenant}
 
-	return results, version
+	// migrations which are pending only for other tenants are skipped
+	tenantMigrations := []types.Migration{}
+	for _, m := range migrations {
+		if len(getMigrationTenants(m, tenants)) > 0 {
+			tenantMigrations = append(tenantMigrations, m)
+		}
+	}
+	migrations = tenantMigrations
+
+	committed := &types.Summary{}
+
+	if scope == config.TransactionScopeMigration {
+		for _, m := range migrations {
+			var err error
+			if m.NoTransaction() {
+				err = bc.applyNoTransactionMigration(versionID, action, tenants, m, committed, progress)
+			} else {
+				err = bc.applyMigrationsInSegments(action, insert, &versionID, tenants, []types.Migration{m}, committed, progress, nil)
+			}
+			if err != nil {
+				return committed, err
+			}
+		}
+		return committed, nil
+	}
+
+	err := bc.applyMigrationsInSegments(action, insert, &versionID, tenants, migrations, committed, progress, nil)
+	return committed, err
+}
+
+// getTransactionScope returns transaction scope used when creating new versions, defaults to version transaction scope
+// tenants can be migrated concurrently only in their own transactions, tenant concurrency implies at least tenant transaction scope
+func (bc *baseConnector) getTransactionScope() string {
+	if bc.config.TransactionScope == "" || bc.config.TransactionScope == config.TransactionScopeVersion {
+		if bc.config.TenantConcurrency > 0 {
+			return config.TransactionScopeTenant
+		}
+		return config.TransactionScopeVersion
+	}
+	return bc.config.TransactionScope
+}
+
+// getTenantConcurrency returns number of tenants migrated concurrently, defaults to 1 and is capped by dialect max tenant concurrency
+// for example SQLite allows only one writer at a time, tenants are migrated one by one but still each tenant in its own transaction
+func (bc *baseConnector) getTenantConcurrency() int {
+	concurrency := bc.config.TenantConcurrency
+	if max := bc.dialect.GetMaxTenantConcurrency(); max > 0 && concurrency > max {
+		concurrency = max
+	}
+	if concurrency < 1 {
+		return 1
+	}
+	return concurrency
 }
 
 // CreateTenant creates new tenant and applies passed tenant migrations
-func (bc *baseConnector) CreateTenant(tenant string, versionName string, action types.Action, migrations []types.Migration, dryRun bool) (*types.Summary, *types.Version) {
-	bc.initOrPanic()
+// migrator's DB-level lock is held for the whole operation, error is returned if lock cannot be acquired
+func (bc *baseConnector) CreateTenant(tenant types.Tenant, versionName string, action types.Action, migrations []types.Migration, dryRun bool) (*types.Summary, *types.Version, error) {
+	if !isValidIdentifier(tenant.Name) {
+		return nil, nil, fmt.Errorf("tenant name contains invalid characters: %v", tenant.Name)
+	}
+
+	tenantInsertArgs, err := bc.getTenantInsertArgs(tenant)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := bc.init(); err != nil {
+		return nil, nil, err
+	}
+
+	unlock, err := bc.lock()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unlock()
 
 	tenantInsertSQL := bc.getTenantInsertSQL()
 
-	tx, err := bc.db.Begin()
+	insertTenantInTx := func(tx *sql.Tx) error {
+		createSchema := bc.dialect.GetCreateSchemaSQL(tenant.Name)
+		if _, err := tx.Exec(createSchema); err != nil {
+			return bc.newDBError("Create schema failed", err)
+		}
+
+		insert, err := bc.db.Prepare(tenantInsertSQL)
+		if err != nil {
+			return bc.newDBError("Could not create prepared statement", err)
+		}
+
+		if _, err = tx.Stmt(insert).Exec(tenantInsertArgs...); err != nil {
+			return bc.newDBError("Failed to add tenant entry", err)
+		}
+		return nil
+	}
+
+	tenants := []types.Tenant{tenant}
+
+	if err := bc.renderMigrations(migrations, tenants); err != nil {
+		return nil, nil, err
+	}
+
+	if !dryRun && containsNoTransactionMigrations(migrations) {
+		return bc.createVersionInSegments(versionName, action, tenants, migrations, insertTenantInTx)
+	}
+
+	var (
+		results *types.Summary
+		version *types.Version
+	)
+
+	err = bc.inTx(action, dryRun, func(tx *sql.Tx) error {
+		if err := insertTenantInTx(tx); err != nil {
+			return err
+		}
+
+		var err error
+		if results, err = bc.applyMigrationsInTx(tx, versionName, action, tenants, migrations); err != nil {
+			return err
+		}
+
+		version, err = bc.getVersionByIDInTx(tx, results.VersionID)
+		return err
+	})
 	if err != nil {
-		panic(fmt.Sprintf("Could not start transaction: %v", err.Error()))
+		return nil, nil, err
 	}
 
-	defer func() {
-		r := recover()
-		if r == nil {
-			if dryRun {
-				common.LogInfo(bc.ctx, "Running in dry-run mode, calling rollback")
-				tx.Rollback()
-			} else {
-				common.LogInfo(bc.ctx, "Running %v action, committing transaction", action)
-				if err := tx.Commit(); err != nil {
-					panic(fmt.Sprintf("Could not commit transaction: %v", err.Error()))
+	return results, version, nil
+}
+
+// createVersionInSegments creates new DB version and applies passed migrations which contain no-transaction migrations
+// see applyMigrationsInSegments, optional before function is called in the first transaction before new version is created
+// when a no-transaction migration or any migration after it fails, migrations applied before it remain committed
+func (bc *baseConnector) createVersionInSegments(versionName string, action types.Action, tenants []types.Tenant, migrations []types.Migration, before func(*sql.Tx) error) (*types.Summary, *types.Version, error) {
+	results := &types.Summary{
+		StartedAt: graphql.Time{Time: time.Now()},
+		Tenants:   int32(len(tenants)),
+	}
+
+	insertMigrationSQL := bc.dialect.GetMigrationInsertSQL()
+	insert, err := bc.db.Prepare(insertMigrationSQL)
+	if err != nil {
+		return nil, nil, bc.newDBError("Could not create prepared statement for migration", err)
+	}
+
+	progress := bc.newProgress(tenants, migrations)
+
+	var versionID int64
+	err = bc.applyMigrationsInSegments(action, insert, &versionID, tenants, migrations, results, progress, func(tx *sql.Tx) error {
+		if before != nil {
+			if err := before(tx); err != nil {
+				return err
+			}
+		}
+		var err error
+		versionID, err = bc.insertVersionInTx(tx, versionName)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	results.VersionID = int32(versionID)
+	results.Duration = time.Since(results.StartedAt.Time).Seconds()
+	results.MigrationsGrandTotal = results.TenantMigrationsTotal + results.SingleMigrations
+	results.ScriptsGrandTotal = results.TenantScriptsTotal + results.SingleScripts
+
+	version, err := bc.GetVersionByID(results.VersionID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return results, version, nil
+}
+
+// applyMigrationsInSegments applies passed migrations to passed tenants, consecutive migrations are applied in a single transaction
+// every no-transaction migration commits the current transaction, is applied using applyNoTransactionMigration and then a new transaction is started
+// optional first function is called in the first transaction, it can be used to create new version and set versionID
+// results are updated only with migrations which were committed
+func (bc *baseConnector) applyMigrationsInSegments(action types.Action, insert *sql.Stmt, versionID *int64, tenants []types.Tenant, migrations []types.Migration, results *types.Summary, progress *progress, first func(*sql.Tx) error) error {
+	for {
+		i := 0
+		for i < len(migrations) && !migrations[i].NoTransaction() {
+			i++
+		}
+
+		if first != nil || i > 0 {
+			segmentResults := &types.Summary{}
+			err := bc.inTx(action, false, func(tx *sql.Tx) error {
+				if first != nil {
+					if err := first(tx); err != nil {
+						return err
+					}
 				}
+				return bc.applyMigrationsToSchemasInTx(tx, insert, *versionID, action, tenants, migrations[:i], segmentResults, progress)
+			})
+			if err != nil {
+				return err
 			}
-		} else {
-			common.LogInfo(bc.ctx, "Recovered in CreateTenant. Transaction rollback.")
+			addResults(results, segmentResults)
+			first = nil
+		}
+
+		if i == len(migrations) {
+			return nil
+		}
+
+		if err := bc.applyNoTransactionMigration(*versionID, action, tenants, migrations[i], results, progress); err != nil {
+			return err
+		}
+		migrations = migrations[i+1:]
+	}
+}
+
+// applyNoTransactionMigration applies passed migration outside of any transaction using a dedicated DB connection
+// migration is recorded as a part of passed version right after it was applied to a schema
+func (bc *baseConnector) applyNoTransactionMigration(versionID int64, action types.Action, tenants []types.Tenant, m types.Migration, results *types.Summary, progress *progress) error {
+	conn, err := bc.db.Conn(bc.ctx)
+	if err != nil {
+		return bc.newDBError("Could not obtain DB connection", err)
+	}
+	defer conn.Close()
+
+	insertMigrationSQL := bc.dialect.GetMigrationInsertSQL()
+	migrationTenants := getMigrationTenants(m, tenants)
+	schemas := bc.getMigrationSchemas(m, migrationTenants)
+
+	for i, s := range schemas {
+		common.LogInfo(bc.ctx, "Applying no-transaction migration type: %d, schema: %s, file: %s ", m.MigrationType, s, m.File)
+
+		contents, err := bc.renderMigration(m, getTemplateTenant(m, migrationTenants, i), s)
+		if err != nil {
+			return err
+		}
+
+		if action == types.ActionApply {
+			if err := bc.execMigration(conn, m.File, s, contents); err != nil {
+				return err
+			}
+		}
+
+		if _, err := conn.ExecContext(bc.ctx, insertMigrationSQL, m.Name, m.SourceDir, m.File, m.MigrationType, s, m.Contents, m.CheckSum, versionID, m.DownContents, bc.renderedContents(contents), m.SourceCommit); err != nil {
+			return bc.newDBError("Failed to add migration entry", err)
+		}
+
+		progress.increment()
+	}
+
+	countMigration(results, m, len(schemas))
+
+	return nil
+}
+
+// containsNoTransactionMigrations returns true if any of passed migrations must be applied outside of a transaction
+func containsNoTransactionMigrations(migrations []types.Migration) bool {
+	for _, m := range migrations {
+		if m.NoTransaction() {
+			return true
+		}
+	}
+	return false
+}
+
+// inTx runs passed function in a new DB transaction
+// transaction is rolled back if the function returns an error or when running in dry-run mode, otherwise it is committed
+func (bc *baseConnector) inTx(action interface{}, dryRun bool, f func(*sql.Tx) error) error {
+	tx, err := bc.db.Begin()
+	if err != nil {
+		return bc.newDBError("Could not start transaction", err)
+	}
+
+	defer func() {
+		// dialects panic on programming errors like invalid schema names, never leave transaction open
+		if r := recover(); r != nil {
+			common.LogInfo(bc.ctx, "Recovered from panic. Transaction rollback.")
 			tx.Rollback()
 			panic(r)
 		}
 	}()
 
-	createSchema := bc.dialect.GetCreateSchemaSQL(tenant)
-	if _, err = tx.Exec(createSchema); err != nil {
-		panic(fmt.Sprintf("Create schema failed: %v", err))
+	if err := f(tx); err != nil {
+		common.LogError(bc.ctx, "Running %v failed: %v. Transaction rollback.", action, err)
+		tx.Rollback()
+		return err
+	}
+
+	if dryRun {
+		common.LogInfo(bc.ctx, "Running in dry-run mode, calling rollback")
+		tx.Rollback()
+		return nil
 	}
 
-	insert, err := bc.db.Prepare(tenantInsertSQL)
-	if err != nil {
-		panic(fmt.Sprintf("Could not create prepared statement: %v", err))
+	common.LogInfo(bc.ctx, "Running %v, committing transaction", action)
+	if err := tx.Commit(); err != nil {
+		return bc.newDBError("Could not commit transaction", err)
 	}
 
-	_, err = tx.Stmt(insert).Exec(tenant)
+	return nil
+}
+
+// getLockTimeout returns a lock timeout which is
+// either the default one or overridden by user in config
+func (bc *baseConnector) getLockTimeout() time.Duration {
+	if bc.config.LockTimeout != "" {
+		// lock timeout is validated when config is loaded
+		if lockTimeout, err := time.ParseDuration(bc.config.LockTimeout); err == nil {
+			return lockTimeout
+		}
+	}
+	return defaultLockTimeout
+}
+
+// lock acquires migrator's DB-level lock using a dedicated DB connection (DB locks are owned by DB sessions)
+// the returned function releases the lock and returns the connection to the pool
+// LockTimeoutError is returned when the lock could not be acquired within the configured lock timeout
+func (bc *baseConnector) lock() (func(), error) {
+	lockTimeout := bc.getLockTimeout()
+
+	ctx, cancel := context.WithTimeout(bc.ctx, lockTimeout)
+	defer cancel()
+
+	conn, err := bc.db.Conn(ctx)
 	if err != nil {
-		panic(fmt.Sprintf("Failed to add tenant entry: %v", err))
+		return nil, bc.newDBError("Could not obtain DB connection", err)
 	}
 
-	tenantStruct := types.Tenant{Name: tenant}
-	results := bc.applyMigrationsInTx(tx, versionName, action, []types.Tenant{tenantStruct}, migrations)
+	var acquired int
+	err = conn.QueryRowContext(ctx, bc.dialect.GetLockSQL(lockTimeout)).Scan(&acquired)
+	if err != nil || acquired != 1 {
+		// connection may still be waiting for the lock, discard it instead of returning it to the pool
+		conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		if err != nil && ctx.Err() == nil {
+			return nil, bc.newDBError("Could not acquire migrator lock", err)
+		}
+		return nil, &types.LockTimeoutError{Timeout: lockTimeout}
+	}
 
-	version := bc.getVersionByIDInTx(tx, results.VersionID)
+	common.LogDebug(bc.ctx, "Acquired migrator lock")
 
-	return results, version
+	return func() {
+		if _, err := conn.ExecContext(bc.ctx, bc.dialect.GetUnlockSQL()); err != nil {
+			common.LogError(bc.ctx, "Could not release migrator lock: %v", err.Error())
+			// the lock is released when DB session ends, discard the connection
+			conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+			return
+		}
+		conn.Close()
+		common.LogDebug(bc.ctx, "Released migrator lock")
+	}, nil
 }
 
 // getTenantInsertSQL returns tenant insert SQL statement from configuration file
@@ -452,11 +1050,32 @@ func (bc *baseConnector) getTenantInsertSQL() string {
 	if bc.config.TenantInsertSQL != "" {
 		tenantInsertSQL = bc.config.TenantInsertSQL
 	} else {
-		tenantInsertSQL = bc.dialect.GetTenantInsertSQL()
+		tenantInsertSQL = bc.dialect.GetTenantInsertSQL(bc.config.TenantMetadataColumns)
 	}
 	return tenantInsertSQL
 }
 
+// getTenantInsertArgs returns tenant name followed by values of tenant metadata columns in the order of tenantMetadataColumns config property
+// metadata which is not set is inserted as null, error is returned when passed metadata contains unknown column
+func (bc *baseConnector) getTenantInsertArgs(tenant types.Tenant) ([]interface{}, error) {
+	args := []interface{}{tenant.Name}
+	columns := map[string]bool{}
+	for _, column := range bc.config.TenantMetadataColumns {
+		columns[column] = true
+		if value, ok := tenant.Metadata[column]; ok {
+			args = append(args, value)
+		} else {
+			args = append(args, nil)
+		}
+	}
+	for key := range tenant.Metadata {
+		if !columns[key] {
+			return nil, fmt.Errorf("unknown tenant metadata: %v, tenant metadata columns are configured using tenantMetadataColumns", key)
+		}
+	}
+	return args, nil
+}
+
 // getSchemaPlaceHolder returns a schema placeholder which is
 // either the default one or overridden by user in config
 func (bc *baseConnector) getSchemaPlaceHolder() string {
@@ -469,7 +1088,7 @@ func (bc *baseConnector) getSchemaPlaceHolder() string {
 	return schemaPlaceHolder
 }
 
-func (bc *baseConnector) applyMigrationsInTx(tx *sql.Tx, versionName string, action types.Action, tenants []types.Tenant, migrations []types.Migration) *types.Summary {
+func (bc *baseConnector) applyMigrationsInTx(tx *sql.Tx, versionName string, action types.Action, tenants []types.Tenant, migrations []types.Migration) (*types.Summary, error) {
 
 	results := &types.Summary{
 		StartedAt: graphql.Time{Time: time.Now()},
@@ -482,73 +1101,487 @@ func (bc *baseConnector) applyMigrationsInTx(tx *sql.Tx, versionName string, act
 		results.ScriptsGrandTotal = results.TenantScriptsTotal + results.SingleScripts
 	}()
 
-	schemaPlaceHolder := bc.getSchemaPlaceHolder()
+	versionID, err := bc.insertVersionInTx(tx, versionName)
+	if err != nil {
+		return nil, err
+	}
+
+	insertMigrationSQL := bc.dialect.GetMigrationInsertSQL()
+	insert, err := bc.db.Prepare(insertMigrationSQL)
+	if err != nil {
+		return nil, bc.newDBError("Could not create prepared statement for migration", err)
+	}
+
+	progress := bc.newProgress(tenants, migrations)
+
+	if err := bc.applyMigrationsToSchemasInTx(tx, insert, versionID, action, tenants, migrations, results, progress); err != nil {
+		return nil, err
+	}
+
+	results.VersionID = int32(versionID)
+
+	return results, nil
+}
+
+// applyMigrationsToSchemasInTx applies passed migrations to all their schemas and records them as a part of passed version
+// results counters are updated for every applied migration
+func (bc *baseConnector) applyMigrationsToSchemasInTx(tx *sql.Tx, insert *sql.Stmt, versionID int64, action types.Action, tenants []types.Tenant, migrations []types.Migration, results *types.Summary, progress *progress) error {
+
+	for _, m := range migrations {
+		migrationTenants := getMigrationTenants(m, tenants)
+		schemas := bc.getMigrationSchemas(m, migrationTenants)
+
+		for i, s := range schemas {
+			common.LogDebug(bc.ctx, "Applying migration type: %d, schema: %s, file: %s ", m.MigrationType, s, m.File)
+
+			contents, err := bc.renderMigration(m, getTemplateTenant(m, migrationTenants, i), s)
+			if err != nil {
+				return err
+			}
+
+			// no-transaction migrations end up in a transaction only in dry-run mode, they cannot be rolled back so are not executed
+			if action == types.ActionApply && !m.NoTransaction() {
+				if err := bc.execMigration(tx, m.File, s, contents); err != nil {
+					return err
+				}
+			}
+
+			if _, err := tx.Stmt(insert).Exec(m.Name, m.SourceDir, m.File, m.MigrationType, s, m.Contents, m.CheckSum, versionID, m.DownContents, bc.renderedContents(contents), m.SourceCommit); err != nil {
+				return bc.newDBError("Failed to add migration entry", err)
+			}
+
+			progress.increment()
+		}
+
+		countMigration(results, m, len(schemas))
+	}
+
+	return nil
+}
+
+// countMigration updates results counters with passed migration applied to passed number of schemas
+func countMigration(results *types.Summary, m types.Migration, schemas int) {
+	if m.MigrationType == types.MigrationTypeSingleMigration {
+		results.SingleMigrations++
+	}
+	if m.MigrationType == types.MigrationTypeSingleScript {
+		results.SingleScripts++
+	}
+	if m.MigrationType == types.MigrationTypeTenantMigration {
+		results.TenantMigrations++
+		results.TenantMigrationsTotal += int32(schemas)
+	}
+	if m.MigrationType == types.MigrationTypeTenantScript {
+		results.TenantScripts++
+		results.TenantScriptsTotal += int32(schemas)
+	}
+}
+
+// addResults adds counters of applied migrations and scripts from passed segment results to results
+func addResults(results *types.Summary, segmentResults *types.Summary) {
+	results.SingleMigrations += segmentResults.SingleMigrations
+	results.SingleScripts += segmentResults.SingleScripts
+	results.TenantMigrations += segmentResults.TenantMigrations
+	results.TenantMigrationsTotal += segmentResults.TenantMigrationsTotal
+	results.TenantScripts += segmentResults.TenantScripts
+	results.TenantScriptsTotal += segmentResults.TenantScriptsTotal
+}
+
+// execer is implemented by both DB transaction and dedicated DB connection
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
 
+// execMigration splits passed migration contents into statements and executes them one by one
+// when migration has more than one statement the returned error contains failed statement index and its line
+// statements are not bound to request context, once started migration is always run to the end
+func (bc *baseConnector) execMigration(ex execer, file, schema, contents string) error {
+	statements := splitStatements(contents, bc.dialect.GetSplitterOptions())
+	for i, s := range statements {
+		if _, err := ex.ExecContext(context.Background(), s.sql); err != nil {
+			migrationErr := bc.newMigrationError(file, schema, err)
+			if len(statements) > 1 {
+				migrationErr.Statement = i + 1
+				migrationErr.Line = s.line
+			}
+			return migrationErr
+		}
+	}
+	return nil
+}
+
+// renderMigration returns SQL of passed migration to be executed for passed schema, tenant is nil for single migrations and scripts
+func (bc *baseConnector) renderMigration(m types.Migration, tenant *types.Tenant, schema string) (string, error) {
+	return bc.renderContents(m.File, schema, tenant, m.Contents)
+}
+
+// Plan returns passed migrations together with schemas they would be applied to and their SQL rendered for every schema
+// only tenants are read from DB, neither migrator's lock is acquired nor a transaction is started
+func (bc *baseConnector) Plan(migrations []types.Migration) (*types.Plan, error) {
+	tenants, err := bc.GetTenants()
+	if err != nil {
+		return nil, err
+	}
+	tenants = getVersionTenants(migrations, tenants)
+
+	plan := &types.Plan{
+		Tenants:    int32(len(tenants)),
+		Migrations: []types.PlannedMigration{},
+	}
+
+	for _, m := range migrations {
+		migrationTenants := getMigrationTenants(m, tenants)
+		schemas := bc.getMigrationSchemas(m, migrationTenants)
+
+		planned := types.PlannedMigration{Migration: m, Schemas: schemas, Statements: []types.PlannedStatement{}}
+		for i, s := range schemas {
+			contents, err := bc.renderMigration(m, getTemplateTenant(m, migrationTenants, i), s)
+			if err != nil {
+				return nil, err
+			}
+			planned.Statements = append(planned.Statements, types.PlannedStatement{Schema: s, SQL: contents})
+		}
+		plan.Migrations = append(plan.Migrations, planned)
+
+		switch m.MigrationType {
+		case types.MigrationTypeSingleMigration:
+			plan.SingleMigrations++
+		case types.MigrationTypeSingleScript:
+			plan.SingleScripts++
+		case types.MigrationTypeTenantMigration:
+			plan.TenantMigrations++
+			plan.TenantMigrationsTotal += int32(len(schemas))
+		case types.MigrationTypeTenantScript:
+			plan.TenantScripts++
+			plan.TenantScriptsTotal += int32(len(schemas))
+		}
+	}
+
+	plan.MigrationsGrandTotal = plan.TenantMigrationsTotal + plan.SingleMigrations
+	plan.ScriptsGrandTotal = plan.TenantScriptsTotal + plan.SingleScripts
+
+	return plan, nil
+}
+
+// progress counts migrations applied to all schemas and reports them using common.ReportProgress
+// it is safe to use progress from multiple goroutines
+type progress struct {
+	ctx     context.Context
+	mutex   sync.Mutex
+	applied int32
+	total   int32
+}
+
+// newProgress creates progress for passed tenants and migrations and reports that nothing was applied yet
+func (bc *baseConnector) newProgress(tenants []types.Tenant, migrations []types.Migration) *progress {
+	p := &progress{ctx: bc.ctx}
+	for _, m := range migrations {
+		p.total += int32(len(bc.getMigrationSchemas(m, tenants)))
+	}
+	common.ReportProgress(p.ctx, p.applied, p.total)
+	return p
+}
+
+func (p *progress) increment() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.applied++
+	common.ReportProgress(p.ctx, p.applied, p.total)
+}
+
+// stringValue returns value of passed nullable string column or empty string if it is null
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// getMigrationSchemas returns schemas to which passed migration is applied
+// tenant migrations and scripts are applied to all tenants (or to Tenants of migration if set), single migrations and scripts to the schema named after source dir
+func (bc *baseConnector) getMigrationSchemas(m types.Migration, tenants []types.Tenant) []string {
+	var schemas []string
+	if m.MigrationType == types.MigrationTypeTenantMigration || m.MigrationType == types.MigrationTypeTenantScript {
+		for _, t := range getMigrationTenants(m, tenants) {
+			schemas = append(schemas, t.Name)
+		}
+	} else {
+		schemas = []string{filepath.Base(m.SourceDir)}
+	}
+	return schemas
+}
+
+// getMigrationTenants returns passed tenants to which passed migration is applied
+// when Tenants of migration is nil migration is applied to all tenants, order of passed tenants is preserved
+func getMigrationTenants(m types.Migration, tenants []types.Tenant) []types.Tenant {
+	if m.Tenants == nil {
+		return tenants
+	}
+	names := map[string]bool{}
+	for _, name := range m.Tenants {
+		names[name] = true
+	}
+	migrationTenants := []types.Tenant{}
+	for _, t := range tenants {
+		if names[t.Name] {
+			migrationTenants = append(migrationTenants, t)
+		}
+	}
+	return migrationTenants
+}
+
+// getVersionTenants returns passed tenants to which any of passed tenant migrations or scripts is applied
+// all tenants are returned when there are no tenant migrations and scripts or when any of them is applied to all tenants
+func getVersionTenants(migrations []types.Migration, tenants []types.Tenant) []types.Tenant {
+	names := map[string]bool{}
+	for _, m := range migrations {
+		if m.MigrationType != types.MigrationTypeTenantMigration && m.MigrationType != types.MigrationTypeTenantScript {
+			continue
+		}
+		if m.Tenants == nil {
+			return tenants
+		}
+		for _, name := range m.Tenants {
+			names[name] = true
+		}
+	}
+	if len(names) == 0 {
+		return tenants
+	}
+	versionTenants := []types.Tenant{}
+	for _, t := range tenants {
+		if names[t.Name] {
+			versionTenants = append(versionTenants, t)
+		}
+	}
+	return versionTenants
+}
+
+// insertVersionInTx creates new version and returns its ID
+func (bc *baseConnector) insertVersionInTx(tx *sql.Tx, versionName string) (int64, error) {
 	var versionID int64
 	versionInsertSQL := bc.dialect.GetVersionInsertSQL()
 	versionInsert, err := bc.db.Prepare(versionInsertSQL)
 	if err != nil {
-		panic(fmt.Sprintf("Could not create prepared statement for version: %v", err))
+		return 0, bc.newDBError("Could not create prepared statement for version", err)
 	}
 	stmt := tx.Stmt(versionInsert)
 	if bc.dialect.LastInsertIDSupported() {
-		result, _ := stmt.Exec(versionName)
+		result, err := stmt.Exec(versionName)
+		if err != nil {
+			return 0, bc.newDBError("Failed to add version entry", err)
+		}
 		versionID, _ = result.LastInsertId()
 	} else {
-		stmt.QueryRow(versionName).Scan(&versionID)
+		if err := stmt.QueryRow(versionName).Scan(&versionID); err != nil {
+			return 0, bc.newDBError("Failed to add version entry", err)
+		}
+	}
+	return versionID, nil
+}
+
+// RollbackVersion runs down migrations of the passed version in reverse order and records them as a new DB version
+// the rolled back version is kept for audit, its migrations are no longer applied, see skipRolledBackMigrations
+// only the latest version with applied migrations can be rolled back, scripts are applied always and are not rolled back
+func (bc *baseConnector) RollbackVersion(ID int32, versionName string, dryRun bool) (*types.Summary, *types.Version, error) {
+	if err := bc.init(); err != nil {
+		return nil, nil, err
+	}
+
+	unlock, err := bc.lock()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unlock()
+
+	if _, err := bc.GetVersionByID(ID); err != nil {
+		return nil, nil, err
+	}
+
+	// down migrations of older versions would run underneath migrations applied after them
+	latestID, err := bc.getLatestAppliedVersionID()
+	if err != nil {
+		return nil, nil, err
+	}
+	if ID != latestID {
+		return nil, nil, &types.RollbackRefusedError{VersionID: ID, Reason: fmt.Sprintf("only the latest version with applied migrations can be rolled back, version ID: %v, latest version ID: %v", ID, latestID)}
+	}
+
+	dbMigrations, err := bc.getMigrationsByVersionID(ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	downMigrations := []types.DBMigration{}
+	for _, m := range dbMigrations {
+		if m.MigrationType == types.MigrationTypeSingleScript || m.MigrationType == types.MigrationTypeTenantScript {
+			continue
+		}
+		if m.DownContents == "" {
+			return nil, nil, &types.RollbackRefusedError{VersionID: ID, File: m.File, Reason: fmt.Sprintf("down migration not found for: %v", m.File)}
+		}
+		downMigrations = append(downMigrations, m)
+	}
+
+	// tenants are read only to pass their metadata to down migration templates
+	var tenants []types.Tenant
+	if bc.config.TemplateRendering {
+		if tenants, err = bc.GetTenants(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := bc.renderDownMigrations(downMigrations, tenants); err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		results *types.Summary
+		version *types.Version
+	)
+
+	err = bc.inTx(fmt.Sprintf("rollback of version %v", ID), dryRun, func(tx *sql.Tx) error {
+		var err error
+		if results, err = bc.applyDownMigrationsInTx(tx, versionName, downMigrations, tenants); err != nil {
+			return err
+		}
+
+		version, err = bc.getVersionByIDInTx(tx, results.VersionID)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return results, version, nil
+}
+
+// getLatestAppliedVersionID returns ID of the latest version which has migrations that are still applied
+// rollback versions and rolled back versions do not have applied migrations, scripts are not taken into account as they cannot be rolled back
+func (bc *baseConnector) getLatestAppliedVersionID() (int32, error) {
+	versions, err := bc.GetVersions()
+	if err != nil {
+		return 0, err
+	}
+	// key is DBMigration.ID
+	versionIDs := map[int32]int32{}
+	dbMigrations := []types.DBMigration{}
+	for _, v := range versions {
+		for _, m := range v.DBMigrations {
+			versionIDs[m.ID] = v.ID
+			dbMigrations = append(dbMigrations, m)
+		}
+	}
+	var latestID int32
+	for _, m := range skipRolledBackMigrations(dbMigrations) {
+		if m.MigrationType == types.MigrationTypeSingleScript || m.MigrationType == types.MigrationTypeTenantScript {
+			continue
+		}
+		if versionIDs[m.ID] > latestID {
+			latestID = versionIDs[m.ID]
+		}
+	}
+	return latestID, nil
+}
+
+// getMigrationsByVersionID returns all DB migrations (including down contents) of given version in reverse order
+func (bc *baseConnector) getMigrationsByVersionID(ID int32) ([]types.DBMigration, error) {
+	query := bc.dialect.GetMigrationsByVersionIDSQL()
+
+	dbMigrations := []types.DBMigration{}
+
+	rows, err := bc.db.Query(query, ID)
+	if err != nil {
+		return nil, bc.newDBError("Could not query DB migrations", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id            int64
+			name          string
+			sourceDir     string
+			filename      string
+			migrationType types.MigrationType
+			schema        string
+			created       time.Time
+			contents      string
+			checksum      string
+			downContents  sql.NullString
+			sourceCommit  *string
+		)
+		if err = rows.Scan(&id, &name, &sourceDir, &filename, &migrationType, &schema, &created, &contents, &checksum, &downContents, &sourceCommit); err != nil {
+			return nil, bc.newDBError("Could not read DB migration", err)
+		}
+		m := types.Migration{Name: name, SourceDir: sourceDir, File: filename, MigrationType: migrationType, Contents: contents, CheckSum: checksum, DownContents: downContents.String, SourceCommit: sourceCommit}
+		dbMigrations = append(dbMigrations, types.DBMigration{Migration: m, ID: int32(id), Schema: schema, Created: graphql.Time{Time: created}})
+	}
+	return dbMigrations, nil
+}
+
+// applyDownMigrationsInTx runs down migrations in passed order and records them in a new version
+// down migrations are recorded using down migration file names, for example: 201602160002.down.sql
+func (bc *baseConnector) applyDownMigrationsInTx(tx *sql.Tx, versionName string, dbMigrations []types.DBMigration, allTenants []types.Tenant) (*types.Summary, error) {
+
+	results := &types.Summary{
+		StartedAt: graphql.Time{Time: time.Now()},
+	}
+
+	defer func() {
+		results.Duration = time.Since(results.StartedAt.Time).Seconds()
+		results.MigrationsGrandTotal = results.TenantMigrationsTotal + results.SingleMigrations
+		results.ScriptsGrandTotal = results.TenantScriptsTotal + results.SingleScripts
+	}()
+
+	versionID, err := bc.insertVersionInTx(tx, versionName)
+	if err != nil {
+		return nil, err
 	}
 
 	insertMigrationSQL := bc.dialect.GetMigrationInsertSQL()
 	insert, err := bc.db.Prepare(insertMigrationSQL)
 	if err != nil {
-		panic(fmt.Sprintf("Could not create prepared statement for migration: %v", err))
+		return nil, bc.newDBError("Could not create prepared statement for migration", err)
 	}
 
-	for _, m := range migrations {
-		var schemas []string
-		if m.MigrationType == types.MigrationTypeTenantMigration || m.MigrationType == types.MigrationTypeTenantScript {
-			for _, t := range tenants {
-				schemas = append(schemas, t.Name)
-			}
-		} else {
-			schemas = []string{filepath.Base(m.SourceDir)}
-		}
+	tenants := map[string]bool{}
+	tenantMigrations := map[string]bool{}
 
-		for _, s := range schemas {
-			common.LogDebug(bc.ctx, "Applying migration type: %d, schema: %s, file: %s ", m.MigrationType, s, m.File)
+	for _, dbm := range dbMigrations {
+		m := dbm.Migration
+		common.LogDebug(bc.ctx, "Rolling back migration type: %d, schema: %s, file: %s ", m.MigrationType, dbm.Schema, m.File)
 
-			if action == types.ActionApply {
-				contents := strings.Replace(m.Contents, schemaPlaceHolder, s, -1)
-				if _, err = tx.Exec(contents); err != nil {
-					panic(fmt.Sprintf("SQL migration %v failed with error: %v", m.File, err.Error()))
-				}
-			}
+		contents, err := bc.renderDownMigration(dbm, allTenants)
+		if err != nil {
+			return nil, err
+		}
+		if err := bc.execMigration(tx, types.DownMigrationFile(m.File), dbm.Schema, contents); err != nil {
+			return nil, err
+		}
 
-			if _, err = tx.Stmt(insert).Exec(m.Name, m.SourceDir, m.File, m.MigrationType, s, m.Contents, m.CheckSum, versionID); err != nil {
-				panic(fmt.Sprintf("Failed to add migration entry: %v", err.Error()))
-			}
+		hasher := sha256.New()
+		hasher.Write([]byte(m.DownContents))
+		checkSum := hex.EncodeToString(hasher.Sum(nil))
+
+		if _, err = tx.Stmt(insert).Exec(types.DownMigrationFile(m.Name), m.SourceDir, types.DownMigrationFile(m.File), m.MigrationType, dbm.Schema, m.DownContents, checkSum, versionID, "", bc.renderedContents(contents), m.SourceCommit); err != nil {
+			return nil, bc.newDBError("Failed to add migration entry", err)
 		}
 
 		if m.MigrationType == types.MigrationTypeSingleMigration {
 			results.SingleMigrations++
 		}
-		if m.MigrationType == types.MigrationTypeSingleScript {
-			results.SingleScripts++
-		}
 		if m.MigrationType == types.MigrationTypeTenantMigration {
-			results.TenantMigrations++
-			results.TenantMigrationsTotal += int32(len(schemas))
+			tenants[dbm.Schema] = true
+			tenantMigrations[m.File] = true
+			results.TenantMigrationsTotal++
 		}
-		if m.MigrationType == types.MigrationTypeTenantScript {
-			results.TenantScripts++
-			results.TenantScriptsTotal += int32(len(schemas))
-		}
-
 	}
 
+	results.Tenants = int32(len(tenants))
+	results.TenantMigrations = int32(len(tenantMigrations))
 	results.VersionID = int32(versionID)
 
-	return results
+	return results, nil
 }
 
 func (bc *baseConnector) HealthCheck() error {
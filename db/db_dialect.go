@@ -1,8 +1,11 @@
 package db
 
 import (
+	"database/sql"
 	"fmt"
 	"regexp"
+	"strings"
+	"time"
 
 	"github.com/lukaszbudnik/migrator/config"
 )
@@ -11,8 +14,8 @@ var isValidIdentifier = regexp.MustCompile(`^[A-Za-z0-9_-]+$`).MatchString
 
 // dialect returns SQL statements for given DB
 type dialect interface {
-	GetTenantInsertSQL() string
-	GetTenantSelectSQL() string
+	GetTenantInsertSQL([]string) string
+	GetTenantSelectSQL([]string) string
 	GetMigrationInsertSQL() string
 	GetMigrationSelectSQL() string
 	GetMigrationByIDSQL() string
@@ -24,6 +27,18 @@ type dialect interface {
 	GetVersionsSelectSQL() string
 	GetVersionsByFileSQL() string
 	GetVersionByIDSQL() string
+	GetAddDownContentsColumnSQL() []string
+	GetAddRenderedContentsColumnSQL() []string
+	GetAddSourceCommitColumnSQL() []string
+	GetAddTenantMetadataColumnSQL(string) []string
+	EnsureColumn(*sql.DB, string, string, string) error
+	GetMaxTenantConcurrency() int
+	GetMigrationsByVersionIDSQL() string
+	GetLockSQL(time.Duration) string
+	GetUnlockSQL() string
+	GetErrorCode(error) string
+	GetSplitterOptions() splitterOptions
+	QuoteIdentifier(string) string
 	LastInsertIDSupported() bool
 }
 
@@ -32,9 +47,9 @@ type baseDialect struct {
 }
 
 const (
-	selectVersionsSQL        = "select mv.id as vid, mv.name as vname, mv.created as vcreated, mm.id as mid, mm.name, mm.source_dir, mm.filename, mm.type, mm.db_schema, mm.created, mm.contents, mm.checksum from %v.%v mv left join %v.%v mm on mv.id = mm.version_id order by vid desc, mid asc"
-	selectMigrationsSQL      = "select name, source_dir as sd, filename, type, db_schema, created, contents, checksum from %v.%v order by name, source_dir"
-	selectTenantsSQL         = "select name from %v.%v"
+	selectVersionsSQL        = "select mv.id as vid, mv.name as vname, mv.created as vcreated, mm.id as mid, mm.name, mm.source_dir, mm.filename, mm.type, mm.db_schema, mm.created, mm.contents, mm.checksum, mm.source_commit from %v.%v mv left join %v.%v mm on mv.id = mm.version_id order by vid desc, mid asc"
+	selectMigrationsSQL      = "select name, source_dir as sd, filename, type, db_schema, created, contents, checksum, source_commit from %v.%v order by name, source_dir"
+	selectTenantsSQL         = "select %v from %v.%v"
 	createMigrationsTableSQL = `
 create table if not exists %v.%v (
   id serial primary key,
@@ -70,10 +85,30 @@ func (bd *baseDialect) GetCreateMigrationsTableSQL() string {
 	return fmt.Sprintf(createMigrationsTableSQL, migratorSchema, migratorMigrationsTable)
 }
 
-// GetTenantSelectSQL returns migrator's default tenant select SQL statement.
+// GetTenantSelectSQL returns migrator's default tenant select SQL statement, name is followed by passed tenant metadata columns.
 // This SQL is used by all MySQL, PostgreSQL, and MS SQL.
-func (bd *baseDialect) GetTenantSelectSQL() string {
-	return fmt.Sprintf(selectTenantsSQL, migratorSchema, migratorTenantsTable)
+func (bd *baseDialect) GetTenantSelectSQL(metadataColumns []string) string {
+	return fmt.Sprintf(selectTenantsSQL, tenantColumns(metadataColumns), migratorSchema, migratorTenantsTable)
+}
+
+// tenantColumns returns comma separated name column followed by passed tenant metadata columns
+func tenantColumns(metadataColumns []string) string {
+	return strings.Join(append([]string{"name"}, metadataColumns...), ", ")
+}
+
+// tenantPlaceholders returns comma separated placeholders of name column and passed tenant metadata columns
+// placeholder function returns dialect-specific placeholder for passed 1-based index
+func tenantPlaceholders(metadataColumns []string, placeholder func(int) string) string {
+	placeholders := make([]string, len(metadataColumns)+1)
+	for i := range placeholders {
+		placeholders[i] = placeholder(i + 1)
+	}
+	return strings.Join(placeholders, ", ")
+}
+
+// questionMarkPlaceholder returns ? placeholder used by MySQL and SQLite
+func questionMarkPlaceholder(int) string {
+	return "?"
 }
 
 // GetMigrationSelectSQL returns migrator's migrations select SQL statement.
@@ -97,6 +132,25 @@ func (bd *baseDialect) GetVersionsSelectSQL() string {
 	return fmt.Sprintf(selectVersionsSQL, migratorSchema, migratorVersionsTable, migratorSchema, migratorMigrationsTable)
 }
 
+// EnsureColumn is a no-op, columns are added using GetAddRenderedContentsColumnSQL, GetAddSourceCommitColumnSQL and GetAddTenantMetadataColumnSQL
+// statements which add columns only if they do not exist.
+// This is used by all MySQL, PostgreSQL, and MS SQL.
+func (bd *baseDialect) EnsureColumn(db *sql.DB, table, column, definition string) error {
+	return nil
+}
+
+// GetMaxTenantConcurrency returns 0 as tenants can be migrated concurrently without limits.
+// This is used by all MySQL, PostgreSQL, and MS SQL.
+func (bd *baseDialect) GetMaxTenantConcurrency() int {
+	return 0
+}
+
+// QuoteIdentifier returns passed identifier quoted using double quotes.
+// This is used by both PostgreSQL and SQLite.
+func (bd *baseDialect) QuoteIdentifier(identifier string) string {
+	return `"` + strings.Replace(identifier, `"`, `""`, -1) + `"`
+}
+
 // newDialect constructs dialect instance based on the passed Config
 func newDialect(config *config.Config) dialect {
 
@@ -112,6 +166,10 @@ func newDialect(config *config.Config) dialect {
 		// migrator switched to jackc/pgx PostgreSQL driver
 		// for backward compatibility the external Driver name is still "postgres" but internally it's now "pgx"
 		config.Driver = "pgx"
+	case "sqlite", "sqlite3":
+		dialect = &sqliteDialect{}
+		// the external Driver name is "sqlite" but internally mattn/go-sqlite3 registers itself as "sqlite3"
+		config.Driver = "sqlite3"
 	default:
 		panic(fmt.Sprintf("Failed to create Connector unknown driver: %v", config.Driver))
 	}
@@ -17,8 +17,9 @@ func TestInitCannotBeginTransactionError(t *testing.T) {
 	assert.Nil(t, err)
 
 	config := &config.Config{}
-	config.Driver = "sqlmock"
-	connector := baseConnector{newTestContext(), config, nil, db, false}
+	config.Driver = "postgres"
+	dialect := newDialect(config)
+	connector := baseConnector{newTestContext(), config, dialect, db, false, nil}
 
 	mock.ExpectBegin().WillReturnError(errors.New("trouble maker"))
 
@@ -39,7 +40,7 @@ func TestInitCannotCreateMigratorSchema(t *testing.T) {
 	config := &config.Config{}
 	config.Driver = "postgres"
 	dialect := newDialect(config)
-	connector := baseConnector{newTestContext(), config, dialect, db, false}
+	connector := baseConnector{newTestContext(), config, dialect, db, false, nil}
 
 	mock.ExpectBegin()
 	// don't have to provide full SQL here - patterns at work
@@ -62,7 +63,7 @@ func TestInitCannotCreateMigratorMigrationsTable(t *testing.T) {
 	config := &config.Config{}
 	config.Driver = "postgres"
 	dialect := newDialect(config)
-	connector := baseConnector{newTestContext(), config, dialect, db, false}
+	connector := baseConnector{newTestContext(), config, dialect, db, false, nil}
 
 	mock.ExpectBegin()
 	// don't have to provide full SQL here - patterns at work
@@ -86,7 +87,7 @@ func TestInitCannotCreateMigratorVersionsTable(t *testing.T) {
 	config := &config.Config{}
 	config.Driver = "postgres"
 	dialect := newDialect(config)
-	connector := baseConnector{newTestContext(), config, dialect, db, false}
+	connector := baseConnector{newTestContext(), config, dialect, db, false, nil}
 
 	mock.ExpectBegin()
 	// don't have to provide full SQL here - patterns at work
@@ -105,6 +106,61 @@ func TestInitCannotCreateMigratorVersionsTable(t *testing.T) {
 	}
 }
 
+func TestInitCannotAddDownContentsColumn(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Nil(t, err)
+
+	config := &config.Config{}
+	config.Driver = "postgres"
+	dialect := newDialect(config)
+	connector := baseConnector{newTestContext(), config, dialect, db, false, nil}
+
+	mock.ExpectBegin()
+	// don't have to provide full SQL here - patterns at work
+	mock.ExpectExec("create schema").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("create table").WillReturnResult(sqlmock.NewResult(0, 0))
+	// create versions table is a script
+	mock.ExpectExec("begin").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("alter table").WillReturnError(errors.New("trouble maker"))
+
+	initErr := connector.init()
+
+	assert.NotNil(t, initErr)
+	assert.Contains(t, initErr.Error(), "could not add down contents column: trouble maker")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestInitCannotAddRenderedContentsColumn(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Nil(t, err)
+
+	config := &config.Config{}
+	config.Driver = "postgres"
+	dialect := newDialect(config)
+	connector := baseConnector{newTestContext(), config, dialect, db, false, nil}
+
+	mock.ExpectBegin()
+	// don't have to provide full SQL here - patterns at work
+	mock.ExpectExec("create schema").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("create table").WillReturnResult(sqlmock.NewResult(0, 0))
+	// create versions table is a script
+	mock.ExpectExec("begin").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("alter table").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("alter table").WillReturnError(errors.New("trouble maker"))
+
+	initErr := connector.init()
+
+	assert.NotNil(t, initErr)
+	assert.Contains(t, initErr.Error(), "could not add rendered contents column: trouble maker")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
 func TestInitCannotCreateMigratorTenantsTable(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	assert.Nil(t, err)
@@ -112,7 +168,7 @@ func TestInitCannotCreateMigratorTenantsTable(t *testing.T) {
 	config := &config.Config{}
 	config.Driver = "postgres"
 	dialect := newDialect(config)
-	connector := baseConnector{newTestContext(), config, dialect, db, false}
+	connector := baseConnector{newTestContext(), config, dialect, db, false, nil}
 
 	mock.ExpectBegin()
 	// don't have to provide full SQL here - patterns at work
@@ -120,6 +176,9 @@ func TestInitCannotCreateMigratorTenantsTable(t *testing.T) {
 	mock.ExpectExec("create table").WillReturnResult(sqlmock.NewResult(0, 0))
 	// create versions table is a script
 	mock.ExpectExec("begin").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("alter table").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("alter table").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("alter table").WillReturnResult(sqlmock.NewResult(0, 0))
 	mock.ExpectExec("create table").WillReturnError(errors.New("trouble maker"))
 
 	initErr := connector.init()
@@ -139,13 +198,16 @@ func TestInitCannotCommitTransaction(t *testing.T) {
 	config := &config.Config{}
 	config.Driver = "postgres"
 	dialect := newDialect(config)
-	connector := baseConnector{newTestContext(), config, dialect, db, false}
+	connector := baseConnector{newTestContext(), config, dialect, db, false, nil}
 
 	mock.ExpectBegin()
 	// don't have to provide full SQL here - patterns at work
 	mock.ExpectExec("create schema").WillReturnResult(sqlmock.NewResult(0, 0))
 	mock.ExpectExec("create table").WillReturnResult(sqlmock.NewResult(0, 0))
 	mock.ExpectExec("begin").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("alter table").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("alter table").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("alter table").WillReturnResult(sqlmock.NewResult(0, 0))
 	mock.ExpectExec("create table").WillReturnResult(sqlmock.NewResult(0, 0))
 	mock.ExpectCommit().WillReturnError(errors.New("trouble maker"))
 
@@ -166,14 +228,15 @@ func TestGetTenantsError(t *testing.T) {
 	config := &config.Config{}
 	config.Driver = "postgres"
 	dialect := newDialect(config)
-	connector := baseConnector{newTestContext(), config, dialect, db, true}
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
 
 	// don't have to provide full SQL here - patterns at work
 	mock.ExpectQuery("select").WillReturnError(errors.New("trouble maker"))
 
-	assert.PanicsWithValue(t, "Could not query tenants: trouble maker", func() {
-		connector.GetTenants()
-	})
+	_, err = connector.GetTenants()
+	assert.Equal(t, "Could not query tenants: trouble maker", err.Error())
+	var dbErr *types.DBError
+	assert.True(t, errors.As(err, &dbErr))
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
@@ -187,14 +250,88 @@ func TestGetAppliedMigrationsError(t *testing.T) {
 	config := &config.Config{}
 	config.Driver = "postgres"
 	dialect := newDialect(config)
-	connector := baseConnector{newTestContext(), config, dialect, db, true}
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
 
 	// don't have to provide full SQL here - patterns at work
 	mock.ExpectQuery("select").WillReturnError(errors.New("trouble maker"))
 
-	assert.PanicsWithValue(t, "Could not query DB migrations: trouble maker", func() {
-		connector.GetAppliedMigrations()
-	})
+	_, err = connector.GetAppliedMigrations()
+	assert.Equal(t, "Could not query DB migrations: trouble maker", err.Error())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCreateVersionLockTimeout(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Nil(t, err)
+
+	config := &config.Config{}
+	config.Driver = "mysql"
+	config.LockTimeout = "5s"
+	dialect := newDialect(config)
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
+
+	// get_lock returns 0 when lock could not be acquired within timeout
+	mock.ExpectQuery("get_lock").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(0))
+
+	t1 := time.Now().UnixNano()
+	tenant1 := types.Migration{Name: fmt.Sprintf("%v.sql", t1), SourceDir: "tenants", File: fmt.Sprintf("tenants/%v.sql", t1), MigrationType: types.MigrationTypeTenantMigration, Contents: "insert into {schema}.settings values (456, '456') "}
+	migrationsToApply := []types.Migration{tenant1}
+
+	results, version, err := connector.CreateVersion("commit-sha", types.ActionApply, migrationsToApply, false)
+	assert.Nil(t, results)
+	assert.Nil(t, version)
+	assert.Equal(t, "could not acquire migrator lock within 5s, another migrator operation is in progress", err.Error())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCreateTenantLockTimeout(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Nil(t, err)
+
+	config := &config.Config{}
+	config.Driver = "postgres"
+	config.LockTimeout = "100ms"
+	dialect := newDialect(config)
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
+
+	// pg_advisory_lock waits until context deadline is exceeded
+	mock.ExpectQuery("pg_advisory_lock").WillDelayFor(time.Second).WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
+
+	migrationsToApply := []types.Migration{}
+
+	results, version, err := connector.CreateTenant(types.Tenant{Name: "newtenant"}, "commit-sha", types.ActionApply, migrationsToApply, false)
+	assert.Nil(t, results)
+	assert.Nil(t, version)
+	assert.Equal(t, "could not acquire migrator lock within 100ms, another migrator operation is in progress", err.Error())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCreateVersionLockError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Nil(t, err)
+
+	config := &config.Config{}
+	config.Driver = "postgres"
+	dialect := newDialect(config)
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
+
+	mock.ExpectQuery("pg_advisory_lock").WillReturnError(errors.New("trouble maker"))
+
+	t1 := time.Now().UnixNano()
+	tenant1 := types.Migration{Name: fmt.Sprintf("%v.sql", t1), SourceDir: "tenants", File: fmt.Sprintf("tenants/%v.sql", t1), MigrationType: types.MigrationTypeTenantMigration, Contents: "insert into {schema}.settings values (456, '456') "}
+	migrationsToApply := []types.Migration{tenant1}
+
+	_, _, err = connector.CreateVersion("commit-sha", types.ActionApply, migrationsToApply, false)
+	assert.Equal(t, "Could not acquire migrator lock: trouble maker", err.Error())
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
@@ -208,19 +345,20 @@ func TestCreateVersionTransactionBeginError(t *testing.T) {
 	config := &config.Config{}
 	config.Driver = "postgres"
 	dialect := newDialect(config)
-	connector := baseConnector{newTestContext(), config, dialect, db, true}
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
 
 	rows := sqlmock.NewRows([]string{"name"}).AddRow("tenantname")
+	mock.ExpectQuery("pg_advisory_lock").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
 	mock.ExpectQuery("select").WillReturnRows(rows)
 	mock.ExpectBegin().WillReturnError(errors.New("trouble maker tx.Begin()"))
+	mock.ExpectExec("pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
 
 	t1 := time.Now().UnixNano()
 	tenant1 := types.Migration{Name: fmt.Sprintf("%v.sql", t1), SourceDir: "tenants", File: fmt.Sprintf("tenants/%v.sql", t1), MigrationType: types.MigrationTypeTenantMigration, Contents: "insert into {schema}.settings values (456, '456') "}
 	migrationsToApply := []types.Migration{tenant1}
 
-	assert.PanicsWithValue(t, "Could not start transaction: trouble maker tx.Begin()", func() {
-		connector.CreateVersion("commit-sha", types.ActionApply, migrationsToApply, false)
-	})
+	_, _, err = connector.CreateVersion("commit-sha", types.ActionApply, migrationsToApply, false)
+	assert.Equal(t, "Could not start transaction: trouble maker tx.Begin()", err.Error())
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
@@ -234,21 +372,22 @@ func TestCreateVersionInsertVersionPreparedStatementError(t *testing.T) {
 	config := &config.Config{}
 	config.Driver = "postgres"
 	dialect := newDialect(config)
-	connector := baseConnector{newTestContext(), config, dialect, db, true}
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
 
 	tenants := sqlmock.NewRows([]string{"name"}).AddRow("tenantname")
+	mock.ExpectQuery("pg_advisory_lock").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
 	mock.ExpectQuery("select").WillReturnRows(tenants)
 	mock.ExpectBegin()
 	mock.ExpectPrepare("insert into").WillReturnError(errors.New("trouble maker"))
 	mock.ExpectRollback()
+	mock.ExpectExec("pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
 
 	t1 := time.Now().UnixNano()
 	tenant1 := types.Migration{Name: fmt.Sprintf("%v.sql", t1), SourceDir: "tenants", File: fmt.Sprintf("tenants/%v.sql", t1), MigrationType: types.MigrationTypeTenantMigration, Contents: "insert into {schema}.settings values (456, '456') "}
 	migrationsToApply := []types.Migration{tenant1}
 
-	assert.PanicsWithValue(t, "Could not create prepared statement for version: trouble maker", func() {
-		connector.CreateVersion("commit-sha", types.ActionApply, migrationsToApply, false)
-	})
+	_, _, err = connector.CreateVersion("commit-sha", types.ActionApply, migrationsToApply, false)
+	assert.Equal(t, "Could not create prepared statement for version: trouble maker", err.Error())
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
@@ -262,25 +401,26 @@ func TestCreateVersionInsertMigrationPreparedStatementError(t *testing.T) {
 	config := &config.Config{}
 	config.Driver = "postgres"
 	dialect := newDialect(config)
-	connector := baseConnector{newTestContext(), config, dialect, db, true}
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
 
 	tenants := sqlmock.NewRows([]string{"name"}).AddRow("tenantname")
+	mock.ExpectQuery("pg_advisory_lock").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
 	mock.ExpectQuery("select").WillReturnRows(tenants)
 	mock.ExpectBegin()
 	// version
 	mock.ExpectPrepare("insert into migrator.migrator_versions")
-	mock.ExpectPrepare("insert into migrator.migrator_versions").ExpectQuery().WithArgs("commit-sha")
+	mock.ExpectPrepare("insert into migrator.migrator_versions").ExpectQuery().WithArgs("commit-sha").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(0))
 	// migration
 	mock.ExpectPrepare("insert into migrator.migrator_migrations").WillReturnError(errors.New("trouble maker"))
 	mock.ExpectRollback()
+	mock.ExpectExec("pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
 
 	t1 := time.Now().UnixNano()
 	tenant1 := types.Migration{Name: fmt.Sprintf("%v.sql", t1), SourceDir: "tenants", File: fmt.Sprintf("tenants/%v.sql", t1), MigrationType: types.MigrationTypeTenantMigration, Contents: "insert into {schema}.settings values (456, '456') "}
 	migrationsToApply := []types.Migration{tenant1}
 
-	assert.PanicsWithValue(t, "Could not create prepared statement for migration: trouble maker", func() {
-		connector.CreateVersion("commit-sha", types.ActionApply, migrationsToApply, false)
-	})
+	_, _, err = connector.CreateVersion("commit-sha", types.ActionApply, migrationsToApply, false)
+	assert.Equal(t, "Could not create prepared statement for migration: trouble maker", err.Error())
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
@@ -294,26 +434,31 @@ func TestCreateVersionMigrationSQLError(t *testing.T) {
 	config := &config.Config{}
 	config.Driver = "postgres"
 	dialect := newDialect(config)
-	connector := baseConnector{newTestContext(), config, dialect, db, true}
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
 
 	tenants := sqlmock.NewRows([]string{"name"}).AddRow("tenantname")
+	mock.ExpectQuery("pg_advisory_lock").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
 	mock.ExpectQuery("select").WillReturnRows(tenants)
 	mock.ExpectBegin()
 	// version
 	mock.ExpectPrepare("insert into migrator.migrator_versions")
-	mock.ExpectPrepare("insert into migrator.migrator_versions").ExpectQuery().WithArgs("commit-sha")
+	mock.ExpectPrepare("insert into migrator.migrator_versions").ExpectQuery().WithArgs("commit-sha").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(0))
 	// migration
 	mock.ExpectPrepare("insert into migrator.migrator_migrations")
 	mock.ExpectExec("insert into").WillReturnError(errors.New("trouble maker"))
 	mock.ExpectRollback()
+	mock.ExpectExec("pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
 
 	t1 := time.Now().UnixNano()
 	tenant1 := types.Migration{Name: fmt.Sprintf("%v.sql", t1), SourceDir: "tenants", File: fmt.Sprintf("tenants/%v.sql", t1), MigrationType: types.MigrationTypeTenantMigration, Contents: "insert into {schema}.settings values (456, '456') "}
 	migrationsToApply := []types.Migration{tenant1}
 
-	assert.PanicsWithValue(t, fmt.Sprintf("SQL migration %v failed with error: trouble maker", tenant1.File), func() {
-		connector.CreateVersion("commit-sha", types.ActionApply, migrationsToApply, false)
-	})
+	_, _, err = connector.CreateVersion("commit-sha", types.ActionApply, migrationsToApply, false)
+	assert.Equal(t, fmt.Sprintf("SQL migration %v failed for schema tenantname with error: trouble maker", tenant1.File), err.Error())
+	var migrationErr *types.MigrationError
+	assert.True(t, errors.As(err, &migrationErr))
+	assert.Equal(t, tenant1.File, migrationErr.File)
+	assert.Equal(t, "tenantname", migrationErr.Schema)
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
@@ -327,7 +472,7 @@ func TestCreateVersionInsertMigrationError(t *testing.T) {
 	config := &config.Config{}
 	config.Driver = "postgres"
 	dialect := newDialect(config)
-	connector := baseConnector{newTestContext(), config, dialect, db, true}
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
 
 	time := time.Now().UnixNano()
 	m := types.Migration{Name: fmt.Sprintf("%v.sql", time), SourceDir: "tenants", File: fmt.Sprintf("tenants/%v.sql", time), MigrationType: types.MigrationTypeTenantMigration, Contents: "insert into {schema}.settings values (456, '456') "}
@@ -335,20 +480,21 @@ func TestCreateVersionInsertMigrationError(t *testing.T) {
 
 	tenant := "tenantname"
 	tenants := sqlmock.NewRows([]string{"name"}).AddRow(tenant)
+	mock.ExpectQuery("pg_advisory_lock").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
 	mock.ExpectQuery("select").WillReturnRows(tenants)
 	mock.ExpectBegin()
 	// version
 	mock.ExpectPrepare("insert into migrator.migrator_versions")
-	mock.ExpectPrepare("insert into migrator.migrator_versions").ExpectQuery().WithArgs("commit-sha")
+	mock.ExpectPrepare("insert into migrator.migrator_versions").ExpectQuery().WithArgs("commit-sha").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(0))
 	// migration
 	mock.ExpectPrepare("insert into migrator.migrator_migrations")
 	mock.ExpectExec("insert into").WillReturnResult(sqlmock.NewResult(0, 0))
-	mock.ExpectPrepare("insert into migrator.migrator_migrations").ExpectExec().WithArgs(m.Name, m.SourceDir, m.File, m.MigrationType, tenant, m.Contents, m.CheckSum, 0).WillReturnError(errors.New("trouble maker"))
+	mock.ExpectPrepare("insert into migrator.migrator_migrations").ExpectExec().WithArgs(m.Name, m.SourceDir, m.File, m.MigrationType, tenant, m.Contents, m.CheckSum, 0, m.DownContents, nil, m.SourceCommit).WillReturnError(errors.New("trouble maker"))
 	mock.ExpectRollback()
+	mock.ExpectExec("pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
 
-	assert.PanicsWithValue(t, "Failed to add migration entry: trouble maker", func() {
-		connector.CreateVersion("commit-sha", types.ActionApply, migrationsToApply, false)
-	})
+	_, _, err = connector.CreateVersion("commit-sha", types.ActionApply, migrationsToApply, false)
+	assert.Equal(t, "Failed to add migration entry: trouble maker", err.Error())
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
@@ -362,7 +508,7 @@ func TestCreateVersionGetVersionError(t *testing.T) {
 	config := &config.Config{}
 	config.Driver = "postgres"
 	dialect := newDialect(config)
-	connector := baseConnector{newTestContext(), config, dialect, db, true}
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
 
 	tn := time.Now().UnixNano()
 	m := types.Migration{Name: fmt.Sprintf("%v.sql", tn), SourceDir: "tenants", File: fmt.Sprintf("tenants/%v.sql", tn), MigrationType: types.MigrationTypeTenantMigration, Contents: "insert into {schema}.settings values (456, '456') "}
@@ -370,21 +516,23 @@ func TestCreateVersionGetVersionError(t *testing.T) {
 
 	tenant := "tenantname"
 	tenants := sqlmock.NewRows([]string{"name"}).AddRow(tenant)
+	mock.ExpectQuery("pg_advisory_lock").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
 	mock.ExpectQuery("select").WillReturnRows(tenants)
 	mock.ExpectBegin()
 	// version
 	mock.ExpectPrepare("insert into migrator.migrator_versions")
-	mock.ExpectPrepare("insert into migrator.migrator_versions").ExpectQuery().WithArgs("commit-sha")
+	mock.ExpectPrepare("insert into migrator.migrator_versions").ExpectQuery().WithArgs("commit-sha").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(0))
 	// migration
 	mock.ExpectPrepare("insert into migrator.migrator_migrations")
 	mock.ExpectExec("insert into").WillReturnResult(sqlmock.NewResult(0, 0))
-	mock.ExpectPrepare("insert into migrator.migrator_migrations").ExpectExec().WithArgs(m.Name, m.SourceDir, m.File, m.MigrationType, tenant, m.Contents, m.CheckSum, 0).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectPrepare("insert into migrator.migrator_migrations").ExpectExec().WithArgs(m.Name, m.SourceDir, m.File, m.MigrationType, tenant, m.Contents, m.CheckSum, 0, m.DownContents, nil, m.SourceCommit).WillReturnResult(sqlmock.NewResult(0, 0))
 	// get version
 	mock.ExpectQuery("select").WillReturnError(errors.New("get version trouble maker"))
+	mock.ExpectRollback()
+	mock.ExpectExec("pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
 
-	assert.PanicsWithValue(t, "Could not query versions: get version trouble maker", func() {
-		connector.CreateVersion("commit-sha", types.ActionApply, migrationsToApply, false)
-	})
+	_, _, err = connector.CreateVersion("commit-sha", types.ActionApply, migrationsToApply, false)
+	assert.Equal(t, "Could not query versions: get version trouble maker", err.Error())
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
@@ -398,7 +546,7 @@ func TestCreateVersionVersionNotFound(t *testing.T) {
 	config := &config.Config{}
 	config.Driver = "postgres"
 	dialect := newDialect(config)
-	connector := baseConnector{newTestContext(), config, dialect, db, true}
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
 
 	tn := time.Now().UnixNano()
 	m := types.Migration{Name: fmt.Sprintf("%v.sql", tn), SourceDir: "tenants", File: fmt.Sprintf("tenants/%v.sql", tn), MigrationType: types.MigrationTypeTenantMigration, Contents: "insert into {schema}.settings values (456, '456') "}
@@ -406,22 +554,24 @@ func TestCreateVersionVersionNotFound(t *testing.T) {
 
 	tenant := "tenantname"
 	tenants := sqlmock.NewRows([]string{"name"}).AddRow(tenant)
+	mock.ExpectQuery("pg_advisory_lock").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
 	mock.ExpectQuery("select").WillReturnRows(tenants)
 	mock.ExpectBegin()
 	// version
 	mock.ExpectPrepare("insert into migrator.migrator_versions")
-	mock.ExpectPrepare("insert into migrator.migrator_versions").ExpectQuery().WithArgs("commit-sha")
+	mock.ExpectPrepare("insert into migrator.migrator_versions").ExpectQuery().WithArgs("commit-sha").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(0))
 	// migration
 	mock.ExpectPrepare("insert into migrator.migrator_migrations")
 	mock.ExpectExec("insert into").WillReturnResult(sqlmock.NewResult(0, 0))
-	mock.ExpectPrepare("insert into migrator.migrator_migrations").ExpectExec().WithArgs(m.Name, m.SourceDir, m.File, m.MigrationType, tenant, m.Contents, m.CheckSum, 0).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectPrepare("insert into migrator.migrator_migrations").ExpectExec().WithArgs(m.Name, m.SourceDir, m.File, m.MigrationType, tenant, m.Contents, m.CheckSum, 0, m.DownContents, nil, m.SourceCommit).WillReturnResult(sqlmock.NewResult(0, 0))
 	// get version
-	rows := sqlmock.NewRows([]string{"vid", "vname", "vcreated", "mid", "name", "source_dir", "filename", "type", "db_schema", "created", "contents", "checksum"})
+	rows := sqlmock.NewRows([]string{"vid", "vname", "vcreated", "mid", "name", "source_dir", "filename", "type", "db_schema", "created", "contents", "checksum", "source_commit"})
 	mock.ExpectQuery("select").WillReturnRows(rows)
+	mock.ExpectRollback()
+	mock.ExpectExec("pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
 
-	assert.PanicsWithValue(t, "Version not found ID: 0", func() {
-		connector.CreateVersion("commit-sha", types.ActionApply, migrationsToApply, false)
-	})
+	_, _, err = connector.CreateVersion("commit-sha", types.ActionApply, migrationsToApply, false)
+	assert.Equal(t, "Version not found ID: 0", err.Error())
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
@@ -435,7 +585,7 @@ func TestCreateVersionMigrationsCommitError(t *testing.T) {
 	config := &config.Config{}
 	config.Driver = "postgres"
 	dialect := newDialect(config)
-	connector := baseConnector{newTestContext(), config, dialect, db, true}
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
 
 	tn := time.Now().UnixNano()
 	m := types.Migration{Name: fmt.Sprintf("%v.sql", tn), SourceDir: "tenants", File: fmt.Sprintf("tenants/%v.sql", tn), MigrationType: types.MigrationTypeTenantMigration, Contents: "insert into {schema}.settings values (456, '456') "}
@@ -443,23 +593,24 @@ func TestCreateVersionMigrationsCommitError(t *testing.T) {
 
 	tenant := "tenantname"
 	tenants := sqlmock.NewRows([]string{"name"}).AddRow(tenant)
+	mock.ExpectQuery("pg_advisory_lock").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
 	mock.ExpectQuery("select").WillReturnRows(tenants)
 	mock.ExpectBegin()
 	// version
 	mock.ExpectPrepare("insert into migrator.migrator_versions")
-	mock.ExpectPrepare("insert into migrator.migrator_versions").ExpectQuery().WithArgs("commit-sha")
+	mock.ExpectPrepare("insert into migrator.migrator_versions").ExpectQuery().WithArgs("commit-sha").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(0))
 	// migration
 	mock.ExpectPrepare("insert into migrator.migrator_migrations")
 	mock.ExpectExec("insert into").WillReturnResult(sqlmock.NewResult(0, 0))
-	mock.ExpectPrepare("insert into migrator.migrator_migrations").ExpectExec().WithArgs(m.Name, m.SourceDir, m.File, m.MigrationType, tenant, m.Contents, m.CheckSum, 0).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectPrepare("insert into migrator.migrator_migrations").ExpectExec().WithArgs(m.Name, m.SourceDir, m.File, m.MigrationType, tenant, m.Contents, m.CheckSum, 0, m.DownContents, nil, m.SourceCommit).WillReturnResult(sqlmock.NewResult(0, 0))
 	// get version
-	rows := sqlmock.NewRows([]string{"vid", "vname", "vcreated", "mid", "name", "source_dir", "filename", "type", "db_schema", "created", "contents", "checksum"}).AddRow("123", "vname", time.Now(), "456", m.Name, m.SourceDir, m.File, m.MigrationType, tenant, time.Now(), m.Contents, m.CheckSum)
+	rows := sqlmock.NewRows([]string{"vid", "vname", "vcreated", "mid", "name", "source_dir", "filename", "type", "db_schema", "created", "contents", "checksum", "source_commit"}).AddRow("123", "vname", time.Now(), "456", m.Name, m.SourceDir, m.File, m.MigrationType, tenant, time.Now(), m.Contents, m.CheckSum, nil)
 	mock.ExpectQuery("select").WillReturnRows(rows)
 	mock.ExpectCommit().WillReturnError(errors.New("tx trouble maker"))
+	mock.ExpectExec("pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
 
-	assert.PanicsWithValue(t, "Could not commit transaction: tx trouble maker", func() {
-		connector.CreateVersion("commit-sha", types.ActionApply, migrationsToApply, false)
-	})
+	_, _, err = connector.CreateVersion("commit-sha", types.ActionApply, migrationsToApply, false)
+	assert.Equal(t, "Could not commit transaction: tx trouble maker", err.Error())
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
@@ -473,17 +624,18 @@ func TestCreateTenantTransactionBeginError(t *testing.T) {
 	config := &config.Config{}
 	config.Driver = "postgres"
 	dialect := newDialect(config)
-	connector := baseConnector{newTestContext(), config, dialect, db, true}
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
 
+	mock.ExpectQuery("pg_advisory_lock").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
 	mock.ExpectBegin().WillReturnError(errors.New("trouble maker tx.Begin()"))
+	mock.ExpectExec("pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
 
 	t1 := time.Now().UnixNano()
 	tenant1 := types.Migration{Name: fmt.Sprintf("%v.sql", t1), SourceDir: "tenants", File: fmt.Sprintf("tenants/%v.sql", t1), MigrationType: types.MigrationTypeTenantMigration, Contents: "insert into {schema}.settings values (456, '456') "}
 	migrationsToApply := []types.Migration{tenant1}
 
-	assert.PanicsWithValue(t, "Could not start transaction: trouble maker tx.Begin()", func() {
-		connector.CreateTenant("newtenant", "commit-sha", types.ActionApply, migrationsToApply, false)
-	})
+	_, _, err = connector.CreateTenant(types.Tenant{Name: "newtenant"}, "commit-sha", types.ActionApply, migrationsToApply, false)
+	assert.Equal(t, "Could not start transaction: trouble maker tx.Begin()", err.Error())
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
@@ -497,19 +649,20 @@ func TestCreateTenantCreateSchemaError(t *testing.T) {
 	config := &config.Config{}
 	config.Driver = "postgres"
 	dialect := newDialect(config)
-	connector := baseConnector{newTestContext(), config, dialect, db, true}
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
 
+	mock.ExpectQuery("pg_advisory_lock").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
 	mock.ExpectBegin()
 	mock.ExpectExec("create schema").WillReturnError(errors.New("trouble maker"))
 	mock.ExpectRollback()
+	mock.ExpectExec("pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
 
 	t1 := time.Now().UnixNano()
 	tenant1 := types.Migration{Name: fmt.Sprintf("%v.sql", t1), SourceDir: "tenants", File: fmt.Sprintf("tenants/%v.sql", t1), MigrationType: types.MigrationTypeTenantMigration, Contents: "insert into {schema}.settings values (456, '456') "}
 	migrationsToApply := []types.Migration{tenant1}
 
-	assert.PanicsWithValue(t, "Create schema failed: trouble maker", func() {
-		connector.CreateTenant("newtenant", "commit-sha", types.ActionApply, migrationsToApply, false)
-	})
+	_, _, err = connector.CreateTenant(types.Tenant{Name: "newtenant"}, "commit-sha", types.ActionApply, migrationsToApply, false)
+	assert.Equal(t, "Create schema failed: trouble maker", err.Error())
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
@@ -523,20 +676,21 @@ func TestCreateTenantInsertTenantPreparedStatementError(t *testing.T) {
 	config := &config.Config{}
 	config.Driver = "postgres"
 	dialect := newDialect(config)
-	connector := baseConnector{newTestContext(), config, dialect, db, true}
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
 
+	mock.ExpectQuery("pg_advisory_lock").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
 	mock.ExpectBegin()
 	mock.ExpectExec("create schema").WillReturnResult(sqlmock.NewResult(0, 0))
 	mock.ExpectPrepare("insert into").WillReturnError(errors.New("trouble maker"))
 	mock.ExpectRollback()
+	mock.ExpectExec("pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
 
 	t1 := time.Now().UnixNano()
 	tenant1 := types.Migration{Name: fmt.Sprintf("%v.sql", t1), SourceDir: "tenants", File: fmt.Sprintf("tenants/%v.sql", t1), MigrationType: types.MigrationTypeTenantMigration, Contents: "insert into {schema}.settings values (456, '456') "}
 	migrationsToApply := []types.Migration{tenant1}
 
-	assert.PanicsWithValue(t, "Could not create prepared statement: trouble maker", func() {
-		connector.CreateTenant("newtenant", "commit-sha", types.ActionApply, migrationsToApply, false)
-	})
+	_, _, err = connector.CreateTenant(types.Tenant{Name: "newtenant"}, "commit-sha", types.ActionApply, migrationsToApply, false)
+	assert.Equal(t, "Could not create prepared statement: trouble maker", err.Error())
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
@@ -550,23 +704,24 @@ func TestCreateTenantInsertTenantError(t *testing.T) {
 	config := &config.Config{}
 	config.Driver = "postgres"
 	dialect := newDialect(config)
-	connector := baseConnector{newTestContext(), config, dialect, db, true}
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
 
 	tenant := "tenant"
 
+	mock.ExpectQuery("pg_advisory_lock").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
 	mock.ExpectBegin()
 	mock.ExpectExec("create schema").WillReturnResult(sqlmock.NewResult(0, 0))
 	mock.ExpectPrepare("insert into")
 	mock.ExpectPrepare("insert into").ExpectExec().WithArgs(tenant).WillReturnError(errors.New("trouble maker"))
 	mock.ExpectRollback()
+	mock.ExpectExec("pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
 
 	t1 := time.Now().UnixNano()
 	m1 := types.Migration{Name: fmt.Sprintf("%v.sql", t1), SourceDir: "tenants", File: fmt.Sprintf("tenants/%v.sql", t1), MigrationType: types.MigrationTypeTenantMigration, Contents: "insert into {schema}.settings values (456, '456') "}
 	migrationsToApply := []types.Migration{m1}
 
-	assert.PanicsWithValue(t, "Failed to add tenant entry: trouble maker", func() {
-		connector.CreateTenant(tenant, "commit-sha", types.ActionApply, migrationsToApply, false)
-	})
+	_, _, err = connector.CreateTenant(types.Tenant{Name: tenant}, "commit-sha", types.ActionApply, migrationsToApply, false)
+	assert.Equal(t, "Failed to add tenant entry: trouble maker", err.Error())
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
@@ -580,13 +735,14 @@ func TestCreateTenantCommitError(t *testing.T) {
 	config := &config.Config{}
 	config.Driver = "postgres"
 	dialect := newDialect(config)
-	connector := baseConnector{newTestContext(), config, dialect, db, true}
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
 
 	tn := time.Now().UnixNano()
 	m := types.Migration{Name: fmt.Sprintf("%v.sql", tn), SourceDir: "tenants", File: fmt.Sprintf("tenants/%v.sql", tn), MigrationType: types.MigrationTypeTenantMigration, Contents: "insert into {schema}.settings values (456, '456') "}
 	migrationsToApply := []types.Migration{m}
 
 	tenant := "tenantname"
+	mock.ExpectQuery("pg_advisory_lock").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
 	mock.ExpectBegin()
 	mock.ExpectExec("create schema").WillReturnResult(sqlmock.NewResult(0, 0))
 	// tenant
@@ -594,19 +750,19 @@ func TestCreateTenantCommitError(t *testing.T) {
 	mock.ExpectPrepare("insert into").ExpectExec().WithArgs(tenant).WillReturnResult(sqlmock.NewResult(0, 0))
 	// version
 	mock.ExpectPrepare("insert into migrator.migrator_versions")
-	mock.ExpectPrepare("insert into migrator.migrator_versions").ExpectQuery().WithArgs("commit-sha")
+	mock.ExpectPrepare("insert into migrator.migrator_versions").ExpectQuery().WithArgs("commit-sha").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(0))
 	// migration
 	mock.ExpectPrepare("insert into migrator.migrator_migrations")
 	mock.ExpectExec("insert into").WillReturnResult(sqlmock.NewResult(0, 0))
-	mock.ExpectPrepare("insert into").ExpectExec().WithArgs(m.Name, m.SourceDir, m.File, m.MigrationType, tenant, m.Contents, m.CheckSum, 0).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectPrepare("insert into").ExpectExec().WithArgs(m.Name, m.SourceDir, m.File, m.MigrationType, tenant, m.Contents, m.CheckSum, 0, m.DownContents, nil, m.SourceCommit).WillReturnResult(sqlmock.NewResult(0, 0))
 	// get version
-	rows := sqlmock.NewRows([]string{"vid", "vname", "vcreated", "mid", "name", "source_dir", "filename", "type", "db_schema", "created", "contents", "checksum"}).AddRow("123", "vname", time.Now(), "456", m.Name, m.SourceDir, m.File, m.MigrationType, tenant, time.Now(), m.Contents, m.CheckSum)
+	rows := sqlmock.NewRows([]string{"vid", "vname", "vcreated", "mid", "name", "source_dir", "filename", "type", "db_schema", "created", "contents", "checksum", "source_commit"}).AddRow("123", "vname", time.Now(), "456", m.Name, m.SourceDir, m.File, m.MigrationType, tenant, time.Now(), m.Contents, m.CheckSum, nil)
 	mock.ExpectQuery("select").WillReturnRows(rows)
 	mock.ExpectCommit().WillReturnError(errors.New("tx trouble maker"))
+	mock.ExpectExec("pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
 
-	assert.PanicsWithValue(t, "Could not commit transaction: tx trouble maker", func() {
-		connector.CreateTenant(tenant, "commit-sha", types.ActionApply, migrationsToApply, false)
-	})
+	_, _, err = connector.CreateTenant(types.Tenant{Name: tenant}, "commit-sha", types.ActionApply, migrationsToApply, false)
+	assert.Equal(t, "Could not commit transaction: tx trouble maker", err.Error())
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
@@ -620,14 +776,13 @@ func TestGetVersionsError(t *testing.T) {
 	config := &config.Config{}
 	config.Driver = "postgres"
 	dialect := newDialect(config)
-	connector := baseConnector{newTestContext(), config, dialect, db, true}
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
 
 	// don't have to provide full SQL here - patterns at work
 	mock.ExpectQuery("select").WillReturnError(errors.New("trouble maker"))
 
-	assert.PanicsWithValue(t, "Could not query versions: trouble maker", func() {
-		connector.GetVersions()
-	})
+	_, err = connector.GetVersions()
+	assert.Equal(t, "Could not query versions: trouble maker", err.Error())
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
@@ -641,14 +796,13 @@ func TestGetVersionsByFileError(t *testing.T) {
 	config := &config.Config{}
 	config.Driver = "postgres"
 	dialect := newDialect(config)
-	connector := baseConnector{newTestContext(), config, dialect, db, true}
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
 
 	// don't have to provide full SQL here - patterns at work
 	mock.ExpectQuery("select").WillReturnError(errors.New("trouble maker"))
 
-	assert.PanicsWithValue(t, "Could not query versions: trouble maker", func() {
-		connector.GetVersionsByFile("file")
-	})
+	_, err = connector.GetVersionsByFile("file")
+	assert.Equal(t, "Could not query versions: trouble maker", err.Error())
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
@@ -662,14 +816,13 @@ func TestGetVersionsByIDError(t *testing.T) {
 	config := &config.Config{}
 	config.Driver = "postgres"
 	dialect := newDialect(config)
-	connector := baseConnector{newTestContext(), config, dialect, db, true}
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
 
 	// don't have to provide full SQL here - patterns at work
 	mock.ExpectQuery("select").WillReturnError(errors.New("trouble maker"))
 
-	assert.PanicsWithValue(t, "Could not query versions: trouble maker", func() {
-		connector.GetVersionByID(0)
-	})
+	_, err = connector.GetVersionByID(0)
+	assert.Equal(t, "Could not query versions: trouble maker", err.Error())
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
@@ -683,14 +836,13 @@ func TestGetDBMigrationByIDError(t *testing.T) {
 	config := &config.Config{}
 	config.Driver = "postgres"
 	dialect := newDialect(config)
-	connector := baseConnector{newTestContext(), config, dialect, db, true}
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
 
 	// don't have to provide full SQL here - patterns at work
 	mock.ExpectQuery("select").WillReturnError(errors.New("trouble maker"))
 
-	assert.PanicsWithValue(t, "Could not query DB migrations: trouble maker", func() {
-		connector.GetDBMigrationByID(0)
-	})
+	_, err = connector.GetDBMigrationByID(0)
+	assert.Equal(t, "Could not query DB migrations: trouble maker", err.Error())
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
@@ -26,7 +26,8 @@ func TestGetTenants(t *testing.T) {
 			connector := New(newTestContext(), config)
 			defer connector.Dispose()
 
-			tenants := connector.GetTenants()
+			tenants, err := connector.GetTenants()
+			assert.Nil(t, err)
 
 			assert.True(t, len(tenants) >= 3)
 			assert.Contains(t, tenants, types.Tenant{Name: "abc"})
@@ -48,10 +49,12 @@ func TestCreateVersion(t *testing.T) {
 			connector := New(newTestContext(), config)
 			defer connector.Dispose()
 
-			tenants := connector.GetTenants()
+			tenants, err := connector.GetTenants()
+			assert.Nil(t, err)
 			noOfTenants := len(tenants)
 
-			dbMigrationsBefore := connector.GetAppliedMigrations()
+			dbMigrationsBefore, err := connector.GetAppliedMigrations()
+			assert.Nil(t, err)
 			lenBefore := len(dbMigrationsBefore)
 
 			p1 := time.Now().UnixNano()
@@ -83,8 +86,9 @@ func TestCreateVersion(t *testing.T) {
 
 			migrationsToApply := []types.Migration{public1, public2, public3, tenant1, tenant2, tenant3, public4, public5, tenant4}
 
-			results, version := connector.CreateVersion("commit-sha", types.ActionApply, migrationsToApply, false)
+			results, version, err := connector.CreateVersion("commit-sha", types.ActionApply, migrationsToApply, false)
 
+			assert.Nil(t, err)
 			assert.NotNil(t, version)
 			assert.True(t, version.ID > 0)
 			assert.Equal(t, "commit-sha", version.Name)
@@ -100,7 +104,8 @@ func TestCreateVersion(t *testing.T) {
 			assert.Equal(t, int32(noOfTenants*1+2), results.ScriptsGrandTotal)
 			assert.Greater(t, results.Duration, float64(0))
 
-			dbMigrationsAfter := connector.GetAppliedMigrations()
+			dbMigrationsAfter, err := connector.GetAppliedMigrations()
+			assert.Nil(t, err)
 			lenAfter := len(dbMigrationsAfter)
 
 			// 3 tenant migrations * no of tenants + 3 public
@@ -125,7 +130,8 @@ func TestCreateVersionEmptyMigrationArray(t *testing.T) {
 
 			migrationsToApply := []types.Migration{}
 
-			results, version := connector.CreateVersion("commit-sha", types.ActionApply, migrationsToApply, false)
+			results, version, err := connector.CreateVersion("commit-sha", types.ActionApply, migrationsToApply, false)
+			assert.Nil(t, err)
 			// empty migrations slice - no version created
 			assert.Nil(t, version)
 			assert.Equal(t, int32(0), results.MigrationsGrandTotal)
@@ -144,7 +150,7 @@ func TestGetTenantsSQLDefault(t *testing.T) {
 			assert.Nil(t, err)
 
 			dialect := newDialect(config)
-			connector := baseConnector{newTestContext(), config, dialect, nil, false}
+			connector := baseConnector{newTestContext(), config, dialect, nil, false, nil}
 			defer connector.Dispose()
 
 			tenantSelectSQL := connector.getTenantSelectSQL()
@@ -178,8 +184,9 @@ func TestCreateTenant(t *testing.T) {
 
 			uniqueTenant := fmt.Sprintf("new_test_tenant_%v", time.Now().UnixNano())
 
-			results, version := connector.CreateTenant(uniqueTenant, "commit-sha", types.ActionApply, migrationsToApply, false)
+			results, version, err := connector.CreateTenant(types.Tenant{Name: uniqueTenant}, "commit-sha", types.ActionApply, migrationsToApply, false)
 
+			assert.Nil(t, err)
 			assert.NotNil(t, version)
 			assert.True(t, version.ID > 0)
 			assert.Equal(t, "commit-sha", version.Name)
@@ -207,7 +214,8 @@ func TestGetVersions(t *testing.T) {
 			connector := New(newTestContext(), config)
 			defer connector.Dispose()
 
-			versions := connector.GetVersions()
+			versions, err := connector.GetVersions()
+			assert.Nil(t, err)
 
 			assert.True(t, len(versions) >= 2)
 			// versions are sorted from newest (highest ID) to oldest (lowest ID)
@@ -228,10 +236,12 @@ func TestGetVersionsByFile(t *testing.T) {
 			connector := New(newTestContext(), config)
 			defer connector.Dispose()
 
-			versions := connector.GetVersions()
+			versions, err := connector.GetVersions()
+			assert.Nil(t, err)
 			existingVersion := versions[0]
 
-			versions = connector.GetVersionsByFile(versions[0].DBMigrations[0].File)
+			versions, err = connector.GetVersionsByFile(versions[0].DBMigrations[0].File)
+			assert.Nil(t, err)
 			version := versions[0]
 			assert.Equal(t, existingVersion.ID, version.ID)
 			assert.Equal(t, existingVersion.DBMigrations[0].File, version.DBMigrations[0].File)
@@ -252,7 +262,8 @@ func TestGetVersionByID(t *testing.T) {
 			connector := New(newTestContext(), config)
 			defer connector.Dispose()
 
-			versions := connector.GetVersions()
+			versions, err := connector.GetVersions()
+			assert.Nil(t, err)
 			existingVersion := versions[0]
 
 			version, err := connector.GetVersionByID(existingVersion.ID)
@@ -294,7 +305,8 @@ func TestGetDBMigrationByID(t *testing.T) {
 			connector := New(newTestContext(), config)
 			defer connector.Dispose()
 
-			versions := connector.GetVersions()
+			versions, err := connector.GetVersions()
+			assert.Nil(t, err)
 			existingVersion := versions[0]
 			existingDBMigration := existingVersion.DBMigrations[0]
 
@@ -323,3 +335,57 @@ func TestGetDBMigrationByIDNotFound(t *testing.T) {
 		})
 	}
 }
+
+func TestRollbackVersion(t *testing.T) {
+	supportedDatabases := getSupportedDatabases()
+
+	for _, database := range supportedDatabases {
+		t.Run(database, func(t *testing.T) {
+			configFile := fmt.Sprintf("../test/migrator-%s.yaml", database)
+			config, err := config.FromFile(configFile)
+			assert.Nil(t, err)
+
+			connector := New(newTestContext(), config)
+			defer connector.Dispose()
+
+			tenants, err := connector.GetTenants()
+			assert.Nil(t, err)
+			noOfTenants := len(tenants)
+
+			p1 := time.Now().UnixNano()
+			t1 := time.Now().UnixNano()
+			t2 := time.Now().UnixNano()
+
+			public1 := types.Migration{Name: fmt.Sprintf("%v.sql", p1), SourceDir: "public", File: fmt.Sprintf("public/%v.sql", p1), MigrationType: types.MigrationTypeSingleMigration, Contents: "create table rollback_modules ( k int, v text )", DownContents: "drop table rollback_modules"}
+			tenant1 := types.Migration{Name: fmt.Sprintf("%v.sql", t1), SourceDir: "tenants", File: fmt.Sprintf("tenants/%v.sql", t1), MigrationType: types.MigrationTypeTenantMigration, Contents: "create table {schema}.rollback_settings (k int, v text)", DownContents: "drop table {schema}.rollback_settings"}
+			// scripts are not rolled back
+			tenant2 := types.Migration{Name: fmt.Sprintf("%v.sql", t2), SourceDir: "tenants", File: fmt.Sprintf("tenants/%v.sql", t2), MigrationType: types.MigrationTypeTenantScript, Contents: "insert into {schema}.rollback_settings values (456, '456')"}
+
+			_, version, err := connector.CreateVersion("commit-sha", types.ActionApply, []types.Migration{public1, tenant1, tenant2}, false)
+			assert.Nil(t, err)
+			assert.NotNil(t, version)
+
+			dbMigrationsBefore, err := connector.GetAppliedMigrations()
+			assert.Nil(t, err)
+
+			results, rollbackVersion, err := connector.RollbackVersion(version.ID, "rollback commit-sha", false)
+			assert.Nil(t, err)
+			assert.NotNil(t, rollbackVersion)
+			assert.Equal(t, "rollback commit-sha", rollbackVersion.Name)
+			assert.Equal(t, int32(1), results.SingleMigrations)
+			assert.Equal(t, int32(1), results.TenantMigrations)
+			assert.Equal(t, int32(noOfTenants), results.TenantMigrationsTotal)
+			assert.Equal(t, int32(noOfTenants+1), int32(len(rollbackVersion.DBMigrations)))
+			assert.Equal(t, fmt.Sprintf("public/%v.down.sql", p1), rollbackVersion.DBMigrations[len(rollbackVersion.DBMigrations)-1].File)
+
+			// rolled back version is kept for audit
+			_, err = connector.GetVersionByID(version.ID)
+			assert.Nil(t, err)
+
+			// rolled back migrations are no longer applied, scripts are not rolled back
+			dbMigrationsAfter, err := connector.GetAppliedMigrations()
+			assert.Nil(t, err)
+			assert.Equal(t, len(dbMigrationsBefore)-noOfTenants-1, len(dbMigrationsAfter))
+		})
+	}
+}
@@ -1,9 +1,13 @@
 package db
 
 import (
+	"errors"
 	"fmt"
-	// blank import for MSSQL driver
-	_ "github.com/microsoft/go-mssqldb"
+	"strconv"
+	"strings"
+	"time"
+
+	mssql "github.com/microsoft/go-mssqldb"
 )
 
 type msSQLDialect struct {
@@ -11,13 +15,20 @@ type msSQLDialect struct {
 }
 
 const (
-	insertMigrationMSSQLDialectSQL      = "insert into %v.%v (name, source_dir, filename, type, db_schema, contents, checksum, version_id) values (@p1, @p2, @p3, @p4, @p5, @p6, @p7, @p8)"
-	insertTenantMSSQLDialectSQL         = "insert into %v.%v (name) values (@p1)"
-	insertVersionMSSQLSQLDialectSQL     = "insert into %v.%v (name) output inserted.id values (@p1)"
-	selectVersionsByFileMSSQLDialectSQL = "select mv.id as vid, mv.name as vname, mv.created as vcreated, mm.id as mid, mm.name, mm.source_dir, mm.filename, mm.type, mm.db_schema, mm.created, mm.contents, mm.checksum from %v.%v mv left join %v.%v mm on mv.id = mm.version_id where mv.id in (select version_id from %v.%v where filename = @p1) order by vid desc, mid asc"
-	selectVersionByIDMSSQLDialectSQL    = "select mv.id as vid, mv.name as vname, mv.created as vcreated, mm.id as mid, mm.name, mm.source_dir, mm.filename, mm.type, mm.db_schema, mm.created, mm.contents, mm.checksum from %v.%v mv left join %v.%v mm on mv.id = mm.version_id where mv.id = @p1 order by mid asc"
-	selectMigrationByIDMSSQLDialectSQL  = "select id, name, source_dir, filename, type, db_schema, created, contents, checksum from %v.%v where id = @p1"
-	createTenantsTableMSSQLDialectSQL   = `
+	insertMigrationMSSQLDialectSQL             = "insert into %v.%v (name, source_dir, filename, type, db_schema, contents, checksum, version_id, down_contents, rendered_contents, source_commit) values (@p1, @p2, @p3, @p4, @p5, @p6, @p7, @p8, @p9, @p10, @p11)"
+	insertTenantMSSQLDialectSQL                = "insert into %v.%v (%v) values (%v)"
+	insertVersionMSSQLSQLDialectSQL            = "insert into %v.%v (name) output inserted.id values (@p1)"
+	selectVersionsByFileMSSQLDialectSQL        = "select mv.id as vid, mv.name as vname, mv.created as vcreated, mm.id as mid, mm.name, mm.source_dir, mm.filename, mm.type, mm.db_schema, mm.created, mm.contents, mm.checksum, mm.source_commit from %v.%v mv left join %v.%v mm on mv.id = mm.version_id where mv.id in (select version_id from %v.%v where filename = @p1) order by vid desc, mid asc"
+	selectVersionByIDMSSQLDialectSQL           = "select mv.id as vid, mv.name as vname, mv.created as vcreated, mm.id as mid, mm.name, mm.source_dir, mm.filename, mm.type, mm.db_schema, mm.created, mm.contents, mm.checksum, mm.source_commit from %v.%v mv left join %v.%v mm on mv.id = mm.version_id where mv.id = @p1 order by mid asc"
+	selectMigrationByIDMSSQLDialectSQL         = "select id, name, source_dir, filename, type, db_schema, created, contents, checksum, source_commit from %v.%v where id = @p1"
+	selectMigrationsByVersionIDMSSQLDialectSQL = "select id, name, source_dir, filename, type, db_schema, created, contents, checksum, down_contents, source_commit from %v.%v where version_id = @p1 order by id desc"
+	unlockMSSQLDialectSQL                      = "exec sp_releaseapplock @Resource = '%v', @LockOwner = 'Session'"
+	lockMSSQLDialectSQL                        = `
+declare @result int;
+exec @result = sp_getapplock @Resource = '%v', @LockMode = 'Exclusive', @LockOwner = 'Session', @LockTimeout = %d;
+select case when @result >= 0 then 1 else 0 end;
+`
+	createTenantsTableMSSQLDialectSQL = `
 IF NOT EXISTS (select * from information_schema.tables where table_schema = '%v' and table_name = '%v')
 BEGIN
   create table [%v].%v (
@@ -75,6 +86,30 @@ begin
   select @cn = name from sys.default_constraints where parent_object_id = object_id('[%v].%v') and name like '%%ver%%';
   EXEC ('alter table [%v].%v drop constraint ' + @cn);
 end
+`
+	downContentsColumnSetupMSSQLDialectSQL = `
+IF NOT EXISTS (select * from information_schema.columns where table_schema = '%v' and table_name = '%v' and column_name = 'down_contents')
+BEGIN
+  alter table [%v].%v add down_contents text;
+END
+`
+	renderedContentsColumnSetupMSSQLDialectSQL = `
+IF NOT EXISTS (select * from information_schema.columns where table_schema = '%v' and table_name = '%v' and column_name = 'rendered_contents')
+BEGIN
+  alter table [%v].%v add rendered_contents text;
+END
+`
+	sourceCommitColumnSetupMSSQLDialectSQL = `
+IF NOT EXISTS (select * from information_schema.columns where table_schema = '%v' and table_name = '%v' and column_name = 'source_commit')
+BEGIN
+  alter table [%v].%v add source_commit varchar(64);
+END
+`
+	tenantMetadataColumnSetupMSSQLDialectSQL = `
+IF NOT EXISTS (select * from information_schema.columns where table_schema = '%v' and table_name = '%v' and column_name = '%v')
+BEGIN
+  alter table [%v].%v add %v varchar(200);
+END
 `
 )
 
@@ -83,14 +118,20 @@ func (md *msSQLDialect) LastInsertIDSupported() bool {
 	return false
 }
 
+// GetSplitterOptions returns MS SQL options used to split migrations, migrations are split into batches using GO separator
+func (md *msSQLDialect) GetSplitterOptions() splitterOptions {
+	return splitterOptions{batchSeparator: "GO", bracketQuotes: true}
+}
+
 // GetMigrationInsertSQL returns MS SQL-specific migration insert SQL statement
 func (md *msSQLDialect) GetMigrationInsertSQL() string {
 	return fmt.Sprintf(insertMigrationMSSQLDialectSQL, migratorSchema, migratorMigrationsTable)
 }
 
-// GetTenantInsertSQL returns MS SQL-specific migrator's default tenant insert SQL statement
-func (md *msSQLDialect) GetTenantInsertSQL() string {
-	return fmt.Sprintf(insertTenantMSSQLDialectSQL, migratorSchema, migratorTenantsTable)
+// GetTenantInsertSQL returns MS SQL-specific migrator's default tenant insert SQL statement, name is followed by passed tenant metadata columns
+func (md *msSQLDialect) GetTenantInsertSQL(metadataColumns []string) string {
+	placeholders := tenantPlaceholders(metadataColumns, func(i int) string { return fmt.Sprintf("@p%d", i) })
+	return fmt.Sprintf(insertTenantMSSQLDialectSQL, migratorSchema, migratorTenantsTable, tenantColumns(metadataColumns), placeholders)
 }
 
 // GetCreateTenantsTableSQL returns migrator's default create tenants table SQL statement.
@@ -133,3 +174,55 @@ func (md *msSQLDialect) GetVersionByIDSQL() string {
 func (md *msSQLDialect) GetMigrationByIDSQL() string {
 	return fmt.Sprintf(selectMigrationByIDMSSQLDialectSQL, migratorSchema, migratorMigrationsTable)
 }
+
+// GetAddDownContentsColumnSQL returns MS SQL-specific SQL which adds down_contents column to migrations table
+// (backwards compatibility) the column is added only if it does not already exist
+func (md *msSQLDialect) GetAddDownContentsColumnSQL() []string {
+	return []string{fmt.Sprintf(downContentsColumnSetupMSSQLDialectSQL, migratorSchema, migratorMigrationsTable, migratorSchema, migratorMigrationsTable)}
+}
+
+// GetAddRenderedContentsColumnSQL returns MS SQL-specific SQL which adds rendered_contents column to migrations table
+// (backwards compatibility) the column is added only if it does not already exist
+func (md *msSQLDialect) GetAddRenderedContentsColumnSQL() []string {
+	return []string{fmt.Sprintf(renderedContentsColumnSetupMSSQLDialectSQL, migratorSchema, migratorMigrationsTable, migratorSchema, migratorMigrationsTable)}
+}
+
+// GetAddSourceCommitColumnSQL returns MS SQL-specific SQL which adds source_commit column to migrations table
+func (md *msSQLDialect) GetAddSourceCommitColumnSQL() []string {
+	return []string{fmt.Sprintf(sourceCommitColumnSetupMSSQLDialectSQL, migratorSchema, migratorMigrationsTable, migratorSchema, migratorMigrationsTable)}
+}
+
+// GetAddTenantMetadataColumnSQL returns MS SQL-specific SQL which adds passed tenant metadata column to default tenants table
+// the column is added only if it does not already exist
+func (md *msSQLDialect) GetAddTenantMetadataColumnSQL(column string) []string {
+	return []string{fmt.Sprintf(tenantMetadataColumnSetupMSSQLDialectSQL, migratorSchema, migratorTenantsTable, column, migratorSchema, migratorTenantsTable, column)}
+}
+
+// QuoteIdentifier returns passed identifier quoted using square brackets
+func (md *msSQLDialect) QuoteIdentifier(identifier string) string {
+	return "[" + strings.Replace(identifier, "]", "]]", -1) + "]"
+}
+
+func (md *msSQLDialect) GetMigrationsByVersionIDSQL() string {
+	return fmt.Sprintf(selectMigrationsByVersionIDMSSQLDialectSQL, migratorSchema, migratorMigrationsTable)
+}
+
+// GetLockSQL returns MS SQL-specific SQL which acquires session-owned application lock
+// sp_getapplock returns a negative value if lock could not be acquired within timeout (in milliseconds)
+func (md *msSQLDialect) GetLockSQL(timeout time.Duration) string {
+	return fmt.Sprintf(lockMSSQLDialectSQL, migratorLockName, timeout.Milliseconds())
+}
+
+// GetUnlockSQL returns MS SQL-specific SQL which releases session-owned application lock
+func (md *msSQLDialect) GetUnlockSQL() string {
+	return fmt.Sprintf(unlockMSSQLDialectSQL, migratorLockName)
+}
+
+// GetErrorCode returns MS SQL error number or empty string if passed error is not a MS SQL error
+func (md *msSQLDialect) GetErrorCode(err error) string {
+	var mssqlErr mssql.Error
+	if errors.As(err, &mssqlErr) {
+		return strconv.Itoa(int(mssqlErr.Number))
+	}
+	return ""
+}
@@ -1,10 +1,13 @@
 package db
 
 import (
+	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/lukaszbudnik/migrator/config"
+	mssql "github.com/microsoft/go-mssqldb"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -37,7 +40,7 @@ func TestMSSQLGetMigrationInsertSQL(t *testing.T) {
 
 	insertMigrationSQL := dialect.GetMigrationInsertSQL()
 
-	assert.Equal(t, "insert into migrator.migrator_migrations (name, source_dir, filename, type, db_schema, contents, checksum, version_id) values (@p1, @p2, @p3, @p4, @p5, @p6, @p7, @p8)", insertMigrationSQL)
+	assert.Equal(t, "insert into migrator.migrator_migrations (name, source_dir, filename, type, db_schema, contents, checksum, version_id, down_contents, rendered_contents, source_commit) values (@p1, @p2, @p3, @p4, @p5, @p6, @p7, @p8, @p9, @p10, @p11)", insertMigrationSQL)
 }
 
 func TestMSSQLGetTenantInsertSQLDefault(t *testing.T) {
@@ -46,7 +49,7 @@ func TestMSSQLGetTenantInsertSQLDefault(t *testing.T) {
 
 	config.Driver = "sqlserver"
 	dialect := newDialect(config)
-	connector := baseConnector{newTestContext(), config, dialect, nil, false}
+	connector := baseConnector{newTestContext(), config, dialect, nil, false, nil}
 	defer connector.Dispose()
 
 	tenantInsertSQL := connector.getTenantInsertSQL()
@@ -201,7 +204,7 @@ func TestMSSQLGetVersionsByFileSQL(t *testing.T) {
 
 	versionsByFile := dialect.GetVersionsByFileSQL()
 
-	assert.Equal(t, "select mv.id as vid, mv.name as vname, mv.created as vcreated, mm.id as mid, mm.name, mm.source_dir, mm.filename, mm.type, mm.db_schema, mm.created, mm.contents, mm.checksum from migrator.migrator_versions mv left join migrator.migrator_migrations mm on mv.id = mm.version_id where mv.id in (select version_id from migrator.migrator_migrations where filename = @p1) order by vid desc, mid asc", versionsByFile)
+	assert.Equal(t, "select mv.id as vid, mv.name as vname, mv.created as vcreated, mm.id as mid, mm.name, mm.source_dir, mm.filename, mm.type, mm.db_schema, mm.created, mm.contents, mm.checksum, mm.source_commit from migrator.migrator_versions mv left join migrator.migrator_migrations mm on mv.id = mm.version_id where mv.id in (select version_id from migrator.migrator_migrations where filename = @p1) order by vid desc, mid asc", versionsByFile)
 }
 
 func TestMSSQLGetVersionByIDSQL(t *testing.T) {
@@ -213,7 +216,7 @@ func TestMSSQLGetVersionByIDSQL(t *testing.T) {
 
 	versionByID := dialect.GetVersionByIDSQL()
 
-	assert.Equal(t, "select mv.id as vid, mv.name as vname, mv.created as vcreated, mm.id as mid, mm.name, mm.source_dir, mm.filename, mm.type, mm.db_schema, mm.created, mm.contents, mm.checksum from migrator.migrator_versions mv left join migrator.migrator_migrations mm on mv.id = mm.version_id where mv.id = @p1 order by mid asc", versionByID)
+	assert.Equal(t, "select mv.id as vid, mv.name as vname, mv.created as vcreated, mm.id as mid, mm.name, mm.source_dir, mm.filename, mm.type, mm.db_schema, mm.created, mm.contents, mm.checksum, mm.source_commit from migrator.migrator_versions mv left join migrator.migrator_migrations mm on mv.id = mm.version_id where mv.id = @p1 order by mid asc", versionByID)
 }
 
 func TestMSSQLGetMigrationByIDSQL(t *testing.T) {
@@ -225,5 +228,109 @@ func TestMSSQLGetMigrationByIDSQL(t *testing.T) {
 
 	migrationByID := dialect.GetMigrationByIDSQL()
 
-	assert.Equal(t, "select id, name, source_dir, filename, type, db_schema, created, contents, checksum from migrator.migrator_migrations where id = @p1", migrationByID)
+	assert.Equal(t, "select id, name, source_dir, filename, type, db_schema, created, contents, checksum, source_commit from migrator.migrator_migrations where id = @p1", migrationByID)
+}
+
+func TestMSSQLGetMigrationsByVersionIDSQL(t *testing.T) {
+	config, err := config.FromFile("../test/migrator-mssql.yaml")
+	assert.Nil(t, err)
+
+	config.Driver = "sqlserver"
+	dialect := newDialect(config)
+
+	migrationsByVersionID := dialect.GetMigrationsByVersionIDSQL()
+
+	assert.Equal(t, "select id, name, source_dir, filename, type, db_schema, created, contents, checksum, down_contents, source_commit from migrator.migrator_migrations where version_id = @p1 order by id desc", migrationsByVersionID)
+}
+
+func TestMSSQLGetAddDownContentsColumnSQL(t *testing.T) {
+	config, err := config.FromFile("../test/migrator-mssql.yaml")
+	assert.Nil(t, err)
+
+	config.Driver = "sqlserver"
+	dialect := newDialect(config)
+
+	actual := dialect.GetAddDownContentsColumnSQL()
+	expected :=
+		`
+IF NOT EXISTS (select * from information_schema.columns where table_schema = 'migrator' and table_name = 'migrator_migrations' and column_name = 'down_contents')
+BEGIN
+  alter table [migrator].migrator_migrations add down_contents text;
+END
+`
+
+	assert.Equal(t, []string{expected}, actual)
+}
+
+func TestMSSQLGetAddRenderedContentsColumnSQL(t *testing.T) {
+	config, err := config.FromFile("../test/migrator-mssql.yaml")
+	assert.Nil(t, err)
+
+	config.Driver = "sqlserver"
+	dialect := newDialect(config)
+
+	actual := dialect.GetAddRenderedContentsColumnSQL()
+	expected :=
+		`
+IF NOT EXISTS (select * from information_schema.columns where table_schema = 'migrator' and table_name = 'migrator_migrations' and column_name = 'rendered_contents')
+BEGIN
+  alter table [migrator].migrator_migrations add rendered_contents text;
+END
+`
+
+	assert.Equal(t, []string{expected}, actual)
+}
+
+func TestMSSQLGetLockAndUnlockSQL(t *testing.T) {
+	config, err := config.FromFile("../test/migrator-mssql.yaml")
+	assert.Nil(t, err)
+
+	config.Driver = "sqlserver"
+	dialect := newDialect(config)
+
+	actual := dialect.GetLockSQL(30 * time.Second)
+	expected :=
+		`
+declare @result int;
+exec @result = sp_getapplock @Resource = 'migrator', @LockMode = 'Exclusive', @LockOwner = 'Session', @LockTimeout = 30000;
+select case when @result >= 0 then 1 else 0 end;
+`
+
+	assert.Equal(t, expected, actual)
+	assert.Equal(t, "exec sp_releaseapplock @Resource = 'migrator', @LockOwner = 'Session'", dialect.GetUnlockSQL())
+}
+
+func TestMSSQLGetErrorCode(t *testing.T) {
+	config := &config.Config{}
+	config.Driver = "sqlserver"
+	dialect := newDialect(config)
+
+	err := fmt.Errorf("wrapped: %w", mssql.Error{Number: 208, Message: "Invalid object name"})
+
+	assert.Equal(t, "208", dialect.GetErrorCode(err))
+	assert.Equal(t, "", dialect.GetErrorCode(errors.New("trouble maker")))
+}
+
+func TestMSSQLGetTenantSQLWithMetadataColumns(t *testing.T) {
+	config, err := config.FromFile("../test/migrator-mssql.yaml")
+	assert.Nil(t, err)
+
+	config.Driver = "sqlserver"
+	config.TenantMetadataColumns = []string{"region", "tier"}
+	dialect := newDialect(config)
+	connector := baseConnector{newTestContext(), config, dialect, nil, false, nil}
+
+	assert.Equal(t, "insert into migrator.migrator_tenants (name, region, tier) values (@p1, @p2, @p3)", connector.getTenantInsertSQL())
+	assert.Equal(t, "select name, region, tier from migrator.migrator_tenants", connector.getTenantSelectSQL())
+
+	actual := dialect.GetAddTenantMetadataColumnSQL("tier")
+	expected :=
+		`
+IF NOT EXISTS (select * from information_schema.columns where table_schema = 'migrator' and table_name = 'migrator_tenants' and column_name = 'tier')
+BEGIN
+  alter table [migrator].migrator_tenants add tier varchar(200);
+END
+`
+
+	assert.Equal(t, []string{expected}, actual)
 }
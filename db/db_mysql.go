@@ -1,9 +1,14 @@
 package db
 
 import (
+	"errors"
 	"fmt"
-	// blank import for MySQL driver
-	_ "github.com/go-sql-driver/mysql"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
 )
 
 type mySQLDialect struct {
@@ -11,12 +16,15 @@ type mySQLDialect struct {
 }
 
 const (
-	insertMigrationMySQLDialectSQL             = "insert into %v.%v (name, source_dir, filename, type, db_schema, contents, checksum, version_id) values (?, ?, ?, ?, ?, ?, ?, ?)"
-	insertTenantMySQLDialectSQL                = "insert into %v.%v (name) values (?)"
+	insertMigrationMySQLDialectSQL             = "insert into %v.%v (name, source_dir, filename, type, db_schema, contents, checksum, version_id, down_contents, rendered_contents, source_commit) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	insertTenantMySQLDialectSQL                = "insert into %v.%v (%v) values (%v)"
 	insertVersionMySQLDialectSQL               = "insert into %v.%v (name) values (?)"
-	selectVersionsByFileMySQLDialectSQL        = "select mv.id as vid, mv.name as vname, mv.created as vcreated, mm.id as mid, mm.name, mm.source_dir, mm.filename, mm.type, mm.db_schema, mm.created, mm.contents, mm.checksum from %v.%v mv left join %v.%v mm on mv.id = mm.version_id where mv.id in (select version_id from %v.%v where filename = ?) order by vid desc, mid asc"
-	selectVersionByIDMySQLDialectSQL           = "select mv.id as vid, mv.name as vname, mv.created as vcreated, mm.id as mid, mm.name, mm.source_dir, mm.filename, mm.type, mm.db_schema, mm.created, mm.contents, mm.checksum from %v.%v mv left join %v.%v mm on mv.id = mm.version_id where mv.id = ? order by mid asc"
-	selectMigrationByIDMySQLDialectSQL         = "select id, name, source_dir, filename, type, db_schema, created, contents, checksum from %v.%v where id = ?"
+	selectVersionsByFileMySQLDialectSQL        = "select mv.id as vid, mv.name as vname, mv.created as vcreated, mm.id as mid, mm.name, mm.source_dir, mm.filename, mm.type, mm.db_schema, mm.created, mm.contents, mm.checksum, mm.source_commit from %v.%v mv left join %v.%v mm on mv.id = mm.version_id where mv.id in (select version_id from %v.%v where filename = ?) order by vid desc, mid asc"
+	selectVersionByIDMySQLDialectSQL           = "select mv.id as vid, mv.name as vname, mv.created as vcreated, mm.id as mid, mm.name, mm.source_dir, mm.filename, mm.type, mm.db_schema, mm.created, mm.contents, mm.checksum, mm.source_commit from %v.%v mv left join %v.%v mm on mv.id = mm.version_id where mv.id = ? order by mid asc"
+	selectMigrationByIDMySQLDialectSQL         = "select id, name, source_dir, filename, type, db_schema, created, contents, checksum, source_commit from %v.%v where id = ?"
+	selectMigrationsByVersionIDMySQLDialectSQL = "select id, name, source_dir, filename, type, db_schema, created, contents, checksum, down_contents, source_commit from %v.%v where version_id = ? order by id desc"
+	lockMySQLDialectSQL                        = "select coalesce(get_lock('%v', %d), 0)"
+	unlockMySQLDialectSQL                      = "select release_lock('%v')"
 	versionsTableSetupMySQLDropDialectSQL      = `drop procedure if exists migrator_create_versions`
 	versionsTableSetupMySQLCallDialectSQL      = `call migrator_create_versions()`
 	versionsTableSetupMySQLProcedureDialectSQL = `
@@ -41,6 +49,46 @@ if not exists (select * from information_schema.tables where table_schema = '%v'
     add constraint migrator_versions_version_id_fk foreign key (version_id) references %v.%v (id) on delete cascade;
 end if;
 end;
+`
+	downContentsColumnSetupMySQLDropDialectSQL      = `drop procedure if exists migrator_create_down_contents`
+	downContentsColumnSetupMySQLCallDialectSQL      = `call migrator_create_down_contents()`
+	downContentsColumnSetupMySQLProcedureDialectSQL = `
+create procedure migrator_create_down_contents()
+begin
+if not exists (select * from information_schema.columns where table_schema = '%v' and table_name = '%v' and column_name = 'down_contents') then
+  alter table %v.%v add column down_contents text;
+end if;
+end;
+`
+	renderedContentsColumnSetupMySQLDropDialectSQL      = `drop procedure if exists migrator_create_rendered_contents`
+	renderedContentsColumnSetupMySQLCallDialectSQL      = `call migrator_create_rendered_contents()`
+	renderedContentsColumnSetupMySQLProcedureDialectSQL = `
+create procedure migrator_create_rendered_contents()
+begin
+if not exists (select * from information_schema.columns where table_schema = '%v' and table_name = '%v' and column_name = 'rendered_contents') then
+  alter table %v.%v add column rendered_contents text;
+end if;
+end;
+`
+	sourceCommitColumnSetupMySQLDropDialectSQL      = `drop procedure if exists migrator_create_source_commit`
+	sourceCommitColumnSetupMySQLCallDialectSQL      = `call migrator_create_source_commit()`
+	sourceCommitColumnSetupMySQLProcedureDialectSQL = `
+create procedure migrator_create_source_commit()
+begin
+if not exists (select * from information_schema.columns where table_schema = '%v' and table_name = '%v' and column_name = 'source_commit') then
+  alter table %v.%v add column source_commit varchar(64);
+end if;
+end;
+`
+	tenantMetadataColumnSetupMySQLDropDialectSQL      = `drop procedure if exists migrator_create_tenant_metadata`
+	tenantMetadataColumnSetupMySQLCallDialectSQL      = `call migrator_create_tenant_metadata()`
+	tenantMetadataColumnSetupMySQLProcedureDialectSQL = `
+create procedure migrator_create_tenant_metadata()
+begin
+if not exists (select * from information_schema.columns where table_schema = '%v' and table_name = '%v' and column_name = '%v') then
+  alter table %v.%v add column %v varchar(200);
+end if;
+end;
 `
 )
 
@@ -49,14 +97,20 @@ func (md *mySQLDialect) LastInsertIDSupported() bool {
 	return true
 }
 
+// GetSplitterOptions returns MySQL options used to split migrations into statements
+func (md *mySQLDialect) GetSplitterOptions() splitterOptions {
+	return splitterOptions{delimiter: ";", delimiterDirective: true, hashComments: true, backslashEscapes: true, backtickQuotes: true}
+}
+
 // GetMigrationInsertSQL returns MySQL-specific migration insert SQL statement
 func (md *mySQLDialect) GetMigrationInsertSQL() string {
 	return fmt.Sprintf(insertMigrationMySQLDialectSQL, migratorSchema, migratorMigrationsTable)
 }
 
-// GetTenantInsertSQL returns MySQL-specific migrator's default tenant insert SQL statement
-func (md *mySQLDialect) GetTenantInsertSQL() string {
-	return fmt.Sprintf(insertTenantMySQLDialectSQL, migratorSchema, migratorTenantsTable)
+// GetTenantInsertSQL returns MySQL-specific migrator's default tenant insert SQL statement, name is followed by passed tenant metadata columns
+func (md *mySQLDialect) GetTenantInsertSQL(metadataColumns []string) string {
+	placeholders := tenantPlaceholders(metadataColumns, questionMarkPlaceholder)
+	return fmt.Sprintf(insertTenantMySQLDialectSQL, migratorSchema, migratorTenantsTable, tenantColumns(metadataColumns), placeholders)
 }
 
 func (md *mySQLDialect) GetVersionInsertSQL() string {
@@ -87,3 +141,72 @@ func (md *mySQLDialect) GetVersionByIDSQL() string {
 func (md *mySQLDialect) GetMigrationByIDSQL() string {
 	return fmt.Sprintf(selectMigrationByIDMySQLDialectSQL, migratorSchema, migratorMigrationsTable)
 }
+
+// GetAddDownContentsColumnSQL returns MySQL-specific SQLs which add down_contents column to migrations table
+// (backwards compatibility) the column is added only if it does not already exist
+// MySQL does not support "add column if not exists" and a procedure is used, see GetCreateVersionsTableSQL
+func (md *mySQLDialect) GetAddDownContentsColumnSQL() []string {
+	return []string{
+		downContentsColumnSetupMySQLDropDialectSQL,
+		fmt.Sprintf(downContentsColumnSetupMySQLProcedureDialectSQL, migratorSchema, migratorMigrationsTable, migratorSchema, migratorMigrationsTable),
+		downContentsColumnSetupMySQLCallDialectSQL,
+	}
+}
+
+// GetAddRenderedContentsColumnSQL returns MySQL-specific SQLs which add rendered_contents column to migrations table
+// (backwards compatibility) the column is added only if it does not already exist, see GetAddDownContentsColumnSQL
+func (md *mySQLDialect) GetAddRenderedContentsColumnSQL() []string {
+	return []string{
+		renderedContentsColumnSetupMySQLDropDialectSQL,
+		fmt.Sprintf(renderedContentsColumnSetupMySQLProcedureDialectSQL, migratorSchema, migratorMigrationsTable, migratorSchema, migratorMigrationsTable),
+		renderedContentsColumnSetupMySQLCallDialectSQL,
+	}
+}
+
+// GetAddSourceCommitColumnSQL returns MySQL-specific SQLs which add source_commit column to migrations table
+func (md *mySQLDialect) GetAddSourceCommitColumnSQL() []string {
+	return []string{
+		sourceCommitColumnSetupMySQLDropDialectSQL,
+		fmt.Sprintf(sourceCommitColumnSetupMySQLProcedureDialectSQL, migratorSchema, migratorMigrationsTable, migratorSchema, migratorMigrationsTable),
+		sourceCommitColumnSetupMySQLCallDialectSQL,
+	}
+}
+
+// GetAddTenantMetadataColumnSQL returns MySQL-specific SQLs which add passed tenant metadata column to default tenants table
+// the column is added only if it does not already exist, see GetAddDownContentsColumnSQL
+func (md *mySQLDialect) GetAddTenantMetadataColumnSQL(column string) []string {
+	return []string{
+		tenantMetadataColumnSetupMySQLDropDialectSQL,
+		fmt.Sprintf(tenantMetadataColumnSetupMySQLProcedureDialectSQL, migratorSchema, migratorTenantsTable, column, migratorSchema, migratorTenantsTable, column),
+		tenantMetadataColumnSetupMySQLCallDialectSQL,
+	}
+}
+
+// QuoteIdentifier returns passed identifier quoted using backticks
+func (md *mySQLDialect) QuoteIdentifier(identifier string) string {
+	return "`" + strings.Replace(identifier, "`", "``", -1) + "`"
+}
+
+func (md *mySQLDialect) GetMigrationsByVersionIDSQL() string {
+	return fmt.Sprintf(selectMigrationsByVersionIDMySQLDialectSQL, migratorSchema, migratorMigrationsTable)
+}
+
+// GetLockSQL returns MySQL-specific SQL which acquires named lock
+// get_lock returns 1 if lock was acquired, 0 if timeout (in seconds) elapsed and null on error
+func (md *mySQLDialect) GetLockSQL(timeout time.Duration) string {
+	return fmt.Sprintf(lockMySQLDialectSQL, migratorLockName, int(math.Ceil(timeout.Seconds())))
+}
+
+// GetUnlockSQL returns MySQL-specific SQL which releases named lock
+func (md *mySQLDialect) GetUnlockSQL() string {
+	return fmt.Sprintf(unlockMySQLDialectSQL, migratorLockName)
+}
+
+// GetErrorCode returns MySQL error number or empty string if passed error is not a MySQL error
+func (md *mySQLDialect) GetErrorCode(err error) string {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return strconv.Itoa(int(mysqlErr.Number))
+	}
+	return ""
+}
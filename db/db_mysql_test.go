@@ -1,8 +1,12 @@
 package db
 
 import (
+	"errors"
+	"fmt"
 	"testing"
+	"time"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/lukaszbudnik/migrator/config"
 	"github.com/stretchr/testify/assert"
 )
@@ -35,7 +39,7 @@ func TestMySQLGetMigrationInsertSQL(t *testing.T) {
 
 	insertMigrationSQL := dialect.GetMigrationInsertSQL()
 
-	assert.Equal(t, "insert into migrator.migrator_migrations (name, source_dir, filename, type, db_schema, contents, checksum, version_id) values (?, ?, ?, ?, ?, ?, ?, ?)", insertMigrationSQL)
+	assert.Equal(t, "insert into migrator.migrator_migrations (name, source_dir, filename, type, db_schema, contents, checksum, version_id, down_contents, rendered_contents, source_commit) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", insertMigrationSQL)
 }
 
 func TestMySQLGetTenantInsertSQLDefault(t *testing.T) {
@@ -44,7 +48,7 @@ func TestMySQLGetTenantInsertSQLDefault(t *testing.T) {
 
 	config.Driver = "mysql"
 	dialect := newDialect(config)
-	connector := baseConnector{newTestContext(), config, dialect, nil, false}
+	connector := baseConnector{newTestContext(), config, dialect, nil, false, nil}
 	defer connector.Dispose()
 
 	tenantInsertSQL := connector.getTenantInsertSQL()
@@ -113,7 +117,7 @@ func TestMySQLGetVersionsByFileSQL(t *testing.T) {
 
 	versionsByFile := dialect.GetVersionsByFileSQL()
 
-	assert.Equal(t, "select mv.id as vid, mv.name as vname, mv.created as vcreated, mm.id as mid, mm.name, mm.source_dir, mm.filename, mm.type, mm.db_schema, mm.created, mm.contents, mm.checksum from migrator.migrator_versions mv left join migrator.migrator_migrations mm on mv.id = mm.version_id where mv.id in (select version_id from migrator.migrator_migrations where filename = ?) order by vid desc, mid asc", versionsByFile)
+	assert.Equal(t, "select mv.id as vid, mv.name as vname, mv.created as vcreated, mm.id as mid, mm.name, mm.source_dir, mm.filename, mm.type, mm.db_schema, mm.created, mm.contents, mm.checksum, mm.source_commit from migrator.migrator_versions mv left join migrator.migrator_migrations mm on mv.id = mm.version_id where mv.id in (select version_id from migrator.migrator_migrations where filename = ?) order by vid desc, mid asc", versionsByFile)
 }
 
 func TestMySQLGetVersionByIDSQL(t *testing.T) {
@@ -125,7 +129,7 @@ func TestMySQLGetVersionByIDSQL(t *testing.T) {
 
 	versionsByID := dialect.GetVersionByIDSQL()
 
-	assert.Equal(t, "select mv.id as vid, mv.name as vname, mv.created as vcreated, mm.id as mid, mm.name, mm.source_dir, mm.filename, mm.type, mm.db_schema, mm.created, mm.contents, mm.checksum from migrator.migrator_versions mv left join migrator.migrator_migrations mm on mv.id = mm.version_id where mv.id = ? order by mid asc", versionsByID)
+	assert.Equal(t, "select mv.id as vid, mv.name as vname, mv.created as vcreated, mm.id as mid, mm.name, mm.source_dir, mm.filename, mm.type, mm.db_schema, mm.created, mm.contents, mm.checksum, mm.source_commit from migrator.migrator_versions mv left join migrator.migrator_migrations mm on mv.id = mm.version_id where mv.id = ? order by mid asc", versionsByID)
 }
 
 func TestMySQLGetMigrationByIDSQL(t *testing.T) {
@@ -137,5 +141,112 @@ func TestMySQLGetMigrationByIDSQL(t *testing.T) {
 
 	migrationByID := dialect.GetMigrationByIDSQL()
 
-	assert.Equal(t, "select id, name, source_dir, filename, type, db_schema, created, contents, checksum from migrator.migrator_migrations where id = ?", migrationByID)
+	assert.Equal(t, "select id, name, source_dir, filename, type, db_schema, created, contents, checksum, source_commit from migrator.migrator_migrations where id = ?", migrationByID)
+}
+
+func TestMySQLGetMigrationsByVersionIDSQL(t *testing.T) {
+	config, err := config.FromFile("../test/migrator-mysql.yaml")
+	assert.Nil(t, err)
+
+	config.Driver = "mysql"
+	dialect := newDialect(config)
+
+	migrationsByVersionID := dialect.GetMigrationsByVersionIDSQL()
+
+	assert.Equal(t, "select id, name, source_dir, filename, type, db_schema, created, contents, checksum, down_contents, source_commit from migrator.migrator_migrations where version_id = ? order by id desc", migrationsByVersionID)
+}
+
+func TestMySQLGetAddDownContentsColumnSQL(t *testing.T) {
+	config, err := config.FromFile("../test/migrator-mysql.yaml")
+	assert.Nil(t, err)
+
+	config.Driver = "mysql"
+	dialect := newDialect(config)
+
+	actual := dialect.GetAddDownContentsColumnSQL()
+	expectedProcedure :=
+		`
+create procedure migrator_create_down_contents()
+begin
+if not exists (select * from information_schema.columns where table_schema = 'migrator' and table_name = 'migrator_migrations' and column_name = 'down_contents') then
+  alter table migrator.migrator_migrations add column down_contents text;
+end if;
+end;
+`
+
+	assert.Equal(t, "drop procedure if exists migrator_create_down_contents", actual[0])
+	assert.Equal(t, expectedProcedure, actual[1])
+	assert.Equal(t, "call migrator_create_down_contents()", actual[2])
+}
+
+func TestMySQLGetAddRenderedContentsColumnSQL(t *testing.T) {
+	config, err := config.FromFile("../test/migrator-mysql.yaml")
+	assert.Nil(t, err)
+
+	config.Driver = "mysql"
+	dialect := newDialect(config)
+
+	actual := dialect.GetAddRenderedContentsColumnSQL()
+	expectedProcedure :=
+		`
+create procedure migrator_create_rendered_contents()
+begin
+if not exists (select * from information_schema.columns where table_schema = 'migrator' and table_name = 'migrator_migrations' and column_name = 'rendered_contents') then
+  alter table migrator.migrator_migrations add column rendered_contents text;
+end if;
+end;
+`
+
+	assert.Equal(t, "drop procedure if exists migrator_create_rendered_contents", actual[0])
+	assert.Equal(t, expectedProcedure, actual[1])
+	assert.Equal(t, "call migrator_create_rendered_contents()", actual[2])
+}
+
+func TestMySQLGetLockAndUnlockSQL(t *testing.T) {
+	config, err := config.FromFile("../test/migrator-mysql.yaml")
+	assert.Nil(t, err)
+
+	config.Driver = "mysql"
+	dialect := newDialect(config)
+
+	// get_lock timeout is in seconds and is rounded up
+	assert.Equal(t, "select coalesce(get_lock('migrator', 2), 0)", dialect.GetLockSQL(1500*time.Millisecond))
+	assert.Equal(t, "select release_lock('migrator')", dialect.GetUnlockSQL())
+}
+
+func TestMySQLGetErrorCode(t *testing.T) {
+	config := &config.Config{}
+	config.Driver = "mysql"
+	dialect := newDialect(config)
+
+	err := fmt.Errorf("wrapped: %w", &mysql.MySQLError{Number: 1146, Message: "Table doesn't exist"})
+
+	assert.Equal(t, "1146", dialect.GetErrorCode(err))
+	assert.Equal(t, "", dialect.GetErrorCode(errors.New("trouble maker")))
+}
+
+func TestMySQLGetTenantSQLWithMetadataColumns(t *testing.T) {
+	config, err := config.FromFile("../test/migrator-mysql.yaml")
+	assert.Nil(t, err)
+
+	config.Driver = "mysql"
+	config.TenantMetadataColumns = []string{"region", "tier"}
+	dialect := newDialect(config)
+	connector := baseConnector{newTestContext(), config, dialect, nil, false, nil}
+
+	assert.Equal(t, "insert into migrator.migrator_tenants (name, region, tier) values (?, ?, ?)", connector.getTenantInsertSQL())
+	assert.Equal(t, "select name, region, tier from migrator.migrator_tenants", connector.getTenantSelectSQL())
+
+	actual := dialect.GetAddTenantMetadataColumnSQL("tier")
+	expectedProcedure :=
+		`
+create procedure migrator_create_tenant_metadata()
+begin
+if not exists (select * from information_schema.columns where table_schema = 'migrator' and table_name = 'migrator_tenants' and column_name = 'tier') then
+  alter table migrator.migrator_tenants add column tier varchar(200);
+end if;
+end;
+`
+
+	assert.Equal(t, []string{"drop procedure if exists migrator_create_tenant_metadata", expectedProcedure, "call migrator_create_tenant_metadata()"}, actual)
 }
@@ -1,7 +1,11 @@
 package db
 
 import (
+	"errors"
 	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
 	// blank import for PostgreSQL driver
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
@@ -11,13 +15,20 @@ type postgreSQLDialect struct {
 }
 
 const (
-	insertMigrationPostgreSQLDialectSQL      = "insert into %v.%v (name, source_dir, filename, type, db_schema, contents, checksum, version_id) values ($1, $2, $3, $4, $5, $6, $7, $8)"
-	insertTenantPostgreSQLDialectSQL         = "insert into %v.%v (name) values ($1)"
-	insertVersionPostgreSQLDialectSQL        = "insert into %v.%v (name) values ($1) returning id"
-	selectVersionsByFilePostgreSQLDialectSQL = "select mv.id as vid, mv.name as vname, mv.created as vcreated, mm.id as mid, mm.name, mm.source_dir, mm.filename, mm.type, mm.db_schema, mm.created, mm.contents, mm.checksum from %v.%v mv left join %v.%v mm on mv.id = mm.version_id where mv.id in (select version_id from %v.%v where filename = $1) order by vid desc, mid asc"
-	selectVersionByIDPostgreSQLDialectSQL    = "select mv.id as vid, mv.name as vname, mv.created as vcreated, mm.id as mid, mm.name, mm.source_dir, mm.filename, mm.type, mm.db_schema, mm.created, mm.contents, mm.checksum from %v.%v mv left join %v.%v mm on mv.id = mm.version_id where mv.id = $1 order by mid asc"
-	selectMigrationByIDPostgreSQLDialectSQL  = "select id, name, source_dir, filename, type, db_schema, created, contents, checksum from %v.%v where id = $1"
-	versionsTableSetupPostgreSQLDialectSQL   = `
+	insertMigrationPostgreSQLDialectSQL             = "insert into %v.%v (name, source_dir, filename, type, db_schema, contents, checksum, version_id, down_contents, rendered_contents, source_commit) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)"
+	insertTenantPostgreSQLDialectSQL                = "insert into %v.%v (%v) values (%v)"
+	insertVersionPostgreSQLDialectSQL               = "insert into %v.%v (name) values ($1) returning id"
+	selectVersionsByFilePostgreSQLDialectSQL        = "select mv.id as vid, mv.name as vname, mv.created as vcreated, mm.id as mid, mm.name, mm.source_dir, mm.filename, mm.type, mm.db_schema, mm.created, mm.contents, mm.checksum, mm.source_commit from %v.%v mv left join %v.%v mm on mv.id = mm.version_id where mv.id in (select version_id from %v.%v where filename = $1) order by vid desc, mid asc"
+	selectVersionByIDPostgreSQLDialectSQL           = "select mv.id as vid, mv.name as vname, mv.created as vcreated, mm.id as mid, mm.name, mm.source_dir, mm.filename, mm.type, mm.db_schema, mm.created, mm.contents, mm.checksum, mm.source_commit from %v.%v mv left join %v.%v mm on mv.id = mm.version_id where mv.id = $1 order by mid asc"
+	selectMigrationByIDPostgreSQLDialectSQL         = "select id, name, source_dir, filename, type, db_schema, created, contents, checksum, source_commit from %v.%v where id = $1"
+	selectMigrationsByVersionIDPostgreSQLDialectSQL = "select id, name, source_dir, filename, type, db_schema, created, contents, checksum, down_contents, source_commit from %v.%v where version_id = $1 order by id desc"
+	lockPostgreSQLDialectSQL                        = "select 1 from pg_advisory_lock(hashtext('%v'))"
+	unlockPostgreSQLDialectSQL                      = "select pg_advisory_unlock(hashtext('%v'))"
+	downContentsColumnSetupPostgreSQLDialectSQL     = "alter table %v.%v add column if not exists down_contents text"
+	renderedContentsColumnSetupPostgreSQLDialectSQL = "alter table %v.%v add column if not exists rendered_contents text"
+	sourceCommitColumnSetupPostgreSQLDialectSQL     = "alter table %v.%v add column if not exists source_commit varchar(64)"
+	tenantMetadataColumnSetupPostgreSQLDialectSQL   = "alter table %v.%v add column if not exists %v varchar(200)"
+	versionsTableSetupPostgreSQLDialectSQL          = `
 do $$
 begin
 if not exists (select * from information_schema.tables where table_schema = '%v' and table_name = '%v') then
@@ -46,14 +57,20 @@ func (pd *postgreSQLDialect) LastInsertIDSupported() bool {
 	return false
 }
 
+// GetSplitterOptions returns PostgreSQL options used to split migrations into statements
+func (pd *postgreSQLDialect) GetSplitterOptions() splitterOptions {
+	return splitterOptions{delimiter: ";", dollarQuoting: true, nestedComments: true, escapeStrings: true, atomicBlocks: true}
+}
+
 // GetMigrationInsertSQL returns PostgreSQL-specific migration insert SQL statement
 func (pd *postgreSQLDialect) GetMigrationInsertSQL() string {
 	return fmt.Sprintf(insertMigrationPostgreSQLDialectSQL, migratorSchema, migratorMigrationsTable)
 }
 
-// GetTenantInsertSQL returns PostgreSQL-specific migrator's default tenant insert SQL statement
-func (pd *postgreSQLDialect) GetTenantInsertSQL() string {
-	return fmt.Sprintf(insertTenantPostgreSQLDialectSQL, migratorSchema, migratorTenantsTable)
+// GetTenantInsertSQL returns PostgreSQL-specific migrator's default tenant insert SQL statement, name is followed by passed tenant metadata columns
+func (pd *postgreSQLDialect) GetTenantInsertSQL(metadataColumns []string) string {
+	placeholders := tenantPlaceholders(metadataColumns, func(i int) string { return fmt.Sprintf("$%d", i) })
+	return fmt.Sprintf(insertTenantPostgreSQLDialectSQL, migratorSchema, migratorTenantsTable, tenantColumns(metadataColumns), placeholders)
 }
 
 func (pd *postgreSQLDialect) GetVersionInsertSQL() string {
@@ -80,3 +97,50 @@ func (pd *postgreSQLDialect) GetVersionByIDSQL() string {
 func (pd *postgreSQLDialect) GetMigrationByIDSQL() string {
 	return fmt.Sprintf(selectMigrationByIDPostgreSQLDialectSQL, migratorSchema, migratorMigrationsTable)
 }
+
+// GetAddDownContentsColumnSQL returns PostgreSQL-specific SQL which adds down_contents column to migrations table
+// (backwards compatibility) the column is added only if it does not already exist
+func (pd *postgreSQLDialect) GetAddDownContentsColumnSQL() []string {
+	return []string{fmt.Sprintf(downContentsColumnSetupPostgreSQLDialectSQL, migratorSchema, migratorMigrationsTable)}
+}
+
+// GetAddRenderedContentsColumnSQL returns PostgreSQL-specific SQL which adds rendered_contents column to migrations table
+// (backwards compatibility) the column is added only if it does not already exist
+func (pd *postgreSQLDialect) GetAddRenderedContentsColumnSQL() []string {
+	return []string{fmt.Sprintf(renderedContentsColumnSetupPostgreSQLDialectSQL, migratorSchema, migratorMigrationsTable)}
+}
+
+// GetAddSourceCommitColumnSQL returns PostgreSQL-specific SQL which adds source_commit column to migrations table
+func (pd *postgreSQLDialect) GetAddSourceCommitColumnSQL() []string {
+	return []string{fmt.Sprintf(sourceCommitColumnSetupPostgreSQLDialectSQL, migratorSchema, migratorMigrationsTable)}
+}
+
+// GetAddTenantMetadataColumnSQL returns PostgreSQL-specific SQL which adds passed tenant metadata column to default tenants table
+// the column is added only if it does not already exist
+func (pd *postgreSQLDialect) GetAddTenantMetadataColumnSQL(column string) []string {
+	return []string{fmt.Sprintf(tenantMetadataColumnSetupPostgreSQLDialectSQL, migratorSchema, migratorTenantsTable, column)}
+}
+
+func (pd *postgreSQLDialect) GetMigrationsByVersionIDSQL() string {
+	return fmt.Sprintf(selectMigrationsByVersionIDPostgreSQLDialectSQL, migratorSchema, migratorMigrationsTable)
+}
+
+// GetLockSQL returns PostgreSQL-specific SQL which acquires session-level advisory lock
+// pg_advisory_lock waits indefinitely, lock timeout is enforced by the connector using context deadline
+func (pd *postgreSQLDialect) GetLockSQL(timeout time.Duration) string {
+	return fmt.Sprintf(lockPostgreSQLDialectSQL, migratorLockName)
+}
+
+// GetUnlockSQL returns PostgreSQL-specific SQL which releases session-level advisory lock
+func (pd *postgreSQLDialect) GetUnlockSQL() string {
+	return fmt.Sprintf(unlockPostgreSQLDialectSQL, migratorLockName)
+}
+
+// GetErrorCode returns PostgreSQL SQLSTATE error code or empty string if passed error is not a PostgreSQL error
+func (pd *postgreSQLDialect) GetErrorCode(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	return ""
+}
@@ -1,8 +1,12 @@
 package db
 
 import (
+	"errors"
+	"fmt"
 	"testing"
+	"time"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/lukaszbudnik/migrator/config"
 	"github.com/stretchr/testify/assert"
 )
@@ -35,7 +39,7 @@ func TestPostgreSQLGetMigrationInsertSQL(t *testing.T) {
 
 	insertMigrationSQL := dialect.GetMigrationInsertSQL()
 
-	assert.Equal(t, "insert into migrator.migrator_migrations (name, source_dir, filename, type, db_schema, contents, checksum, version_id) values ($1, $2, $3, $4, $5, $6, $7, $8)", insertMigrationSQL)
+	assert.Equal(t, "insert into migrator.migrator_migrations (name, source_dir, filename, type, db_schema, contents, checksum, version_id, down_contents, rendered_contents, source_commit) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)", insertMigrationSQL)
 }
 
 func TestPostgreSQLGetTenantInsertSQLDefault(t *testing.T) {
@@ -44,7 +48,7 @@ func TestPostgreSQLGetTenantInsertSQLDefault(t *testing.T) {
 
 	config.Driver = "postgres"
 	dialect := newDialect(config)
-	connector := baseConnector{newTestContext(), config, dialect, nil, false}
+	connector := baseConnector{newTestContext(), config, dialect, nil, false, nil}
 	defer connector.Dispose()
 
 	tenantInsertSQL := connector.getTenantInsertSQL()
@@ -109,7 +113,7 @@ func TestPostgreSQLGetVersionsByFileSQL(t *testing.T) {
 
 	versionsByFile := dialect.GetVersionsByFileSQL()
 
-	assert.Equal(t, "select mv.id as vid, mv.name as vname, mv.created as vcreated, mm.id as mid, mm.name, mm.source_dir, mm.filename, mm.type, mm.db_schema, mm.created, mm.contents, mm.checksum from migrator.migrator_versions mv left join migrator.migrator_migrations mm on mv.id = mm.version_id where mv.id in (select version_id from migrator.migrator_migrations where filename = $1) order by vid desc, mid asc", versionsByFile)
+	assert.Equal(t, "select mv.id as vid, mv.name as vname, mv.created as vcreated, mm.id as mid, mm.name, mm.source_dir, mm.filename, mm.type, mm.db_schema, mm.created, mm.contents, mm.checksum, mm.source_commit from migrator.migrator_versions mv left join migrator.migrator_migrations mm on mv.id = mm.version_id where mv.id in (select version_id from migrator.migrator_migrations where filename = $1) order by vid desc, mid asc", versionsByFile)
 }
 
 func TestPostgreSQLGetVersionByIDSQL(t *testing.T) {
@@ -121,7 +125,7 @@ func TestPostgreSQLGetVersionByIDSQL(t *testing.T) {
 
 	versionsByID := dialect.GetVersionByIDSQL()
 
-	assert.Equal(t, "select mv.id as vid, mv.name as vname, mv.created as vcreated, mm.id as mid, mm.name, mm.source_dir, mm.filename, mm.type, mm.db_schema, mm.created, mm.contents, mm.checksum from migrator.migrator_versions mv left join migrator.migrator_migrations mm on mv.id = mm.version_id where mv.id = $1 order by mid asc", versionsByID)
+	assert.Equal(t, "select mv.id as vid, mv.name as vname, mv.created as vcreated, mm.id as mid, mm.name, mm.source_dir, mm.filename, mm.type, mm.db_schema, mm.created, mm.contents, mm.checksum, mm.source_commit from migrator.migrator_versions mv left join migrator.migrator_migrations mm on mv.id = mm.version_id where mv.id = $1 order by mid asc", versionsByID)
 }
 
 func TestPostgreSQLGetMigrationByIDSQL(t *testing.T) {
@@ -133,5 +137,77 @@ func TestPostgreSQLGetMigrationByIDSQL(t *testing.T) {
 
 	migrationByID := dialect.GetMigrationByIDSQL()
 
-	assert.Equal(t, "select id, name, source_dir, filename, type, db_schema, created, contents, checksum from migrator.migrator_migrations where id = $1", migrationByID)
+	assert.Equal(t, "select id, name, source_dir, filename, type, db_schema, created, contents, checksum, source_commit from migrator.migrator_migrations where id = $1", migrationByID)
+}
+
+func TestPostgreSQLGetMigrationsByVersionIDSQL(t *testing.T) {
+	config, err := config.FromFile("../test/migrator-postgresql.yaml")
+	assert.Nil(t, err)
+
+	config.Driver = "postgres"
+	dialect := newDialect(config)
+
+	migrationsByVersionID := dialect.GetMigrationsByVersionIDSQL()
+
+	assert.Equal(t, "select id, name, source_dir, filename, type, db_schema, created, contents, checksum, down_contents, source_commit from migrator.migrator_migrations where version_id = $1 order by id desc", migrationsByVersionID)
+}
+
+func TestPostgreSQLGetAddDownContentsColumnSQL(t *testing.T) {
+	config, err := config.FromFile("../test/migrator-postgresql.yaml")
+	assert.Nil(t, err)
+
+	config.Driver = "postgres"
+	dialect := newDialect(config)
+
+	actual := dialect.GetAddDownContentsColumnSQL()
+
+	assert.Equal(t, []string{"alter table migrator.migrator_migrations add column if not exists down_contents text"}, actual)
+}
+
+func TestPostgreSQLGetAddRenderedContentsColumnSQL(t *testing.T) {
+	config, err := config.FromFile("../test/migrator-postgresql.yaml")
+	assert.Nil(t, err)
+
+	config.Driver = "postgres"
+	dialect := newDialect(config)
+
+	actual := dialect.GetAddRenderedContentsColumnSQL()
+
+	assert.Equal(t, []string{"alter table migrator.migrator_migrations add column if not exists rendered_contents text"}, actual)
+}
+
+func TestPostgreSQLGetLockAndUnlockSQL(t *testing.T) {
+	config, err := config.FromFile("../test/migrator-postgresql.yaml")
+	assert.Nil(t, err)
+
+	config.Driver = "postgres"
+	dialect := newDialect(config)
+
+	assert.Equal(t, "select 1 from pg_advisory_lock(hashtext('migrator'))", dialect.GetLockSQL(30*time.Second))
+	assert.Equal(t, "select pg_advisory_unlock(hashtext('migrator'))", dialect.GetUnlockSQL())
+}
+
+func TestPostgreSQLGetErrorCode(t *testing.T) {
+	config := &config.Config{}
+	config.Driver = "postgres"
+	dialect := newDialect(config)
+
+	err := fmt.Errorf("wrapped: %w", &pgconn.PgError{Code: "42P01", Message: "relation does not exist"})
+
+	assert.Equal(t, "42P01", dialect.GetErrorCode(err))
+	assert.Equal(t, "", dialect.GetErrorCode(errors.New("trouble maker")))
+}
+
+func TestPostgreSQLGetTenantSQLWithMetadataColumns(t *testing.T) {
+	config, err := config.FromFile("../test/migrator-postgresql.yaml")
+	assert.Nil(t, err)
+
+	config.Driver = "postgres"
+	config.TenantMetadataColumns = []string{"region", "tier"}
+	dialect := newDialect(config)
+	connector := baseConnector{newTestContext(), config, dialect, nil, false, nil}
+
+	assert.Equal(t, "insert into migrator.migrator_tenants (name, region, tier) values ($1, $2, $3)", connector.getTenantInsertSQL())
+	assert.Equal(t, "select name, region, tier from migrator.migrator_tenants", connector.getTenantSelectSQL())
+	assert.Equal(t, []string{"alter table migrator.migrator_tenants add column if not exists tier varchar(200)"}, dialect.GetAddTenantMetadataColumnSQL("tier"))
 }
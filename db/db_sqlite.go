@@ -0,0 +1,217 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteDialect implements dialect interface for SQLite
+// SQLite has no schemas, migrator tables are created in the main database
+// and tenants are mapped to table-name prefixes, for example: {schema}_settings
+type sqliteDialect struct {
+	baseDialect
+}
+
+const (
+	insertMigrationSQLiteDialectSQL             = "insert into %v (name, source_dir, filename, type, db_schema, contents, checksum, version_id, down_contents, rendered_contents, source_commit) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	insertTenantSQLiteDialectSQL                = "insert into %v (%v) values (%v)"
+	insertVersionSQLiteDialectSQL               = "insert into %v (name) values (?)"
+	selectTenantsSQLiteDialectSQL               = "select %v from %v"
+	selectMigrationsSQLiteDialectSQL            = "select name, source_dir as sd, filename, type, db_schema, created, contents, checksum, source_commit from %v order by name, source_dir"
+	selectVersionsSQLiteDialectSQL              = "select mv.id as vid, mv.name as vname, mv.created as vcreated, mm.id as mid, mm.name, mm.source_dir, mm.filename, mm.type, mm.db_schema, mm.created, mm.contents, mm.checksum, mm.source_commit from %v mv left join %v mm on mv.id = mm.version_id order by vid desc, mid asc"
+	selectVersionsByFileSQLiteDialectSQL        = "select mv.id as vid, mv.name as vname, mv.created as vcreated, mm.id as mid, mm.name, mm.source_dir, mm.filename, mm.type, mm.db_schema, mm.created, mm.contents, mm.checksum, mm.source_commit from %v mv left join %v mm on mv.id = mm.version_id where mv.id in (select version_id from %v where filename = ?) order by vid desc, mid asc"
+	selectVersionByIDSQLiteDialectSQL           = "select mv.id as vid, mv.name as vname, mv.created as vcreated, mm.id as mid, mm.name, mm.source_dir, mm.filename, mm.type, mm.db_schema, mm.created, mm.contents, mm.checksum, mm.source_commit from %v mv left join %v mm on mv.id = mm.version_id where mv.id = ? order by mid asc"
+	selectMigrationByIDSQLiteDialectSQL         = "select id, name, source_dir, filename, type, db_schema, created, contents, checksum, source_commit from %v where id = ?"
+	selectMigrationsByVersionIDSQLiteDialectSQL = "select id, name, source_dir, filename, type, db_schema, created, contents, checksum, down_contents, source_commit from %v where version_id = ? order by id desc"
+	columnExistsSQLiteDialectSQL                = "select count(*) from pragma_table_info('%v') where name = '%v'"
+	addColumnSQLiteDialectSQL                   = "alter table %v add column %v %v"
+	// SQLite has no advisory locks, migrator does not lock SQLite databases, only transactions are serialised by SQLite write lock
+	lockSQLiteDialectSQL   = "select 1"
+	unlockSQLiteDialectSQL = "select 1"
+	// SQLite has no schemas, the statement is a no-op kept only to validate the schema name
+	createSchemaSQLiteDialectSQL       = "select 1"
+	createTenantsTableSQLiteDialectSQL = `
+create table if not exists %v (
+  id integer primary key autoincrement,
+  name varchar(200) not null,
+  created timestamp default current_timestamp
+)
+`
+	// SQLite support was added after versions were introduced
+	// migrations table is created together with version_id and down_contents columns, rendered_contents and source_commit columns are added later
+	createMigrationsTableSQLiteDialectSQL = `
+create table if not exists %v (
+  id integer primary key autoincrement,
+  name varchar(200) not null,
+  source_dir varchar(200) not null,
+  filename varchar(200) not null,
+  type int not null,
+  db_schema varchar(200) not null,
+  created timestamp default current_timestamp,
+  contents text,
+  checksum varchar(64),
+  version_id integer not null references %v (id) on delete cascade,
+  down_contents text,
+  rendered_contents text,
+  source_commit varchar(64)
+)
+`
+	createVersionsTableSQLiteDialectSQL = `
+create table if not exists %v (
+  id integer primary key autoincrement,
+  name varchar(200) not null,
+  created timestamp default current_timestamp
+)
+`
+	createVersionIDIndexSQLiteDialectSQL = "create index if not exists migrator_versions_version_id_idx on %v (version_id)"
+)
+
+// LastInsertIDSupported instructs migrator if Result.LastInsertId() is supported by the DB driver
+func (sd *sqliteDialect) LastInsertIDSupported() bool {
+	return true
+}
+
+// GetSplitterOptions returns SQLite options used to split migrations into statements
+func (sd *sqliteDialect) GetSplitterOptions() splitterOptions {
+	return splitterOptions{delimiter: ";", backtickQuotes: true, bracketQuotes: true, triggerBlocks: true}
+}
+
+// GetMigrationInsertSQL returns SQLite-specific migration insert SQL statement
+func (sd *sqliteDialect) GetMigrationInsertSQL() string {
+	return fmt.Sprintf(insertMigrationSQLiteDialectSQL, migratorMigrationsTable)
+}
+
+// GetTenantInsertSQL returns SQLite-specific migrator's default tenant insert SQL statement, name is followed by passed tenant metadata columns
+func (sd *sqliteDialect) GetTenantInsertSQL(metadataColumns []string) string {
+	return fmt.Sprintf(insertTenantSQLiteDialectSQL, migratorTenantsTable, tenantColumns(metadataColumns), tenantPlaceholders(metadataColumns, questionMarkPlaceholder))
+}
+
+// GetTenantSelectSQL returns SQLite-specific migrator's default tenant select SQL statement, name is followed by passed tenant metadata columns
+func (sd *sqliteDialect) GetTenantSelectSQL(metadataColumns []string) string {
+	return fmt.Sprintf(selectTenantsSQLiteDialectSQL, tenantColumns(metadataColumns), migratorTenantsTable)
+}
+
+// GetMigrationSelectSQL returns SQLite-specific migrations select SQL statement
+func (sd *sqliteDialect) GetMigrationSelectSQL() string {
+	return fmt.Sprintf(selectMigrationsSQLiteDialectSQL, migratorMigrationsTable)
+}
+
+// GetCreateTenantsTableSQL returns migrator's default create tenants table SQL statement.
+// This SQL is used by SQLite.
+func (sd *sqliteDialect) GetCreateTenantsTableSQL() string {
+	return fmt.Sprintf(createTenantsTableSQLiteDialectSQL, migratorTenantsTable)
+}
+
+// GetCreateMigrationsTableSQL returns migrator's create migrations table SQL statement.
+// This SQL is used by SQLite.
+func (sd *sqliteDialect) GetCreateMigrationsTableSQL() string {
+	return fmt.Sprintf(createMigrationsTableSQLiteDialectSQL, migratorMigrationsTable, migratorVersionsTable)
+}
+
+// GetCreateSchemaSQL returns a no-op statement as SQLite does not support schemas.
+// Tenants are mapped to table-name prefixes and schema name is still validated.
+func (sd *sqliteDialect) GetCreateSchemaSQL(schema string) string {
+	if !isValidIdentifier(schema) {
+		panic(fmt.Sprintf("Schema name contains invalid characters: %v", schema))
+	}
+	return createSchemaSQLiteDialectSQL
+}
+
+func (sd *sqliteDialect) GetVersionInsertSQL() string {
+	return fmt.Sprintf(insertVersionSQLiteDialectSQL, migratorVersionsTable)
+}
+
+// GetCreateVersionsTableSQL returns SQLite-specific SQLs which does:
+// 1. create versions table
+// 2. create index on version column
+// version column is created together with migrations table
+func (sd *sqliteDialect) GetCreateVersionsTableSQL() []string {
+	return []string{
+		fmt.Sprintf(createVersionsTableSQLiteDialectSQL, migratorVersionsTable),
+		fmt.Sprintf(createVersionIDIndexSQLiteDialectSQL, migratorMigrationsTable),
+	}
+}
+
+// GetVersionsSelectSQL returns select SQL statement that returns all versions
+// This SQL is used by SQLite.
+func (sd *sqliteDialect) GetVersionsSelectSQL() string {
+	return fmt.Sprintf(selectVersionsSQLiteDialectSQL, migratorVersionsTable, migratorMigrationsTable)
+}
+
+func (sd *sqliteDialect) GetVersionsByFileSQL() string {
+	return fmt.Sprintf(selectVersionsByFileSQLiteDialectSQL, migratorVersionsTable, migratorMigrationsTable, migratorMigrationsTable)
+}
+
+func (sd *sqliteDialect) GetVersionByIDSQL() string {
+	return fmt.Sprintf(selectVersionByIDSQLiteDialectSQL, migratorVersionsTable, migratorMigrationsTable)
+}
+
+func (sd *sqliteDialect) GetMigrationByIDSQL() string {
+	return fmt.Sprintf(selectMigrationByIDSQLiteDialectSQL, migratorMigrationsTable)
+}
+
+// GetAddDownContentsColumnSQL returns no SQLs as SQLite migrations table is always created with down_contents column
+func (sd *sqliteDialect) GetAddDownContentsColumnSQL() []string {
+	return []string{}
+}
+
+// GetAddRenderedContentsColumnSQL returns no SQLs as SQLite does not support "add column if not exists"
+// the column is added to migrations tables created by previous migrator versions by EnsureColumn
+func (sd *sqliteDialect) GetAddRenderedContentsColumnSQL() []string {
+	return []string{}
+}
+
+// GetAddSourceCommitColumnSQL returns no SQLs as SQLite does not support "add column if not exists"
+// the column is added to migrations tables created by previous migrator versions by EnsureColumn
+func (sd *sqliteDialect) GetAddSourceCommitColumnSQL() []string {
+	return []string{}
+}
+
+// GetAddTenantMetadataColumnSQL returns no SQLs as SQLite does not support "add column if not exists"
+// tenant metadata columns are added to default tenants table by EnsureColumn
+func (sd *sqliteDialect) GetAddTenantMetadataColumnSQL(column string) []string {
+	return []string{}
+}
+
+// EnsureColumn adds column with passed definition to passed table if it does not already exist
+func (sd *sqliteDialect) EnsureColumn(db *sql.DB, table, column, definition string) error {
+	var count int
+	if err := db.QueryRow(fmt.Sprintf(columnExistsSQLiteDialectSQL, table, column)).Scan(&count); err != nil || count > 0 {
+		return err
+	}
+	_, err := db.Exec(fmt.Sprintf(addColumnSQLiteDialectSQL, table, column, definition))
+	return err
+}
+
+func (sd *sqliteDialect) GetMigrationsByVersionIDSQL() string {
+	return fmt.Sprintf(selectMigrationsByVersionIDSQLiteDialectSQL, migratorMigrationsTable)
+}
+
+// GetMaxTenantConcurrency returns 1 as SQLite allows only one writer
+func (sd *sqliteDialect) GetMaxTenantConcurrency() int {
+	return 1
+}
+
+// GetLockSQL returns a no-op statement as SQLite does not support advisory locks
+func (sd *sqliteDialect) GetLockSQL(timeout time.Duration) string {
+	return lockSQLiteDialectSQL
+}
+
+// GetUnlockSQL returns a no-op statement as SQLite does not support advisory locks
+func (sd *sqliteDialect) GetUnlockSQL() string {
+	return unlockSQLiteDialectSQL
+}
+
+// GetErrorCode returns SQLite extended result code or empty string if passed error is not a SQLite error
+func (sd *sqliteDialect) GetErrorCode(err error) string {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return strconv.Itoa(int(sqliteErr.ExtendedCode))
+	}
+	return ""
+}
@@ -0,0 +1,316 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lukaszbudnik/migrator/config"
+	"github.com/lukaszbudnik/migrator/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// newSQLiteTestConfig returns config of embedded SQLite database stored in test's temp dir, tests using it run against a real database file
+func newSQLiteTestConfig(t *testing.T) *config.Config {
+	config := &config.Config{}
+	config.Driver = "sqlite"
+	config.DataSource = fmt.Sprintf("file:%v?_foreign_keys=1", filepath.Join(t.TempDir(), "migrator.db"))
+	return config
+}
+
+func TestDBCreateDialectSQLiteDriver(t *testing.T) {
+	config := &config.Config{}
+	config.Driver = "sqlite"
+	dialect := newDialect(config)
+	assert.IsType(t, &sqliteDialect{}, dialect)
+	// mattn/go-sqlite3 registers itself as sqlite3
+	assert.Equal(t, "sqlite3", config.Driver)
+}
+
+func TestSQLiteGetMigrationInsertSQL(t *testing.T) {
+	config := &config.Config{}
+	config.Driver = "sqlite"
+	dialect := newDialect(config)
+
+	insertMigrationSQL := dialect.GetMigrationInsertSQL()
+
+	assert.Equal(t, "insert into migrator_migrations (name, source_dir, filename, type, db_schema, contents, checksum, version_id, down_contents, rendered_contents, source_commit) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", insertMigrationSQL)
+}
+
+func TestSQLiteGetTenantInsertSQLDefault(t *testing.T) {
+	config := &config.Config{}
+	config.Driver = "sqlite"
+	dialect := newDialect(config)
+	connector := baseConnector{newTestContext(), config, dialect, nil, false, nil}
+	defer connector.Dispose()
+
+	tenantInsertSQL := connector.getTenantInsertSQL()
+
+	assert.Equal(t, "insert into migrator_tenants (name) values (?)", tenantInsertSQL)
+}
+
+func TestSQLiteGetTenantSelectSQL(t *testing.T) {
+	config := &config.Config{}
+	config.Driver = "sqlite"
+	dialect := newDialect(config)
+
+	tenantSelectSQL := dialect.GetTenantSelectSQL(nil)
+
+	assert.Equal(t, "select name from migrator_tenants", tenantSelectSQL)
+}
+
+func TestSQLiteGetCreateSchemaSQL(t *testing.T) {
+	config := &config.Config{}
+	config.Driver = "sqlite"
+	dialect := newDialect(config)
+
+	createSchemaSQL := dialect.GetCreateSchemaSQL("abc")
+
+	assert.Equal(t, "select 1", createSchemaSQL)
+}
+
+func TestSQLiteGetCreateSchemaSQLInvalidName(t *testing.T) {
+	config := &config.Config{}
+	config.Driver = "sqlite"
+	dialect := newDialect(config)
+
+	assert.PanicsWithValue(t, "Schema name contains invalid characters: abc; drop table x", func() {
+		dialect.GetCreateSchemaSQL("abc; drop table x")
+	})
+}
+
+func TestSQLiteGetVersionInsertSQL(t *testing.T) {
+	config := &config.Config{}
+	config.Driver = "sqlite"
+	dialect := newDialect(config)
+
+	versionInsertSQL := dialect.GetVersionInsertSQL()
+
+	assert.Equal(t, "insert into migrator_versions (name) values (?)", versionInsertSQL)
+}
+
+func TestSQLiteGetCreateVersionsTableSQL(t *testing.T) {
+	config := &config.Config{}
+	config.Driver = "sqlite"
+	dialect := newDialect(config)
+
+	actual := dialect.GetCreateVersionsTableSQL()
+
+	expected :=
+		`
+create table if not exists migrator_versions (
+  id integer primary key autoincrement,
+  name varchar(200) not null,
+  created timestamp default current_timestamp
+)
+`
+
+	assert.Len(t, actual, 2)
+	assert.Equal(t, expected, actual[0])
+	assert.Equal(t, "create index if not exists migrator_versions_version_id_idx on migrator_migrations (version_id)", actual[1])
+}
+
+func TestSQLiteGetVersionsByFileSQL(t *testing.T) {
+	config := &config.Config{}
+	config.Driver = "sqlite"
+	dialect := newDialect(config)
+
+	versionsByFile := dialect.GetVersionsByFileSQL()
+
+	assert.Equal(t, "select mv.id as vid, mv.name as vname, mv.created as vcreated, mm.id as mid, mm.name, mm.source_dir, mm.filename, mm.type, mm.db_schema, mm.created, mm.contents, mm.checksum, mm.source_commit from migrator_versions mv left join migrator_migrations mm on mv.id = mm.version_id where mv.id in (select version_id from migrator_migrations where filename = ?) order by vid desc, mid asc", versionsByFile)
+}
+
+func TestSQLiteGetVersionByIDSQL(t *testing.T) {
+	config := &config.Config{}
+	config.Driver = "sqlite"
+	dialect := newDialect(config)
+
+	versionsByID := dialect.GetVersionByIDSQL()
+
+	assert.Equal(t, "select mv.id as vid, mv.name as vname, mv.created as vcreated, mm.id as mid, mm.name, mm.source_dir, mm.filename, mm.type, mm.db_schema, mm.created, mm.contents, mm.checksum, mm.source_commit from migrator_versions mv left join migrator_migrations mm on mv.id = mm.version_id where mv.id = ? order by mid asc", versionsByID)
+}
+
+func TestSQLiteGetMigrationByIDSQL(t *testing.T) {
+	config := &config.Config{}
+	config.Driver = "sqlite"
+	dialect := newDialect(config)
+
+	migrationByID := dialect.GetMigrationByIDSQL()
+
+	assert.Equal(t, "select id, name, source_dir, filename, type, db_schema, created, contents, checksum, source_commit from migrator_migrations where id = ?", migrationByID)
+}
+
+func TestSQLiteCreateTenantAndVersion(t *testing.T) {
+	config := newSQLiteTestConfig(t)
+	connector := New(newTestContext(), config)
+	defer connector.Dispose()
+
+	assert.Nil(t, connector.HealthCheck())
+
+	tn := time.Now().UnixNano()
+	single := types.Migration{Name: fmt.Sprintf("%v.sql", tn), SourceDir: "ref", File: fmt.Sprintf("ref/%v.sql", tn), MigrationType: types.MigrationTypeSingleMigration, Contents: "create table ref_roles (id integer primary key, name text)", CheckSum: "abc"}
+	tenant := types.Migration{Name: fmt.Sprintf("%v.sql", tn+1), SourceDir: "tenants", File: fmt.Sprintf("tenants/%v.sql", tn+1), MigrationType: types.MigrationTypeTenantMigration, Contents: "create table {schema}_settings (k integer, v text)", CheckSum: "def"}
+
+	results, version, err := connector.CreateTenant(types.Tenant{Name: "abc"}, "create abc", types.ActionApply, []types.Migration{tenant}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), results.TenantMigrationsTotal)
+	assert.Equal(t, "create abc", version.Name)
+	assert.Len(t, version.DBMigrations, 1)
+	assert.Equal(t, "abc", version.DBMigrations[0].Schema)
+
+	tenants, err := connector.GetTenants()
+	assert.Nil(t, err)
+	assert.Len(t, tenants, 1)
+	assert.Equal(t, "abc", tenants[0].Name)
+
+	insert := types.Migration{Name: fmt.Sprintf("%v.sql", tn+2), SourceDir: "tenants", File: fmt.Sprintf("tenants/%v.sql", tn+2), MigrationType: types.MigrationTypeTenantMigration, Contents: "insert into {schema}_settings values (1, 'a')", CheckSum: "ghi"}
+	// migrations loaded from git repository record source commit
+	sourceCommit := "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+	single.SourceCommit = &sourceCommit
+	insert.SourceCommit = &sourceCommit
+	results, version, err = connector.CreateVersion("v2", types.ActionApply, []types.Migration{single, insert}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), results.SingleMigrations)
+	assert.Equal(t, int32(1), results.TenantMigrationsTotal)
+	assert.Len(t, version.DBMigrations, 2)
+	assert.Equal(t, &sourceCommit, version.SourceCommit)
+
+	versions, err := connector.GetVersions()
+	assert.Nil(t, err)
+	assert.Len(t, versions, 2)
+	assert.Equal(t, "v2", versions[0].Name)
+	assert.Equal(t, &sourceCommit, versions[0].SourceCommit)
+	assert.Nil(t, versions[1].SourceCommit)
+	assert.Nil(t, versions[1].DBMigrations[0].SourceCommit)
+
+	byFile, err := connector.GetVersionsByFile(insert.File)
+	assert.Nil(t, err)
+	assert.Len(t, byFile, 1)
+	assert.Equal(t, version.ID, byFile[0].ID)
+
+	dbMigration, err := connector.GetDBMigrationByID(version.DBMigrations[0].ID)
+	assert.Nil(t, err)
+	assert.Equal(t, single.File, dbMigration.File)
+	assert.Equal(t, &sourceCommit, dbMigration.SourceCommit)
+
+	applied, err := connector.GetAppliedMigrations()
+	assert.Nil(t, err)
+	assert.Len(t, applied, 3)
+
+	// dry-run does not persist anything
+	dryRun := types.Migration{Name: fmt.Sprintf("%v.sql", tn+3), SourceDir: "ref", File: fmt.Sprintf("ref/%v.sql", tn+3), MigrationType: types.MigrationTypeSingleMigration, Contents: "create table ref_dry (id integer)", CheckSum: "jkl"}
+	_, version, err = connector.CreateVersion("v3", types.ActionApply, []types.Migration{dryRun}, true)
+	assert.Nil(t, err)
+	assert.NotNil(t, version)
+	versions, err = connector.GetVersions()
+	assert.Nil(t, err)
+	assert.Len(t, versions, 2)
+}
+
+func TestSQLiteRollbackVersion(t *testing.T) {
+	config := newSQLiteTestConfig(t)
+	connector := New(newTestContext(), config)
+	defer connector.Dispose()
+
+	tn := time.Now().UnixNano()
+	tenant1 := types.Migration{Name: fmt.Sprintf("%v.sql", tn), SourceDir: "tenants", File: fmt.Sprintf("tenants/%v.sql", tn), MigrationType: types.MigrationTypeTenantMigration, Contents: "create table {schema}_settings (k integer, v text)", DownContents: "drop table {schema}_settings"}
+	connector.CreateTenant(types.Tenant{Name: "abc"}, "create abc", types.ActionApply, []types.Migration{tenant1}, false)
+	connector.CreateTenant(types.Tenant{Name: "def"}, "create def", types.ActionApply, []types.Migration{tenant1}, false)
+
+	single1 := types.Migration{Name: fmt.Sprintf("%v.sql", tn+1), SourceDir: "ref", File: fmt.Sprintf("ref/%v.sql", tn+1), MigrationType: types.MigrationTypeSingleMigration, Contents: "create table ref_roles (id integer)", DownContents: "drop table ref_roles"}
+	tenant2 := types.Migration{Name: fmt.Sprintf("%v.sql", tn+2), SourceDir: "tenants", File: fmt.Sprintf("tenants/%v.sql", tn+2), MigrationType: types.MigrationTypeTenantMigration, Contents: "alter table {schema}_settings add column x text", DownContents: "alter table {schema}_settings drop column x"}
+	script := types.Migration{Name: fmt.Sprintf("%v.sql", tn+3), SourceDir: "tenants-scripts", File: fmt.Sprintf("tenants-scripts/%v.sql", tn+3), MigrationType: types.MigrationTypeTenantScript, Contents: "insert into {schema}_settings values (1, 'a', 'b')"}
+	_, version, err := connector.CreateVersion("v3", types.ActionApply, []types.Migration{single1, tenant2, script}, false)
+	assert.Nil(t, err)
+	assert.Len(t, version.DBMigrations, 5)
+
+	// older versions cannot be rolled back before newer versions
+	_, _, err = connector.RollbackVersion(version.ID-1, "rollback create def", false)
+	assert.NotNil(t, err)
+	assert.Equal(t, fmt.Sprintf("only the latest version with applied migrations can be rolled back, version ID: %v, latest version ID: %v", version.ID-1, version.ID), err.Error())
+	assert.IsType(t, &types.RollbackRefusedError{}, err)
+	assert.Equal(t, types.ErrorCodeRollbackRefused, err.(*types.RollbackRefusedError).Extensions()["code"])
+
+	// dry-run does not persist anything
+	_, _, err = connector.RollbackVersion(version.ID, "rollback v3", true)
+	assert.Nil(t, err)
+	versions, err := connector.GetVersions()
+	assert.Nil(t, err)
+	assert.Len(t, versions, 3)
+
+	results, rollbackVersion, err := connector.RollbackVersion(version.ID, "rollback v3", false)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), results.SingleMigrations)
+	assert.Equal(t, int32(1), results.TenantMigrations)
+	assert.Equal(t, int32(2), results.TenantMigrationsTotal)
+	assert.Equal(t, int32(2), results.Tenants)
+	assert.Equal(t, "rollback v3", rollbackVersion.Name)
+	assert.Len(t, rollbackVersion.DBMigrations, 3)
+	// down migrations are run in reverse order
+	assert.Equal(t, fmt.Sprintf("tenants/%v.down.sql", tn+2), rollbackVersion.DBMigrations[0].File)
+	assert.Equal(t, "def", rollbackVersion.DBMigrations[0].Schema)
+	assert.Equal(t, fmt.Sprintf("ref/%v.down.sql", tn+1), rollbackVersion.DBMigrations[2].File)
+
+	// rolled back version is kept for audit
+	versions, err = connector.GetVersions()
+	assert.Nil(t, err)
+	assert.Len(t, versions, 4)
+	assert.Equal(t, "rollback v3", versions[0].Name)
+	rolledBack, err := connector.GetVersionByID(version.ID)
+	assert.Nil(t, err)
+	assert.Len(t, rolledBack.DBMigrations, 5)
+
+	// rolled back migrations and down migrations are not returned as applied migrations, scripts are
+	applied, err := connector.GetAppliedMigrations()
+	assert.Nil(t, err)
+	assert.Len(t, applied, 4)
+	for _, m := range applied {
+		assert.NotContains(t, []string{single1.File, tenant2.File}, m.File)
+	}
+
+	// rolled back version cannot be rolled back again, older versions can be rolled back once newer versions are rolled back
+	_, _, err = connector.RollbackVersion(version.ID, "rollback v3 again", false)
+	assert.NotNil(t, err)
+	assert.Equal(t, fmt.Sprintf("only the latest version with applied migrations can be rolled back, version ID: %v, latest version ID: %v", version.ID, version.ID-1), err.Error())
+	_, _, err = connector.RollbackVersion(version.ID-1, "rollback create def", false)
+	assert.Nil(t, err)
+
+	// migration without down migration cannot be rolled back
+	noDown := types.Migration{Name: fmt.Sprintf("%v.sql", tn+4), SourceDir: "ref", File: fmt.Sprintf("ref/%v.sql", tn+4), MigrationType: types.MigrationTypeSingleMigration, Contents: "create table ref_x (id integer)"}
+	_, version, err = connector.CreateVersion("v5", types.ActionApply, []types.Migration{noDown}, false)
+	assert.Nil(t, err)
+	_, _, err = connector.RollbackVersion(version.ID, "rollback v5", false)
+	assert.NotNil(t, err)
+	assert.Equal(t, fmt.Sprintf("down migration not found for: ref/%v.sql", tn+4), err.Error())
+	assert.Equal(t, fmt.Sprintf("ref/%v.sql", tn+4), err.(*types.RollbackRefusedError).Extensions()["file"])
+
+	_, _, err = connector.RollbackVersion(12345, "rollback", false)
+	assert.NotNil(t, err)
+	assert.Equal(t, "version not found ID: 12345", err.Error())
+}
+
+func TestSQLiteGetErrorCodeAndMigrationError(t *testing.T) {
+	config := newSQLiteTestConfig(t)
+	connector := New(newTestContext(), config)
+	defer connector.Dispose()
+
+	tn := time.Now().UnixNano()
+	broken := types.Migration{Name: fmt.Sprintf("%v.sql", tn), SourceDir: "ref", File: fmt.Sprintf("ref/%v.sql", tn), MigrationType: types.MigrationTypeSingleMigration, Contents: "insert into not_existing values (1)"}
+
+	_, _, err := connector.CreateVersion("broken", types.ActionApply, []types.Migration{broken}, false)
+
+	var migrationErr *types.MigrationError
+	assert.True(t, errors.As(err, &migrationErr))
+	assert.Equal(t, broken.File, migrationErr.File)
+	assert.Equal(t, "ref", migrationErr.Schema)
+	// SQLITE_ERROR
+	assert.Equal(t, "1", migrationErr.DBErrorCode)
+	assert.Equal(t, types.ErrorCodeMigrationFailed, migrationErr.Extensions()["code"])
+
+	// failed version is rolled back
+	versions, err := connector.GetVersions()
+	assert.Nil(t, err)
+	assert.Len(t, versions, 0)
+}
@@ -0,0 +1,326 @@
+package db
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// statement is a single SQL statement (or MS SQL batch) split from migration contents
+// line is the line of migration contents at which the statement starts
+type statement struct {
+	sql  string
+	line int
+}
+
+// splitterOptions defines dialect-specific SQL syntax understood by splitStatements
+type splitterOptions struct {
+	// delimiter separates statements, empty delimiter means that statements are not split
+	delimiter string
+	// batchSeparator placed on its own line separates batches, for example MS SQL GO, batches are executed as a whole
+	batchSeparator string
+	// delimiterDirective enables MySQL client DELIMITER directive which changes the delimiter
+	delimiterDirective bool
+	// dollarQuoting enables PostgreSQL dollar-quoted strings, for example $$ or $body$
+	dollarQuoting bool
+	// nestedComments enables PostgreSQL nested block comments
+	nestedComments bool
+	// hashComments enables MySQL # line comments
+	hashComments bool
+	// backslashEscapes enables MySQL backslash escapes in quoted strings
+	backslashEscapes bool
+	// escapeStrings enables PostgreSQL E'...' strings which contain backslash escapes
+	escapeStrings bool
+	// backtickQuotes enables `quoted` identifiers
+	backtickQuotes bool
+	// bracketQuotes enables [quoted] identifiers
+	bracketQuotes bool
+	// triggerBlocks enables SQLite CREATE TRIGGER ... BEGIN ... END blocks which contain delimiters
+	triggerBlocks bool
+	// atomicBlocks enables PostgreSQL BEGIN ATOMIC ... END function bodies which contain delimiters
+	atomicBlocks bool
+}
+
+var (
+	batchSeparatorRegexp     = regexp.MustCompile(`^(\S+)(?:\s+(\d+))?$`)
+	delimiterDirectiveRegexp = regexp.MustCompile(`(?i)^DELIMITER\s+(\S+)$`)
+	dollarTagRegexp          = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)?\$`)
+)
+
+// splitter holds the state of splitting migration contents into statements
+type splitter struct {
+	options    splitterOptions
+	contents   string
+	delimiter  string
+	statements []statement
+	// position and line of the current character
+	pos  int
+	line int
+	// start of the current statement and line of its first non-comment character
+	start      int
+	startLine  int
+	hasContent bool
+	// SQLite trigger and PostgreSQL BEGIN ATOMIC block state
+	words      []string
+	lastWord   string
+	trigger    bool
+	blockDepth int
+}
+
+// splitStatements splits migration contents into statements using passed dialect options
+// quoted strings and identifiers, comments and dialect-specific blocks are never split
+// statements which contain only comments are skipped
+func splitStatements(contents string, options splitterOptions) []statement {
+	s := &splitter{options: options, contents: contents, delimiter: options.delimiter, line: 1}
+
+	for s.pos < len(s.contents) {
+		if s.atLineStart() && s.directive() {
+			continue
+		}
+
+		c := s.contents[s.pos]
+		rest := s.contents[s.pos:]
+
+		switch {
+		case strings.HasPrefix(rest, "--") || (options.hashComments && c == '#'):
+			s.skipLineComment()
+		case strings.HasPrefix(rest, "/*!"):
+			// MySQL executable comment is a part of statement
+			s.markContent()
+			s.skipBlockComment()
+		case strings.HasPrefix(rest, "/*"):
+			s.skipBlockComment()
+		case c == '\'' || c == '"':
+			s.markContent()
+			s.skipQuoted(c, options.backslashEscapes || (c == '\'' && options.escapeStrings && s.escapeStringPrefix()))
+		case c == '`' && options.backtickQuotes:
+			s.markContent()
+			s.skipQuoted('`', false)
+		case c == '[' && options.bracketQuotes:
+			s.markContent()
+			s.skipQuoted(']', false)
+		case c == '$' && options.dollarQuoting && s.dollarTag() != "":
+			s.markContent()
+			s.skipDollarQuoted()
+		case s.delimiter != "" && s.blockDepth == 0 && strings.HasPrefix(rest, s.delimiter):
+			s.endStatement(s.pos, 1)
+			s.pos += len(s.delimiter)
+			s.start = s.pos
+		case isIdentifierChar(c) && (s.pos == 0 || !isIdentifierChar(s.contents[s.pos-1])):
+			s.markContent()
+			s.word()
+		default:
+			if !isSpace(c) {
+				s.markContent()
+			}
+			s.advance()
+		}
+	}
+
+	s.endStatement(len(s.contents), 1)
+
+	return s.statements
+}
+
+func (s *splitter) advance() {
+	if s.contents[s.pos] == '\n' {
+		s.line++
+	}
+	s.pos++
+}
+
+func (s *splitter) atLineStart() bool {
+	return s.pos == 0 || s.contents[s.pos-1] == '\n'
+}
+
+func (s *splitter) markContent() {
+	if !s.hasContent {
+		s.hasContent = true
+		s.startLine = s.line
+	}
+}
+
+// directive handles lines which are not SQL: batch separators and DELIMITER directives
+// returns true if the current line was consumed
+func (s *splitter) directive() bool {
+	if s.options.batchSeparator == "" && !s.options.delimiterDirective {
+		return false
+	}
+
+	end := strings.IndexByte(s.contents[s.pos:], '\n')
+	if end == -1 {
+		end = len(s.contents)
+	} else {
+		end += s.pos
+	}
+	line := strings.TrimSpace(s.contents[s.pos:end])
+
+	if s.options.batchSeparator != "" {
+		if matches := batchSeparatorRegexp.FindStringSubmatch(line); matches != nil && strings.EqualFold(matches[1], s.options.batchSeparator) {
+			// GO 3 executes the batch 3 times
+			count := 1
+			if matches[2] != "" {
+				count, _ = strconv.Atoi(matches[2])
+			}
+			s.endStatement(s.pos, count)
+			s.skipLine(end)
+			return true
+		}
+	}
+
+	if s.options.delimiterDirective {
+		if matches := delimiterDirectiveRegexp.FindStringSubmatch(line); matches != nil {
+			s.endStatement(s.pos, 1)
+			s.delimiter = matches[1]
+			s.skipLine(end)
+			return true
+		}
+	}
+
+	return false
+}
+
+// skipLine moves to the beginning of the next line and starts a new statement there
+func (s *splitter) skipLine(end int) {
+	for s.pos < end {
+		s.advance()
+	}
+	if s.pos < len(s.contents) {
+		s.advance()
+	}
+	s.start = s.pos
+}
+
+// endStatement adds statement which ends at passed position count times
+func (s *splitter) endStatement(end int, count int) {
+	if s.hasContent {
+		sql := strings.TrimSpace(s.contents[s.start:end])
+		for i := 0; i < count; i++ {
+			s.statements = append(s.statements, statement{sql: sql, line: s.startLine})
+		}
+	}
+	s.start = end
+	s.hasContent = false
+	s.words = nil
+	s.lastWord = ""
+	s.trigger = false
+	s.blockDepth = 0
+}
+
+func (s *splitter) skipLineComment() {
+	for s.pos < len(s.contents) && s.contents[s.pos] != '\n' {
+		s.advance()
+	}
+}
+
+func (s *splitter) skipBlockComment() {
+	depth := 0
+	for s.pos < len(s.contents) {
+		rest := s.contents[s.pos:]
+		if strings.HasPrefix(rest, "/*") && (depth == 0 || s.options.nestedComments) {
+			depth++
+			s.pos += 2
+			continue
+		}
+		if strings.HasPrefix(rest, "*/") {
+			depth--
+			s.pos += 2
+			if depth == 0 {
+				return
+			}
+			continue
+		}
+		s.advance()
+	}
+}
+
+// skipQuoted skips quoted string or identifier, doubled closing quote is an escaped quote
+func (s *splitter) skipQuoted(closing byte, backslashEscapes bool) {
+	s.advance()
+	for s.pos < len(s.contents) {
+		c := s.contents[s.pos]
+		if backslashEscapes && c == '\\' {
+			s.advance()
+			if s.pos < len(s.contents) {
+				s.advance()
+			}
+			continue
+		}
+		s.advance()
+		if c == closing {
+			return
+		}
+	}
+}
+
+// escapeStringPrefix returns true if the quote at the current position is preceded by a standalone E or e
+func (s *splitter) escapeStringPrefix() bool {
+	if s.pos == 0 || (s.contents[s.pos-1] != 'E' && s.contents[s.pos-1] != 'e') {
+		return false
+	}
+	return s.pos == 1 || !isIdentifierChar(s.contents[s.pos-2])
+}
+
+// dollarTag returns dollar quote tag at the current position or empty string
+func (s *splitter) dollarTag() string {
+	// $ is a valid identifier character in PostgreSQL, for example abc$1$
+	if s.pos > 0 && (isIdentifierChar(s.contents[s.pos-1]) || s.contents[s.pos-1] == '$') {
+		return ""
+	}
+	return dollarTagRegexp.FindString(s.contents[s.pos:])
+}
+
+func (s *splitter) skipDollarQuoted() {
+	tag := s.dollarTag()
+	for i := 0; i < len(tag); i++ {
+		s.advance()
+	}
+	for s.pos < len(s.contents) {
+		if strings.HasPrefix(s.contents[s.pos:], tag) {
+			s.pos += len(tag)
+			return
+		}
+		s.advance()
+	}
+}
+
+// word reads a keyword or an identifier, SQLite trigger blocks and PostgreSQL BEGIN ATOMIC blocks are tracked using keywords
+func (s *splitter) word() {
+	start := s.pos
+	for s.pos < len(s.contents) && isIdentifierChar(s.contents[s.pos]) {
+		s.advance()
+	}
+	if !s.options.triggerBlocks && !s.options.atomicBlocks {
+		return
+	}
+
+	word := strings.ToUpper(s.contents[start:s.pos])
+	lastWord := s.lastWord
+	s.lastWord = word
+	if len(s.words) < 3 {
+		s.words = append(s.words, word)
+		// CREATE TRIGGER or CREATE TEMP TRIGGER
+		if word == "TRIGGER" && s.words[0] == "CREATE" {
+			s.trigger = true
+		}
+	}
+
+	switch {
+	case word == "BEGIN" && s.options.triggerBlocks && s.trigger && s.blockDepth == 0:
+		s.blockDepth++
+	case word == "ATOMIC" && s.options.atomicBlocks && lastWord == "BEGIN" && s.blockDepth == 0:
+		s.blockDepth++
+	case word == "CASE" && s.blockDepth > 0:
+		s.blockDepth++
+	case word == "END" && s.blockDepth > 0:
+		s.blockDepth--
+	}
+}
+
+func isIdentifierChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
@@ -0,0 +1,201 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lukaszbudnik/migrator/config"
+	"github.com/lukaszbudnik/migrator/types"
+)
+
+func splitSQL(contents string, options splitterOptions) []string {
+	sqls := []string{}
+	for _, s := range splitStatements(contents, options) {
+		sqls = append(sqls, s.sql)
+	}
+	return sqls
+}
+
+func TestSplitStatementsPostgreSQL(t *testing.T) {
+	options := (&postgreSQLDialect{}).GetSplitterOptions()
+
+	contents := `-- create table
+create table abc (id int, name varchar(100) default 'a;b');
+insert into abc values (1, 'it''s; here');
+/* block comment; /* nested; */ still comment; */
+create function abc_fn() returns trigger as $body$
+begin
+  new.name := 'x;y';
+  return new;
+end;
+$body$ language plpgsql;
+do $$ begin perform 1; end $$;
+select "weird;column" from abc
+`
+	statements := splitStatements(contents, options)
+	assert.Len(t, statements, 5)
+	assert.Equal(t, "-- create table\ncreate table abc (id int, name varchar(100) default 'a;b')", statements[0].sql)
+	assert.Equal(t, 2, statements[0].line)
+	assert.Equal(t, "insert into abc values (1, 'it''s; here')", statements[1].sql)
+	assert.Equal(t, 3, statements[1].line)
+	assert.Contains(t, statements[2].sql, "$body$ language plpgsql")
+	assert.Equal(t, 5, statements[2].line)
+	assert.Equal(t, "do $$ begin perform 1; end $$", statements[3].sql)
+	assert.Equal(t, 11, statements[3].line)
+	assert.Equal(t, `select "weird;column" from abc`, statements[4].sql)
+	assert.Equal(t, 12, statements[4].line)
+}
+
+func TestSplitStatementsPostgreSQLPositionalParameters(t *testing.T) {
+	options := (&postgreSQLDialect{}).GetSplitterOptions()
+
+	// $1 is not a dollar quote tag
+	assert.Equal(t, []string{"prepare abc as select $1, $2", "execute abc(1, 2)"}, splitSQL("prepare abc as select $1, $2; execute abc(1, 2);", options))
+}
+
+func TestSplitStatementsPostgreSQLEscapeStrings(t *testing.T) {
+	options := (&postgreSQLDialect{}).GetSplitterOptions()
+
+	// backslash escapes only E'...' strings, in standard strings backslash is a regular character
+	contents := `insert into abc values (E'it\'s; here', e'\\');
+insert into abc values ('c:\');
+select name from abc where name = 'e';`
+
+	assert.Equal(t, []string{
+		`insert into abc values (E'it\'s; here', e'\\')`,
+		`insert into abc values ('c:\')`,
+		`select name from abc where name = 'e'`,
+	}, splitSQL(contents, options))
+}
+
+func TestSplitStatementsPostgreSQLBeginAtomic(t *testing.T) {
+	options := (&postgreSQLDialect{}).GetSplitterOptions()
+
+	contents := `create function abc_size(id int) returns text
+language sql
+begin atomic
+  select case when id > 1 then 'big' else 'small' end;
+end;
+begin;
+commit;`
+
+	assert.Equal(t, []string{
+		"create function abc_size(id int) returns text\nlanguage sql\nbegin atomic\n  select case when id > 1 then 'big' else 'small' end;\nend",
+		"begin",
+		"commit",
+	}, splitSQL(contents, options))
+}
+
+func TestSplitStatementsMySQL(t *testing.T) {
+	options := (&mySQLDialect{}).GetSplitterOptions()
+
+	contents := "# hash comment; not a statement\n" +
+		"insert into abc values ('it\\'s; here', `col;umn`);\n" +
+		"/*!40101 SET NAMES utf8 */;\n" +
+		"DELIMITER //\n" +
+		"create procedure abc_proc()\n" +
+		"begin\n" +
+		"  select 1;\n" +
+		"  select 2;\n" +
+		"end //\n" +
+		"delimiter ;\n" +
+		"select 3;"
+
+	statements := splitStatements(contents, options)
+	assert.Len(t, statements, 4)
+	assert.Equal(t, "# hash comment; not a statement\ninsert into abc values ('it\\'s; here', `col;umn`)", statements[0].sql)
+	assert.Equal(t, 2, statements[0].line)
+	assert.Equal(t, "/*!40101 SET NAMES utf8 */", statements[1].sql)
+	assert.Equal(t, "create procedure abc_proc()\nbegin\n  select 1;\n  select 2;\nend", statements[2].sql)
+	assert.Equal(t, 5, statements[2].line)
+	assert.Equal(t, "select 3", statements[3].sql)
+	assert.Equal(t, 11, statements[3].line)
+}
+
+func TestSplitStatementsMSSQL(t *testing.T) {
+	options := (&msSQLDialect{}).GetSplitterOptions()
+
+	contents := `create table [abc;def] (id int);
+insert into [abc;def] values (1);
+GO
+-- GO in comment and in 'GO' string is not a separator
+create procedure abc_proc as
+begin
+  select 'GO';
+end
+go
+print 'repeated'
+GO 2
+`
+	statements := splitStatements(contents, options)
+	assert.Len(t, statements, 4)
+	assert.Equal(t, "create table [abc;def] (id int);\ninsert into [abc;def] values (1);", statements[0].sql)
+	assert.Equal(t, 1, statements[0].line)
+	assert.Equal(t, "-- GO in comment and in 'GO' string is not a separator\ncreate procedure abc_proc as\nbegin\n  select 'GO';\nend", statements[1].sql)
+	assert.Equal(t, 5, statements[1].line)
+	assert.Equal(t, "print 'repeated'", statements[2].sql)
+	assert.Equal(t, statements[2], statements[3])
+	assert.Equal(t, 10, statements[3].line)
+}
+
+func TestSplitStatementsSQLite(t *testing.T) {
+	options := (&sqliteDialect{}).GetSplitterOptions()
+
+	contents := `create table abc (id int, status text);
+create trigger abc_trigger after insert on abc
+begin
+  update abc set status = case when new.id > 1 then 'big' else 'small' end where id = new.id;
+  insert into audit values (new.id);
+end;
+begin transaction;
+commit;`
+
+	assert.Equal(t, []string{
+		"create table abc (id int, status text)",
+		"create trigger abc_trigger after insert on abc\nbegin\n  update abc set status = case when new.id > 1 then 'big' else 'small' end where id = new.id;\n  insert into audit values (new.id);\nend",
+		"begin transaction",
+		"commit",
+	}, splitSQL(contents, options))
+}
+
+func TestSplitStatementsSkipsEmptyStatements(t *testing.T) {
+	options := (&postgreSQLDialect{}).GetSplitterOptions()
+
+	assert.Empty(t, splitStatements("", options))
+	assert.Empty(t, splitStatements("-- only comment\n/* and block comment */\n;;\n", options))
+	assert.Equal(t, []string{"select 1"}, splitSQL(";select 1;;\n-- trailing comment", options))
+}
+
+func TestExecMigrationStatementError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Nil(t, err)
+
+	config := &config.Config{}
+	config.Driver = "postgres"
+	dialect := newDialect(config)
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("create table abc").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("insert into abc").WillReturnError(errors.New("trouble maker"))
+	mock.ExpectRollback()
+
+	err = connector.inTx(types.ActionApply, false, func(tx *sql.Tx) error {
+		return connector.execMigration(tx, "public/201602220000.sql", "public", "create table abc (id int);\n\ninsert into abc values (1);\ninsert into abc values (2);")
+	})
+
+	var migrationErr *types.MigrationError
+	assert.True(t, errors.As(err, &migrationErr))
+	assert.Equal(t, 2, migrationErr.Statement)
+	assert.Equal(t, 3, migrationErr.Line)
+	assert.Equal(t, "SQL migration public/201602220000.sql failed for schema public at statement 2 (line 3) with error: trouble maker", err.Error())
+	assert.Equal(t, 2, migrationErr.Extensions()["statement"])
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,171 @@
+package db
+
+import (
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/lukaszbudnik/migrator/config"
+	"github.com/lukaszbudnik/migrator/types"
+)
+
+// templateData is passed to migration templates when template rendering is enabled, for example:
+//
+//	create table {{ quoteIdent .Schema }}.events (id int) tablespace {{ .Vars.tablespace }};
+//
+// Tenant is set only for tenant migrations and scripts, its metadata can be used for tenant-specific DDL, for example:
+//
+//	{{ if eq .Tenant.Metadata.tier "premium" }}create index ...;{{ end }}
+//
+// Env contains only environment variables allowed by templateEnv config property
+type templateData struct {
+	Schema string
+	Tenant *types.Tenant
+	Vars   map[string]string
+	Env    map[string]string
+}
+
+// templateEnv returns environment variables allowed by templateEnv config property
+// entries are variable names, entry ending with * allows all variables starting with the rest of the entry, for example: MIGRATOR_*
+func templateEnv(config *config.Config) map[string]string {
+	env := map[string]string{}
+	for _, e := range os.Environ() {
+		pair := strings.SplitN(e, "=", 2)
+		if len(pair) != 2 {
+			continue
+		}
+		for _, allowed := range config.TemplateEnv {
+			if pair[0] == allowed || (strings.HasSuffix(allowed, "*") && strings.HasPrefix(pair[0], strings.TrimSuffix(allowed, "*"))) {
+				env[pair[0]] = pair[1]
+				break
+			}
+		}
+	}
+	return env
+}
+
+// quoteLiteral returns passed value as SQL string literal
+func quoteLiteral(value string) string {
+	return "'" + strings.Replace(value, "'", "''", -1) + "'"
+}
+
+// templateFuncs returns helper functions available in migration templates
+func (bc *baseConnector) templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"quoteIdent":   bc.dialect.QuoteIdentifier,
+		"quoteLiteral": quoteLiteral,
+		"lower":        strings.ToLower,
+		"upper":        strings.ToUpper,
+	}
+}
+
+// getTemplateTenant returns tenant passed to template of migration applied to i-th of its schemas
+// tenant migrations and scripts are applied to passed tenants in order, tenants must be filtered using getMigrationTenants, see getMigrationSchemas
+func getTemplateTenant(m types.Migration, tenants []types.Tenant, i int) *types.Tenant {
+	if m.MigrationType == types.MigrationTypeTenantMigration || m.MigrationType == types.MigrationTypeTenantScript {
+		return &tenants[i]
+	}
+	return nil
+}
+
+// templateTenant returns a copy of passed tenant with metadata columns which are not set defaulting to empty strings
+// so that templates can reference every column listed in tenantMetadataColumns
+func (bc *baseConnector) templateTenant(tenant *types.Tenant) *types.Tenant {
+	if tenant == nil {
+		return nil
+	}
+	metadata := types.TenantMetadata{}
+	for _, column := range bc.config.TenantMetadataColumns {
+		metadata[column] = ""
+	}
+	for key, value := range tenant.Metadata {
+		metadata[key] = value
+	}
+	return &types.Tenant{Name: tenant.Name, Metadata: metadata}
+}
+
+// renderTemplate renders passed contents using text/template, referencing a missing variable is an error
+func (bc *baseConnector) renderTemplate(file, schema string, tenant *types.Tenant, contents string) (string, error) {
+	t, err := template.New(file).Funcs(bc.templateFuncs()).Option("missingkey=error").Parse(contents)
+	if err != nil {
+		return "", &types.TemplateError{File: file, Schema: schema, Err: err}
+	}
+	data := templateData{Schema: schema, Tenant: bc.templateTenant(tenant), Vars: bc.config.TemplateVariables, Env: bc.templateEnv}
+	if data.Vars == nil {
+		data.Vars = map[string]string{}
+	}
+	if data.Env == nil {
+		data.Env = map[string]string{}
+	}
+	var sb strings.Builder
+	if err := t.Execute(&sb, data); err != nil {
+		return "", &types.TemplateError{File: file, Schema: schema, Err: err}
+	}
+	return sb.String(), nil
+}
+
+// renderContents returns SQL to be executed for passed schema, when template rendering is enabled contents are rendered first
+// schema placeholder is replaced in both cases
+func (bc *baseConnector) renderContents(file, schema string, tenant *types.Tenant, contents string) (string, error) {
+	if bc.config.TemplateRendering {
+		var err error
+		if contents, err = bc.renderTemplate(file, schema, tenant, contents); err != nil {
+			return "", err
+		}
+	}
+	return strings.Replace(contents, bc.getSchemaPlaceHolder(), schema, -1), nil
+}
+
+// renderedContents returns SQL recorded together with raw contents of applied migration
+// rendered SQL is recorded only when template rendering is enabled, otherwise it is null
+func (bc *baseConnector) renderedContents(contents string) interface{} {
+	if bc.config.TemplateRendering {
+		return contents
+	}
+	return nil
+}
+
+// renderMigrations renders passed migrations for all their schemas so that template errors are returned before any SQL is executed
+func (bc *baseConnector) renderMigrations(migrations []types.Migration, tenants []types.Tenant) error {
+	if !bc.config.TemplateRendering {
+		return nil
+	}
+	for _, m := range migrations {
+		migrationTenants := getMigrationTenants(m, tenants)
+		for i, s := range bc.getMigrationSchemas(m, migrationTenants) {
+			if _, err := bc.renderMigration(m, getTemplateTenant(m, migrationTenants, i), s); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// renderDownMigrations renders down contents of passed DB migrations so that template errors are returned before any SQL is executed
+func (bc *baseConnector) renderDownMigrations(dbMigrations []types.DBMigration, tenants []types.Tenant) error {
+	if !bc.config.TemplateRendering {
+		return nil
+	}
+	for _, dbm := range dbMigrations {
+		if _, err := bc.renderDownMigration(dbm, tenants); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderDownMigration returns SQL of passed DB migration's down migration, tenant of tenant migrations is found by schema
+// tenant which no longer exists is passed to template without metadata
+func (bc *baseConnector) renderDownMigration(dbm types.DBMigration, tenants []types.Tenant) (string, error) {
+	var tenant *types.Tenant
+	if dbm.MigrationType == types.MigrationTypeTenantMigration {
+		tenant = &types.Tenant{Name: dbm.Schema}
+		for i := range tenants {
+			if tenants[i].Name == dbm.Schema {
+				tenant = &tenants[i]
+				break
+			}
+		}
+	}
+	return bc.renderContents(types.DownMigrationFile(dbm.File), dbm.Schema, tenant, dbm.DownContents)
+}
@@ -0,0 +1,268 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/lukaszbudnik/migrator/config"
+	"github.com/lukaszbudnik/migrator/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanTemplateRendering(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Nil(t, err)
+
+	t.Setenv("MIGRATOR_RETENTION_DAYS", "30")
+
+	config := &config.Config{}
+	config.Driver = "postgres"
+	config.TemplateRendering = true
+	config.TemplateVariables = map[string]string{"tablespace": "fast_ssd", "role": "app's reader"}
+	config.TemplateEnv = []string{"MIGRATOR_*"}
+	dialect := newDialect(config)
+	connector := baseConnector{newTestContext(), config, dialect, db, true, templateEnv(config)}
+
+	s := types.Migration{Name: "201602220000.sql", SourceDir: "source", File: "source/201602220000.sql", MigrationType: types.MigrationTypeSingleMigration, Contents: "create table {{ quoteIdent .Schema }}.abc (id int) tablespace {{ .Vars.tablespace }}{{ if .Tenant }} -- tenant{{ end }}"}
+	m := types.Migration{Name: "201602220001.sql", SourceDir: "tenants", File: "tenants/201602220001.sql", MigrationType: types.MigrationTypeTenantMigration, Contents: "comment on schema {schema} is {{ quoteLiteral (printf \"%v %v\" .Tenant.Name .Vars.role) }}; -- {{ upper .Env.MIGRATOR_RETENTION_DAYS }} days"}
+
+	tenants := sqlmock.NewRows([]string{"name"}).AddRow("abc").AddRow("def")
+	mock.ExpectQuery("select").WillReturnRows(tenants)
+
+	plan, err := connector.Plan([]types.Migration{s, m})
+	assert.Nil(t, err)
+	assert.Equal(t, []types.PlannedStatement{{Schema: "source", SQL: `create table "source".abc (id int) tablespace fast_ssd`}}, plan.Migrations[0].Statements)
+	assert.Equal(t, []types.PlannedStatement{{Schema: "abc", SQL: "comment on schema abc is 'abc app''s reader'; -- 30 days"}, {Schema: "def", SQL: "comment on schema def is 'def app''s reader'; -- 30 days"}}, plan.Migrations[1].Statements)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestTemplateEnv(t *testing.T) {
+	t.Setenv("MIGRATOR_RETENTION_DAYS", "30")
+	t.Setenv("MIGRATOR_REGION", "eu")
+	t.Setenv("RETENTION_DAYS", "7")
+	t.Setenv("DB_PASSWORD", "secret")
+
+	// only allowed variables are exposed
+	config := &config.Config{Driver: "postgres", TemplateEnv: []string{"RETENTION_DAYS", "MIGRATOR_*"}}
+	env := templateEnv(config)
+	assert.Equal(t, "30", env["MIGRATOR_RETENTION_DAYS"])
+	assert.Equal(t, "eu", env["MIGRATOR_REGION"])
+	assert.Equal(t, "7", env["RETENTION_DAYS"])
+	assert.NotContains(t, env, "DB_PASSWORD")
+
+	// by default no variables are exposed
+	config.TemplateEnv = nil
+	assert.Empty(t, templateEnv(config))
+
+	config.TemplateRendering = true
+	connector := baseConnector{newTestContext(), config, newDialect(config), nil, false, env}
+	_, err := connector.renderTemplate("source/201602220000.sql", "source", nil, "select {{ .Env.DB_PASSWORD }}")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), `map has no entry for key "DB_PASSWORD"`)
+}
+
+func TestTemplateTenantMetadata(t *testing.T) {
+	config := &config.Config{Driver: "postgres", TemplateRendering: true, TenantMetadataColumns: []string{"region", "tier"}}
+	connector := baseConnector{newTestContext(), config, newDialect(config), nil, false, nil}
+
+	// metadata columns which are not set default to empty strings
+	tenant := &types.Tenant{Name: "abc", Metadata: types.TenantMetadata{"region": "eu-west-1"}}
+	contents, err := connector.renderTemplate("tenants/201602220000.sql", "abc", tenant, "{{ .Tenant.Metadata.region }}:{{ .Tenant.Metadata.tier }}")
+	assert.Nil(t, err)
+	assert.Equal(t, "eu-west-1:", contents)
+	// passed tenant is not modified
+	assert.Equal(t, types.TenantMetadata{"region": "eu-west-1"}, tenant.Metadata)
+
+	// metadata which is not configured is still an error
+	_, err = connector.renderTemplate("tenants/201602220000.sql", "abc", tenant, "{{ .Tenant.Metadata.plan }}")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), `map has no entry for key "plan"`)
+}
+
+func TestPlanTemplateRenderingDisabled(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Nil(t, err)
+
+	config := &config.Config{}
+	config.Driver = "postgres"
+	dialect := newDialect(config)
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
+
+	m := types.Migration{Name: "201602220000.sql", SourceDir: "source", File: "source/201602220000.sql", MigrationType: types.MigrationTypeSingleMigration, Contents: "select '{{ .Schema }}'"}
+
+	mock.ExpectQuery("select").WillReturnRows(sqlmock.NewRows([]string{"name"}))
+
+	plan, err := connector.Plan([]types.Migration{m})
+	assert.Nil(t, err)
+	assert.Equal(t, "select '{{ .Schema }}'", plan.Migrations[0].Statements[0].SQL)
+}
+
+func TestCreateVersionTemplateError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Nil(t, err)
+
+	config := &config.Config{}
+	config.Driver = "postgres"
+	config.TemplateRendering = true
+	dialect := newDialect(config)
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
+
+	s := types.Migration{Name: "201602220000.sql", SourceDir: "source", File: "source/201602220000.sql", MigrationType: types.MigrationTypeSingleMigration, Contents: "create table abc (id int)"}
+	m := types.Migration{Name: "201602220001.sql", SourceDir: "tenants", File: "tenants/201602220001.sql", MigrationType: types.MigrationTypeTenantMigration, Contents: "create table def (id int) tablespace {{ .Vars.tablespace }}"}
+
+	// template errors are returned before transaction is started
+	mock.ExpectQuery("pg_advisory_lock").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
+	mock.ExpectQuery("select").WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("abc"))
+	mock.ExpectExec("pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	_, _, err = connector.CreateVersion("commit-sha", types.ActionApply, []types.Migration{s, m}, false)
+	assert.IsType(t, &types.TemplateError{}, err)
+	assert.Contains(t, err.Error(), "rendering template of migration tenants/201602220001.sql failed for schema abc with error:")
+	assert.Contains(t, err.Error(), `map has no entry for key "tablespace"`)
+	assert.Equal(t, types.ErrorCodeTemplateInvalid, err.(*types.TemplateError).Extensions()["code"])
+
+	// syntax errors are reported too
+	m.Contents = "create table def (id int) tablespace {{ .Vars.tablespace"
+	mock.ExpectQuery("pg_advisory_lock").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
+	mock.ExpectExec("pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+	_, _, err = connector.CreateTenant(types.Tenant{Name: "abc"}, "commit-sha", types.ActionApply, []types.Migration{m}, false)
+	assert.IsType(t, &types.TemplateError{}, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCreateVersionTemplateRenderingRecordsRenderedContents(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Nil(t, err)
+
+	config := &config.Config{}
+	config.Driver = "postgres"
+	config.TemplateRendering = true
+	config.TemplateVariables = map[string]string{"tablespace": "fast_ssd"}
+	dialect := newDialect(config)
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
+
+	tn := time.Now().UnixNano()
+	m := types.Migration{Name: fmt.Sprintf("%v.sql", tn), SourceDir: "tenants", File: fmt.Sprintf("tenants/%v.sql", tn), MigrationType: types.MigrationTypeTenantMigration, Contents: "create table {schema}.settings (k int) tablespace {{ .Vars.tablespace }}"}
+	rendered := "create table tenantname.settings (k int) tablespace fast_ssd"
+
+	tenant := "tenantname"
+	mock.ExpectQuery("pg_advisory_lock").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
+	mock.ExpectQuery("select").WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow(tenant))
+	mock.ExpectBegin()
+	// version
+	mock.ExpectPrepare("insert into migrator.migrator_versions")
+	mock.ExpectPrepare("insert into migrator.migrator_versions").ExpectQuery().WithArgs("commit-sha").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(0))
+	// migration, rendered SQL is executed and recorded together with raw contents
+	mock.ExpectPrepare("insert into migrator.migrator_migrations")
+	mock.ExpectExec("create table tenantname.settings \\(k int\\) tablespace fast_ssd").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectPrepare("insert into migrator.migrator_migrations").ExpectExec().WithArgs(m.Name, m.SourceDir, m.File, m.MigrationType, tenant, m.Contents, m.CheckSum, 0, m.DownContents, rendered, m.SourceCommit).WillReturnResult(sqlmock.NewResult(0, 0))
+	// get version
+	rows := sqlmock.NewRows([]string{"vid", "vname", "vcreated", "mid", "name", "source_dir", "filename", "type", "db_schema", "created", "contents", "checksum", "source_commit"}).AddRow("123", "vname", time.Now(), "456", m.Name, m.SourceDir, m.File, m.MigrationType, tenant, time.Now(), m.Contents, m.CheckSum, nil)
+	mock.ExpectQuery("select").WillReturnRows(rows)
+	mock.ExpectCommit()
+	mock.ExpectExec("pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	_, version, err := connector.CreateVersion("commit-sha", types.ActionApply, []types.Migration{m}, false)
+	assert.Nil(t, err)
+	assert.NotNil(t, version)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestQuoteIdentifier(t *testing.T) {
+	for driver, expected := range map[string]string{
+		"postgres":  `"my""table"`,
+		"sqlite":    `"my""table"`,
+		"mysql":     "`my\"table`",
+		"sqlserver": `[my"table]`,
+	} {
+		dialect := newDialect(&config.Config{Driver: driver})
+		assert.Equal(t, expected, dialect.QuoteIdentifier(`my"table`), driver)
+	}
+	assert.Equal(t, "`a``b`", newDialect(&config.Config{Driver: "mysql"}).QuoteIdentifier("a`b"))
+	assert.Equal(t, "[a]]b]", newDialect(&config.Config{Driver: "sqlserver"}).QuoteIdentifier("a]b"))
+}
+
+// rendered SQL is recorded next to raw contents, down migrations are rendered when version is rolled back
+func TestSQLiteTemplateRendering(t *testing.T) {
+	config := newSQLiteTestConfig(t)
+	config.TemplateRendering = true
+	config.TemplateVariables = map[string]string{"suffix": "settings"}
+	connector := New(newTestContext(), config)
+	defer connector.Dispose()
+
+	tn := time.Now().UnixNano()
+	m := types.Migration{Name: fmt.Sprintf("%v.sql", tn), SourceDir: "tenants", File: fmt.Sprintf("tenants/%v.sql", tn), MigrationType: types.MigrationTypeTenantMigration, Contents: "create table {{ quoteIdent (printf \"%v_%v\" .Tenant.Name .Vars.suffix) }} (k integer)", DownContents: "drop table {{ .Tenant.Name }}_{{ .Vars.suffix }}"}
+
+	_, version, err := connector.CreateTenant(types.Tenant{Name: "abc"}, "create abc", types.ActionApply, []types.Migration{m}, false)
+	assert.Nil(t, err)
+
+	bc := connector.(*baseConnector)
+	var contents, rendered string
+	err = bc.db.QueryRow("select contents, rendered_contents from migrator_migrations where version_id = ?", version.ID).Scan(&contents, &rendered)
+	assert.Nil(t, err)
+	assert.Equal(t, m.Contents, contents)
+	assert.Equal(t, `create table "abc_settings" (k integer)`, rendered)
+
+	_, rollbackVersion, err := connector.RollbackVersion(version.ID, "rollback abc", false)
+	assert.Nil(t, err)
+	err = bc.db.QueryRow("select rendered_contents from migrator_migrations where version_id = ?", rollbackVersion.ID).Scan(&rendered)
+	assert.Nil(t, err)
+	assert.Equal(t, "drop table abc_settings", rendered)
+}
+
+func TestSQLiteTenantMetadata(t *testing.T) {
+	config := newSQLiteTestConfig(t)
+	config.TemplateRendering = true
+	config.TenantMetadataColumns = []string{"region", "tier"}
+	connector := New(newTestContext(), config)
+	defer connector.Dispose()
+
+	tn := time.Now().UnixNano()
+	m := types.Migration{Name: fmt.Sprintf("%v.sql", tn), SourceDir: "tenants", File: fmt.Sprintf("tenants/%v.sql", tn), MigrationType: types.MigrationTypeTenantMigration, Contents: "create table {{ .Tenant.Name }}_settings (k integer){{ if eq .Tenant.Metadata.tier \"premium\" }}; create table {{ .Tenant.Name }}_audit (k integer){{ end }}"}
+
+	_, _, err := connector.CreateTenant(types.Tenant{Name: "abc", Metadata: types.TenantMetadata{"region": "eu-west-1", "tier": "premium"}}, "create abc", types.ActionApply, []types.Migration{m}, false)
+	assert.Nil(t, err)
+	_, _, err = connector.CreateTenant(types.Tenant{Name: "def", Metadata: types.TenantMetadata{"tier": "standard"}}, "create def", types.ActionApply, []types.Migration{m}, false)
+	assert.Nil(t, err)
+
+	tenants, err := connector.GetTenants()
+	assert.Nil(t, err)
+	assert.Equal(t, []types.Tenant{{Name: "abc", Metadata: types.TenantMetadata{"region": "eu-west-1", "tier": "premium"}}, {Name: "def", Metadata: types.TenantMetadata{"region": "", "tier": "standard"}}}, tenants)
+
+	bc := connector.(*baseConnector)
+	var count int
+	assert.Nil(t, bc.db.QueryRow("select count(*) from sqlite_master where type = 'table' and name like '%_audit'").Scan(&count))
+	assert.Equal(t, 1, count)
+}
+
+func TestSQLiteAddRenderedContentsColumn(t *testing.T) {
+	config := newSQLiteTestConfig(t)
+	connector := New(newTestContext(), config)
+	defer connector.Dispose()
+
+	assert.Nil(t, connector.HealthCheck())
+
+	// migrations table created by previous migrator versions has no rendered_contents column
+	bc := connector.(*baseConnector)
+	_, err := bc.db.Exec("alter table migrator_migrations drop column rendered_contents")
+	assert.Nil(t, err)
+
+	assert.Nil(t, bc.dialect.EnsureColumn(bc.db, migratorMigrationsTable, "rendered_contents", "text"))
+	// column already exists
+	assert.Nil(t, bc.dialect.EnsureColumn(bc.db, migratorMigrationsTable, "rendered_contents", "text"))
+
+	var count int
+	assert.Nil(t, bc.db.QueryRow("select count(*) from pragma_table_info('migrator_migrations') where name = 'rendered_contents'").Scan(&count))
+	assert.Equal(t, 1, count)
+}
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/graph-gophers/graphql-go"
 	"github.com/lukaszbudnik/migrator/common"
 	"github.com/lukaszbudnik/migrator/config"
 	"github.com/lukaszbudnik/migrator/types"
@@ -30,28 +31,20 @@ func TestDBCreateConnectorPanicUnknownDriver(t *testing.T) {
 	})
 }
 
-func TestConnectorInitPanicConnectionError(t *testing.T) {
+func TestConnectorInitConnectionError(t *testing.T) {
 	config, err := config.FromFile("../test/migrator-postgresql.yaml")
 	assert.Nil(t, err)
 
 	config.DataSource = strings.Replace(config.DataSource, "127.0.0.1", "1.0.0.1", -1)
 
-	didPanic := false
-	var message interface{}
-	func() {
+	db := New(newTestContext(), config)
+	_, err = db.GetTenants()
 
-		defer func() {
-			if message = recover(); message != nil {
-				didPanic = true
-			}
-		}()
-
-		db := New(newTestContext(), config)
-		db.GetTenants()
-
-	}()
-	assert.True(t, didPanic)
-	assert.Contains(t, message, "Error initialising migrator: failed to connect to database")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "failed to connect to database")
+	var unreachableErr *types.DBUnreachableError
+	assert.True(t, errors.As(err, &unreachableErr))
+	assert.Equal(t, types.ErrorCodeDBUnreachable, unreachableErr.Extensions()["code"])
 }
 
 func TestCreateVersionDryRunMode(t *testing.T) {
@@ -61,7 +54,7 @@ func TestCreateVersionDryRunMode(t *testing.T) {
 	config := &config.Config{}
 	config.Driver = "postgres"
 	dialect := newDialect(config)
-	connector := baseConnector{newTestContext(), config, dialect, db, true}
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
 
 	tn := time.Now().UnixNano()
 	m := types.Migration{Name: fmt.Sprintf("%v.sql", tn), SourceDir: "tenants", File: fmt.Sprintf("tenants/%v.sql", tn), MigrationType: types.MigrationTypeTenantMigration, Contents: "insert into {schema}.settings values (456, '456') "}
@@ -69,23 +62,26 @@ func TestCreateVersionDryRunMode(t *testing.T) {
 
 	tenant := "tenantname"
 	tenants := sqlmock.NewRows([]string{"name"}).AddRow(tenant)
+	mock.ExpectQuery("pg_advisory_lock").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
 	mock.ExpectQuery("select").WillReturnRows(tenants)
 	mock.ExpectBegin()
 	// version
 	mock.ExpectPrepare("insert into migrator.migrator_versions")
-	mock.ExpectPrepare("insert into migrator.migrator_versions").ExpectQuery().WithArgs("commit-sha")
+	mock.ExpectPrepare("insert into migrator.migrator_versions").ExpectQuery().WithArgs("commit-sha").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(0))
 	// migration
 	mock.ExpectPrepare("insert into migrator.migrator_migrations")
 	mock.ExpectExec("insert into").WillReturnResult(sqlmock.NewResult(0, 0))
-	mock.ExpectPrepare("insert into migrator.migrator_migrations").ExpectExec().WithArgs(m.Name, m.SourceDir, m.File, m.MigrationType, tenant, m.Contents, m.CheckSum, 0).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectPrepare("insert into migrator.migrator_migrations").ExpectExec().WithArgs(m.Name, m.SourceDir, m.File, m.MigrationType, tenant, m.Contents, m.CheckSum, 0, m.DownContents, nil, m.SourceCommit).WillReturnResult(sqlmock.NewResult(0, 0))
 	// get version
-	rows := sqlmock.NewRows([]string{"vid", "vname", "vcreated", "mid", "name", "source_dir", "filename", "type", "db_schema", "created", "contents", "checksum"}).AddRow("123", "vname", time.Now(), "456", m.Name, m.SourceDir, m.File, m.MigrationType, tenant, time.Now(), m.Contents, m.CheckSum)
+	rows := sqlmock.NewRows([]string{"vid", "vname", "vcreated", "mid", "name", "source_dir", "filename", "type", "db_schema", "created", "contents", "checksum", "source_commit"}).AddRow("123", "vname", time.Now(), "456", m.Name, m.SourceDir, m.File, m.MigrationType, tenant, time.Now(), m.Contents, m.CheckSum, nil)
 	mock.ExpectQuery("select").WillReturnRows(rows)
 	// dry-run mode calls rollback instead of commit
 	mock.ExpectRollback()
+	mock.ExpectExec("pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
 
 	// however the results contain correct dry-run data like number of applied migrations/scripts
-	results, version := connector.CreateVersion("commit-sha", types.ActionApply, migrationsToApply, true)
+	results, version, err := connector.CreateVersion("commit-sha", types.ActionApply, migrationsToApply, true)
+	assert.Nil(t, err)
 	assert.NotNil(t, version)
 	assert.True(t, version.ID > 0)
 	assert.Equal(t, results.MigrationsGrandTotal+results.ScriptsGrandTotal, int32(len(version.DBMigrations)))
@@ -103,7 +99,7 @@ func TestCreateVersionSyncMode(t *testing.T) {
 	config := &config.Config{}
 	config.Driver = "postgres"
 	dialect := newDialect(config)
-	connector := baseConnector{newTestContext(), config, dialect, db, true}
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
 
 	tn := time.Now().UnixNano()
 	m := types.Migration{Name: fmt.Sprintf("%v.sql", tn), SourceDir: "tenants", File: fmt.Sprintf("tenants/%v.sql", tn), MigrationType: types.MigrationTypeTenantMigration, Contents: "insert into {schema}.settings values (456, '456') "}
@@ -111,21 +107,24 @@ func TestCreateVersionSyncMode(t *testing.T) {
 
 	tenant := "tenantname"
 	tenants := sqlmock.NewRows([]string{"name"}).AddRow(tenant)
+	mock.ExpectQuery("pg_advisory_lock").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
 	mock.ExpectQuery("select").WillReturnRows(tenants)
 	mock.ExpectBegin()
 	// version
 	mock.ExpectPrepare("insert into migrator.migrator_versions")
-	mock.ExpectPrepare("insert into migrator.migrator_versions").ExpectQuery().WithArgs("commit-sha")
+	mock.ExpectPrepare("insert into migrator.migrator_versions").ExpectQuery().WithArgs("commit-sha").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(0))
 	// migration
 	mock.ExpectPrepare("insert into migrator.migrator_migrations")
-	mock.ExpectPrepare("insert into").ExpectExec().WithArgs(m.Name, m.SourceDir, m.File, m.MigrationType, tenant, m.Contents, m.CheckSum, 0).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectPrepare("insert into").ExpectExec().WithArgs(m.Name, m.SourceDir, m.File, m.MigrationType, tenant, m.Contents, m.CheckSum, 0, m.DownContents, nil, m.SourceCommit).WillReturnResult(sqlmock.NewResult(0, 0))
 	// get version
-	rows := sqlmock.NewRows([]string{"vid", "vname", "vcreated", "mid", "name", "source_dir", "filename", "type", "db_schema", "created", "contents", "checksum"}).AddRow("123", "vname", time.Now(), "456", m.Name, m.SourceDir, m.File, m.MigrationType, tenant, time.Now(), m.Contents, m.CheckSum)
+	rows := sqlmock.NewRows([]string{"vid", "vname", "vcreated", "mid", "name", "source_dir", "filename", "type", "db_schema", "created", "contents", "checksum", "source_commit"}).AddRow("123", "vname", time.Now(), "456", m.Name, m.SourceDir, m.File, m.MigrationType, tenant, time.Now(), m.Contents, m.CheckSum, nil)
 	mock.ExpectQuery("select").WillReturnRows(rows)
 	mock.ExpectCommit()
+	mock.ExpectExec("pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
 
 	// sync the results contain correct data like number of applied migrations/scripts
-	results, version := connector.CreateVersion("commit-sha", types.ActionSync, migrationsToApply, false)
+	results, version, err := connector.CreateVersion("commit-sha", types.ActionSync, migrationsToApply, false)
+	assert.Nil(t, err)
 	assert.NotNil(t, version)
 	assert.True(t, version.ID > 0)
 	assert.Equal(t, results.MigrationsGrandTotal+results.ScriptsGrandTotal, int32(len(version.DBMigrations)))
@@ -136,12 +135,478 @@ func TestCreateVersionSyncMode(t *testing.T) {
 	}
 }
 
+func TestCreateVersionReportsProgress(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Nil(t, err)
+
+	config := &config.Config{}
+	config.Driver = "postgres"
+	dialect := newDialect(config)
+
+	var progress [][2]int32
+	ctx := context.WithValue(newTestContext(), common.ProgressKey{}, common.ProgressFunc(func(applied, total int32) {
+		progress = append(progress, [2]int32{applied, total})
+	}))
+	connector := baseConnector{ctx, config, dialect, db, true, nil}
+
+	tn := time.Now().UnixNano()
+	m := types.Migration{Name: fmt.Sprintf("%v.sql", tn), SourceDir: "tenants", File: fmt.Sprintf("tenants/%v.sql", tn), MigrationType: types.MigrationTypeTenantMigration, Contents: "insert into {schema}.settings values (456, '456') "}
+	migrationsToApply := []types.Migration{m}
+
+	tenants := sqlmock.NewRows([]string{"name"}).AddRow("abc").AddRow("def")
+	mock.ExpectQuery("pg_advisory_lock").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
+	mock.ExpectQuery("select").WillReturnRows(tenants)
+	mock.ExpectBegin()
+	// version
+	mock.ExpectPrepare("insert into migrator.migrator_versions")
+	mock.ExpectPrepare("insert into migrator.migrator_versions").ExpectQuery().WithArgs("commit-sha").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(0))
+	// migration
+	mock.ExpectPrepare("insert into migrator.migrator_migrations")
+	mock.ExpectPrepare("insert into").ExpectExec().WithArgs(m.Name, m.SourceDir, m.File, m.MigrationType, "abc", m.Contents, m.CheckSum, 0, m.DownContents, nil, m.SourceCommit).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("insert into").WithArgs(m.Name, m.SourceDir, m.File, m.MigrationType, "def", m.Contents, m.CheckSum, 0, m.DownContents, nil, m.SourceCommit).WillReturnResult(sqlmock.NewResult(0, 0))
+	// get version
+	rows := sqlmock.NewRows([]string{"vid", "vname", "vcreated", "mid", "name", "source_dir", "filename", "type", "db_schema", "created", "contents", "checksum", "source_commit"}).AddRow("123", "vname", time.Now(), "456", m.Name, m.SourceDir, m.File, m.MigrationType, "abc", time.Now(), m.Contents, m.CheckSum, nil)
+	mock.ExpectQuery("select").WillReturnRows(rows)
+	mock.ExpectCommit()
+	mock.ExpectExec("pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	_, _, err = connector.CreateVersion("commit-sha", types.ActionSync, migrationsToApply, false)
+	assert.Nil(t, err)
+	// progress is reported before first migration and after every migration applied to every schema
+	assert.Equal(t, [][2]int32{{0, 2}, {1, 2}, {2, 2}}, progress)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCreateVersionPerTenant(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Nil(t, err)
+
+	config := &config.Config{}
+	config.Driver = "postgres"
+	// single worker makes the order of tenant transactions deterministic
+	config.TenantConcurrency = 1
+	dialect := newDialect(config)
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
+
+	tn := time.Now().UnixNano()
+	s := types.Migration{Name: fmt.Sprintf("%v.sql", tn), SourceDir: "public", File: fmt.Sprintf("public/%v.sql", tn), MigrationType: types.MigrationTypeSingleMigration, Contents: "create table abc (id int)"}
+	m := types.Migration{Name: fmt.Sprintf("%v.sql", tn), SourceDir: "tenants", File: fmt.Sprintf("tenants/%v.sql", tn), MigrationType: types.MigrationTypeTenantMigration, Contents: "insert into {schema}.settings values (456, '456') "}
+	migrationsToApply := []types.Migration{m, s}
+
+	tenants := sqlmock.NewRows([]string{"name"}).AddRow("abc").AddRow("def")
+	mock.ExpectQuery("pg_advisory_lock").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
+	mock.ExpectQuery("select").WillReturnRows(tenants)
+	mock.ExpectPrepare("insert into migrator.migrator_migrations")
+	// version transaction with single schema migrations
+	mock.ExpectBegin()
+	mock.ExpectPrepare("insert into migrator.migrator_versions")
+	mock.ExpectPrepare("insert into migrator.migrator_versions").ExpectQuery().WithArgs("commit-sha").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(12))
+	mock.ExpectExec("create table abc").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("insert into migrator.migrator_migrations").WithArgs(s.Name, s.SourceDir, s.File, s.MigrationType, "public", s.Contents, s.CheckSum, 12, s.DownContents, nil, s.SourceCommit).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	// tenant transactions
+	for _, tenant := range []string{"abc", "def"} {
+		mock.ExpectBegin()
+		mock.ExpectExec(fmt.Sprintf("insert into %v.settings", tenant)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("insert into migrator.migrator_migrations").WithArgs(m.Name, m.SourceDir, m.File, m.MigrationType, tenant, m.Contents, m.CheckSum, 12, m.DownContents, nil, m.SourceCommit).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+	}
+	// get version
+	rows := sqlmock.NewRows([]string{"vid", "vname", "vcreated", "mid", "name", "source_dir", "filename", "type", "db_schema", "created", "contents", "checksum", "source_commit"}).AddRow("12", "commit-sha", time.Now(), "456", m.Name, m.SourceDir, m.File, m.MigrationType, "abc", time.Now(), m.Contents, m.CheckSum, nil)
+	mock.ExpectQuery("select").WithArgs(12).WillReturnRows(rows)
+	mock.ExpectExec("pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	results, version, err := connector.CreateVersion("commit-sha", types.ActionApply, migrationsToApply, false)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(12), version.ID)
+	assert.Equal(t, int32(12), results.VersionID)
+	assert.Equal(t, int32(2), results.Tenants)
+	assert.Equal(t, int32(1), results.SingleMigrations)
+	assert.Equal(t, int32(1), results.TenantMigrations)
+	assert.Equal(t, int32(2), results.TenantMigrationsTotal)
+	assert.Equal(t, int32(3), results.MigrationsGrandTotal)
+	assert.Equal(t, []types.TenantResult{{Name: "abc", Outcome: types.TenantOutcomeSucceeded}, {Name: "def", Outcome: types.TenantOutcomeSucceeded}}, results.TenantResults)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCreateVersionPerTenantFailurePolicy(t *testing.T) {
+	for _, policy := range []string{"", "stop", "continue"} {
+		db, mock, err := sqlmock.New()
+		assert.Nil(t, err)
+
+		config := &config.Config{}
+		config.Driver = "postgres"
+		config.TenantConcurrency = 1
+		config.TenantFailurePolicy = policy
+		dialect := newDialect(config)
+		connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
+
+		tn := time.Now().UnixNano()
+		m := types.Migration{Name: fmt.Sprintf("%v.sql", tn), SourceDir: "tenants", File: fmt.Sprintf("tenants/%v.sql", tn), MigrationType: types.MigrationTypeTenantScript, Contents: "insert into {schema}.settings values (456, '456') "}
+		migrationsToApply := []types.Migration{m}
+
+		tenants := sqlmock.NewRows([]string{"name"}).AddRow("abc").AddRow("def").AddRow("ghi")
+		mock.ExpectQuery("pg_advisory_lock").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
+		mock.ExpectQuery("select").WillReturnRows(tenants)
+		mock.ExpectPrepare("insert into migrator.migrator_migrations")
+		mock.ExpectBegin()
+		mock.ExpectPrepare("insert into migrator.migrator_versions")
+		mock.ExpectPrepare("insert into migrator.migrator_versions").ExpectQuery().WithArgs("commit-sha").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(12))
+		mock.ExpectCommit()
+		// first tenant fails
+		mock.ExpectBegin()
+		mock.ExpectExec("insert into abc.settings").WillReturnError(errors.New("trouble maker"))
+		mock.ExpectRollback()
+		if policy == "continue" {
+			for _, tenant := range []string{"def", "ghi"} {
+				mock.ExpectBegin()
+				mock.ExpectExec(fmt.Sprintf("insert into %v.settings", tenant)).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec("insert into migrator.migrator_migrations").WithArgs(m.Name, m.SourceDir, m.File, m.MigrationType, tenant, m.Contents, m.CheckSum, 12, m.DownContents, nil, m.SourceCommit).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectCommit()
+			}
+		}
+		rows := sqlmock.NewRows([]string{"vid", "vname", "vcreated", "mid", "name", "source_dir", "filename", "type", "db_schema", "created", "contents", "checksum", "source_commit"}).AddRow("12", "commit-sha", time.Now(), nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+		mock.ExpectQuery("select").WithArgs(12).WillReturnRows(rows)
+		mock.ExpectExec("pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+
+		// failed tenants are reported in summary, version was committed so no error is returned
+		results, version, err := connector.CreateVersion("commit-sha", types.ActionApply, migrationsToApply, false)
+		assert.Nil(t, err)
+		assert.Equal(t, int32(12), version.ID)
+		assert.Len(t, results.TenantResults, 3)
+		assert.Equal(t, types.TenantOutcomeFailed, results.TenantResults[0].Outcome)
+		assert.Equal(t, fmt.Sprintf("SQL migration %v failed for schema abc with error: trouble maker", m.File), *results.TenantResults[0].Error)
+		if policy == "continue" {
+			assert.Equal(t, types.TenantOutcomeSucceeded, results.TenantResults[1].Outcome)
+			assert.Equal(t, types.TenantOutcomeSucceeded, results.TenantResults[2].Outcome)
+			assert.Equal(t, int32(2), results.TenantScriptsTotal)
+		} else {
+			assert.Equal(t, types.TenantOutcomeSkipped, results.TenantResults[1].Outcome)
+			assert.Equal(t, types.TenantOutcomeSkipped, results.TenantResults[2].Outcome)
+			assert.Equal(t, int32(0), results.TenantScriptsTotal)
+		}
+		assert.Equal(t, int32(1), results.TenantScripts)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("policy %q: there were unfulfilled expectations: %s", policy, err)
+		}
+	}
+}
+
+func TestCreateVersionPerTenantVersionError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Nil(t, err)
+
+	config := &config.Config{}
+	config.Driver = "postgres"
+	config.TenantConcurrency = 4
+	dialect := newDialect(config)
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
+
+	tn := time.Now().UnixNano()
+	s := types.Migration{Name: fmt.Sprintf("%v.sql", tn), SourceDir: "public", File: fmt.Sprintf("public/%v.sql", tn), MigrationType: types.MigrationTypeSingleMigration, Contents: "create table abc (id int)"}
+	m := types.Migration{Name: fmt.Sprintf("%v.sql", tn), SourceDir: "tenants", File: fmt.Sprintf("tenants/%v.sql", tn), MigrationType: types.MigrationTypeTenantMigration, Contents: "insert into {schema}.settings values (456, '456') "}
+	migrationsToApply := []types.Migration{s, m}
+
+	tenants := sqlmock.NewRows([]string{"name"}).AddRow("abc").AddRow("def")
+	mock.ExpectQuery("pg_advisory_lock").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
+	mock.ExpectQuery("select").WillReturnRows(tenants)
+	mock.ExpectPrepare("insert into migrator.migrator_migrations")
+	mock.ExpectBegin()
+	mock.ExpectPrepare("insert into migrator.migrator_versions")
+	mock.ExpectPrepare("insert into migrator.migrator_versions").ExpectQuery().WithArgs("commit-sha").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(12))
+	mock.ExpectExec("create table abc").WillReturnError(errors.New("trouble maker"))
+	mock.ExpectRollback()
+	mock.ExpectExec("pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// when single schema migrations fail no tenant is migrated
+	_, _, err = connector.CreateVersion("commit-sha", types.ActionApply, migrationsToApply, false)
+	assert.Equal(t, fmt.Sprintf("SQL migration %v failed for schema public with error: trouble maker", s.File), err.Error())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCreateVersionNoTransactionMigration(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Nil(t, err)
+
+	config := &config.Config{}
+	config.Driver = "postgres"
+	dialect := newDialect(config)
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
+
+	s1 := types.Migration{Name: "201602220000.sql", SourceDir: "public", File: "public/201602220000.sql", MigrationType: types.MigrationTypeSingleMigration, Contents: "create table abc (id int)"}
+	n := types.Migration{Name: "201602220001.sql", SourceDir: "tenants", File: "tenants/201602220001.sql", MigrationType: types.MigrationTypeTenantMigration, Contents: "-- add index\n-- migrator:no-transaction\ncreate index concurrently idx_def on {schema}.def (id)"}
+	s2 := types.Migration{Name: "201602220002.sql", SourceDir: "public", File: "public/201602220002.sql", MigrationType: types.MigrationTypeSingleMigration, Contents: "create table ghi (id int)"}
+	migrationsToApply := []types.Migration{s1, n, s2}
+
+	tenants := sqlmock.NewRows([]string{"name"}).AddRow("abc").AddRow("def")
+	mock.ExpectQuery("pg_advisory_lock").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
+	mock.ExpectQuery("select").WillReturnRows(tenants)
+	mock.ExpectPrepare("insert into migrator.migrator_migrations")
+	// first transaction creates version and applies migrations before the no-transaction one
+	mock.ExpectBegin()
+	mock.ExpectPrepare("insert into migrator.migrator_versions")
+	mock.ExpectPrepare("insert into migrator.migrator_versions").ExpectQuery().WithArgs("commit-sha").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(12))
+	mock.ExpectExec("create table abc").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("insert into migrator.migrator_migrations").WithArgs(s1.Name, s1.SourceDir, s1.File, s1.MigrationType, "public", s1.Contents, s1.CheckSum, 12, s1.DownContents, nil, s1.SourceCommit).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	// no-transaction migration is applied and recorded outside of transaction
+	for _, tenant := range []string{"abc", "def"} {
+		mock.ExpectExec(fmt.Sprintf("create index concurrently idx_def on %v.def", tenant)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("insert into migrator.migrator_migrations").WithArgs(n.Name, n.SourceDir, n.File, n.MigrationType, tenant, n.Contents, n.CheckSum, 12, n.DownContents, nil, n.SourceCommit).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	// remaining migrations are applied in a new transaction
+	mock.ExpectBegin()
+	mock.ExpectExec("create table ghi").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("insert into migrator.migrator_migrations").WithArgs(s2.Name, s2.SourceDir, s2.File, s2.MigrationType, "public", s2.Contents, s2.CheckSum, 12, s2.DownContents, nil, s2.SourceCommit).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	rows := sqlmock.NewRows([]string{"vid", "vname", "vcreated", "mid", "name", "source_dir", "filename", "type", "db_schema", "created", "contents", "checksum", "source_commit"}).AddRow("12", "commit-sha", time.Now(), "456", s1.Name, s1.SourceDir, s1.File, s1.MigrationType, "public", time.Now(), s1.Contents, s1.CheckSum, nil)
+	mock.ExpectQuery("select").WithArgs(12).WillReturnRows(rows)
+	mock.ExpectExec("pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	results, version, err := connector.CreateVersion("commit-sha", types.ActionApply, migrationsToApply, false)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(12), version.ID)
+	assert.Equal(t, int32(12), results.VersionID)
+	assert.Equal(t, int32(2), results.SingleMigrations)
+	assert.Equal(t, int32(1), results.TenantMigrations)
+	assert.Equal(t, int32(2), results.TenantMigrationsTotal)
+	assert.Equal(t, int32(4), results.MigrationsGrandTotal)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCreateVersionNoTransactionMigrationError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Nil(t, err)
+
+	config := &config.Config{}
+	config.Driver = "postgres"
+	dialect := newDialect(config)
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
+
+	n := types.Migration{Name: "201602220001.sql", SourceDir: "public", File: "public/201602220001.sql", MigrationType: types.MigrationTypeSingleMigration, Contents: "-- migrator:no-transaction\ncreate index concurrently idx_def on def (id)"}
+	s := types.Migration{Name: "201602220002.sql", SourceDir: "public", File: "public/201602220002.sql", MigrationType: types.MigrationTypeSingleMigration, Contents: "create table ghi (id int)"}
+
+	mock.ExpectQuery("pg_advisory_lock").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
+	mock.ExpectQuery("select").WillReturnRows(sqlmock.NewRows([]string{"name"}))
+	mock.ExpectPrepare("insert into migrator.migrator_migrations")
+	// version is created even though there are no migrations before the no-transaction one
+	mock.ExpectBegin()
+	mock.ExpectPrepare("insert into migrator.migrator_versions")
+	mock.ExpectPrepare("insert into migrator.migrator_versions").ExpectQuery().WithArgs("commit-sha").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(12))
+	mock.ExpectCommit()
+	mock.ExpectExec("create index concurrently idx_def on def").WillReturnError(errors.New("trouble maker"))
+	mock.ExpectExec("pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	_, _, err = connector.CreateVersion("commit-sha", types.ActionApply, []types.Migration{n, s}, false)
+	assert.Equal(t, "SQL migration public/201602220001.sql failed for schema public with error: trouble maker", err.Error())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCreateVersionNoTransactionMigrationDryRun(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Nil(t, err)
+
+	config := &config.Config{}
+	config.Driver = "postgres"
+	dialect := newDialect(config)
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
+
+	n := types.Migration{Name: "201602220001.sql", SourceDir: "public", File: "public/201602220001.sql", MigrationType: types.MigrationTypeSingleMigration, Contents: "-- migrator:no-transaction\ncreate index concurrently idx_def on def (id)"}
+
+	mock.ExpectQuery("pg_advisory_lock").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
+	mock.ExpectQuery("select").WillReturnRows(sqlmock.NewRows([]string{"name"}))
+	// dry-run uses single transaction, no-transaction migration is recorded but not executed
+	mock.ExpectBegin()
+	mock.ExpectPrepare("insert into migrator.migrator_versions")
+	mock.ExpectPrepare("insert into migrator.migrator_versions").ExpectQuery().WithArgs("commit-sha").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(12))
+	mock.ExpectPrepare("insert into migrator.migrator_migrations").ExpectExec().WithArgs(n.Name, n.SourceDir, n.File, n.MigrationType, "public", n.Contents, n.CheckSum, 12, n.DownContents, nil, n.SourceCommit).WillReturnResult(sqlmock.NewResult(0, 0))
+	rows := sqlmock.NewRows([]string{"vid", "vname", "vcreated", "mid", "name", "source_dir", "filename", "type", "db_schema", "created", "contents", "checksum", "source_commit"}).AddRow("12", "commit-sha", time.Now(), "456", n.Name, n.SourceDir, n.File, n.MigrationType, "public", time.Now(), n.Contents, n.CheckSum, nil)
+	mock.ExpectQuery("select").WithArgs(12).WillReturnRows(rows)
+	mock.ExpectRollback()
+	mock.ExpectExec("pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	results, _, err := connector.CreateVersion("commit-sha", types.ActionApply, []types.Migration{n}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), results.SingleMigrations)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCreateVersionNoTransactionMigrationTransactionScope(t *testing.T) {
+	for _, scope := range []string{"tenant", "migration"} {
+		db, mock, err := sqlmock.New()
+		assert.Nil(t, err)
+
+		config := &config.Config{}
+		config.Driver = "postgres"
+		config.TransactionScope = scope
+		dialect := newDialect(config)
+		connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
+
+		n := types.Migration{Name: "201602220001.sql", SourceDir: "tenants", File: "tenants/201602220001.sql", MigrationType: types.MigrationTypeTenantMigration, Contents: "-- migrator:no-transaction\ncreate index concurrently idx_def on {schema}.def (id)"}
+
+		mock.ExpectQuery("pg_advisory_lock").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
+		mock.ExpectQuery("select").WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("abc"))
+		mock.ExpectPrepare("insert into migrator.migrator_migrations")
+		mock.ExpectBegin()
+		mock.ExpectPrepare("insert into migrator.migrator_versions")
+		mock.ExpectPrepare("insert into migrator.migrator_versions").ExpectQuery().WithArgs("commit-sha").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(12))
+		mock.ExpectCommit()
+		// tenant has no transactional migrations, no tenant transaction is started
+		mock.ExpectExec("create index concurrently idx_def on abc.def").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("insert into migrator.migrator_migrations").WithArgs(n.Name, n.SourceDir, n.File, n.MigrationType, "abc", n.Contents, n.CheckSum, 12, n.DownContents, nil, n.SourceCommit).WillReturnResult(sqlmock.NewResult(0, 0))
+		rows := sqlmock.NewRows([]string{"vid", "vname", "vcreated", "mid", "name", "source_dir", "filename", "type", "db_schema", "created", "contents", "checksum", "source_commit"}).AddRow("12", "commit-sha", time.Now(), "456", n.Name, n.SourceDir, n.File, n.MigrationType, "abc", time.Now(), n.Contents, n.CheckSum, nil)
+		mock.ExpectQuery("select").WithArgs(12).WillReturnRows(rows)
+		mock.ExpectExec("pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+
+		results, _, err := connector.CreateVersion("commit-sha", types.ActionApply, []types.Migration{n}, false)
+		assert.Nil(t, err)
+		assert.Equal(t, int32(1), results.TenantMigrationsTotal)
+		assert.Equal(t, types.TenantOutcomeSucceeded, results.TenantResults[0].Outcome)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("scope %q: there were unfulfilled expectations: %s", scope, err)
+		}
+	}
+}
+
+func TestContainsNoTransactionMigrations(t *testing.T) {
+	cases := []struct {
+		contents string
+		expected bool
+	}{
+		{"-- migrator:no-transaction\ncreate index concurrently idx on abc (id)", true},
+		{"\n  -- migrator:no-transaction  \r\ncreate index concurrently idx on abc (id)", true},
+		{"-- add index\n\n-- migrator:no-transaction\ncreate index concurrently idx on abc (id)", true},
+		// directive must be in the header
+		{"create table abc (id int);\n-- migrator:no-transaction", false},
+		{"-- migrator:no-transactions\ncreate table abc (id int)", false},
+		{"create table abc (id int)", false},
+	}
+	for _, c := range cases {
+		m := types.Migration{Contents: c.contents}
+		assert.Equal(t, c.expected, containsNoTransactionMigrations([]types.Migration{m}), c.contents)
+	}
+}
+
+func TestGetTenantConcurrency(t *testing.T) {
+	pgConfig := &config.Config{Driver: "postgres", TenantConcurrency: 10}
+	connector := baseConnector{newTestContext(), pgConfig, newDialect(pgConfig), nil, false, nil}
+	assert.Equal(t, 10, connector.getTenantConcurrency())
+
+	sqliteConfig := &config.Config{Driver: "sqlite", TenantConcurrency: 10}
+	connector = baseConnector{newTestContext(), sqliteConfig, newDialect(sqliteConfig), nil, false, nil}
+	assert.Equal(t, 1, connector.getTenantConcurrency())
+
+	// transaction scope set without tenant concurrency
+	defaultConfig := &config.Config{Driver: "postgres", TransactionScope: config.TransactionScopeTenant}
+	connector = baseConnector{newTestContext(), defaultConfig, newDialect(defaultConfig), nil, false, nil}
+	assert.Equal(t, 1, connector.getTenantConcurrency())
+}
+
+func TestGetTransactionScope(t *testing.T) {
+	cases := []struct {
+		scope       string
+		concurrency int
+		expected    string
+	}{
+		{"", 0, config.TransactionScopeVersion},
+		{config.TransactionScopeVersion, 0, config.TransactionScopeVersion},
+		{"", 4, config.TransactionScopeTenant},
+		{config.TransactionScopeVersion, 4, config.TransactionScopeTenant},
+		{config.TransactionScopeTenant, 0, config.TransactionScopeTenant},
+		{config.TransactionScopeMigration, 0, config.TransactionScopeMigration},
+		{config.TransactionScopeMigration, 4, config.TransactionScopeMigration},
+	}
+	for _, c := range cases {
+		cfg := &config.Config{Driver: "postgres", TransactionScope: c.scope, TenantConcurrency: c.concurrency}
+		connector := baseConnector{newTestContext(), cfg, newDialect(cfg), nil, false, nil}
+		assert.Equal(t, c.expected, connector.getTransactionScope(), "scope %q concurrency %v", c.scope, c.concurrency)
+	}
+}
+
+func TestCreateVersionMigrationTransactionScope(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Nil(t, err)
+
+	config := &config.Config{}
+	config.Driver = "postgres"
+	config.TransactionScope = "migration"
+	config.TenantFailurePolicy = "continue"
+	dialect := newDialect(config)
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
+
+	tn := time.Now().UnixNano()
+	m1 := types.Migration{Name: fmt.Sprintf("%v.sql", tn), SourceDir: "tenants", File: fmt.Sprintf("tenants/%v.sql", tn), MigrationType: types.MigrationTypeTenantMigration, Contents: "insert into {schema}.settings values (456, '456') "}
+	m2 := types.Migration{Name: fmt.Sprintf("%v.sql", tn+1), SourceDir: "tenants", File: fmt.Sprintf("tenants/%v.sql", tn+1), MigrationType: types.MigrationTypeTenantMigration, Contents: "update {schema}.settings set v = 'abc'"}
+	migrationsToApply := []types.Migration{m1, m2}
+
+	tenants := sqlmock.NewRows([]string{"name"}).AddRow("abc").AddRow("def")
+	mock.ExpectQuery("pg_advisory_lock").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
+	mock.ExpectQuery("select").WillReturnRows(tenants)
+	mock.ExpectPrepare("insert into migrator.migrator_migrations")
+	mock.ExpectBegin()
+	mock.ExpectPrepare("insert into migrator.migrator_versions")
+	mock.ExpectPrepare("insert into migrator.migrator_versions").ExpectQuery().WithArgs("commit-sha").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(12))
+	mock.ExpectCommit()
+	// first migration is committed for abc, second one fails
+	mock.ExpectBegin()
+	mock.ExpectExec("insert into abc.settings").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("insert into migrator.migrator_migrations").WithArgs(m1.Name, m1.SourceDir, m1.File, m1.MigrationType, "abc", m1.Contents, m1.CheckSum, 12, m1.DownContents, nil, m1.SourceCommit).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec("update abc.settings").WillReturnError(errors.New("trouble maker"))
+	mock.ExpectRollback()
+	// both migrations are committed for def, each in its own transaction
+	for i, m := range migrationsToApply {
+		mock.ExpectBegin()
+		mock.ExpectExec([]string{"insert into def.settings", "update def.settings"}[i]).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("insert into migrator.migrator_migrations").WithArgs(m.Name, m.SourceDir, m.File, m.MigrationType, "def", m.Contents, m.CheckSum, 12, m.DownContents, nil, m.SourceCommit).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+	}
+	rows := sqlmock.NewRows([]string{"vid", "vname", "vcreated", "mid", "name", "source_dir", "filename", "type", "db_schema", "created", "contents", "checksum", "source_commit"}).AddRow("12", "commit-sha", time.Now(), nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	mock.ExpectQuery("select").WithArgs(12).WillReturnRows(rows)
+	mock.ExpectExec("pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	results, _, err := connector.CreateVersion("commit-sha", types.ActionApply, migrationsToApply, false)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), results.TenantMigrations)
+	// 1 committed for abc and 2 for def
+	assert.Equal(t, int32(3), results.TenantMigrationsTotal)
+	assert.Equal(t, int32(3), results.MigrationsGrandTotal)
+	assert.Equal(t, types.TenantOutcomeFailed, results.TenantResults[0].Outcome)
+	assert.Equal(t, fmt.Sprintf("SQL migration %v failed for schema abc with error: trouble maker", m2.File), *results.TenantResults[0].Error)
+	assert.Equal(t, types.TenantOutcomeSucceeded, results.TenantResults[1].Outcome)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
 func TestGetTenantsSQLOverride(t *testing.T) {
 	config, err := config.FromFile("../test/migrator-overrides.yaml")
 	assert.Nil(t, err)
 
 	dialect := newDialect(config)
-	connector := baseConnector{newTestContext(), config, dialect, nil, false}
+	connector := baseConnector{newTestContext(), config, dialect, nil, false, nil}
 	defer connector.Dispose()
 
 	tenantSelectSQL := connector.getTenantSelectSQL()
@@ -154,7 +619,7 @@ func TestGetSchemaPlaceHolderDefault(t *testing.T) {
 	assert.Nil(t, err)
 
 	dialect := newDialect(config)
-	connector := baseConnector{newTestContext(), config, dialect, nil, false}
+	connector := baseConnector{newTestContext(), config, dialect, nil, false, nil}
 	defer connector.Dispose()
 
 	placeholder := connector.getSchemaPlaceHolder()
@@ -167,7 +632,7 @@ func TestGetSchemaPlaceHolderOverride(t *testing.T) {
 	assert.Nil(t, err)
 
 	dialect := newDialect(config)
-	connector := baseConnector{newTestContext(), config, dialect, nil, false}
+	connector := baseConnector{newTestContext(), config, dialect, nil, false, nil}
 	defer connector.Dispose()
 
 	placeholder := connector.getSchemaPlaceHolder()
@@ -175,6 +640,95 @@ func TestGetSchemaPlaceHolderOverride(t *testing.T) {
 	assert.Equal(t, "[schema]", placeholder)
 }
 
+func TestPlan(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Nil(t, err)
+
+	config := &config.Config{}
+	config.Driver = "postgres"
+	config.SchemaPlaceHolder = "[schema]"
+	dialect := newDialect(config)
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
+
+	s := types.Migration{Name: "201602220000.sql", SourceDir: "source", File: "source/201602220000.sql", MigrationType: types.MigrationTypeSingleMigration, Contents: "create table [schema].abc (id int)"}
+	m := types.Migration{Name: "201602220001.sql", SourceDir: "tenants", File: "tenants/201602220001.sql", MigrationType: types.MigrationTypeTenantMigration, Contents: "create table [schema].def (id int)"}
+	sc := types.Migration{Name: "recreate-indexes.sql", SourceDir: "tenants-scripts", File: "tenants-scripts/recreate-indexes.sql", MigrationType: types.MigrationTypeTenantScript, Contents: "reindex table [schema].def"}
+
+	// only tenants are read, no lock and no transaction
+	tenants := sqlmock.NewRows([]string{"name"}).AddRow("abc").AddRow("def")
+	mock.ExpectQuery("select").WillReturnRows(tenants)
+
+	plan, err := connector.Plan([]types.Migration{s, m, sc})
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), plan.Tenants)
+	assert.Equal(t, int32(1), plan.SingleMigrations)
+	assert.Equal(t, int32(1), plan.TenantMigrations)
+	assert.Equal(t, int32(2), plan.TenantMigrationsTotal)
+	assert.Equal(t, int32(3), plan.MigrationsGrandTotal)
+	assert.Equal(t, int32(1), plan.TenantScripts)
+	assert.Equal(t, int32(2), plan.TenantScriptsTotal)
+	assert.Equal(t, int32(2), plan.ScriptsGrandTotal)
+	assert.Len(t, plan.Migrations, 3)
+	assert.Equal(t, []string{"source"}, plan.Migrations[0].Schemas)
+	assert.Equal(t, []types.PlannedStatement{{Schema: "source", SQL: "create table source.abc (id int)"}}, plan.Migrations[0].Statements)
+	assert.Equal(t, []string{"abc", "def"}, plan.Migrations[1].Schemas)
+	assert.Equal(t, []types.PlannedStatement{{Schema: "abc", SQL: "create table abc.def (id int)"}, {Schema: "def", SQL: "create table def.def (id int)"}}, plan.Migrations[1].Statements)
+	assert.Equal(t, "reindex table def.def", plan.Migrations[2].Statements[1].SQL)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPlanNoMigrations(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Nil(t, err)
+
+	config := &config.Config{}
+	config.Driver = "postgres"
+	dialect := newDialect(config)
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
+
+	mock.ExpectQuery("select").WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("abc"))
+
+	plan, err := connector.Plan([]types.Migration{})
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), plan.Tenants)
+	assert.Equal(t, int32(0), plan.MigrationsGrandTotal)
+	assert.Empty(t, plan.Migrations)
+	assert.NotNil(t, plan.Migrations)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetLockTimeoutDefault(t *testing.T) {
+	config, err := config.FromFile("../test/migrator-postgresql.yaml")
+	assert.Nil(t, err)
+
+	dialect := newDialect(config)
+	connector := baseConnector{newTestContext(), config, dialect, nil, false, nil}
+	defer connector.Dispose()
+
+	lockTimeout := connector.getLockTimeout()
+
+	assert.Equal(t, 30*time.Second, lockTimeout)
+}
+
+func TestGetLockTimeoutOverride(t *testing.T) {
+	config, err := config.FromFile("../test/migrator-overrides.yaml")
+	assert.Nil(t, err)
+
+	dialect := newDialect(config)
+	connector := baseConnector{newTestContext(), config, dialect, nil, false, nil}
+	defer connector.Dispose()
+
+	lockTimeout := connector.getLockTimeout()
+
+	assert.Equal(t, 2*time.Minute, lockTimeout)
+}
+
 func TestCreateTenantDryRunMode(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	assert.Nil(t, err)
@@ -182,7 +736,7 @@ func TestCreateTenantDryRunMode(t *testing.T) {
 	config := &config.Config{}
 	config.Driver = "postgres"
 	dialect := newDialect(config)
-	connector := baseConnector{newTestContext(), config, dialect, db, true}
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
 
 	tn := time.Now().UnixNano()
 	m := types.Migration{Name: fmt.Sprintf("%v.sql", tn), SourceDir: "tenants", File: fmt.Sprintf("tenants/%v.sql", tn), MigrationType: types.MigrationTypeTenantMigration, Contents: "insert into {schema}.settings values (456, '456') "}
@@ -190,6 +744,7 @@ func TestCreateTenantDryRunMode(t *testing.T) {
 
 	tenant := "tenantname"
 
+	mock.ExpectQuery("pg_advisory_lock").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
 	mock.ExpectBegin()
 	mock.ExpectExec("create schema").WillReturnResult(sqlmock.NewResult(0, 0))
 	// tenant
@@ -197,19 +752,21 @@ func TestCreateTenantDryRunMode(t *testing.T) {
 	mock.ExpectPrepare("insert into").ExpectExec().WithArgs(tenant).WillReturnResult(sqlmock.NewResult(1, 1))
 	// version
 	mock.ExpectPrepare("insert into migrator.migrator_versions")
-	mock.ExpectPrepare("insert into migrator.migrator_versions").ExpectQuery().WithArgs("commit-sha")
+	mock.ExpectPrepare("insert into migrator.migrator_versions").ExpectQuery().WithArgs("commit-sha").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(0))
 	// migration
 	mock.ExpectPrepare("insert into migrator.migrator_migrations")
 	mock.ExpectExec("insert into").WillReturnResult(sqlmock.NewResult(0, 0))
-	mock.ExpectPrepare("insert into migrator.migrator_migrations").ExpectExec().WithArgs(m.Name, m.SourceDir, m.File, m.MigrationType, tenant, m.Contents, m.CheckSum, 0).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectPrepare("insert into migrator.migrator_migrations").ExpectExec().WithArgs(m.Name, m.SourceDir, m.File, m.MigrationType, tenant, m.Contents, m.CheckSum, 0, m.DownContents, nil, m.SourceCommit).WillReturnResult(sqlmock.NewResult(0, 0))
 	// get version
-	rows := sqlmock.NewRows([]string{"vid", "vname", "vcreated", "mid", "name", "source_dir", "filename", "type", "db_schema", "created", "contents", "checksum"}).AddRow("123", "vname", time.Now(), "456", m.Name, m.SourceDir, m.File, m.MigrationType, tenant, time.Now(), m.Contents, m.CheckSum)
+	rows := sqlmock.NewRows([]string{"vid", "vname", "vcreated", "mid", "name", "source_dir", "filename", "type", "db_schema", "created", "contents", "checksum", "source_commit"}).AddRow("123", "vname", time.Now(), "456", m.Name, m.SourceDir, m.File, m.MigrationType, tenant, time.Now(), m.Contents, m.CheckSum, nil)
 	mock.ExpectQuery("select").WillReturnRows(rows)
 	// dry-run mode calls rollback instead of commit
 	mock.ExpectRollback()
+	mock.ExpectExec("pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
 
 	// however the results contain correct dry-run data like number of applied migrations/scripts
-	results, version := connector.CreateTenant(tenant, "commit-sha", types.ActionApply, migrationsToApply, true)
+	results, version, err := connector.CreateTenant(types.Tenant{Name: tenant}, "commit-sha", types.ActionApply, migrationsToApply, true)
+	assert.Nil(t, err)
 	assert.NotNil(t, version)
 	assert.True(t, version.ID > 0)
 	assert.Equal(t, results.MigrationsGrandTotal+results.ScriptsGrandTotal, int32(len(version.DBMigrations)))
@@ -227,13 +784,14 @@ func TestCreateTenantSyncMode(t *testing.T) {
 	config := &config.Config{}
 	config.Driver = "postgres"
 	dialect := newDialect(config)
-	connector := baseConnector{newTestContext(), config, dialect, db, true}
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
 
 	tn := time.Now().UnixNano()
 	m := types.Migration{Name: fmt.Sprintf("%v.sql", tn), SourceDir: "tenants", File: fmt.Sprintf("tenants/%v.sql", tn), MigrationType: types.MigrationTypeTenantMigration, Contents: "insert into {schema}.settings values (456, '456') "}
 	migrationsToApply := []types.Migration{m}
 
 	tenant := "tenantname"
+	mock.ExpectQuery("pg_advisory_lock").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
 	mock.ExpectBegin()
 	mock.ExpectExec("create schema").WillReturnResult(sqlmock.NewResult(0, 0))
 	// tenant
@@ -241,17 +799,19 @@ func TestCreateTenantSyncMode(t *testing.T) {
 	mock.ExpectPrepare("insert into").ExpectExec().WithArgs(tenant).WillReturnResult(sqlmock.NewResult(0, 0))
 	// version
 	mock.ExpectPrepare("insert into migrator.migrator_versions")
-	mock.ExpectPrepare("insert into migrator.migrator_versions").ExpectQuery().WithArgs("commit-sha")
+	mock.ExpectPrepare("insert into migrator.migrator_versions").ExpectQuery().WithArgs("commit-sha").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(0))
 	// migration
 	mock.ExpectPrepare("insert into migrator.migrator_migrations")
-	mock.ExpectPrepare("insert into").ExpectExec().WithArgs(m.Name, m.SourceDir, m.File, m.MigrationType, tenant, m.Contents, m.CheckSum, 0).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectPrepare("insert into").ExpectExec().WithArgs(m.Name, m.SourceDir, m.File, m.MigrationType, tenant, m.Contents, m.CheckSum, 0, m.DownContents, nil, m.SourceCommit).WillReturnResult(sqlmock.NewResult(0, 0))
 	// get version
-	rows := sqlmock.NewRows([]string{"vid", "vname", "vcreated", "mid", "name", "source_dir", "filename", "type", "db_schema", "created", "contents", "checksum"}).AddRow("123", "vname", time.Now(), "456", m.Name, m.SourceDir, m.File, m.MigrationType, tenant, time.Now(), m.Contents, m.CheckSum)
+	rows := sqlmock.NewRows([]string{"vid", "vname", "vcreated", "mid", "name", "source_dir", "filename", "type", "db_schema", "created", "contents", "checksum", "source_commit"}).AddRow("123", "vname", time.Now(), "456", m.Name, m.SourceDir, m.File, m.MigrationType, tenant, time.Now(), m.Contents, m.CheckSum, nil)
 	mock.ExpectQuery("select").WillReturnRows(rows)
 	mock.ExpectCommit()
+	mock.ExpectExec("pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
 
 	// sync results contain correct data like number of applied migrations/scripts
-	results, version := connector.CreateTenant(tenant, "commit-sha", types.ActionSync, migrationsToApply, false)
+	results, version, err := connector.CreateTenant(types.Tenant{Name: tenant}, "commit-sha", types.ActionSync, migrationsToApply, false)
+	assert.Nil(t, err)
 	assert.NotNil(t, version)
 	assert.True(t, version.ID > 0)
 	assert.Equal(t, results.MigrationsGrandTotal+results.ScriptsGrandTotal, int32(len(version.DBMigrations)))
@@ -267,7 +827,7 @@ func TestGetTenantInsertSQLOverride(t *testing.T) {
 	assert.Nil(t, err)
 
 	dialect := newDialect(config)
-	connector := baseConnector{newTestContext(), config, dialect, nil, false}
+	connector := baseConnector{newTestContext(), config, dialect, nil, false, nil}
 	defer connector.Dispose()
 
 	tenantInsertSQL := connector.getTenantInsertSQL()
@@ -275,6 +835,83 @@ func TestGetTenantInsertSQLOverride(t *testing.T) {
 	assert.Equal(t, "insert into someschema.sometable (somename) values ($1)", tenantInsertSQL)
 }
 
+func TestGetTenantsMetadata(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Nil(t, err)
+
+	config := &config.Config{}
+	config.Driver = "postgres"
+	config.TenantSelectSQL = "select name, region, tier from public.customers"
+	dialect := newDialect(config)
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
+
+	// additional columns returned by tenant select SQL are tenant metadata, nulls are returned as empty strings
+	rows := sqlmock.NewRows([]string{"name", "region", "tier"}).AddRow("abc", "eu-west-1", "premium").AddRow("def", "us-east-1", nil)
+	mock.ExpectQuery("select name, region, tier from public.customers").WillReturnRows(rows)
+
+	tenants, err := connector.GetTenants()
+	assert.Nil(t, err)
+	assert.Equal(t, []types.Tenant{{Name: "abc", Metadata: types.TenantMetadata{"region": "eu-west-1", "tier": "premium"}}, {Name: "def", Metadata: types.TenantMetadata{"region": "us-east-1", "tier": ""}}}, tenants)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetTenantInsertArgs(t *testing.T) {
+	config := &config.Config{}
+	config.Driver = "postgres"
+	config.TenantMetadataColumns = []string{"region", "tier"}
+	dialect := newDialect(config)
+	connector := baseConnector{newTestContext(), config, dialect, nil, false, nil}
+
+	args, err := connector.getTenantInsertArgs(types.Tenant{Name: "abc", Metadata: types.TenantMetadata{"tier": "premium"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"abc", nil, "premium"}, args)
+
+	_, err = connector.getTenantInsertArgs(types.Tenant{Name: "abc", Metadata: types.TenantMetadata{"plan": "premium"}})
+	assert.Equal(t, "unknown tenant metadata: plan, tenant metadata columns are configured using tenantMetadataColumns", err.Error())
+}
+
+func TestGetMigrationAndVersionTenants(t *testing.T) {
+	tenants := []types.Tenant{{Name: "abc"}, {Name: "def"}, {Name: "ghi"}}
+	s := types.Migration{Name: "201602220000.sql", SourceDir: "source", File: "source/201602220000.sql", MigrationType: types.MigrationTypeSingleMigration}
+	m1 := types.Migration{Name: "201602220001.sql", SourceDir: "tenants", File: "tenants/201602220001.sql", MigrationType: types.MigrationTypeTenantMigration, Tenants: []string{"ghi", "abc"}}
+	m2 := types.Migration{Name: "201602220002.sql", SourceDir: "tenants", File: "tenants/201602220002.sql", MigrationType: types.MigrationTypeTenantMigration, Tenants: []string{"def"}}
+
+	assert.Equal(t, tenants, getMigrationTenants(s, tenants))
+	assert.Equal(t, []types.Tenant{{Name: "abc"}, {Name: "ghi"}}, getMigrationTenants(m1, tenants))
+
+	assert.Equal(t, tenants, getVersionTenants([]types.Migration{s}, tenants))
+	assert.Equal(t, []types.Tenant{{Name: "abc"}, {Name: "ghi"}}, getVersionTenants([]types.Migration{s, m1}, tenants))
+	assert.Equal(t, tenants, getVersionTenants([]types.Migration{m1, m2}, tenants))
+	m2.Tenants = nil
+	assert.Equal(t, tenants, getVersionTenants([]types.Migration{m1, m2}, tenants))
+}
+
+func TestSkipRolledBackMigrations(t *testing.T) {
+	created := time.Now()
+	m1 := types.Migration{Name: "201602220001.sql", SourceDir: "tenants", File: "tenants/201602220001.sql", MigrationType: types.MigrationTypeTenantMigration}
+	d1 := types.Migration{Name: "201602220001.down.sql", SourceDir: "tenants", File: "tenants/201602220001.down.sql", MigrationType: types.MigrationTypeTenantMigration}
+	s1 := types.Migration{Name: "201602220002.sql", SourceDir: "scripts", File: "scripts/201602220002.sql", MigrationType: types.MigrationTypeSingleScript}
+
+	dbMigrations := []types.DBMigration{
+		// applied to abc, rolled back, and applied again
+		{Migration: d1, Schema: "abc", Created: graphql.Time{Time: created.Add(time.Second)}},
+		{Migration: m1, Schema: "abc", Created: graphql.Time{Time: created.Add(2 * time.Second)}},
+		{Migration: m1, Schema: "abc", Created: graphql.Time{Time: created}},
+		// applied to def and rolled back
+		{Migration: d1, Schema: "def", Created: graphql.Time{Time: created.Add(time.Second)}},
+		{Migration: m1, Schema: "def", Created: graphql.Time{Time: created}},
+		// scripts are not rolled back and all their rows are returned
+		{Migration: s1, Schema: "scripts", Created: graphql.Time{Time: created}},
+		{Migration: s1, Schema: "scripts", Created: graphql.Time{Time: created.Add(time.Second)}},
+	}
+
+	applied := skipRolledBackMigrations(dbMigrations)
+	assert.Equal(t, []types.DBMigration{dbMigrations[1], dbMigrations[5], dbMigrations[6]}, applied)
+}
+
 func TestHealthCheck(t *testing.T) {
 	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
 	assert.Nil(t, err)
@@ -282,7 +919,7 @@ func TestHealthCheck(t *testing.T) {
 	config := &config.Config{}
 	config.Driver = "postgres"
 	dialect := newDialect(config)
-	connector := baseConnector{newTestContext(), config, dialect, db, true}
+	connector := baseConnector{newTestContext(), config, dialect, db, true, nil}
 
 	mock.ExpectPing().WillReturnError(errors.New("trouble maker"))
 
@@ -0,0 +1,200 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/graph-gophers/graphql-go"
+
+	"github.com/lukaszbudnik/migrator/common"
+	"github.com/lukaszbudnik/migrator/config"
+	"github.com/lukaszbudnik/migrator/coordinator"
+	"github.com/lukaszbudnik/migrator/metrics"
+	"github.com/lukaszbudnik/migrator/types"
+)
+
+const (
+	// maxFinishedJobs is the number of finished jobs kept in memory, oldest finished jobs are removed first
+	maxFinishedJobs = 100
+)
+
+// Operation is run by asynchronous job using coordinator created for the job
+type Operation func(coordinator.Coordinator) (*types.CreateResults, error)
+
+// Manager runs createVersion and createTenant operations in background and keeps track of their state
+type Manager interface {
+	Submit(ctx context.Context, name string, operation Operation) types.Job
+	GetJob(id graphql.ID) *types.Job
+	GetJobs() []types.Job
+}
+
+type manager struct {
+	config         *config.Config
+	metrics        metrics.Metrics
+	newCoordinator coordinator.Factory
+	mutex          sync.RWMutex
+	jobs           map[graphql.ID]*types.Job
+	// jobs are run one at a time, migrator's DB-level lock would serialise them anyway
+	running chan struct{}
+}
+
+// New creates new instance of jobs Manager
+func New(config *config.Config, metrics metrics.Metrics, newCoordinator coordinator.Factory) Manager {
+	return &manager{
+		config:         config,
+		metrics:        metrics,
+		newCoordinator: newCoordinator,
+		jobs:           make(map[graphql.ID]*types.Job),
+		running:        make(chan struct{}, 1),
+	}
+}
+
+// Submit creates new job and runs passed operation in background, the returned job is in Queued state
+// the operation runs with its own context which is not cancelled when ctx (usually HTTP request context) is done
+// log level and request ID are copied from ctx so that job logs can be correlated with the request which created it
+func (m *manager) Submit(ctx context.Context, name string, operation Operation) types.Job {
+	job := &types.Job{
+		ID:        newJobID(),
+		Operation: name,
+		State:     types.JobStateQueued,
+		Created:   graphql.Time{Time: time.Now()},
+	}
+
+	m.mutex.Lock()
+	m.jobs[job.ID] = job
+	m.removeFinishedJobs()
+	submitted := *job
+	m.mutex.Unlock()
+
+	jobCtx := context.WithValue(context.Background(), common.LogLevelKey{}, ctx.Value(common.LogLevelKey{}))
+	jobCtx = context.WithValue(jobCtx, common.RequestIDKey{}, ctx.Value(common.RequestIDKey{}))
+	jobCtx = context.WithValue(jobCtx, common.ProgressKey{}, common.ProgressFunc(func(applied, total int32) {
+		m.update(job, func(j *types.Job) {
+			j.MigrationsApplied = applied
+			j.MigrationsTotal = total
+		})
+	}))
+
+	common.LogInfo(ctx, "Submitted %v job: %v", name, job.ID)
+
+	go m.run(jobCtx, job, operation)
+
+	return submitted
+}
+
+func (m *manager) run(ctx context.Context, job *types.Job, operation Operation) {
+	m.running <- struct{}{}
+	defer func() { <-m.running }()
+
+	m.update(job, func(j *types.Job) {
+		j.State = types.JobStateRunning
+		j.Started = &graphql.Time{Time: time.Now()}
+	})
+	common.LogInfo(ctx, "Running %v job: %v", job.Operation, job.ID)
+
+	results, err := m.runOperation(ctx, operation)
+
+	m.update(job, func(j *types.Job) {
+		j.Finished = &graphql.Time{Time: time.Now()}
+		if err != nil {
+			j.State = types.JobStateFailed
+			message := err.Error()
+			j.Error = &message
+			if e, ok := err.(interface{ Extensions() map[string]interface{} }); ok {
+				code := fmt.Sprint(e.Extensions()["code"])
+				j.ErrorCode = &code
+			}
+			return
+		}
+		j.State = types.JobStateSucceeded
+		j.Results = results
+	})
+
+	if err != nil {
+		common.LogError(ctx, "Job %v failed: %v", job.ID, err)
+	} else {
+		common.LogInfo(ctx, "Job %v succeeded", job.ID)
+	}
+}
+
+// runOperation runs operation using new coordinator, panics are turned into errors so that a job never stays in Running state
+func (m *manager) runOperation(ctx context.Context, operation Operation) (results *types.CreateResults, err error) {
+	coordinator := m.newCoordinator(ctx, m.config, m.metrics)
+	defer coordinator.Dispose()
+
+	defer func() {
+		if r := recover(); r != nil {
+			common.LogPanic(ctx, "Panic recovered: %v", r)
+			results, err = nil, fmt.Errorf("%v", r)
+		}
+	}()
+
+	return operation(coordinator)
+}
+
+// update applies changes to job while holding the write lock
+func (m *manager) update(job *types.Job, f func(*types.Job)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	f(job)
+}
+
+// GetJob returns a copy of job with given ID or nil if job does not exist
+func (m *manager) GetJob(id graphql.ID) *types.Job {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil
+	}
+	copy := *job
+	return &copy
+}
+
+// GetJobs returns copies of all jobs, the most recent jobs first
+func (m *manager) GetJobs() []types.Job {
+	m.mutex.RLock()
+	jobs := make([]types.Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, *job)
+	}
+	m.mutex.RUnlock()
+
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].Created.After(jobs[j].Created.Time)
+	})
+	return jobs
+}
+
+// removeFinishedJobs removes oldest finished jobs when there are more than maxFinishedJobs of them
+// must be called while holding the write lock
+func (m *manager) removeFinishedJobs() {
+	var finished []*types.Job
+	for _, job := range m.jobs {
+		if job.State == types.JobStateSucceeded || job.State == types.JobStateFailed {
+			finished = append(finished, job)
+		}
+	}
+	if len(finished) <= maxFinishedJobs {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].Finished.Before(finished[j].Finished.Time)
+	})
+	for _, job := range finished[:len(finished)-maxFinishedJobs] {
+		delete(m.jobs, job.ID)
+	}
+}
+
+func newJobID() graphql.ID {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return graphql.ID(fmt.Sprintf("%d", time.Now().UnixNano()))
+	}
+	return graphql.ID(hex.EncodeToString(b))
+}
@@ -0,0 +1,45 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/lukaszbudnik/migrator/common"
+	"github.com/lukaszbudnik/migrator/config"
+	"github.com/lukaszbudnik/migrator/coordinator"
+	"github.com/lukaszbudnik/migrator/metrics"
+	"github.com/lukaszbudnik/migrator/types"
+)
+
+// mockedCoordinator embeds Coordinator interface, only methods used by jobs tests are implemented
+type mockedCoordinator struct {
+	coordinator.Coordinator
+	ctx      context.Context
+	release  chan struct{}
+	disposed chan struct{}
+}
+
+func newMockedCoordinatorFactory(release chan struct{}, disposed chan struct{}) coordinator.Factory {
+	return func(ctx context.Context, config *config.Config, metrics metrics.Metrics) coordinator.Coordinator {
+		return &mockedCoordinator{ctx: ctx, release: release, disposed: disposed}
+	}
+}
+
+func (m *mockedCoordinator) CreateVersion(versionName string, action types.Action, dryRun bool, selector *types.TenantSelector) (*types.CreateResults, error) {
+	common.ReportProgress(m.ctx, 0, 2)
+	<-m.release
+	common.ReportProgress(m.ctx, 1, 2)
+	switch versionName {
+	case "broken":
+		return nil, &types.LockTimeoutError{Timeout: 30 * time.Second}
+	case "panic":
+		panic(errors.New("trouble maker"))
+	}
+	common.ReportProgress(m.ctx, 2, 2)
+	return &types.CreateResults{Summary: &types.Summary{MigrationsGrandTotal: 2}, Version: &types.Version{ID: 123, Name: versionName}}, nil
+}
+
+func (m *mockedCoordinator) Dispose() {
+	m.disposed <- struct{}{}
+}
@@ -0,0 +1,166 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/graph-gophers/graphql-go"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lukaszbudnik/migrator/common"
+	"github.com/lukaszbudnik/migrator/config"
+	"github.com/lukaszbudnik/migrator/coordinator"
+	"github.com/lukaszbudnik/migrator/metrics"
+	"github.com/lukaszbudnik/migrator/types"
+)
+
+func newTestContext() context.Context {
+	ctx := context.TODO()
+	ctx = context.WithValue(ctx, common.RequestIDKey{}, "123")
+	// log level empty = default log level = INFO
+	ctx = context.WithValue(ctx, common.LogLevelKey{}, "")
+	return ctx
+}
+
+func createVersion(versionName string) Operation {
+	return func(c coordinator.Coordinator) (*types.CreateResults, error) {
+		return c.CreateVersion(versionName, types.ActionApply, false, nil)
+	}
+}
+
+func waitForState(t *testing.T, m Manager, id graphql.ID, state types.JobState) *types.Job {
+	var job *types.Job
+	assert.Eventually(t, func() bool {
+		job = m.GetJob(id)
+		return job.State == state
+	}, time.Second, time.Millisecond)
+	return job
+}
+
+func TestSubmitSucceeded(t *testing.T) {
+	release := make(chan struct{})
+	disposed := make(chan struct{}, 1)
+	m := New(&config.Config{}, metrics.NewNoop(), newMockedCoordinatorFactory(release, disposed))
+
+	job := m.Submit(newTestContext(), "createVersion", createVersion("v1"))
+	assert.NotEmpty(t, job.ID)
+	assert.Equal(t, "createVersion", job.Operation)
+	assert.Equal(t, types.JobStateQueued, job.State)
+	assert.Nil(t, job.Started)
+
+	running := waitForState(t, m, job.ID, types.JobStateRunning)
+	assert.NotNil(t, running.Started)
+	assert.Equal(t, int32(0), running.MigrationsApplied)
+	assert.Equal(t, int32(2), running.MigrationsTotal)
+
+	close(release)
+	<-disposed
+
+	succeeded := waitForState(t, m, job.ID, types.JobStateSucceeded)
+	assert.NotNil(t, succeeded.Finished)
+	assert.Equal(t, int32(2), succeeded.MigrationsApplied)
+	assert.Equal(t, "v1", succeeded.Results.Version.Name)
+	assert.Equal(t, int32(2), succeeded.Results.Summary.MigrationsGrandTotal)
+	assert.Nil(t, succeeded.Error)
+}
+
+func TestSubmitFailed(t *testing.T) {
+	release := make(chan struct{})
+	disposed := make(chan struct{}, 1)
+	m := New(&config.Config{}, metrics.NewNoop(), newMockedCoordinatorFactory(release, disposed))
+
+	job := m.Submit(newTestContext(), "createVersion", createVersion("broken"))
+	close(release)
+	<-disposed
+
+	failed := waitForState(t, m, job.ID, types.JobStateFailed)
+	assert.NotNil(t, failed.Finished)
+	assert.Equal(t, int32(1), failed.MigrationsApplied)
+	assert.Nil(t, failed.Results)
+	assert.Equal(t, "could not acquire migrator lock within 30s, another migrator operation is in progress", *failed.Error)
+	assert.Equal(t, string(types.ErrorCodeLockTimeout), *failed.ErrorCode)
+}
+
+func TestSubmitPanic(t *testing.T) {
+	release := make(chan struct{})
+	disposed := make(chan struct{}, 1)
+	m := New(&config.Config{}, metrics.NewNoop(), newMockedCoordinatorFactory(release, disposed))
+
+	job := m.Submit(newTestContext(), "createVersion", createVersion("panic"))
+	close(release)
+	<-disposed
+
+	failed := waitForState(t, m, job.ID, types.JobStateFailed)
+	assert.Equal(t, "trouble maker", *failed.Error)
+	assert.Nil(t, failed.ErrorCode)
+}
+
+func TestSubmitRequestContextCancelled(t *testing.T) {
+	release := make(chan struct{})
+	disposed := make(chan struct{}, 1)
+	m := New(&config.Config{}, metrics.NewNoop(), newMockedCoordinatorFactory(release, disposed))
+
+	ctx, cancel := context.WithCancel(newTestContext())
+	job := m.Submit(ctx, "createVersion", createVersion("v1"))
+	// HTTP request finished before job
+	cancel()
+	close(release)
+	<-disposed
+
+	waitForState(t, m, job.ID, types.JobStateSucceeded)
+}
+
+func TestSubmitJobsRunOneAtATime(t *testing.T) {
+	release := make(chan struct{})
+	disposed := make(chan struct{}, 2)
+	m := New(&config.Config{}, metrics.NewNoop(), newMockedCoordinatorFactory(release, disposed))
+
+	job1 := m.Submit(newTestContext(), "createVersion", createVersion("v1"))
+	waitForState(t, m, job1.ID, types.JobStateRunning)
+	job2 := m.Submit(newTestContext(), "createVersion", createVersion("v2"))
+
+	// second job waits for the first one
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, types.JobStateQueued, m.GetJob(job2.ID).State)
+
+	release <- struct{}{}
+	waitForState(t, m, job1.ID, types.JobStateSucceeded)
+	waitForState(t, m, job2.ID, types.JobStateRunning)
+	release <- struct{}{}
+	waitForState(t, m, job2.ID, types.JobStateSucceeded)
+
+	// most recent jobs first
+	jobs := m.GetJobs()
+	assert.Len(t, jobs, 2)
+	assert.Equal(t, job2.ID, jobs[0].ID)
+	assert.Equal(t, job1.ID, jobs[1].ID)
+}
+
+func TestGetJobNotFound(t *testing.T) {
+	m := New(&config.Config{}, metrics.NewNoop(), nil)
+	assert.Nil(t, m.GetJob(graphql.ID("abc")))
+	assert.Empty(t, m.GetJobs())
+}
+
+func TestRemoveFinishedJobs(t *testing.T) {
+	m := New(&config.Config{}, metrics.NewNoop(), nil).(*manager)
+
+	now := time.Now()
+	for i := 0; i < maxFinishedJobs+5; i++ {
+		id := graphql.ID(fmt.Sprintf("%v", i))
+		finished := graphql.Time{Time: now.Add(time.Duration(i) * time.Second)}
+		m.jobs[id] = &types.Job{ID: id, State: types.JobStateSucceeded, Finished: &finished}
+	}
+	m.jobs["running"] = &types.Job{ID: "running", State: types.JobStateRunning}
+
+	m.removeFinishedJobs()
+
+	assert.Len(t, m.jobs, maxFinishedJobs+1)
+	// oldest finished jobs are removed
+	assert.Nil(t, m.GetJob("0"))
+	assert.Nil(t, m.GetJob("4"))
+	assert.NotNil(t, m.GetJob("5"))
+	assert.NotNil(t, m.GetJob("running"))
+}
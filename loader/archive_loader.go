@@ -0,0 +1,281 @@
+package loader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/lukaszbudnik/migrator/config"
+	"github.com/lukaszbudnik/migrator/types"
+)
+
+const (
+	// archiveChecksumSuffix is added to baseLocation to get detached checksum file location
+	archiveChecksumSuffix = ".sha256"
+	// archiveManifestFile is the manifest file at the root of the archive
+	archiveManifestFile = "SHA256SUMS"
+)
+
+// storageLoader is implemented by loaders which can read a single file from their storage
+type storageLoader interface {
+	Loader
+	readFile(location string) ([]byte, error)
+}
+
+// archiveLoader is struct used for implementing Loader interface for loading migrations from tar.gz, tgz and zip archives
+// archives are read from local disk, AWS S3, Google Cloud Storage, Azure Blob or HTTP(S) servers using storage loader
+// files are not read using readCached as the whole archive is downloaded anyway before its files can be listed
+type archiveLoader struct {
+	baseLoader
+	storage storageLoader
+}
+
+// isArchiveLocation returns true if passed baseLocation points to an archive
+func isArchiveLocation(baseLocation string) bool {
+	lower := strings.ToLower(baseLocation)
+	return strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz") || strings.HasSuffix(lower, ".zip")
+}
+
+// GetSourceMigrations returns all migrations from archive, archive is verified before any migration is read
+func (al *archiveLoader) GetSourceMigrations() ([]types.Migration, error) {
+	migrations := []types.Migration{}
+
+	contents, err := al.storage.readFile(al.config.BaseLocation)
+	if err != nil {
+		return nil, &types.SourceError{Location: al.config.BaseLocation, Err: err}
+	}
+
+	if al.config.ArchiveVerification == config.ArchiveVerificationChecksum {
+		if err := al.verifyChecksum(contents); err != nil {
+			return nil, &types.SourceError{Location: al.config.BaseLocation + archiveChecksumSuffix, Err: err}
+		}
+	}
+
+	files, err := al.extract(contents)
+	if err != nil {
+		return nil, &types.SourceError{Location: al.config.BaseLocation, Err: err}
+	}
+
+	if al.config.ArchiveVerification == config.ArchiveVerificationManifest {
+		if err := al.verifyManifest(files); err != nil {
+			return nil, &types.SourceError{Location: fmt.Sprintf("%s/%s", al.config.BaseLocation, archiveManifestFile), Err: err}
+		}
+	}
+
+	migrationsMap := make(map[string][]types.Migration)
+	if err := al.readFromDirs(files, migrationsMap, al.config.SingleMigrations, types.MigrationTypeSingleMigration); err != nil {
+		return nil, err
+	}
+	if err := al.readFromDirs(files, migrationsMap, al.config.TenantMigrations, types.MigrationTypeTenantMigration); err != nil {
+		return nil, err
+	}
+	al.sortMigrations(migrationsMap, &migrations)
+
+	migrationsMap = make(map[string][]types.Migration)
+	if err := al.readFromDirs(files, migrationsMap, al.config.SingleScripts, types.MigrationTypeSingleScript); err != nil {
+		return nil, err
+	}
+	al.sortMigrations(migrationsMap, &migrations)
+
+	migrationsMap = make(map[string][]types.Migration)
+	if err := al.readFromDirs(files, migrationsMap, al.config.TenantScripts, types.MigrationTypeTenantScript); err != nil {
+		return nil, err
+	}
+	al.sortMigrations(migrationsMap, &migrations)
+
+	return migrations, nil
+}
+
+// HealthCheck checks if archive exists in the storage
+func (al *archiveLoader) HealthCheck() error {
+	return al.storage.HealthCheck()
+}
+
+// verifyChecksum compares SHA-256 of the archive with the detached checksum file
+// checksum file uses sha256sum format, only the first field is used
+func (al *archiveLoader) verifyChecksum(contents []byte) error {
+	checksumFile, err := al.storage.readFile(al.config.BaseLocation + archiveChecksumSuffix)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(string(checksumFile))
+	if len(fields) == 0 {
+		return errors.New("checksum file is empty")
+	}
+	expected := strings.ToLower(fields[0])
+	actual := checkSum(contents)
+	if expected != actual {
+		return fmt.Errorf("archive checksum mismatch, expected %v, got %v", expected, actual)
+	}
+	return nil
+}
+
+// verifyManifest checks that every file in the archive is listed in the manifest with correct SHA-256
+// and that every file listed in the manifest exists in the archive
+// manifest uses sha256sum format: <checksum> <file>
+func (al *archiveLoader) verifyManifest(files map[string][]byte) error {
+	manifest, ok := files[archiveManifestFile]
+	if !ok {
+		return errors.New("manifest not found in archive")
+	}
+
+	expected := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(manifest))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return fmt.Errorf("invalid manifest line: %v", line)
+		}
+		// sha256sum marks files read in binary mode with *
+		expected[cleanArchivePath(strings.TrimPrefix(fields[1], "*"))] = strings.ToLower(fields[0])
+	}
+
+	for name, contents := range files {
+		if name == archiveManifestFile {
+			continue
+		}
+		sum, ok := expected[name]
+		if !ok {
+			return fmt.Errorf("file %v not listed in manifest", name)
+		}
+		if actual := checkSum(contents); sum != actual {
+			return fmt.Errorf("checksum mismatch for file %v, expected %v, got %v", name, sum, actual)
+		}
+	}
+	for name := range expected {
+		if _, ok := files[name]; !ok {
+			return fmt.Errorf("file %v listed in manifest not found in archive", name)
+		}
+	}
+
+	return nil
+}
+
+// extract returns all regular files from the archive, keys are slash-separated paths relative to the archive root
+func (al *archiveLoader) extract(contents []byte) (map[string][]byte, error) {
+	if strings.HasSuffix(strings.ToLower(al.config.BaseLocation), ".zip") {
+		return extractZip(contents)
+	}
+	return extractTarGz(contents)
+}
+
+func extractTarGz(contents []byte) (map[string][]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(contents))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	files := map[string][]byte{}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		file, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files[cleanArchivePath(header.Name)] = file
+	}
+	return files, nil
+}
+
+func extractZip(contents []byte) (map[string][]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(contents), int64(len(contents)))
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string][]byte{}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		file, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		files[cleanArchivePath(f.Name)] = file
+	}
+	return files, nil
+}
+
+func cleanArchivePath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// location returns location of the directory which contains the archive
+// migrations are identified as if the archive was extracted next to it so that the archive can be renamed for every release
+func (al *archiveLoader) location() string {
+	if _, ok := al.storage.(*diskLoader); ok {
+		if absLocation, err := filepath.Abs(al.config.BaseLocation); err == nil {
+			return filepath.Dir(absLocation)
+		}
+	}
+	// path.Dir would clean scheme separator of s3:// and https:// locations
+	return al.config.BaseLocation[:strings.LastIndex(al.config.BaseLocation, "/")]
+}
+
+// readFromDirs reads files placed in passed dirs, same as diskLoader it reads subdirectories only when recursive is enabled
+func (al *archiveLoader) readFromDirs(files map[string][]byte, migrations map[string][]types.Migration, dirs []string, migrationType types.MigrationType) error {
+	location := al.location()
+	for _, dir := range dirs {
+		dir = cleanArchivePath(dir)
+		sourceDir := fmt.Sprintf("%s/%s", location, dir)
+
+		names := []string{}
+		dirExists := false
+		for name := range files {
+			if strings.HasPrefix(name, dir+"/") {
+				dirExists = true
+				if fileName, ok := al.objectName(dir+"/", name); ok && al.includeFile(path.Base(fileName), fmt.Sprintf("%s/%s", al.config.BaseLocation, name)) {
+					names = append(names, fileName)
+				}
+			}
+		}
+		if !dirExists {
+			return &types.SourceError{Location: fmt.Sprintf("%s/%s", al.config.BaseLocation, dir), Err: errors.New("directory not found in archive")}
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			contents := files[fmt.Sprintf("%s/%s", dir, name)]
+			m := types.Migration{Name: name, SourceDir: sourceDir, File: fmt.Sprintf("%s/%s", sourceDir, name), MigrationType: migrationType, Contents: string(contents), CheckSum: checkSum(contents)}
+
+			e, ok := migrations[m.Name]
+			if ok {
+				e = append(e, m)
+			} else {
+				e = []types.Migration{m}
+			}
+			migrations[m.Name] = e
+		}
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+package loader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lukaszbudnik/migrator/config"
+	"github.com/lukaszbudnik/migrator/types"
+)
+
+var archiveFiles = map[string]string{
+	"migrations/config/201602160001.sql":         "create schema config;",
+	"migrations/ref/201602160002.sql":            "create table ref.roles (id int);",
+	"migrations/ref/201602160002.down.sql":       "drop table ref.roles;",
+	"migrations/tenants/201602160002.sql":        "create table {schema}.users (id int);",
+	"migrations/tenants/nested/201602160009.sql": "select 1;",
+	"migrations/tenants-scripts/a.sql":           "select 1;",
+}
+
+func sortedNames(files map[string]string) []string {
+	names := []string{}
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newTarGz(t *testing.T, files map[string]string) []byte {
+	buf := new(bytes.Buffer)
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	for _, name := range sortedNames(files) {
+		assert.Nil(t, tw.WriteHeader(&tar.Header{Name: "./" + name, Mode: 0644, Size: int64(len(files[name])), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(files[name]))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, tw.Close())
+	assert.Nil(t, gz.Close())
+	return buf.Bytes()
+}
+
+func newZip(t *testing.T, files map[string]string) []byte {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, name := range sortedNames(files) {
+		w, err := zw.Create(name)
+		assert.Nil(t, err)
+		_, err = w.Write([]byte(files[name]))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, zw.Close())
+	return buf.Bytes()
+}
+
+// newManifest returns files with SHA256SUMS manifest listing all of them
+func newManifest(files map[string]string) map[string]string {
+	manifest := ""
+	withManifest := map[string]string{}
+	for _, name := range sortedNames(files) {
+		manifest += fmt.Sprintf("%v  %v\n", checkSum([]byte(files[name])), name)
+		withManifest[name] = files[name]
+	}
+	withManifest[archiveManifestFile] = manifest
+	return withManifest
+}
+
+func writeArchive(t *testing.T, name string, contents []byte) string {
+	location := filepath.Join(t.TempDir(), name)
+	assert.Nil(t, os.WriteFile(location, contents, 0644))
+	return location
+}
+
+func newArchiveLoader(baseLocation, archiveVerification string) Loader {
+	config := &config.Config{
+		BaseLocation:        baseLocation,
+		SingleMigrations:    []string{"migrations/config", "migrations/ref"},
+		TenantMigrations:    []string{"migrations/tenants"},
+		TenantScripts:       []string{"migrations/tenants-scripts"},
+		ArchiveVerification: archiveVerification,
+	}
+	return New(context.TODO(), config)
+}
+
+func assertArchiveMigrations(t *testing.T, dir string, migrations []types.Migration) {
+	assert.Len(t, migrations, 4)
+	// migrations are read as if the archive was extracted next to it
+	assert.Equal(t, filepath.Join(dir, "migrations/config/201602160001.sql"), migrations[0].File)
+	assert.Equal(t, filepath.Join(dir, "migrations/config"), migrations[0].SourceDir)
+	assert.Equal(t, "201602160001.sql", migrations[0].Name)
+	assert.Equal(t, "create schema config;", migrations[0].Contents)
+	assert.Equal(t, checkSum([]byte("create schema config;")), migrations[0].CheckSum)
+	assert.Equal(t, filepath.Join(dir, "migrations/ref/201602160002.sql"), migrations[1].File)
+	assert.Equal(t, "drop table ref.roles;", migrations[1].DownContents)
+	assert.Equal(t, filepath.Join(dir, "migrations/tenants/201602160002.sql"), migrations[2].File)
+	assert.Equal(t, types.MigrationTypeTenantMigration, migrations[2].MigrationType)
+	assert.Equal(t, filepath.Join(dir, "migrations/tenants-scripts/a.sql"), migrations[3].File)
+	assert.Equal(t, types.MigrationTypeTenantScript, migrations[3].MigrationType)
+}
+
+func TestArchiveGetSourceMigrationsTarGz(t *testing.T) {
+	location := writeArchive(t, "migrations-1.0.0.tar.gz", newTarGz(t, archiveFiles))
+
+	migrations, err := newArchiveLoader(location, "").GetSourceMigrations()
+	assert.Nil(t, err)
+	assertArchiveMigrations(t, filepath.Dir(location), migrations)
+}
+
+func TestArchiveGetSourceMigrationsZip(t *testing.T) {
+	location := writeArchive(t, "migrations-1.0.0.zip", newZip(t, archiveFiles))
+
+	migrations, err := newArchiveLoader(location, "").GetSourceMigrations()
+	assert.Nil(t, err)
+	assertArchiveMigrations(t, filepath.Dir(location), migrations)
+}
+
+func TestArchiveGetSourceMigrationsNonExistingMigrationsDirError(t *testing.T) {
+	location := writeArchive(t, "migrations.tgz", newTarGz(t, archiveFiles))
+
+	config := &config.Config{
+		BaseLocation:     location,
+		SingleMigrations: []string{"migrations/abcdef"},
+	}
+	migrations, err := New(context.TODO(), config).GetSourceMigrations()
+	assert.Nil(t, migrations)
+	assert.Equal(t, fmt.Sprintf("could not read source migrations from %v/migrations/abcdef: directory not found in archive", location), err.Error())
+}
+
+func TestArchiveGetSourceMigrationsNonExistingArchiveError(t *testing.T) {
+	migrations, err := newArchiveLoader("/path/to/migrations.zip", "").GetSourceMigrations()
+	assert.Nil(t, migrations)
+	var sourceErr *types.SourceError
+	assert.True(t, errors.As(err, &sourceErr))
+	assert.Equal(t, "/path/to/migrations.zip", sourceErr.Location)
+}
+
+func TestArchiveGetSourceMigrationsInvalidArchiveError(t *testing.T) {
+	location := writeArchive(t, "migrations.tar.gz", []byte("not an archive"))
+
+	migrations, err := newArchiveLoader(location, "").GetSourceMigrations()
+	assert.Nil(t, migrations)
+	assert.Contains(t, err.Error(), "gzip: invalid header")
+}
+
+func TestArchiveChecksumVerification(t *testing.T) {
+	contents := newTarGz(t, archiveFiles)
+	location := writeArchive(t, "migrations.tar.gz", contents)
+	assert.Nil(t, os.WriteFile(location+".sha256", []byte(fmt.Sprintf("%v  migrations.tar.gz\n", checkSum(contents))), 0644))
+
+	migrations, err := newArchiveLoader(location, config.ArchiveVerificationChecksum).GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 4)
+}
+
+func TestArchiveChecksumVerificationMismatchError(t *testing.T) {
+	location := writeArchive(t, "migrations.tar.gz", newTarGz(t, archiveFiles))
+	assert.Nil(t, os.WriteFile(location+".sha256", []byte(checkSum([]byte("abc"))), 0644))
+
+	migrations, err := newArchiveLoader(location, config.ArchiveVerificationChecksum).GetSourceMigrations()
+	assert.Nil(t, migrations)
+	assert.Contains(t, err.Error(), fmt.Sprintf("could not read source migrations from %v.sha256: archive checksum mismatch, expected %v", location, checkSum([]byte("abc"))))
+}
+
+func TestArchiveChecksumVerificationMissingChecksumFileError(t *testing.T) {
+	location := writeArchive(t, "migrations.tar.gz", newTarGz(t, archiveFiles))
+
+	migrations, err := newArchiveLoader(location, config.ArchiveVerificationChecksum).GetSourceMigrations()
+	assert.Nil(t, migrations)
+	assert.Contains(t, err.Error(), "migrations.tar.gz.sha256: no such file or directory")
+}
+
+func TestArchiveManifestVerification(t *testing.T) {
+	location := writeArchive(t, "migrations.zip", newZip(t, newManifest(archiveFiles)))
+
+	migrations, err := newArchiveLoader(location, config.ArchiveVerificationManifest).GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 4)
+}
+
+func TestArchiveManifestVerificationErrors(t *testing.T) {
+	tampered := newManifest(archiveFiles)
+	tampered["migrations/config/201602160001.sql"] = "drop schema config;"
+
+	unlisted := newManifest(archiveFiles)
+	unlisted["migrations/config/201602160003.sql"] = "select 1;"
+
+	missing := newManifest(archiveFiles)
+	delete(missing, "migrations/tenants-scripts/a.sql")
+
+	tests := []struct {
+		files map[string]string
+		err   string
+	}{
+		{archiveFiles, "manifest not found in archive"},
+		{tampered, "checksum mismatch for file migrations/config/201602160001.sql"},
+		{unlisted, "file migrations/config/201602160003.sql not listed in manifest"},
+		{missing, "file migrations/tenants-scripts/a.sql listed in manifest not found in archive"},
+	}
+
+	for _, test := range tests {
+		location := writeArchive(t, "migrations.tar.gz", newTarGz(t, test.files))
+		migrations, err := newArchiveLoader(location, config.ArchiveVerificationManifest).GetSourceMigrations()
+		assert.Nil(t, migrations)
+		assert.Contains(t, err.Error(), test.err)
+		var sourceErr *types.SourceError
+		assert.True(t, errors.As(err, &sourceErr))
+		assert.Equal(t, location+"/SHA256SUMS", sourceErr.Location)
+	}
+}
+
+type mockS3ArchiveClient struct {
+	S3APIClient
+	objects map[string][]byte
+}
+
+func (m *mockS3ArchiveClient) GetObject(ctx context.Context, input *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	object, ok := m.objects[*input.Bucket+"/"+*input.Key]
+	if !ok {
+		return nil, errors.New("NoSuchKey")
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(object))}, nil
+}
+
+func TestArchiveS3GetSourceMigrations(t *testing.T) {
+	contents := newTarGz(t, archiveFiles)
+	client := &mockS3ArchiveClient{objects: map[string][]byte{
+		"bucket/releases/migrations-1.0.0.tar.gz":        contents,
+		"bucket/releases/migrations-1.0.0.tar.gz.sha256": []byte(checkSum(contents)),
+	}}
+
+	config := &config.Config{
+		BaseLocation:        "s3://bucket/releases/migrations-1.0.0.tar.gz",
+		SingleMigrations:    []string{"migrations/config"},
+		ArchiveVerification: config.ArchiveVerificationChecksum,
+	}
+	loader := &archiveLoader{
+		baseLoader: baseLoader{context.TODO(), config},
+		storage:    &s3Loader{baseLoader: baseLoader{context.TODO(), config}, clientFactory: &mockS3ClientFactory{client: client}},
+	}
+
+	migrations, err := loader.GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 1)
+	assert.Equal(t, "s3://bucket/releases/migrations/config/201602160001.sql", migrations[0].File)
+	assert.Equal(t, "s3://bucket/releases/migrations/config", migrations[0].SourceDir)
+
+	config.BaseLocation = "s3://bucket/releases/migrations-1.0.1.tar.gz"
+	migrations, err = loader.GetSourceMigrations()
+	assert.Nil(t, migrations)
+	assert.Equal(t, "could not read source migrations from s3://bucket/releases/migrations-1.0.1.tar.gz: NoSuchKey", err.Error())
+}
+
+func TestArchiveHealthCheck(t *testing.T) {
+	location := writeArchive(t, "migrations.tar.gz", newTarGz(t, archiveFiles))
+
+	assert.Nil(t, newArchiveLoader(location, "").HealthCheck())
+	assert.NotNil(t, newArchiveLoader("/path/to/migrations.tar.gz", "").HealthCheck())
+}
+
+func TestArchiveGetSourceMigrationsRecursive(t *testing.T) {
+	location := writeArchive(t, "migrations-1.0.0.tar.gz", newTarGz(t, archiveFiles))
+
+	config := &config.Config{
+		BaseLocation:     location,
+		TenantMigrations: []string{"migrations/tenants"},
+		Recursive:        true,
+	}
+	migrations, err := New(context.TODO(), config).GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 2)
+	assert.Equal(t, "201602160002.sql", migrations[0].Name)
+	assert.Equal(t, "nested/201602160009.sql", migrations[1].Name)
+	assert.Equal(t, filepath.Join(filepath.Dir(location), "migrations/tenants"), migrations[1].SourceDir)
+	assert.Equal(t, "select 1;", migrations[1].Contents)
+}
@@ -7,11 +7,13 @@ import (
 	"fmt"
 	"io"
 	"net/url"
+	"path"
 	"strings"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
 
 	"github.com/lukaszbudnik/migrator/types"
 )
@@ -72,7 +74,7 @@ func (abl *azureBlobLoader) getClientFactory() AzureBlobClientFactory {
 }
 
 // GetSourceMigrations returns all migrations from Azure Blob location
-func (abl *azureBlobLoader) GetSourceMigrations() []types.Migration {
+func (abl *azureBlobLoader) GetSourceMigrations() ([]types.Migration, error) {
 	// migrator expects that container as a part of the service url
 	// the URL can contain optional prefixes like prod/artefacts
 	// for example:
@@ -80,42 +82,72 @@ func (abl *azureBlobLoader) GetSourceMigrations() []types.Migration {
 	// https://lukaszbudniktest.blob.core.windows.net/mycontainer/prod/artefacts/
 
 	// Parse URL to extract service URL and container name
-	serviceURL, containerName, optionalPrefixes := abl.parseBaseLocation()
+	serviceURL, containerName, optionalPrefixes, err := abl.parseBaseLocation()
+	if err != nil {
+		return nil, &types.SourceError{Location: abl.config.BaseLocation, Err: err}
+	}
 
 	client, err := abl.getClientFactory().NewClient(abl.ctx, serviceURL, containerName)
 	if err != nil {
-		panic(err.Error())
+		return nil, &types.SourceError{Location: abl.config.BaseLocation, Err: err}
 	}
 
 	return abl.doGetSourceMigrations(client, containerName, optionalPrefixes)
 }
 
-func (abl *azureBlobLoader) doGetSourceMigrations(client AzureBlobClient, containerName, optionalPrefixes string) []types.Migration {
+func (abl *azureBlobLoader) doGetSourceMigrations(client AzureBlobClient, containerName, optionalPrefixes string) ([]types.Migration, error) {
 	migrations := []types.Migration{}
 
-	singleMigrationsObjects := abl.getObjectList(client, containerName, optionalPrefixes, abl.config.SingleMigrations)
-	tenantMigrationsObjects := abl.getObjectList(client, containerName, optionalPrefixes, abl.config.TenantMigrations)
-	singleScriptsObjects := abl.getObjectList(client, containerName, optionalPrefixes, abl.config.SingleScripts)
-	tenantScriptsObjects := abl.getObjectList(client, containerName, optionalPrefixes, abl.config.TenantScripts)
+	singleMigrationsObjects, err := abl.getObjectList(client, containerName, optionalPrefixes, abl.config.SingleMigrations)
+	if err != nil {
+		return nil, err
+	}
+	tenantMigrationsObjects, err := abl.getObjectList(client, containerName, optionalPrefixes, abl.config.TenantMigrations)
+	if err != nil {
+		return nil, err
+	}
+	singleScriptsObjects, err := abl.getObjectList(client, containerName, optionalPrefixes, abl.config.SingleScripts)
+	if err != nil {
+		return nil, err
+	}
+	tenantScriptsObjects, err := abl.getObjectList(client, containerName, optionalPrefixes, abl.config.TenantScripts)
+	if err != nil {
+		return nil, err
+	}
 
 	migrationsMap := make(map[string][]types.Migration)
-	abl.getObjects(client, containerName, migrationsMap, singleMigrationsObjects, types.MigrationTypeSingleMigration)
-	abl.getObjects(client, containerName, migrationsMap, tenantMigrationsObjects, types.MigrationTypeTenantMigration)
+	if err := abl.getObjects(client, containerName, migrationsMap, singleMigrationsObjects, types.MigrationTypeSingleMigration); err != nil {
+		return nil, err
+	}
+	if err := abl.getObjects(client, containerName, migrationsMap, tenantMigrationsObjects, types.MigrationTypeTenantMigration); err != nil {
+		return nil, err
+	}
 	abl.sortMigrations(migrationsMap, &migrations)
 
 	migrationsMap = make(map[string][]types.Migration)
-	abl.getObjects(client, containerName, migrationsMap, singleScriptsObjects, types.MigrationTypeSingleScript)
+	if err := abl.getObjects(client, containerName, migrationsMap, singleScriptsObjects, types.MigrationTypeSingleScript); err != nil {
+		return nil, err
+	}
 	abl.sortMigrations(migrationsMap, &migrations)
 
 	migrationsMap = make(map[string][]types.Migration)
-	abl.getObjects(client, containerName, migrationsMap, tenantScriptsObjects, types.MigrationTypeTenantScript)
+	if err := abl.getObjects(client, containerName, migrationsMap, tenantScriptsObjects, types.MigrationTypeTenantScript); err != nil {
+		return nil, err
+	}
 	abl.sortMigrations(migrationsMap, &migrations)
 
-	return migrations
+	return migrations, nil
 }
 
-func (abl *azureBlobLoader) getObjectList(client AzureBlobClient, containerName, optionalPrefixes string, prefixes []string) []string {
-	objects := []string{}
+// azureBlob is a blob listed in source dir, name is its name relative to source dir
+type azureBlob struct {
+	*container.BlobItem
+	name string
+}
+
+// getObjectList lists blobs placed in passed dirs and their subdirectories, see prefixObjectName
+func (abl *azureBlobLoader) getObjectList(client AzureBlobClient, containerName, optionalPrefixes string, prefixes []string) ([]azureBlob, error) {
+	objects := []azureBlob{}
 
 	for _, prefix := range prefixes {
 		var fullPrefix string
@@ -132,40 +164,50 @@ func (abl *azureBlobLoader) getObjectList(client AzureBlobClient, containerName,
 		for pager.More() {
 			page, err := pager.NextPage(abl.ctx)
 			if err != nil {
-				panic(err.Error())
+				return nil, &types.SourceError{Location: fmt.Sprintf("%s/%s", containerName, fullPrefix), Err: err}
 			}
 
 			for _, blob := range page.Segment.BlobItems {
-				if blob.Name != nil {
-					objects = append(objects, *blob.Name)
+				if blob.Name == nil {
+					continue
+				}
+				name, ok := abl.prefixObjectName(fullPrefix, *blob.Name)
+				if ok && abl.includeFile(path.Base(name), fmt.Sprintf("%s/%s", containerName, *blob.Name)) {
+					objects = append(objects, azureBlob{blob, name})
 				}
 			}
 		}
 	}
 
-	return objects
+	return objects, nil
 }
 
-func (abl *azureBlobLoader) getObjects(client AzureBlobClient, containerName string, migrationsMap map[string][]types.Migration, objects []string, migrationType types.MigrationType) {
-	for _, o := range objects {
-		response, err := client.DownloadStream(abl.ctx, containerName, o, nil)
-		if err != nil {
-			panic(err.Error())
+// getObjects downloads blobs, blobs which did not change are read from source cache
+func (abl *azureBlobLoader) getObjects(client AzureBlobClient, containerName string, migrationsMap map[string][]types.Migration, blobs []azureBlob, migrationType types.MigrationType) error {
+	for _, blob := range blobs {
+		o := *blob.Name
+		version := ""
+		if blob.Properties != nil && blob.Properties.ETag != nil {
+			version = string(*blob.Properties.ETag)
 		}
 
-		contents, err := io.ReadAll(response.Body)
+		contents, err := abl.readCached(fmt.Sprintf("%s/%s", containerName, o), version, func() ([]byte, error) {
+			response, err := client.DownloadStream(abl.ctx, containerName, o, nil)
+			if err != nil {
+				return nil, err
+			}
+			defer response.Body.Close()
+			return io.ReadAll(response.Body)
+		})
 		if err != nil {
-			panic(err.Error())
+			return &types.SourceError{Location: fmt.Sprintf("%s/%s", containerName, o), Err: err}
 		}
-		response.Body.Close()
 
 		hasher := sha256.New()
 		hasher.Write(contents)
 		file := fmt.Sprintf("%s/%s", abl.config.BaseLocation, o)
-		from := strings.LastIndex(file, "/")
-		sourceDir := file[0:from]
-		name := file[from+1:]
-		m := types.Migration{Name: name, SourceDir: sourceDir, File: file, MigrationType: migrationType, Contents: string(contents), CheckSum: hex.EncodeToString(hasher.Sum(nil))}
+		sourceDir := strings.TrimSuffix(file, "/"+blob.name)
+		m := types.Migration{Name: blob.name, SourceDir: sourceDir, File: file, MigrationType: migrationType, Contents: string(contents), CheckSum: hex.EncodeToString(hasher.Sum(nil))}
 
 		e, ok := migrationsMap[m.Name]
 		if ok {
@@ -175,10 +217,14 @@ func (abl *azureBlobLoader) getObjects(client AzureBlobClient, containerName str
 		}
 		migrationsMap[m.Name] = e
 	}
+	return nil
 }
 
 func (abl *azureBlobLoader) HealthCheck() error {
-	serviceURL, containerName, prefix := abl.parseBaseLocation()
+	serviceURL, containerName, prefix, err := abl.parseBaseLocation()
+	if err != nil {
+		return err
+	}
 
 	client, err := abl.getClientFactory().NewClient(abl.ctx, serviceURL, containerName)
 	if err != nil {
@@ -197,11 +243,36 @@ func (abl *azureBlobLoader) HealthCheck() error {
 	return nil
 }
 
-func (abl *azureBlobLoader) parseBaseLocation() (string, string, string) {
-	baseLocation := strings.TrimSpace(abl.config.BaseLocation)
-	u, err := url.Parse(baseLocation)
+// readFile reads a single blob, location is the blob URL
+func (abl *azureBlobLoader) readFile(location string) ([]byte, error) {
+	serviceURL, containerName, blobName, err := abl.parseLocation(location)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := abl.getClientFactory().NewClient(abl.ctx, serviceURL, containerName)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := client.DownloadStream(abl.ctx, containerName, blobName, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	return io.ReadAll(response.Body)
+}
+
+func (abl *azureBlobLoader) parseBaseLocation() (string, string, string, error) {
+	return abl.parseLocation(abl.config.BaseLocation)
+}
+
+// parseLocation returns service URL, container name and optional prefixes (or blob name) of passed location
+func (abl *azureBlobLoader) parseLocation(location string) (string, string, string, error) {
+	u, err := url.Parse(strings.TrimSpace(location))
 	if err != nil {
-		panic(err)
+		return "", "", "", err
 	}
 
 	serviceURL := fmt.Sprintf("%s://%s", u.Scheme, u.Host)
@@ -218,5 +289,5 @@ func (abl *azureBlobLoader) parseBaseLocation() (string, string, string) {
 		optionalPrefixes = strings.Join(pathComponents[1:], "/")
 	}
 
-	return serviceURL, containerName, optionalPrefixes
+	return serviceURL, containerName, optionalPrefixes, nil
 }
@@ -5,11 +5,15 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"io"
+	"sort"
 	"strings"
 	"testing"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
 	"github.com/lukaszbudnik/migrator/config"
 	"github.com/lukaszbudnik/migrator/types"
 	"github.com/stretchr/testify/assert"
@@ -244,3 +248,98 @@ func (m *mockAzureBlobLoader) mockHealthCheck() error {
 	// Mock health check always returns nil (healthy)
 	return nil
 }
+
+type mockAzureBlobListClient struct {
+	blobs map[string]string
+}
+
+func (m *mockAzureBlobListClient) NewListBlobsFlatPager(containerName string, options *azblob.ListBlobsFlatOptions) *runtime.Pager[azblob.ListBlobsFlatResponse] {
+	return runtime.NewPager(runtime.PagingHandler[azblob.ListBlobsFlatResponse]{
+		More: func(azblob.ListBlobsFlatResponse) bool {
+			return false
+		},
+		Fetcher: func(context.Context, *azblob.ListBlobsFlatResponse) (azblob.ListBlobsFlatResponse, error) {
+			items := []*container.BlobItem{}
+			names := []string{}
+			for name := range m.blobs {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				if strings.HasPrefix(name, *options.Prefix) {
+					items = append(items, &container.BlobItem{Name: to.Ptr(name)})
+				}
+			}
+			response := azblob.ListBlobsFlatResponse{}
+			response.Segment = &container.BlobFlatListSegment{BlobItems: items}
+			return response, nil
+		},
+	})
+}
+
+func (m *mockAzureBlobListClient) DownloadStream(ctx context.Context, containerName, blobName string, options *azblob.DownloadStreamOptions) (azblob.DownloadStreamResponse, error) {
+	response := azblob.DownloadStreamResponse{}
+	response.Body = io.NopCloser(strings.NewReader(m.blobs[blobName]))
+	return response, nil
+}
+
+func TestAzureGetSourceMigrationsIncludeExcludePatterns(t *testing.T) {
+	client := &mockAzureBlobListClient{blobs: map[string]string{
+		"migrations/config/201602160001.sql":      "create schema config;",
+		"migrations/config/201602160001.down.sql": "drop schema config;",
+		"migrations/config/README.md":             "# config",
+		"migrations/config/201602160002.sql.bak":  "select 1;",
+	}}
+
+	config := &config.Config{
+		BaseLocation:     "https://storageaccountname.blob.core.windows.net/mycontainer",
+		SingleMigrations: []string{"migrations/config"},
+		ExcludePatterns:  []string{"*.down.sql"},
+	}
+
+	loader := &azureBlobLoader{
+		baseLoader:    baseLoader{context.TODO(), config},
+		clientFactory: &mockAzureBlobClientFactory{client: client},
+	}
+
+	migrations, err := loader.GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 1)
+	assert.Equal(t, "https://storageaccountname.blob.core.windows.net/mycontainer/migrations/config/201602160001.sql", migrations[0].File)
+	assert.Equal(t, "create schema config;", migrations[0].Contents)
+	assert.Equal(t, "", migrations[0].DownContents)
+}
+
+func TestAzureGetSourceMigrationsRecursive(t *testing.T) {
+	client := &mockAzureBlobListClient{blobs: map[string]string{
+		"migrations/ref/202403010001.sql":         "select 1;",
+		"migrations/ref/2024/01/202401010001.sql": "select 2;",
+		"migrations/ref/2024/02/":                 "",
+		"migrations/ref-scripts/cleanup.sql":      "select 4;",
+	}}
+
+	config := &config.Config{
+		BaseLocation:     "https://storageaccountname.blob.core.windows.net/mycontainer",
+		SingleMigrations: []string{"migrations/ref"},
+	}
+	loader := &azureBlobLoader{baseLoader: baseLoader{context.TODO(), config}, clientFactory: &mockAzureBlobClientFactory{client: client}}
+
+	// blobs in subdirectories are read using their file names like in previous versions, blobs in other dirs with the same prefix are skipped
+	migrations, err := loader.GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 2)
+	assert.Equal(t, "202401010001.sql", migrations[0].Name)
+	assert.Equal(t, "https://storageaccountname.blob.core.windows.net/mycontainer/migrations/ref/2024/01", migrations[0].SourceDir)
+	assert.Equal(t, "https://storageaccountname.blob.core.windows.net/mycontainer/migrations/ref/2024/01/202401010001.sql", migrations[0].File)
+	assert.Equal(t, "select 2;", migrations[0].Contents)
+	assert.Equal(t, "202403010001.sql", migrations[1].Name)
+
+	config.Recursive = true
+	migrations, err = loader.GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 2)
+	assert.Equal(t, "2024/01/202401010001.sql", migrations[0].Name)
+	assert.Equal(t, "https://storageaccountname.blob.core.windows.net/mycontainer/migrations/ref", migrations[0].SourceDir)
+	assert.Equal(t, "https://storageaccountname.blob.core.windows.net/mycontainer/migrations/ref/2024/01/202401010001.sql", migrations[0].File)
+	assert.Equal(t, "202403010001.sql", migrations[1].Name)
+}
@@ -0,0 +1,196 @@
+package loader
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lukaszbudnik/migrator/common"
+	"github.com/lukaszbudnik/migrator/config"
+	"github.com/lukaszbudnik/migrator/types"
+)
+
+// Refresher is implemented by loaders which cache source migrations
+type Refresher interface {
+	// Refresh drops cached source migrations and loads them again
+	Refresh() ([]types.Migration, error)
+}
+
+// sourceCache caches source migrations in process, new loader is created for every request so the cache is shared by all of them
+var sourceCache = &migrationsCache{entries: map[string]*cacheEntry{}}
+
+type migrationsCache struct {
+	mutex   sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+// cacheEntry holds source migrations loaded using the same config
+// objects are contents of files keyed by their location, they are reused when file version did not change
+type cacheEntry struct {
+	// mutex serialises loading of source migrations using the same config
+	mutex      sync.Mutex
+	loadedAt   time.Time
+	migrations []types.Migration
+
+	// objectsMutex guards objects read concurrently by loaders
+	objectsMutex sync.Mutex
+	objects      map[string]cachedObject
+	loading      map[string]cachedObject
+}
+
+// cachedObject is contents of a file, version is ETag, last modified timestamp, or modification time and size
+type cachedObject struct {
+	version  string
+	contents []byte
+}
+
+// cacheKey returns key of cache entry for passed config, only properties which change what loaders read are part of the key
+func cacheKey(config *config.Config) string {
+	return strings.Join([]string{
+		config.BaseLocation,
+		strings.Join(config.SingleMigrations, ","),
+		strings.Join(config.TenantMigrations, ","),
+		strings.Join(config.SingleScripts, ","),
+		strings.Join(config.TenantScripts, ","),
+		config.ArchiveVerification,
+		strconv.FormatBool(config.Recursive),
+		strings.Join(config.SignaturePublicKeys, ","),
+		strings.Join(config.IncludePatterns, ","),
+		strings.Join(config.ExcludePatterns, ","),
+		config.S3Endpoint,
+		config.S3Region,
+		strconv.FormatBool(config.S3ForcePathStyle),
+		config.S3Profile,
+		config.GCSEndpoint,
+		strings.Join(config.HTTPHeaders, ","),
+	}, "|")
+}
+
+// sourceCacheTTL returns TTL of cached source migrations, 0 means source migrations are not cached
+func sourceCacheTTL(config *config.Config) time.Duration {
+	if config.SourceCacheTTL == "" {
+		return 0
+	}
+	ttl, _ := time.ParseDuration(config.SourceCacheTTL)
+	return ttl
+}
+
+func (c *migrationsCache) entry(key string) *cacheEntry {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		e = &cacheEntry{}
+		c.entries[key] = e
+	}
+	return e
+}
+
+func (c *migrationsCache) get(key string) *cacheEntry {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.entries[key]
+}
+
+// cachingLoader is Loader which caches source migrations loaded by wrapped loader for sourceCacheTTL
+// when TTL expired source migrations are loaded again but only files which changed since they were cached are read
+type cachingLoader struct {
+	Loader
+	key string
+	ttl time.Duration
+}
+
+// GetSourceMigrations returns cached source migrations, or loads them when they expired
+func (cl *cachingLoader) GetSourceMigrations() ([]types.Migration, error) {
+	return cl.load(false)
+}
+
+// Refresh drops cached source migrations and files and loads them again
+func (cl *cachingLoader) Refresh() ([]types.Migration, error) {
+	return cl.load(true)
+}
+
+func (cl *cachingLoader) load(refresh bool) ([]types.Migration, error) {
+	e := sourceCache.entry(cl.key)
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if refresh {
+		e.loadedAt = time.Time{}
+		e.objectsMutex.Lock()
+		e.objects = nil
+		e.objectsMutex.Unlock()
+	}
+
+	if !e.loadedAt.IsZero() && time.Since(e.loadedAt) < cl.ttl {
+		return copyMigrations(e.migrations), nil
+	}
+
+	e.objectsMutex.Lock()
+	e.loading = map[string]cachedObject{}
+	e.objectsMutex.Unlock()
+
+	migrations, err := cl.Loader.GetSourceMigrations()
+
+	e.objectsMutex.Lock()
+	// files which were not read are removed from cache
+	if err == nil {
+		e.objects = e.loading
+	}
+	e.loading = nil
+	e.objectsMutex.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	e.loadedAt = time.Now()
+	e.migrations = migrations
+	return copyMigrations(migrations), nil
+}
+
+func copyMigrations(migrations []types.Migration) []types.Migration {
+	return append([]types.Migration{}, migrations...)
+}
+
+// readCached returns contents of file from cache if its version did not change, otherwise file is read using passed function
+// files without version and files read outside of cachingLoader are not cached
+func (bl *baseLoader) readCached(location, version string, read func() ([]byte, error)) ([]byte, error) {
+	e := sourceCache.get(cacheKey(bl.config))
+	if version == "" || sourceCacheTTL(bl.config) == 0 || e == nil {
+		return read()
+	}
+
+	e.objectsMutex.Lock()
+	cached, ok := e.objects[location]
+	loading := e.loading != nil
+	e.objectsMutex.Unlock()
+
+	if !loading {
+		return read()
+	}
+
+	if !ok || cached.version != version {
+		contents, err := read()
+		if err != nil {
+			return nil, err
+		}
+		common.LogDebug(bl.ctx, "Read changed source migration %v", location)
+		cached = cachedObject{version: version, contents: contents}
+	}
+
+	e.objectsMutex.Lock()
+	if e.loading != nil {
+		e.loading[location] = cached
+	}
+	e.objectsMutex.Unlock()
+
+	return cached.contents, nil
+}
+
+// fileVersion returns version of local file using its modification time and size
+func fileVersion(modTime time.Time, size int64) string {
+	return fmt.Sprintf("%v-%v", modTime.UnixNano(), size)
+}
@@ -0,0 +1,225 @@
+package loader
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lukaszbudnik/migrator/config"
+)
+
+func writeMigration(t *testing.T, file, contents string, modTime time.Time) {
+	assert.Nil(t, os.WriteFile(file, []byte(contents), 0644))
+	assert.Nil(t, os.Chtimes(file, modTime, modTime))
+}
+
+func TestNewCachingLoader(t *testing.T) {
+	config := &config.Config{
+		BaseLocation:   "/path/to/migrations",
+		SourceCacheTTL: "5m",
+	}
+	loader := New(context.TODO(), config)
+	assert.IsType(t, &cachingLoader{}, loader)
+	assert.IsType(t, &diskLoader{}, loader.(*cachingLoader).Loader)
+	assert.Implements(t, (*Refresher)(nil), loader)
+}
+
+func TestCacheKey(t *testing.T) {
+	base := config.Config{BaseLocation: "s3://your-bucket-migrator", SingleMigrations: []string{"migrations/config"}}
+	key := cacheKey(&base)
+
+	// every property which changes what loaders read is a part of the key
+	changes := []func(*config.Config){
+		func(c *config.Config) { c.IncludePatterns = []string{"*.sql"} },
+		func(c *config.Config) { c.ExcludePatterns = []string{"*.draft.sql"} },
+		func(c *config.Config) { c.S3Endpoint = "http://localhost:9000" },
+		func(c *config.Config) { c.S3Region = "us-east-1" },
+		func(c *config.Config) { c.S3ForcePathStyle = true },
+		func(c *config.Config) { c.S3Profile = "migrator" },
+		func(c *config.Config) { c.GCSEndpoint = "http://localhost:4443" },
+		func(c *config.Config) { c.HTTPHeaders = []string{"Authorization: Bearer token"} },
+	}
+	for i, change := range changes {
+		changed := base
+		change(&changed)
+		assert.NotEqual(t, key, cacheKey(&changed), i)
+	}
+
+	// properties not used by loaders are not
+	changed := base
+	changed.TenantConcurrency = 10
+	assert.Equal(t, key, cacheKey(&changed))
+}
+
+func TestCachingLoaderTTLAndRefresh(t *testing.T) {
+	dir := t.TempDir()
+	assert.Nil(t, os.Mkdir(filepath.Join(dir, "ref"), 0755))
+	modTime := time.Now().Add(-time.Hour)
+	writeMigration(t, filepath.Join(dir, "ref", "201602160001.sql"), "select 1;", modTime)
+
+	config := &config.Config{
+		BaseLocation:     dir,
+		SingleMigrations: []string{"ref"},
+		SourceCacheTTL:   "1h",
+	}
+
+	migrations, err := New(context.TODO(), config).GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 1)
+
+	// new loader is created for every request, cached migrations are returned until TTL expires
+	writeMigration(t, filepath.Join(dir, "ref", "201602160002.sql"), "select 2;", modTime)
+	migrations, err = New(context.TODO(), config).GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 1)
+
+	migrations, err = New(context.TODO(), config).(Refresher).Refresh()
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 2)
+}
+
+func TestCachingLoaderDiskChangeDetection(t *testing.T) {
+	dir := t.TempDir()
+	assert.Nil(t, os.Mkdir(filepath.Join(dir, "ref"), 0755))
+	modTime := time.Now().Add(-time.Hour)
+	file := filepath.Join(dir, "ref", "201602160001.sql")
+	writeMigration(t, file, "select 1;", modTime)
+
+	config := &config.Config{
+		BaseLocation:     dir,
+		SingleMigrations: []string{"ref"},
+		// expires immediately, files are listed every time
+		SourceCacheTTL: "1ns",
+	}
+
+	migrations, err := New(context.TODO(), config).GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Equal(t, "select 1;", migrations[0].Contents)
+
+	// same modification time and size, file is not read again
+	writeMigration(t, file, "select 2;", modTime)
+	migrations, err = New(context.TODO(), config).GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Equal(t, "select 1;", migrations[0].Contents)
+
+	writeMigration(t, file, "select 2;", modTime.Add(time.Minute))
+	migrations, err = New(context.TODO(), config).GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Equal(t, "select 2;", migrations[0].Contents)
+	assert.Equal(t, checkSum([]byte("select 2;")), migrations[0].CheckSum)
+}
+
+func TestCachingLoaderErrorNotCached(t *testing.T) {
+	dir := t.TempDir()
+
+	config := &config.Config{
+		BaseLocation:     dir,
+		SingleMigrations: []string{"ref"},
+		SourceCacheTTL:   "1h",
+	}
+
+	migrations, err := New(context.TODO(), config).GetSourceMigrations()
+	assert.Nil(t, migrations)
+	assert.NotNil(t, err)
+
+	assert.Nil(t, os.Mkdir(filepath.Join(dir, "ref"), 0755))
+	migrations, err = New(context.TODO(), config).GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 0)
+}
+
+type mockS3ETagObject struct {
+	etag     string
+	contents string
+}
+
+type mockS3ETagClient struct {
+	mutex   sync.Mutex
+	objects map[string]mockS3ETagObject
+	gets    int
+}
+
+func (m *mockS3ETagClient) ListObjectsV2(ctx context.Context, input *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	contents := []s3types.Object{}
+	for _, key := range sortedKeys(m.objects) {
+		if strings.HasPrefix(key, *input.Prefix) {
+			contents = append(contents, s3types.Object{Key: aws.String(key), ETag: aws.String(m.objects[key].etag)})
+		}
+	}
+	return &s3.ListObjectsV2Output{Contents: contents}, nil
+}
+
+func (m *mockS3ETagClient) GetObject(ctx context.Context, input *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.gets++
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader([]byte(m.objects[*input.Key].contents)))}, nil
+}
+
+func sortedKeys(objects map[string]mockS3ETagObject) []string {
+	keys := []string{}
+	for key := range objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestCachingLoaderS3ChangeDetection(t *testing.T) {
+	client := &mockS3ETagClient{objects: map[string]mockS3ETagObject{
+		"migrations/config/201602160001.sql": {"etag-1", "create schema config;"},
+		"migrations/config/201602160002.sql": {"etag-2", "create table config.settings (k text);"},
+	}}
+
+	config := &config.Config{
+		BaseLocation:     "s3://your-bucket-migrator-cache",
+		SingleMigrations: []string{"migrations/config"},
+		SourceCacheTTL:   "1ns",
+	}
+	newCachingLoader := func() Loader {
+		return &cachingLoader{
+			Loader: &s3Loader{baseLoader: baseLoader{context.TODO(), config}, clientFactory: &mockS3ClientFactory{client: client}},
+			key:    cacheKey(config),
+			ttl:    sourceCacheTTL(config),
+		}
+	}
+
+	migrations, err := newCachingLoader().GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 2)
+	assert.Equal(t, 2, client.gets)
+
+	// nothing changed, objects are only listed
+	migrations, err = newCachingLoader().GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 2)
+	assert.Equal(t, 2, client.gets)
+
+	client.objects["migrations/config/201602160002.sql"] = mockS3ETagObject{"etag-3", "create table config.settings (k text, v text);"}
+	client.objects["migrations/config/201602160003.sql"] = mockS3ETagObject{"etag-4", "select 1;"}
+	migrations, err = newCachingLoader().GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 3)
+	assert.Equal(t, "create table config.settings (k text, v text);", migrations[1].Contents)
+	assert.Equal(t, 4, client.gets)
+
+	// refresh reads all objects again
+	migrations, err = newCachingLoader().(Refresher).Refresh()
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 3)
+	assert.Equal(t, 7, client.gets)
+}
@@ -4,9 +4,9 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
-	"strings"
 
 	"github.com/lukaszbudnik/migrator/types"
 )
@@ -17,12 +17,12 @@ type diskLoader struct {
 }
 
 // GetSourceMigrations returns all migrations from disk
-func (dl *diskLoader) GetSourceMigrations() []types.Migration {
+func (dl *diskLoader) GetSourceMigrations() ([]types.Migration, error) {
 	migrations := []types.Migration{}
 
 	absBaseDir, err := filepath.Abs(dl.config.BaseLocation)
 	if err != nil {
-		panic(fmt.Sprintf("Could not convert baseLocation to absolute path: %v", err.Error()))
+		return nil, &types.SourceError{Location: dl.config.BaseLocation, Err: fmt.Errorf("could not convert baseLocation to absolute path: %w", err)}
 	}
 
 	singleMigrationsDirs := dl.getDirs(absBaseDir, dl.config.SingleMigrations)
@@ -31,19 +31,27 @@ func (dl *diskLoader) GetSourceMigrations() []types.Migration {
 	tenantScriptsDirs := dl.getDirs(absBaseDir, dl.config.TenantScripts)
 
 	migrationsMap := make(map[string][]types.Migration)
-	dl.readFromDirs(migrationsMap, singleMigrationsDirs, types.MigrationTypeSingleMigration)
-	dl.readFromDirs(migrationsMap, tenantMigrationsDirs, types.MigrationTypeTenantMigration)
+	if err := dl.readFromDirs(migrationsMap, singleMigrationsDirs, types.MigrationTypeSingleMigration); err != nil {
+		return nil, err
+	}
+	if err := dl.readFromDirs(migrationsMap, tenantMigrationsDirs, types.MigrationTypeTenantMigration); err != nil {
+		return nil, err
+	}
 	dl.sortMigrations(migrationsMap, &migrations)
 
 	migrationsMap = make(map[string][]types.Migration)
-	dl.readFromDirs(migrationsMap, singleScriptsDirs, types.MigrationTypeSingleScript)
+	if err := dl.readFromDirs(migrationsMap, singleScriptsDirs, types.MigrationTypeSingleScript); err != nil {
+		return nil, err
+	}
 	dl.sortMigrations(migrationsMap, &migrations)
 
 	migrationsMap = make(map[string][]types.Migration)
-	dl.readFromDirs(migrationsMap, tenantScriptsDirs, types.MigrationTypeTenantScript)
+	if err := dl.readFromDirs(migrationsMap, tenantScriptsDirs, types.MigrationTypeTenantScript); err != nil {
+		return nil, err
+	}
 	dl.sortMigrations(migrationsMap, &migrations)
 
-	return migrations
+	return migrations, nil
 }
 
 func (dl *diskLoader) HealthCheck() error {
@@ -51,10 +59,19 @@ func (dl *diskLoader) HealthCheck() error {
 	if err != nil {
 		return err
 	}
+	if isArchiveLocation(dl.config.BaseLocation) {
+		_, err = os.Stat(absBaseDir)
+		return err
+	}
 	_, err = os.ReadDir(absBaseDir)
 	return err
 }
 
+// readFile reads a single file from disk
+func (dl *diskLoader) readFile(location string) ([]byte, error) {
+	return os.ReadFile(location)
+}
+
 func (dl *diskLoader) getDirs(baseDir string, migrationsDirs []string) []string {
 	var filteredDirs []string
 	for _, migrationsDir := range migrationsDirs {
@@ -63,32 +80,76 @@ func (dl *diskLoader) getDirs(baseDir string, migrationsDirs []string) []string
 	return filteredDirs
 }
 
-func (dl *diskLoader) readFromDirs(migrations map[string][]types.Migration, sourceDirs []string, migrationType types.MigrationType) {
-	for _, sourceDir := range sourceDirs {
+// listFiles returns files placed in source dir, files in subdirectories are returned only when recursive is enabled
+// names of returned files are slash-separated paths relative to source dir
+func (dl *diskLoader) listFiles(sourceDir string) ([]string, []fs.DirEntry, error) {
+	names := []string{}
+	entries := []fs.DirEntry{}
+	if !dl.config.Recursive {
 		files, err := os.ReadDir(sourceDir)
 		if err != nil {
-			panic(fmt.Sprintf("Could not read source dir %v: %v", sourceDir, err.Error()))
+			return nil, nil, err
 		}
 		for _, file := range files {
 			if !file.IsDir() {
-				fullPath := filepath.Join(sourceDir, file.Name())
-				contents, err := os.ReadFile(fullPath)
-				if err != nil {
-					panic(fmt.Sprintf("Could not read file %v: %v", fullPath, err.Error()))
-				}
-				hasher := sha256.New()
-				hasher.Write([]byte(contents))
-				name := strings.Replace(file.Name(), dl.config.BaseLocation, "", 1)
-				m := types.Migration{Name: name, SourceDir: sourceDir, File: filepath.Join(sourceDir, file.Name()), MigrationType: migrationType, Contents: string(contents), CheckSum: hex.EncodeToString(hasher.Sum(nil))}
-
-				e, ok := migrations[m.Name]
-				if ok {
-					e = append(e, m)
-				} else {
-					e = []types.Migration{m}
-				}
-				migrations[m.Name] = e
+				names = append(names, file.Name())
+				entries = append(entries, file)
+			}
+		}
+		return names, entries, nil
+	}
+	err := filepath.WalkDir(sourceDir, func(fullPath string, file fs.DirEntry, err error) error {
+		if err != nil || file.IsDir() {
+			return err
+		}
+		name, err := filepath.Rel(sourceDir, fullPath)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(name))
+		entries = append(entries, file)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return names, entries, nil
+}
+
+func (dl *diskLoader) readFromDirs(migrations map[string][]types.Migration, sourceDirs []string, migrationType types.MigrationType) error {
+	for _, sourceDir := range sourceDirs {
+		names, files, err := dl.listFiles(sourceDir)
+		if err != nil {
+			return &types.SourceError{Location: sourceDir, Err: err}
+		}
+		for i, file := range files {
+			name := names[i]
+			fullPath := filepath.Join(sourceDir, filepath.FromSlash(name))
+			if !dl.includeFile(file.Name(), fullPath) {
+				continue
+			}
+			version := ""
+			if info, err := file.Info(); err == nil {
+				version = fileVersion(info.ModTime(), info.Size())
+			}
+			contents, err := dl.readCached(fullPath, version, func() ([]byte, error) {
+				return os.ReadFile(fullPath)
+			})
+			if err != nil {
+				return &types.SourceError{Location: fullPath, Err: err}
+			}
+			hasher := sha256.New()
+			hasher.Write([]byte(contents))
+			m := types.Migration{Name: name, SourceDir: sourceDir, File: fullPath, MigrationType: migrationType, Contents: string(contents), CheckSum: hex.EncodeToString(hasher.Sum(nil))}
+
+			e, ok := migrations[m.Name]
+			if ok {
+				e = append(e, m)
+			} else {
+				e = []types.Migration{m}
 			}
+			migrations[m.Name] = e
 		}
 	}
+	return nil
 }
@@ -2,9 +2,13 @@ package loader
 
 import (
 	"context"
+	"errors"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/lukaszbudnik/migrator/config"
+	"github.com/lukaszbudnik/migrator/types"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -15,21 +19,11 @@ func TestDiskReadDiskMigrationsNonExistingBaseLocationError(t *testing.T) {
 
 	loader := New(context.TODO(), &config)
 
-	didPanic := false
-	var message interface{}
-	func() {
-
-		defer func() {
-			if message = recover(); message != nil {
-				didPanic = true
-			}
-		}()
-
-		loader.GetSourceMigrations()
-
-	}()
-	assert.True(t, didPanic)
-	assert.Contains(t, message, "xyzabc/migrations/config: no such file or directory")
+	migrations, err := loader.GetSourceMigrations()
+	assert.Nil(t, migrations)
+	assert.Contains(t, err.Error(), "xyzabc/migrations/config: no such file or directory")
+	var sourceErr *types.SourceError
+	assert.True(t, errors.As(err, &sourceErr))
 }
 
 func TestDiskReadDiskMigrationsNonExistingMigrationsDirError(t *testing.T) {
@@ -39,21 +33,11 @@ func TestDiskReadDiskMigrationsNonExistingMigrationsDirError(t *testing.T) {
 
 	loader := New(context.TODO(), &config)
 
-	didPanic := false
-	var message interface{}
-	func() {
-
-		defer func() {
-			if message = recover(); message != nil {
-				didPanic = true
-			}
-		}()
-
-		loader.GetSourceMigrations()
-
-	}()
-	assert.True(t, didPanic)
-	assert.Contains(t, message, "test/migrations/abcdef: no such file or directory")
+	migrations, err := loader.GetSourceMigrations()
+	assert.Nil(t, migrations)
+	assert.Contains(t, err.Error(), "test/migrations/abcdef: no such file or directory")
+	var sourceErr *types.SourceError
+	assert.True(t, errors.As(err, &sourceErr))
 }
 
 func TestDiskGetDiskMigrations(t *testing.T) {
@@ -65,7 +49,8 @@ func TestDiskGetDiskMigrations(t *testing.T) {
 	config.TenantScripts = []string{"migrations/tenants-scripts"}
 
 	loader := New(context.TODO(), &config)
-	migrations := loader.GetSourceMigrations()
+	migrations, err := loader.GetSourceMigrations()
+	assert.Nil(t, err)
 
 	assert.Len(t, migrations, 12)
 
@@ -75,6 +60,8 @@ func TestDiskGetDiskMigrations(t *testing.T) {
 	assert.Contains(t, migrations[3].File, "test/migrations/ref/201602160003.sql")
 	assert.Contains(t, migrations[4].File, "test/migrations/tenants/201602160003.sql")
 	assert.Contains(t, migrations[5].File, "test/migrations/ref/201602160004.sql")
+	// down migration is paired with its up migration and is not returned on its own
+	assert.Equal(t, "drop table {schema}.roles;\n", migrations[5].DownContents)
 	assert.Contains(t, migrations[6].File, "test/migrations/tenants/201602160004.sql")
 	assert.Contains(t, migrations[7].File, "test/migrations/tenants/201602160005.sql")
 	// SingleScripts are second to last
@@ -93,3 +80,62 @@ func TestDiskHealthCheck(t *testing.T) {
 	err := loader.HealthCheck()
 	assert.NotNil(t, err)
 }
+
+func TestDiskGetSourceMigrationsIncludeExcludePatterns(t *testing.T) {
+	dir := t.TempDir()
+	assert.Nil(t, os.Mkdir(filepath.Join(dir, "ref"), 0755))
+	for _, name := range []string{"201602160001.sql", "201602160001.sql~", "201602160002.sql", "201602160002.cql", "README.md", ".DS_Store"} {
+		assert.Nil(t, os.WriteFile(filepath.Join(dir, "ref", name), []byte(name), 0644))
+	}
+
+	config := &config.Config{
+		BaseLocation:     dir,
+		SingleMigrations: []string{"ref"},
+	}
+	migrations, err := New(context.TODO(), config).GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 2)
+	assert.Equal(t, "201602160001.sql", migrations[0].Name)
+	assert.Equal(t, "201602160002.sql", migrations[1].Name)
+
+	config.IncludePatterns = []string{"*.sql", "*.cql"}
+	config.ExcludePatterns = []string{"201602160001.*"}
+	migrations, err = New(context.TODO(), config).GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 2)
+	assert.Equal(t, "201602160002.cql", migrations[0].Name)
+	assert.Equal(t, "201602160002.sql", migrations[1].Name)
+}
+
+func TestDiskGetSourceMigrationsRecursive(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"ref/2024/02/202402010001.sql", "ref/2024/01/202401010001.sql", "ref/2024/01/202401010001.down.sql", "ref/2023/12/202312010001.sql", "ref/202403010001.sql"} {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		assert.Nil(t, os.MkdirAll(filepath.Dir(file), 0755))
+		assert.Nil(t, os.WriteFile(file, []byte(name), 0644))
+	}
+
+	config := &config.Config{
+		BaseLocation:     dir,
+		SingleMigrations: []string{"ref"},
+	}
+	migrations, err := New(context.TODO(), config).GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 1)
+	assert.Equal(t, "202403010001.sql", migrations[0].Name)
+
+	config.Recursive = true
+	migrations, err = New(context.TODO(), config).GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 4)
+	assert.Equal(t, "2023/12/202312010001.sql", migrations[0].Name)
+	assert.Equal(t, "2024/01/202401010001.sql", migrations[1].Name)
+	assert.Equal(t, "ref/2024/01/202401010001.down.sql", migrations[1].DownContents)
+	assert.Equal(t, "2024/02/202402010001.sql", migrations[2].Name)
+	assert.Equal(t, "202403010001.sql", migrations[3].Name)
+	for _, m := range migrations {
+		// schema is resolved using source dir
+		assert.Equal(t, filepath.Join(dir, "ref"), m.SourceDir)
+		assert.Equal(t, filepath.Join(dir, "ref", filepath.FromSlash(m.Name)), m.File)
+	}
+}
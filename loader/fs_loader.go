@@ -0,0 +1,145 @@
+package loader
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/lukaszbudnik/migrator/config"
+	"github.com/lukaszbudnik/migrator/types"
+)
+
+// fsLoader is struct used for implementing Loader interface for loading migrations from fs.FS, for example embed.FS
+// baseLocation is a slash-separated directory in the file system, "." is the root of the file system
+type fsLoader struct {
+	baseLoader
+	fsys fs.FS
+}
+
+// NewFS returns new instance of Loader which loads migrations from passed file system
+// baseLocation and migrations directories are slash-separated paths in the file system, for example:
+//
+//	//go:embed migrations
+//	var migrations embed.FS
+//
+// is loaded using baseLocation: migrations and singleMigrations: [ref, config]
+func NewFS(ctx context.Context, config *config.Config, fsys fs.FS) Loader {
+	return &fsLoader{baseLoader{ctx, config}, fsys}
+}
+
+// NewFSFactory returns Factory which creates loaders reading migrations from passed file system
+func NewFSFactory(fsys fs.FS) Factory {
+	return func(ctx context.Context, config *config.Config) Loader {
+		return NewFS(ctx, config, fsys)
+	}
+}
+
+// GetSourceMigrations returns all migrations from file system
+func (fl *fsLoader) GetSourceMigrations() ([]types.Migration, error) {
+	migrations := []types.Migration{}
+
+	root, err := fl.root()
+	if err != nil {
+		return nil, &types.SourceError{Location: fl.config.BaseLocation, Err: err}
+	}
+
+	migrationsMap := make(map[string][]types.Migration)
+	if err := fl.readFromDirs(root, migrationsMap, fl.config.SingleMigrations, types.MigrationTypeSingleMigration); err != nil {
+		return nil, err
+	}
+	if err := fl.readFromDirs(root, migrationsMap, fl.config.TenantMigrations, types.MigrationTypeTenantMigration); err != nil {
+		return nil, err
+	}
+	fl.sortMigrations(migrationsMap, &migrations)
+
+	migrationsMap = make(map[string][]types.Migration)
+	if err := fl.readFromDirs(root, migrationsMap, fl.config.SingleScripts, types.MigrationTypeSingleScript); err != nil {
+		return nil, err
+	}
+	fl.sortMigrations(migrationsMap, &migrations)
+
+	migrationsMap = make(map[string][]types.Migration)
+	if err := fl.readFromDirs(root, migrationsMap, fl.config.TenantScripts, types.MigrationTypeTenantScript); err != nil {
+		return nil, err
+	}
+	fl.sortMigrations(migrationsMap, &migrations)
+
+	return migrations, nil
+}
+
+// HealthCheck checks if baseLocation can be read
+func (fl *fsLoader) HealthCheck() error {
+	root, err := fl.root()
+	if err != nil {
+		return err
+	}
+	_, err = fs.ReadDir(root, ".")
+	return err
+}
+
+// root returns baseLocation sub tree of the file system
+func (fl *fsLoader) root() (fs.FS, error) {
+	baseLocation := path.Clean(fl.config.BaseLocation)
+	if fl.config.BaseLocation == "" || baseLocation == "." {
+		return fl.fsys, nil
+	}
+	return fs.Sub(fl.fsys, baseLocation)
+}
+
+// listFiles returns names of files placed in source dir, files in subdirectories are returned only when recursive is enabled
+// names of files in subdirectories are paths relative to source dir
+func (fl *fsLoader) listFiles(root fs.FS, sourceDir string) ([]string, error) {
+	names := []string{}
+	if !fl.config.Recursive {
+		entries, err := fs.ReadDir(root, sourceDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				names = append(names, entry.Name())
+			}
+		}
+		return names, nil
+	}
+	err := fs.WalkDir(root, sourceDir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		names = append(names, strings.TrimPrefix(file, sourceDir+"/"))
+		return nil
+	})
+	return names, err
+}
+
+func (fl *fsLoader) readFromDirs(root fs.FS, migrations map[string][]types.Migration, sourceDirs []string, migrationType types.MigrationType) error {
+	for _, sourceDir := range sourceDirs {
+		sourceDir = path.Clean(sourceDir)
+		location := path.Join(fl.config.BaseLocation, sourceDir)
+		names, err := fl.listFiles(root, sourceDir)
+		if err != nil {
+			return &types.SourceError{Location: location, Err: err}
+		}
+		for _, name := range names {
+			file := path.Join(location, name)
+			if !fl.includeFile(path.Base(name), file) {
+				continue
+			}
+			contents, err := fs.ReadFile(root, path.Join(sourceDir, name))
+			if err != nil {
+				return &types.SourceError{Location: file, Err: err}
+			}
+			m := types.Migration{Name: name, SourceDir: location, File: file, MigrationType: migrationType, Contents: string(contents), CheckSum: checkSum(contents)}
+
+			e, ok := migrations[m.Name]
+			if ok {
+				e = append(e, m)
+			} else {
+				e = []types.Migration{m}
+			}
+			migrations[m.Name] = e
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lukaszbudnik/migrator/config"
+	"github.com/lukaszbudnik/migrator/types"
+)
+
+var fsMigrations = fstest.MapFS{
+	"migrations/config/201602160001.sql":         {Data: []byte("create schema config;")},
+	"migrations/ref/201602160002.sql":            {Data: []byte("create table ref.roles (id int);")},
+	"migrations/ref/201602160002.down.sql":       {Data: []byte("drop table ref.roles;")},
+	"migrations/tenants/201602160002.sql":        {Data: []byte("create table {schema}.users (id int);")},
+	"migrations/tenants/nested/201602160009.sql": {Data: []byte("select 1;")},
+	"migrations/tenants-scripts/a.sql":           {Data: []byte("select 1;")},
+}
+
+func TestFSGetSourceMigrations(t *testing.T) {
+	config := &config.Config{
+		BaseLocation:     "migrations",
+		SingleMigrations: []string{"config", "ref"},
+		TenantMigrations: []string{"tenants"},
+		TenantScripts:    []string{"tenants-scripts"},
+	}
+
+	migrations, err := NewFS(context.TODO(), config, fsMigrations).GetSourceMigrations()
+	assert.Nil(t, err)
+
+	assert.Len(t, migrations, 4)
+	assert.Equal(t, "migrations/config/201602160001.sql", migrations[0].File)
+	assert.Equal(t, "migrations/config", migrations[0].SourceDir)
+	assert.Equal(t, "201602160001.sql", migrations[0].Name)
+	assert.Equal(t, "create schema config;", migrations[0].Contents)
+	assert.Equal(t, checkSum([]byte("create schema config;")), migrations[0].CheckSum)
+	assert.Equal(t, "migrations/ref/201602160002.sql", migrations[1].File)
+	assert.Equal(t, "drop table ref.roles;", migrations[1].DownContents)
+	assert.Equal(t, "migrations/tenants/201602160002.sql", migrations[2].File)
+	assert.Equal(t, types.MigrationTypeTenantMigration, migrations[2].MigrationType)
+	assert.Equal(t, "migrations/tenants-scripts/a.sql", migrations[3].File)
+	assert.Equal(t, types.MigrationTypeTenantScript, migrations[3].MigrationType)
+}
+
+func TestFSGetSourceMigrationsRoot(t *testing.T) {
+	config := &config.Config{
+		BaseLocation:     ".",
+		SingleMigrations: []string{"migrations/config"},
+	}
+
+	migrations, err := NewFSFactory(fsMigrations)(context.TODO(), config).GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 1)
+	assert.Equal(t, "migrations/config/201602160001.sql", migrations[0].File)
+}
+
+func TestFSGetSourceMigrationsNonExistingMigrationsDirError(t *testing.T) {
+	config := &config.Config{
+		BaseLocation:     "migrations",
+		SingleMigrations: []string{"abcdef"},
+	}
+
+	migrations, err := NewFS(context.TODO(), config, fsMigrations).GetSourceMigrations()
+	assert.Nil(t, migrations)
+	var sourceErr *types.SourceError
+	assert.True(t, errors.As(err, &sourceErr))
+	assert.Equal(t, "migrations/abcdef", sourceErr.Location)
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+}
+
+func TestFSGetSourceMigrationsInvalidBaseLocationError(t *testing.T) {
+	config := &config.Config{
+		BaseLocation:     "/migrations",
+		SingleMigrations: []string{"config"},
+	}
+
+	migrations, err := NewFS(context.TODO(), config, fsMigrations).GetSourceMigrations()
+	assert.Nil(t, migrations)
+	assert.Contains(t, err.Error(), "could not read source migrations from /migrations")
+}
+
+func TestFSHealthCheck(t *testing.T) {
+	config := &config.Config{
+		BaseLocation: "migrations",
+	}
+	assert.Nil(t, NewFS(context.TODO(), config, fsMigrations).HealthCheck())
+
+	config.BaseLocation = "abcdef"
+	assert.NotNil(t, NewFS(context.TODO(), config, fsMigrations).HealthCheck())
+}
+
+func TestFSGetSourceMigrationsRecursive(t *testing.T) {
+	config := &config.Config{
+		BaseLocation:     "migrations",
+		TenantMigrations: []string{"tenants"},
+		Recursive:        true,
+	}
+
+	migrations, err := NewFS(context.TODO(), config, fsMigrations).GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 2)
+	assert.Equal(t, "201602160002.sql", migrations[0].Name)
+	assert.Equal(t, "nested/201602160009.sql", migrations[1].Name)
+	assert.Equal(t, "migrations/tenants", migrations[1].SourceDir)
+	assert.Equal(t, "migrations/tenants/nested/201602160009.sql", migrations[1].File)
+}
@@ -0,0 +1,409 @@
+package loader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2/google"
+
+	"github.com/lukaszbudnik/migrator/types"
+)
+
+const (
+	// gcsDefaultEndpoint is the Google Cloud Storage JSON API endpoint
+	gcsDefaultEndpoint = "https://storage.googleapis.com"
+	// gcsEmulatorHostEnv is the standard env variable used by Google Cloud Storage emulators, for example fake-gcs-server
+	gcsEmulatorHostEnv = "STORAGE_EMULATOR_HOST"
+	// gcsReadOnlyScope is the OAuth2 scope used by migrator
+	gcsReadOnlyScope = "https://www.googleapis.com/auth/devstorage.read_only"
+	// defaultGCSConcurrency is the number of objects downloaded concurrently when gcsConcurrency is not set
+	defaultGCSConcurrency = 10
+)
+
+// GCSObjectsPage is a single page of objects listed in a bucket
+type GCSObjectsPage struct {
+	Objects       []GCSObject
+	NextPageToken string
+}
+
+// GCSObject is an object listed in a bucket, generation changes every time object is overwritten
+type GCSObject struct {
+	Name       string
+	Generation string
+}
+
+// GCSAPIClient interface for Google Cloud Storage operations
+type GCSAPIClient interface {
+	ListObjects(ctx context.Context, bucket, prefix, pageToken string, maxResults int) (*GCSObjectsPage, error)
+	GetObject(ctx context.Context, bucket, object string) (io.ReadCloser, error)
+}
+
+// GCSListObjectsPaginator interface for pagination
+type GCSListObjectsPaginator interface {
+	HasMorePages() bool
+	NextPage(context.Context) (*GCSObjectsPage, error)
+}
+
+// GCSClientFactory creates Google Cloud Storage clients
+type GCSClientFactory interface {
+	NewClient(ctx context.Context, endpoint string, anonymous bool) (GCSAPIClient, error)
+}
+
+// GCSPaginatorFactory creates paginators
+type GCSPaginatorFactory interface {
+	NewListObjectsPaginator(client GCSAPIClient, bucket, prefix string) GCSListObjectsPaginator
+}
+
+// gcsLoader is struct used for implementing Loader interface for loading migrations from Google Cloud Storage
+type gcsLoader struct {
+	baseLoader
+	clientFactory    GCSClientFactory
+	paginatorFactory GCSPaginatorFactory
+}
+
+// defaultGCSClientFactory implements GCSClientFactory
+type defaultGCSClientFactory struct{}
+
+// NewClient returns client which uses Google Cloud Storage JSON API
+// anonymous clients (for example for fake-gcs-server) do not authenticate requests
+// otherwise Application Default Credentials are used
+func (f *defaultGCSClientFactory) NewClient(ctx context.Context, endpoint string, anonymous bool) (GCSAPIClient, error) {
+	if anonymous {
+		return &gcsJSONClient{httpClient: http.DefaultClient, endpoint: endpoint}, nil
+	}
+	httpClient, err := google.DefaultClient(ctx, gcsReadOnlyScope)
+	if err != nil {
+		return nil, err
+	}
+	return &gcsJSONClient{httpClient: httpClient, endpoint: endpoint}, nil
+}
+
+// gcsJSONClient implements GCSAPIClient using Google Cloud Storage JSON API
+type gcsJSONClient struct {
+	httpClient *http.Client
+	endpoint   string
+}
+
+func (c *gcsJSONClient) ListObjects(ctx context.Context, bucket, prefix, pageToken string, maxResults int) (*GCSObjectsPage, error) {
+	query := url.Values{}
+	query.Set("prefix", prefix)
+	query.Set("maxResults", fmt.Sprintf("%d", maxResults))
+	if pageToken != "" {
+		query.Set("pageToken", pageToken)
+	}
+	body, err := c.get(ctx, fmt.Sprintf("%s/storage/v1/b/%s/o?%s", c.endpoint, url.PathEscape(bucket), query.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var response struct {
+		Items []struct {
+			Name       string `json:"name"`
+			Generation string `json:"generation"`
+		} `json:"items"`
+		NextPageToken string `json:"nextPageToken"`
+	}
+	if err := json.NewDecoder(body).Decode(&response); err != nil {
+		return nil, err
+	}
+
+	page := &GCSObjectsPage{NextPageToken: response.NextPageToken}
+	for _, item := range response.Items {
+		page.Objects = append(page.Objects, GCSObject{Name: item.Name, Generation: item.Generation})
+	}
+	return page, nil
+}
+
+func (c *gcsJSONClient) GetObject(ctx context.Context, bucket, object string) (io.ReadCloser, error) {
+	return c.get(ctx, fmt.Sprintf("%s/storage/v1/b/%s/o/%s?alt=media", c.endpoint, url.PathEscape(bucket), url.PathEscape(object)))
+}
+
+func (c *gcsJSONClient) get(ctx context.Context, location string) (io.ReadCloser, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		defer response.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		return nil, fmt.Errorf("GCS request failed with status %v: %v", response.Status, strings.TrimSpace(string(message)))
+	}
+	return response.Body, nil
+}
+
+// defaultGCSPaginatorFactory implements GCSPaginatorFactory
+type defaultGCSPaginatorFactory struct{}
+
+func (f *defaultGCSPaginatorFactory) NewListObjectsPaginator(client GCSAPIClient, bucket, prefix string) GCSListObjectsPaginator {
+	return &gcsListObjectsPaginator{client: client, bucket: bucket, prefix: prefix, firstPage: true}
+}
+
+// gcsListObjectsPaginator follows next page tokens returned by Google Cloud Storage
+type gcsListObjectsPaginator struct {
+	client    GCSAPIClient
+	bucket    string
+	prefix    string
+	pageToken string
+	firstPage bool
+}
+
+func (p *gcsListObjectsPaginator) HasMorePages() bool {
+	return p.firstPage || p.pageToken != ""
+}
+
+func (p *gcsListObjectsPaginator) NextPage(ctx context.Context) (*GCSObjectsPage, error) {
+	page, err := p.client.ListObjects(ctx, p.bucket, p.prefix, p.pageToken, 100)
+	if err != nil {
+		return nil, err
+	}
+	p.firstPage = false
+	p.pageToken = page.NextPageToken
+	return page, nil
+}
+
+func (gl *gcsLoader) getPaginatorFactory() GCSPaginatorFactory {
+	if gl.paginatorFactory != nil {
+		return gl.paginatorFactory
+	}
+	return &defaultGCSPaginatorFactory{}
+}
+
+func (gl *gcsLoader) getClientFactory() GCSClientFactory {
+	if gl.clientFactory != nil {
+		return gl.clientFactory
+	}
+	return &defaultGCSClientFactory{}
+}
+
+// getConcurrency returns number of objects downloaded concurrently
+func (gl *gcsLoader) getConcurrency() int {
+	if gl.config.GCSConcurrency > 0 {
+		return gl.config.GCSConcurrency
+	}
+	return defaultGCSConcurrency
+}
+
+// getEndpoint returns gcsEndpoint config property, STORAGE_EMULATOR_HOST env variable or the default endpoint
+func (gl *gcsLoader) getEndpoint() string {
+	endpoint := gl.config.GCSEndpoint
+	if endpoint == "" {
+		endpoint = os.Getenv(gcsEmulatorHostEnv)
+	}
+	if endpoint == "" {
+		return gcsDefaultEndpoint
+	}
+	// STORAGE_EMULATOR_HOST is usually set without scheme, for example localhost:4443
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	return strings.TrimRight(endpoint, "/")
+}
+
+// isAnonymous returns true when gcsAnonymous config property or STORAGE_EMULATOR_HOST env variable is set
+// emulators do not support authentication, custom endpoints set using gcsEndpoint use Application Default Credentials unless gcsAnonymous is set
+func (gl *gcsLoader) isAnonymous() bool {
+	return gl.config.GCSAnonymous || os.Getenv(gcsEmulatorHostEnv) != ""
+}
+
+// newClient creates client using gcsEndpoint and gcsAnonymous config properties
+func (gl *gcsLoader) newClient() (GCSAPIClient, error) {
+	return gl.getClientFactory().NewClient(gl.ctx, gl.getEndpoint(), gl.isAnonymous())
+}
+
+// GetSourceMigrations returns all migrations from Google Cloud Storage location
+func (gl *gcsLoader) GetSourceMigrations() ([]types.Migration, error) {
+	client, err := gl.newClient()
+	if err != nil {
+		return nil, &types.SourceError{Location: gl.config.BaseLocation, Err: err}
+	}
+	return gl.doGetSourceMigrations(client)
+}
+
+func (gl *gcsLoader) HealthCheck() error {
+	client, err := gl.newClient()
+	if err != nil {
+		return err
+	}
+	return gl.doHealthCheck(client)
+}
+
+func (gl *gcsLoader) doHealthCheck(client GCSAPIClient) error {
+	bucket, prefix := gl.parseBaseLocation()
+	_, err := client.ListObjects(gl.ctx, bucket, prefix, "", 1)
+	return err
+}
+
+// readFile reads a single object, location is gs://bucket/object
+func (gl *gcsLoader) readFile(location string) ([]byte, error) {
+	client, err := gl.newClient()
+	if err != nil {
+		return nil, err
+	}
+	return gl.doReadFile(client, location)
+}
+
+func (gl *gcsLoader) doReadFile(client GCSAPIClient, location string) ([]byte, error) {
+	bucketWithObject := strings.SplitN(strings.Replace(location, "gs://", "", 1), "/", 2)
+	if len(bucketWithObject) != 2 {
+		return nil, fmt.Errorf("invalid GCS object location: %v", location)
+	}
+	body, err := client.GetObject(gl.ctx, bucketWithObject[0], bucketWithObject[1])
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// parseBaseLocation returns bucket and optional prefixes
+func (gl *gcsLoader) parseBaseLocation() (string, string) {
+	bucketWithPrefixes := strings.SplitN(strings.Replace(strings.TrimRight(gl.config.BaseLocation, "/"), "gs://", "", 1), "/", 2)
+	if len(bucketWithPrefixes) > 1 {
+		return bucketWithPrefixes[0], bucketWithPrefixes[1]
+	}
+	return bucketWithPrefixes[0], ""
+}
+
+func (gl *gcsLoader) doGetSourceMigrations(client GCSAPIClient) ([]types.Migration, error) {
+	migrations := []types.Migration{}
+
+	bucket, optionalPrefixes := gl.parseBaseLocation()
+
+	singleMigrationsObjects, err := gl.getObjectList(client, bucket, optionalPrefixes, gl.config.SingleMigrations)
+	if err != nil {
+		return nil, err
+	}
+	tenantMigrationsObjects, err := gl.getObjectList(client, bucket, optionalPrefixes, gl.config.TenantMigrations)
+	if err != nil {
+		return nil, err
+	}
+	singleScriptsObjects, err := gl.getObjectList(client, bucket, optionalPrefixes, gl.config.SingleScripts)
+	if err != nil {
+		return nil, err
+	}
+	tenantScriptsObjects, err := gl.getObjectList(client, bucket, optionalPrefixes, gl.config.TenantScripts)
+	if err != nil {
+		return nil, err
+	}
+
+	migrationsMap := make(map[string][]types.Migration)
+	if err := gl.getObjects(client, bucket, optionalPrefixes, migrationsMap, singleMigrationsObjects, types.MigrationTypeSingleMigration); err != nil {
+		return nil, err
+	}
+	if err := gl.getObjects(client, bucket, optionalPrefixes, migrationsMap, tenantMigrationsObjects, types.MigrationTypeTenantMigration); err != nil {
+		return nil, err
+	}
+	gl.sortMigrations(migrationsMap, &migrations)
+
+	migrationsMap = make(map[string][]types.Migration)
+	if err := gl.getObjects(client, bucket, optionalPrefixes, migrationsMap, singleScriptsObjects, types.MigrationTypeSingleScript); err != nil {
+		return nil, err
+	}
+	gl.sortMigrations(migrationsMap, &migrations)
+
+	migrationsMap = make(map[string][]types.Migration)
+	if err := gl.getObjects(client, bucket, optionalPrefixes, migrationsMap, tenantScriptsObjects, types.MigrationTypeTenantScript); err != nil {
+		return nil, err
+	}
+	gl.sortMigrations(migrationsMap, &migrations)
+
+	return migrations, nil
+}
+
+// gcsObject is an object listed in source dir, name is its name relative to source dir
+type gcsObject struct {
+	GCSObject
+	name string
+}
+
+// getObjectList lists objects placed in passed dirs, objects in subdirectories are listed only when recursive is enabled
+func (gl *gcsLoader) getObjectList(client GCSAPIClient, bucket, optionalPrefixes string, prefixes []string) ([]gcsObject, error) {
+	objects := []gcsObject{}
+
+	for _, prefix := range prefixes {
+		fullPrefix := prefix + "/"
+		if optionalPrefixes != "" {
+			fullPrefix = optionalPrefixes + "/" + fullPrefix
+		}
+
+		paginator := gl.getPaginatorFactory().NewListObjectsPaginator(client, bucket, fullPrefix)
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(gl.ctx)
+			if err != nil {
+				return nil, &types.SourceError{Location: fmt.Sprintf("gs://%s/%s", bucket, fullPrefix), Err: err}
+			}
+			for _, object := range page.Objects {
+				name, ok := gl.objectName(fullPrefix, object.Name)
+				if ok && gl.includeFile(path.Base(name), fmt.Sprintf("gs://%s/%s", bucket, object.Name)) {
+					objects = append(objects, gcsObject{object, name})
+				}
+			}
+		}
+	}
+
+	return objects, nil
+}
+
+// getObjects downloads objects using a pool of gcsConcurrency workers, objects which did not change are read from source cache
+// migrations are added to the map in the order of passed objects so that sortMigrations stays deterministic
+// when more than one download failed the error of the first object is returned
+func (gl *gcsLoader) getObjects(client GCSAPIClient, bucket, optionalPrefixes string, migrationsMap map[string][]types.Migration, objects []gcsObject, migrationType types.MigrationType) error {
+	contents := make([][]byte, len(objects))
+	errs := make([]error, len(objects))
+
+	var (
+		wg      sync.WaitGroup
+		indexes = make(chan int)
+	)
+
+	for w := 0; w < gl.getConcurrency(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				location := fmt.Sprintf("gs://%s/%s", bucket, objects[i].Name)
+				contents[i], errs[i] = gl.readCached(location, objects[i].Generation, func() ([]byte, error) {
+					return gl.doReadFile(client, location)
+				})
+			}
+		}()
+	}
+
+	for i := range objects {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	for i, o := range objects {
+		location := fmt.Sprintf("gs://%s/%s", bucket, o.Name)
+		if errs[i] != nil {
+			return &types.SourceError{Location: location, Err: errs[i]}
+		}
+
+		sourceDir := strings.TrimSuffix(location, "/"+o.name)
+		m := types.Migration{Name: o.name, SourceDir: sourceDir, File: location, MigrationType: migrationType, Contents: string(contents[i]), CheckSum: checkSum(contents[i])}
+
+		e, ok := migrationsMap[m.Name]
+		if ok {
+			e = append(e, m)
+		} else {
+			e = []types.Migration{m}
+		}
+		migrationsMap[m.Name] = e
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package loader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/lukaszbudnik/migrator/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGCSGetSourceMigrationsIntegration(t *testing.T) {
+	bucketName := os.Getenv("GCS_BUCKET")
+
+	if len(bucketName) == 0 {
+		t.Skip("skipping integration test: GCS_BUCKET not set")
+	}
+
+	baseLocation := fmt.Sprintf("gs://%s", bucketName)
+
+	config := &config.Config{
+		BaseLocation:     baseLocation,
+		SingleMigrations: []string{"migrations/config", "migrations/ref"},
+		TenantMigrations: []string{"migrations/tenants"},
+		SingleScripts:    []string{"migrations/config-scripts"},
+		TenantScripts:    []string{"migrations/tenants-scripts"},
+		// when empty STORAGE_EMULATOR_HOST env variable is used, for example fake-gcs-server
+		GCSEndpoint: os.Getenv("GCS_ENDPOINT"),
+		// fake-gcs-server does not authenticate requests
+		GCSAnonymous: os.Getenv("GCS_ENDPOINT") != "",
+	}
+
+	loader := New(context.TODO(), config)
+
+	migrations, err := loader.GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.NotEmpty(t, migrations)
+
+	assert.Nil(t, loader.HealthCheck())
+}
@@ -0,0 +1,353 @@
+package loader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lukaszbudnik/migrator/config"
+)
+
+type mockGCSClient struct {
+	objects []string
+	// generations of objects, objects without generation are never cached
+	generations map[string]string
+	// failing is the object which cannot be downloaded
+	failing  string
+	mutex    sync.Mutex
+	gets     int
+	inFlight int
+	max      int
+}
+
+func (m *mockGCSClient) ListObjects(ctx context.Context, bucket, prefix, pageToken string, maxResults int) (*GCSObjectsPage, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	page := &GCSObjectsPage{}
+	for _, name := range m.objects {
+		if strings.HasPrefix(name, prefix) {
+			page.Objects = append(page.Objects, GCSObject{Name: name, Generation: m.generations[name]})
+		}
+	}
+	return page, nil
+}
+
+func (m *mockGCSClient) GetObject(ctx context.Context, bucket, object string) (io.ReadCloser, error) {
+	m.mutex.Lock()
+	m.gets++
+	m.inFlight++
+	if m.inFlight > m.max {
+		m.max = m.inFlight
+	}
+	m.mutex.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	m.mutex.Lock()
+	m.inFlight--
+	m.mutex.Unlock()
+
+	if object == m.failing {
+		return nil, errors.New("access denied: " + object)
+	}
+	return io.NopCloser(bytes.NewReader([]byte(object))), nil
+}
+
+type mockGCSClientFactory struct {
+	client GCSAPIClient
+}
+
+func (f *mockGCSClientFactory) NewClient(ctx context.Context, endpoint string, anonymous bool) (GCSAPIClient, error) {
+	return f.client, nil
+}
+
+var gcsObjects = []string{
+	"migrations/config/",
+	"migrations/config/201602160001.sql",
+	"migrations/config/201602160002.sql",
+	"migrations/ref/202001100003.sql",
+	"migrations/ref/202001100005.sql",
+	"migrations/tenants/201602160002.sql",
+	"migrations/tenants/202001100004.sql",
+	"migrations/tenants/nested/202001100006.sql",
+	"migrations/config-scripts/recreate-triggers.sql",
+	"migrations/config-scripts/cleanup.sql",
+	"migrations/tenants-scripts/recreate-triggers.sql",
+	"migrations/tenants-scripts/run-reports.sql",
+}
+
+func newGCSTestConfig(baseLocation string) *config.Config {
+	return &config.Config{
+		BaseLocation:     baseLocation,
+		SingleMigrations: []string{"migrations/config", "migrations/ref"},
+		TenantMigrations: []string{"migrations/tenants"},
+		SingleScripts:    []string{"migrations/config-scripts"},
+		TenantScripts:    []string{"migrations/tenants-scripts"},
+	}
+}
+
+func TestGCSGetSourceMigrations(t *testing.T) {
+	loader := &gcsLoader{
+		baseLoader:    baseLoader{context.TODO(), newGCSTestConfig("gs://your-bucket-migrator")},
+		clientFactory: &mockGCSClientFactory{client: &mockGCSClient{objects: gcsObjects}},
+	}
+
+	migrations, err := loader.GetSourceMigrations()
+	assert.Nil(t, err)
+
+	assert.Len(t, migrations, 10)
+
+	assert.Equal(t, "gs://your-bucket-migrator/migrations/config/201602160001.sql", migrations[0].File)
+	assert.Equal(t, "gs://your-bucket-migrator/migrations/config", migrations[0].SourceDir)
+	assert.Equal(t, "201602160001.sql", migrations[0].Name)
+	assert.Equal(t, "migrations/config/201602160001.sql", migrations[0].Contents)
+	assert.Equal(t, "gs://your-bucket-migrator/migrations/config/201602160002.sql", migrations[1].File)
+	assert.Equal(t, "gs://your-bucket-migrator/migrations/tenants/201602160002.sql", migrations[2].File)
+	assert.Equal(t, "gs://your-bucket-migrator/migrations/ref/202001100003.sql", migrations[3].File)
+	assert.Equal(t, "gs://your-bucket-migrator/migrations/tenants/202001100004.sql", migrations[4].File)
+	assert.Equal(t, "gs://your-bucket-migrator/migrations/ref/202001100005.sql", migrations[5].File)
+	assert.Equal(t, "gs://your-bucket-migrator/migrations/config-scripts/cleanup.sql", migrations[6].File)
+	assert.Equal(t, "gs://your-bucket-migrator/migrations/config-scripts/recreate-triggers.sql", migrations[7].File)
+	assert.Equal(t, "gs://your-bucket-migrator/migrations/tenants-scripts/recreate-triggers.sql", migrations[8].File)
+	assert.Equal(t, "gs://your-bucket-migrator/migrations/tenants-scripts/run-reports.sql", migrations[9].File)
+}
+
+func TestGCSGetSourceMigrationsBucketWithPrefix(t *testing.T) {
+	objects := []string{}
+	for _, o := range gcsObjects {
+		objects = append(objects, "application-x/prod/"+o)
+	}
+	loader := &gcsLoader{
+		baseLoader:    baseLoader{context.TODO(), newGCSTestConfig("gs://your-bucket-migrator/application-x/prod/")},
+		clientFactory: &mockGCSClientFactory{client: &mockGCSClient{objects: objects}},
+	}
+
+	migrations, err := loader.GetSourceMigrations()
+	assert.Nil(t, err)
+
+	assert.Len(t, migrations, 10)
+	assert.Equal(t, "gs://your-bucket-migrator/application-x/prod/migrations/config/201602160001.sql", migrations[0].File)
+	assert.Equal(t, "gs://your-bucket-migrator/application-x/prod/migrations/tenants-scripts/run-reports.sql", migrations[9].File)
+}
+
+func TestGCSEndpoint(t *testing.T) {
+	config := &config.Config{BaseLocation: "gs://your-bucket-migrator"}
+	loader := &gcsLoader{baseLoader: baseLoader{context.TODO(), config}}
+
+	t.Setenv(gcsEmulatorHostEnv, "")
+	assert.Equal(t, gcsDefaultEndpoint, loader.getEndpoint())
+
+	t.Setenv(gcsEmulatorHostEnv, "localhost:4443")
+	assert.Equal(t, "http://localhost:4443", loader.getEndpoint())
+
+	config.GCSEndpoint = "https://gcs.example.com/"
+	assert.Equal(t, "https://gcs.example.com", loader.getEndpoint())
+}
+
+func TestGCSAnonymous(t *testing.T) {
+	config := &config.Config{BaseLocation: "gs://your-bucket-migrator", GCSEndpoint: "https://storage-europe-west1.p.googleapis.com"}
+	loader := &gcsLoader{baseLoader: baseLoader{context.TODO(), config}}
+
+	// custom endpoints use Application Default Credentials
+	t.Setenv(gcsEmulatorHostEnv, "")
+	assert.False(t, loader.isAnonymous())
+
+	config.GCSAnonymous = true
+	assert.True(t, loader.isAnonymous())
+
+	// emulators do not authenticate requests
+	config.GCSAnonymous = false
+	t.Setenv(gcsEmulatorHostEnv, "localhost:4443")
+	assert.True(t, loader.isAnonymous())
+}
+
+// newFakeGCSServer returns server implementing list and download endpoints of Google Cloud Storage JSON API
+// objects are listed one per page to exercise pagination
+func newFakeGCSServer(bucket string, objects []string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		listPath := "/storage/v1/b/" + bucket + "/o"
+		if r.URL.Path == listPath {
+			prefix := r.URL.Query().Get("prefix")
+			matching := []string{}
+			for _, o := range objects {
+				if strings.HasPrefix(o, prefix) {
+					matching = append(matching, o)
+				}
+			}
+			response := map[string]interface{}{}
+			start := 0
+			for i, o := range matching {
+				if o == r.URL.Query().Get("pageToken") {
+					start = i
+				}
+			}
+			if start < len(matching) {
+				response["items"] = []map[string]string{{"name": matching[start], "generation": "1"}}
+			}
+			if start+1 < len(matching) {
+				response["nextPageToken"] = matching[start+1]
+			}
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, listPath+"/") && r.URL.Query().Get("alt") == "media" {
+			name := strings.TrimPrefix(r.URL.Path, listPath+"/")
+			for _, o := range objects {
+				if o == name {
+					w.Write([]byte("contents of " + name))
+					return
+				}
+			}
+		}
+		http.Error(w, "Not Found", http.StatusNotFound)
+	}))
+}
+
+func TestGCSGetSourceMigrationsEndpoint(t *testing.T) {
+	server := newFakeGCSServer("your-bucket-migrator", gcsObjects)
+	defer server.Close()
+
+	config := newGCSTestConfig("gs://your-bucket-migrator")
+	config.GCSEndpoint = server.URL
+	config.GCSAnonymous = true
+	loader := New(context.TODO(), config)
+
+	migrations, err := loader.GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 10)
+	assert.Equal(t, "gs://your-bucket-migrator/migrations/config/201602160001.sql", migrations[0].File)
+	assert.Equal(t, "contents of migrations/config/201602160001.sql", migrations[0].Contents)
+	assert.Equal(t, "gs://your-bucket-migrator/migrations/tenants-scripts/run-reports.sql", migrations[9].File)
+
+	assert.Nil(t, loader.HealthCheck())
+}
+
+func TestGCSGetSourceMigrationsEndpointError(t *testing.T) {
+	server := newFakeGCSServer("your-bucket-migrator", gcsObjects)
+	defer server.Close()
+
+	config := newGCSTestConfig("gs://abcdef")
+	config.GCSEndpoint = server.URL
+	config.GCSAnonymous = true
+	loader := New(context.TODO(), config)
+
+	migrations, err := loader.GetSourceMigrations()
+	assert.Nil(t, migrations)
+	assert.Equal(t, "could not read source migrations from gs://abcdef/migrations/config/: GCS request failed with status 404 Not Found: Not Found", err.Error())
+
+	assert.NotNil(t, loader.HealthCheck())
+}
+
+func TestGCSArchiveGetSourceMigrations(t *testing.T) {
+	contents := newTarGz(t, archiveFiles)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/storage/v1/b/bucket/o/releases/migrations.tar.gz" {
+			w.Write(contents)
+			return
+		}
+		http.Error(w, "Not Found", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	config := &config.Config{
+		BaseLocation:     "gs://bucket/releases/migrations.tar.gz",
+		SingleMigrations: []string{"migrations/config"},
+		GCSEndpoint:      server.URL,
+		GCSAnonymous:     true,
+	}
+
+	migrations, err := New(context.TODO(), config).GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 1)
+	assert.Equal(t, "gs://bucket/releases/migrations/config/201602160001.sql", migrations[0].File)
+}
+
+type mockGCSErrorClientFactory struct{}
+
+func (f *mockGCSErrorClientFactory) NewClient(ctx context.Context, endpoint string, anonymous bool) (GCSAPIClient, error) {
+	return nil, errors.New("could not find default credentials")
+}
+
+func TestGCSClientFactoryError(t *testing.T) {
+	loader := &gcsLoader{
+		baseLoader:    baseLoader{context.TODO(), newGCSTestConfig("gs://your-bucket-migrator")},
+		clientFactory: &mockGCSErrorClientFactory{},
+	}
+
+	migrations, err := loader.GetSourceMigrations()
+	assert.Nil(t, migrations)
+	assert.Equal(t, "could not read source migrations from gs://your-bucket-migrator: could not find default credentials", err.Error())
+	assert.NotNil(t, loader.HealthCheck())
+}
+
+func TestGCSGetSourceMigrationsConcurrency(t *testing.T) {
+	client := &mockGCSClient{objects: gcsObjects}
+	config := newGCSTestConfig("gs://your-bucket-migrator")
+	config.GCSConcurrency = 2
+	loader := &gcsLoader{
+		baseLoader:    baseLoader{context.TODO(), config},
+		clientFactory: &mockGCSClientFactory{client: client},
+	}
+
+	migrations, err := loader.GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, client.max)
+
+	// same order as when objects are downloaded one by one
+	assert.Len(t, migrations, 10)
+	assert.Equal(t, "gs://your-bucket-migrator/migrations/config/201602160001.sql", migrations[0].File)
+	assert.Equal(t, "gs://your-bucket-migrator/migrations/tenants/201602160002.sql", migrations[2].File)
+	assert.Equal(t, "gs://your-bucket-migrator/migrations/ref/202001100005.sql", migrations[5].File)
+	assert.Equal(t, "gs://your-bucket-migrator/migrations/tenants-scripts/run-reports.sql", migrations[9].File)
+
+	// first failed object is reported
+	client.failing = "migrations/config-scripts/cleanup.sql"
+	migrations, err = loader.GetSourceMigrations()
+	assert.Nil(t, migrations)
+	assert.Equal(t, "could not read source migrations from gs://your-bucket-migrator/migrations/config-scripts/cleanup.sql: access denied: migrations/config-scripts/cleanup.sql", err.Error())
+}
+
+func TestCachingLoaderGCSChangeDetection(t *testing.T) {
+	client := &mockGCSClient{
+		objects:     []string{"migrations/config/201602160001.sql", "migrations/config/201602160002.sql"},
+		generations: map[string]string{"migrations/config/201602160001.sql": "1", "migrations/config/201602160002.sql": "2"},
+	}
+	config := &config.Config{
+		BaseLocation:     "gs://your-bucket-migrator-cache",
+		SingleMigrations: []string{"migrations/config"},
+		SourceCacheTTL:   "1ns",
+	}
+	newCachingLoader := func() Loader {
+		return &cachingLoader{
+			Loader: &gcsLoader{baseLoader: baseLoader{context.TODO(), config}, clientFactory: &mockGCSClientFactory{client: client}},
+			key:    cacheKey(config),
+			ttl:    sourceCacheTTL(config),
+		}
+	}
+
+	migrations, err := newCachingLoader().GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 2)
+	assert.Equal(t, 2, client.gets)
+
+	// nothing changed, objects are only listed
+	_, err = newCachingLoader().GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, client.gets)
+
+	// overwritten object gets new generation
+	client.generations["migrations/config/201602160002.sql"] = "3"
+	_, err = newCachingLoader().GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, client.gets)
+}
@@ -0,0 +1,244 @@
+package loader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/lukaszbudnik/migrator/types"
+)
+
+const (
+	gitFileLocationPrefix  = "git+file://"
+	gitHTTPSLocationPrefix = "git+https://"
+	// gitDefaultRef is used when baseLocation does not contain ref
+	gitDefaultRef = "HEAD"
+)
+
+// gitLoader is struct used for implementing Loader interface for loading migrations from git repository
+// baseLocation is git+file:// or git+https:// URL with optional ref (branch, tag or commit SHA) after #, for example:
+// git+https://github.com/lukaszbudnik/migrations.git#v1.0.0
+// files are not read using readCached as remote repository is cloned anyway before its files can be listed
+type gitLoader struct {
+	baseLoader
+}
+
+// isGitLocation returns true if passed baseLocation points to git repository
+func isGitLocation(baseLocation string) bool {
+	return strings.HasPrefix(baseLocation, gitFileLocationPrefix) || strings.HasPrefix(baseLocation, gitHTTPSLocationPrefix)
+}
+
+// GetSourceMigrations returns all migrations from git repository at configured ref
+func (gl *gitLoader) GetSourceMigrations() ([]types.Migration, error) {
+	migrations := []types.Migration{}
+
+	repository, err := gl.openRepository()
+	if err != nil {
+		return nil, &types.SourceError{Location: gl.location(), Err: err}
+	}
+
+	commit, err := gl.resolveCommit(repository)
+	if err != nil {
+		return nil, &types.SourceError{Location: gl.location(), Err: err}
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, &types.SourceError{Location: gl.location(), Err: err}
+	}
+
+	migrationsMap := make(map[string][]types.Migration)
+	if err := gl.readFromDirs(tree, commit, migrationsMap, gl.config.SingleMigrations, types.MigrationTypeSingleMigration); err != nil {
+		return nil, err
+	}
+	if err := gl.readFromDirs(tree, commit, migrationsMap, gl.config.TenantMigrations, types.MigrationTypeTenantMigration); err != nil {
+		return nil, err
+	}
+	gl.sortMigrations(migrationsMap, &migrations)
+
+	migrationsMap = make(map[string][]types.Migration)
+	if err := gl.readFromDirs(tree, commit, migrationsMap, gl.config.SingleScripts, types.MigrationTypeSingleScript); err != nil {
+		return nil, err
+	}
+	gl.sortMigrations(migrationsMap, &migrations)
+
+	migrationsMap = make(map[string][]types.Migration)
+	if err := gl.readFromDirs(tree, commit, migrationsMap, gl.config.TenantScripts, types.MigrationTypeTenantScript); err != nil {
+		return nil, err
+	}
+	gl.sortMigrations(migrationsMap, &migrations)
+
+	return migrations, nil
+}
+
+// HealthCheck checks if local repository can be opened and its ref resolved or if remote repository refs can be listed
+func (gl *gitLoader) HealthCheck() error {
+	repositoryURL, _ := gl.parseLocation()
+	if strings.HasPrefix(gl.config.BaseLocation, gitFileLocationPrefix) {
+		repository, err := gl.openRepository()
+		if err != nil {
+			return err
+		}
+		_, err = gl.resolveCommit(repository)
+		return err
+	}
+
+	remote := git.NewRemote(memory.NewStorage(), &gitconfig.RemoteConfig{Name: git.DefaultRemoteName, URLs: []string{repositoryURL}})
+	_, err := remote.ListContext(gl.ctx, &git.ListOptions{})
+	return err
+}
+
+// parseLocation returns repository URL (without git+ prefix) and ref
+func (gl *gitLoader) parseLocation() (string, string) {
+	repositoryURL := strings.TrimPrefix(gl.config.BaseLocation, "git+")
+	ref := gitDefaultRef
+	if i := strings.LastIndex(repositoryURL, "#"); i != -1 {
+		if repositoryURL[i+1:] != "" {
+			ref = repositoryURL[i+1:]
+		}
+		repositoryURL = repositoryURL[:i]
+	}
+	return repositoryURL, ref
+}
+
+// location returns baseLocation without ref and credentials
+// it is used in source dirs and files of migrations so that they do not change when ref changes
+func (gl *gitLoader) location() string {
+	repositoryURL, _ := gl.parseLocation()
+	if u, err := url.Parse(repositoryURL); err == nil && u.User != nil {
+		u.User = nil
+		repositoryURL = u.String()
+	}
+	return "git+" + strings.TrimRight(repositoryURL, "/")
+}
+
+// openRepository opens local repository (bare or not) in place
+// remote repositories are cloned in memory, see cloneRepository
+func (gl *gitLoader) openRepository() (*git.Repository, error) {
+	repositoryURL, ref := gl.parseLocation()
+	if strings.HasPrefix(gl.config.BaseLocation, gitFileLocationPrefix) {
+		return git.PlainOpen(strings.TrimPrefix(repositoryURL, "file://"))
+	}
+	return gl.cloneRepository(repositoryURL, ref)
+}
+
+// cloneRepository clones only the commit ref points to (depth 1) and checks it out as HEAD
+// ref which is a commit SHA cannot be fetched shallowly from every server so the whole repository is mirrored instead
+func (gl *gitLoader) cloneRepository(repositoryURL, ref string) (*git.Repository, error) {
+	if plumbing.IsHash(ref) {
+		return git.CloneContext(gl.ctx, memory.NewStorage(), nil, &git.CloneOptions{URL: repositoryURL, Mirror: true})
+	}
+	options := &git.CloneOptions{URL: repositoryURL, Depth: 1, SingleBranch: true, Tags: git.NoTags}
+	if ref != gitDefaultRef {
+		referenceName, err := gl.resolveRemoteRef(repositoryURL, ref)
+		if err != nil {
+			return nil, err
+		}
+		options.ReferenceName = referenceName
+	}
+	return git.CloneContext(gl.ctx, memory.NewStorage(), nil, options)
+}
+
+// resolveRemoteRef returns full name of remote branch or tag, ref can be a full name (refs/heads/main) or a short one (main)
+func (gl *gitLoader) resolveRemoteRef(repositoryURL, ref string) (plumbing.ReferenceName, error) {
+	remote := git.NewRemote(memory.NewStorage(), &gitconfig.RemoteConfig{Name: git.DefaultRemoteName, URLs: []string{repositoryURL}})
+	references, err := remote.ListContext(gl.ctx, &git.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+	candidates := []plumbing.ReferenceName{plumbing.ReferenceName(ref), plumbing.NewBranchReferenceName(ref), plumbing.NewTagReferenceName(ref)}
+	for _, candidate := range candidates {
+		for _, reference := range references {
+			if reference.Name() == candidate {
+				return candidate, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("could not resolve ref %v: %w", ref, plumbing.ErrReferenceNotFound)
+}
+
+func (gl *gitLoader) resolveCommit(repository *git.Repository) (*object.Commit, error) {
+	_, ref := gl.parseLocation()
+	// remote repositories are cloned at ref, see cloneRepository
+	if !strings.HasPrefix(gl.config.BaseLocation, gitFileLocationPrefix) && !plumbing.IsHash(ref) {
+		ref = gitDefaultRef
+	}
+	hash, err := repository.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve ref %v: %w", ref, err)
+	}
+	return repository.CommitObject(*hash)
+}
+
+// listFiles returns files placed in dir tree, files in subdirectories are returned only when recursive is enabled
+// names of returned files are paths relative to dir tree
+func (gl *gitLoader) listFiles(dirTree *object.Tree) ([]*object.File, error) {
+	files := []*object.File{}
+	if gl.config.Recursive {
+		err := dirTree.Files().ForEach(func(file *object.File) error {
+			if file.Mode.IsFile() {
+				files = append(files, file)
+			}
+			return nil
+		})
+		return files, err
+	}
+	for _, entry := range dirTree.Entries {
+		if !entry.Mode.IsFile() {
+			continue
+		}
+		file, err := dirTree.TreeEntryFile(&entry)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+func (gl *gitLoader) readFromDirs(tree *object.Tree, commit *object.Commit, migrations map[string][]types.Migration, dirs []string, migrationType types.MigrationType) error {
+	sourceCommit := commit.Hash.String()
+	for _, dir := range dirs {
+		dir = path.Clean(dir)
+		sourceDir := fmt.Sprintf("%s/%s", gl.location(), dir)
+		dirTree, err := tree.Tree(dir)
+		if err != nil {
+			return &types.SourceError{Location: sourceDir, Err: err}
+		}
+		blobs, err := gl.listFiles(dirTree)
+		if err != nil {
+			return &types.SourceError{Location: sourceDir, Err: err}
+		}
+		for _, blob := range blobs {
+			file := fmt.Sprintf("%s/%s", sourceDir, blob.Name)
+			if !gl.includeFile(path.Base(blob.Name), file) {
+				continue
+			}
+			contents, err := blob.Contents()
+			if err != nil {
+				return &types.SourceError{Location: file, Err: err}
+			}
+			hasher := sha256.New()
+			hasher.Write([]byte(contents))
+			m := types.Migration{Name: blob.Name, SourceDir: sourceDir, File: file, MigrationType: migrationType, Contents: contents, CheckSum: hex.EncodeToString(hasher.Sum(nil)), SourceCommit: &sourceCommit}
+
+			e, ok := migrations[m.Name]
+			if ok {
+				e = append(e, m)
+			} else {
+				e = []types.Migration{m}
+			}
+			migrations[m.Name] = e
+		}
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package loader
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/lukaszbudnik/migrator/types"
+)
+
+const (
+	// httpManifestFile is the manifest loaded when baseLocation does not point to a manifest file
+	httpManifestFile = "manifest.json"
+	// defaultHTTPTimeout is the timeout of a single HTTP request when httpTimeout is not set
+	defaultHTTPTimeout = 30 * time.Second
+)
+
+// httpManifest lists files available in every migration directory, JSON and YAML manifests are supported:
+//
+//	{"directories": {"config": [{"name": "201602160001.sql", "sha256": "..."}]}}
+type httpManifest struct {
+	Directories map[string][]httpManifestFileEntry `yaml:"directories"`
+}
+
+type httpManifestFileEntry struct {
+	Name   string `yaml:"name"`
+	SHA256 string `yaml:"sha256"`
+}
+
+// httpLoader is struct used for implementing Loader interface for loading migrations from HTTP(S) servers
+// files are listed in a manifest and downloaded one by one, every file is verified against its SHA-256 from the manifest
+// when source cache is enabled only files whose SHA-256 changed are downloaded again
+type httpLoader struct {
+	baseLoader
+}
+
+// isHTTPLocation returns true if passed baseLocation is HTTP(S) URL
+func isHTTPLocation(baseLocation string) bool {
+	matched, _ := regexp.MatchString(`^https?://`, baseLocation)
+	return matched
+}
+
+// GetSourceMigrations returns all migrations listed in the manifest
+func (hl *httpLoader) GetSourceMigrations() ([]types.Migration, error) {
+	migrations := []types.Migration{}
+
+	manifest, err := hl.readManifest()
+	if err != nil {
+		return nil, &types.SourceError{Location: hl.manifestLocation(), Err: err}
+	}
+
+	migrationsMap := make(map[string][]types.Migration)
+	if err := hl.readFromDirs(manifest, migrationsMap, hl.config.SingleMigrations, types.MigrationTypeSingleMigration); err != nil {
+		return nil, err
+	}
+	if err := hl.readFromDirs(manifest, migrationsMap, hl.config.TenantMigrations, types.MigrationTypeTenantMigration); err != nil {
+		return nil, err
+	}
+	hl.sortMigrations(migrationsMap, &migrations)
+
+	migrationsMap = make(map[string][]types.Migration)
+	if err := hl.readFromDirs(manifest, migrationsMap, hl.config.SingleScripts, types.MigrationTypeSingleScript); err != nil {
+		return nil, err
+	}
+	hl.sortMigrations(migrationsMap, &migrations)
+
+	migrationsMap = make(map[string][]types.Migration)
+	if err := hl.readFromDirs(manifest, migrationsMap, hl.config.TenantScripts, types.MigrationTypeTenantScript); err != nil {
+		return nil, err
+	}
+	hl.sortMigrations(migrationsMap, &migrations)
+
+	return migrations, nil
+}
+
+// HealthCheck checks if manifest can be read
+func (hl *httpLoader) HealthCheck() error {
+	_, err := hl.readManifest()
+	return err
+}
+
+// manifestLocation returns baseLocation if it points to JSON or YAML manifest, otherwise manifest.json in baseLocation
+func (hl *httpLoader) manifestLocation() string {
+	switch strings.ToLower(path.Ext(hl.config.BaseLocation)) {
+	case ".json", ".yaml", ".yml":
+		return hl.config.BaseLocation
+	}
+	return fmt.Sprintf("%s/%s", strings.TrimRight(hl.config.BaseLocation, "/"), httpManifestFile)
+}
+
+// location returns URL of the directory which contains the manifest, migration directories are relative to it
+func (hl *httpLoader) location() string {
+	manifestLocation := hl.manifestLocation()
+	return manifestLocation[:strings.LastIndex(manifestLocation, "/")]
+}
+
+func (hl *httpLoader) readManifest() (*httpManifest, error) {
+	contents, err := hl.readFile(hl.manifestLocation())
+	if err != nil {
+		return nil, err
+	}
+	// YAML is a superset of JSON so both JSON and YAML manifests are parsed by YAML decoder
+	manifest := &httpManifest{}
+	if err := yaml.Unmarshal(contents, manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %v", err)
+	}
+	return manifest, nil
+}
+
+// getTimeout returns a timeout of a single HTTP request which is
+// either the default one or overridden by user in config
+func (hl *httpLoader) getTimeout() time.Duration {
+	if hl.config.HTTPTimeout != "" {
+		// HTTP timeout is validated when config is loaded
+		if timeout, err := time.ParseDuration(hl.config.HTTPTimeout); err == nil {
+			return timeout
+		}
+	}
+	return defaultHTTPTimeout
+}
+
+// readFile downloads file from passed URL, headers are set using httpHeaders config property
+// the request, including reading the response body, must finish within httpTimeout
+func (hl *httpLoader) readFile(location string) ([]byte, error) {
+	request, err := http.NewRequestWithContext(hl.ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, header := range hl.config.HTTPHeaders {
+		pair := strings.SplitN(header, ":", 2)
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("invalid HTTP header: %v", header)
+		}
+		request.Header.Set(strings.TrimSpace(pair[0]), strings.TrimSpace(pair[1]))
+	}
+
+	client := &http.Client{Timeout: hl.getTimeout()}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP request failed with status %v", response.Status)
+	}
+	return io.ReadAll(response.Body)
+}
+
+// validFileName returns true if name from the manifest is a file name
+// when recursive is enabled name can also be a path relative to migration directory, for example: 2024/01/202401010001.sql
+func (hl *httpLoader) validFileName(name string) bool {
+	if hl.config.Recursive {
+		return fs.ValidPath(name) && name != "."
+	}
+	return name != "" && !strings.Contains(name, "/")
+}
+
+func (hl *httpLoader) readFromDirs(manifest *httpManifest, migrations map[string][]types.Migration, dirs []string, migrationType types.MigrationType) error {
+	location := hl.location()
+	for _, dir := range dirs {
+		dir = strings.Trim(dir, "/")
+		sourceDir := fmt.Sprintf("%s/%s", location, dir)
+
+		entries, ok := manifest.Directories[dir]
+		if !ok {
+			return &types.SourceError{Location: sourceDir, Err: fmt.Errorf("directory not found in manifest %v", hl.manifestLocation())}
+		}
+
+		for _, entry := range entries {
+			file := fmt.Sprintf("%s/%s", sourceDir, entry.Name)
+			if !hl.validFileName(entry.Name) {
+				return &types.SourceError{Location: sourceDir, Err: fmt.Errorf("invalid file name in manifest: %q", entry.Name)}
+			}
+			if !hl.includeFile(path.Base(entry.Name), file) {
+				continue
+			}
+			if entry.SHA256 == "" {
+				return &types.SourceError{Location: file, Err: fmt.Errorf("checksum not found in manifest %v", hl.manifestLocation())}
+			}
+
+			// SHA-256 from the manifest changes whenever file changes so it is used as file version
+			contents, err := hl.readCached(file, entry.SHA256, func() ([]byte, error) {
+				return hl.readFile(file)
+			})
+			if err != nil {
+				return &types.SourceError{Location: file, Err: err}
+			}
+			expected := strings.ToLower(entry.SHA256)
+			actual := checkSum(contents)
+			if expected != actual {
+				return &types.SourceError{Location: file, Err: fmt.Errorf("checksum mismatch, expected %v, got %v", expected, actual)}
+			}
+
+			m := types.Migration{Name: entry.Name, SourceDir: sourceDir, File: file, MigrationType: migrationType, Contents: string(contents), CheckSum: actual}
+
+			e, ok := migrations[m.Name]
+			if ok {
+				e = append(e, m)
+			} else {
+				e = []types.Migration{m}
+			}
+			migrations[m.Name] = e
+		}
+	}
+	return nil
+}
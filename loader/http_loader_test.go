@@ -0,0 +1,252 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lukaszbudnik/migrator/config"
+	"github.com/lukaszbudnik/migrator/types"
+)
+
+var httpFiles = map[string]string{
+	"/migrations/config/201602160001.sql":      "create schema config;",
+	"/migrations/ref/201602160002.sql":         "create table ref.roles (id int);",
+	"/migrations/ref/201602160002.down.sql":    "drop table ref.roles;",
+	"/migrations/tenants/201602160002.sql":     "create table {schema}.users (id int);",
+	"/migrations/tenants-scripts/a.sql":        "select 1;",
+	"/migrations/tenants-scripts/tampered.sql": "select 2;",
+}
+
+func httpManifestJSON(dirs map[string][]string) string {
+	entries := []string{}
+	for dir, files := range dirs {
+		fileEntries := []string{}
+		for _, file := range files {
+			fileEntries = append(fileEntries, fmt.Sprintf(`{"name": "%v", "sha256": "%v"}`, file, checkSum([]byte(httpFiles["/migrations/"+dir+"/"+file]))))
+		}
+		entries = append(entries, fmt.Sprintf(`"%v": [%v]`, dir, strings.Join(fileEntries, ", ")))
+	}
+	return fmt.Sprintf(`{"directories": {%v}}`, strings.Join(entries, ", "))
+}
+
+var httpManifestYAML = fmt.Sprintf(`directories:
+  config:
+    - name: 201602160001.sql
+      sha256: %v
+`, checkSum([]byte("create schema config;")))
+
+// newHTTPMigrationsServer serves files and manifests, when authorization is set every request must have matching Authorization header
+func newHTTPMigrationsServer(authorization string, manifests map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authorization != "" && r.Header.Get("Authorization") != authorization {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if manifest, ok := manifests[r.URL.Path]; ok {
+			w.Write([]byte(manifest))
+			return
+		}
+		if file, ok := httpFiles[r.URL.Path]; ok {
+			w.Write([]byte(file))
+			return
+		}
+		http.Error(w, "Not Found", http.StatusNotFound)
+	}))
+}
+
+func newHTTPTestConfig(baseLocation string) *config.Config {
+	return &config.Config{
+		BaseLocation:     baseLocation,
+		SingleMigrations: []string{"config", "ref"},
+		TenantMigrations: []string{"tenants"},
+		TenantScripts:    []string{"tenants-scripts"},
+		HTTPHeaders:      []string{"Authorization: Bearer token"},
+	}
+}
+
+func TestHTTPGetSourceMigrations(t *testing.T) {
+	manifest := httpManifestJSON(map[string][]string{
+		"config":          {"201602160001.sql"},
+		"ref":             {"201602160002.sql", "201602160002.down.sql"},
+		"tenants":         {"201602160002.sql"},
+		"tenants-scripts": {"a.sql"},
+	})
+	server := newHTTPMigrationsServer("Bearer token", map[string]string{"/migrations/manifest.json": manifest})
+	defer server.Close()
+
+	loader := New(context.TODO(), newHTTPTestConfig(server.URL+"/migrations"))
+	assert.IsType(t, &httpLoader{}, loader)
+
+	migrations, err := loader.GetSourceMigrations()
+	assert.Nil(t, err)
+
+	assert.Len(t, migrations, 4)
+	assert.Equal(t, server.URL+"/migrations/config/201602160001.sql", migrations[0].File)
+	assert.Equal(t, server.URL+"/migrations/config", migrations[0].SourceDir)
+	assert.Equal(t, "201602160001.sql", migrations[0].Name)
+	assert.Equal(t, "create schema config;", migrations[0].Contents)
+	assert.Equal(t, checkSum([]byte("create schema config;")), migrations[0].CheckSum)
+	assert.Equal(t, server.URL+"/migrations/ref/201602160002.sql", migrations[1].File)
+	assert.Equal(t, "drop table ref.roles;", migrations[1].DownContents)
+	assert.Equal(t, server.URL+"/migrations/tenants/201602160002.sql", migrations[2].File)
+	assert.Equal(t, types.MigrationTypeTenantMigration, migrations[2].MigrationType)
+	assert.Equal(t, server.URL+"/migrations/tenants-scripts/a.sql", migrations[3].File)
+	assert.Equal(t, types.MigrationTypeTenantScript, migrations[3].MigrationType)
+
+	assert.Nil(t, loader.HealthCheck())
+}
+
+func TestHTTPGetSourceMigrationsYAMLManifest(t *testing.T) {
+	server := newHTTPMigrationsServer("", map[string]string{"/migrations/releases.yaml": httpManifestYAML})
+	defer server.Close()
+
+	config := &config.Config{
+		BaseLocation:     server.URL + "/migrations/releases.yaml",
+		SingleMigrations: []string{"config"},
+	}
+
+	migrations, err := New(context.TODO(), config).GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 1)
+	assert.Equal(t, server.URL+"/migrations/config/201602160001.sql", migrations[0].File)
+}
+
+func TestHTTPGetSourceMigrationsUnauthorizedError(t *testing.T) {
+	server := newHTTPMigrationsServer("Bearer token", map[string]string{"/migrations/manifest.json": httpManifestYAML})
+	defer server.Close()
+
+	config := newHTTPTestConfig(server.URL + "/migrations/")
+	config.HTTPHeaders = nil
+	loader := New(context.TODO(), config)
+
+	migrations, err := loader.GetSourceMigrations()
+	assert.Nil(t, migrations)
+	assert.Equal(t, fmt.Sprintf("could not read source migrations from %v/migrations/manifest.json: HTTP request failed with status 401 Unauthorized", server.URL), err.Error())
+
+	assert.NotNil(t, loader.HealthCheck())
+}
+
+func TestHTTPGetSourceMigrationsTimeout(t *testing.T) {
+	stalled := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-stalled
+	}))
+	defer server.Close()
+	defer close(stalled)
+
+	config := newHTTPTestConfig(server.URL + "/migrations/")
+	config.HTTPTimeout = "50ms"
+	loader := New(context.TODO(), config)
+
+	migrations, err := loader.GetSourceMigrations()
+	assert.Nil(t, migrations)
+	assert.IsType(t, &types.SourceError{}, err)
+	assert.Contains(t, err.Error(), "Client.Timeout exceeded")
+}
+
+func TestHTTPGetSourceMigrationsManifestErrors(t *testing.T) {
+	tampered := httpManifestJSON(map[string][]string{"config": {"201602160001.sql"}, "ref": {"201602160002.sql"}, "tenants": {"201602160002.sql"}, "tenants-scripts": {"a.sql"}})
+	tampered = strings.Replace(tampered, `"a.sql"`, `"tampered.sql"`, 1)
+
+	tests := []struct {
+		manifest string
+		location string
+		err      string
+	}{
+		{"directories: [", "/migrations/manifest.json", "invalid manifest"},
+		{httpManifestYAML, "/migrations/ref", "directory not found in manifest"},
+		{`{"directories": {"config": [{"name": "201602160001.sql"}]}}`, "/migrations/config/201602160001.sql", "checksum not found in manifest"},
+		{`{"directories": {"config": [{"name": "../ref/201602160002.sql", "sha256": "abc"}]}}`, "/migrations/config", "invalid file name in manifest"},
+		{`{"directories": {"config": [{"name": "201602160003.sql", "sha256": "abc"}]}}`, "/migrations/config/201602160003.sql", "HTTP request failed with status 404 Not Found"},
+		{tampered, "/migrations/tenants-scripts/tampered.sql", fmt.Sprintf("checksum mismatch, expected %v, got %v", checkSum([]byte("select 1;")), checkSum([]byte("select 2;")))},
+	}
+
+	for _, test := range tests {
+		server := newHTTPMigrationsServer("", map[string]string{"/migrations/manifest.json": test.manifest})
+
+		migrations, err := New(context.TODO(), newHTTPTestConfig(server.URL+"/migrations")).GetSourceMigrations()
+		assert.Nil(t, migrations)
+		assert.Contains(t, err.Error(), test.err)
+		var sourceErr *types.SourceError
+		assert.True(t, errors.As(err, &sourceErr))
+		assert.Equal(t, server.URL+test.location, sourceErr.Location)
+
+		server.Close()
+	}
+}
+
+func TestHTTPInvalidHeaderError(t *testing.T) {
+	server := newHTTPMigrationsServer("", map[string]string{"/migrations/manifest.json": httpManifestYAML})
+	defer server.Close()
+
+	config := newHTTPTestConfig(server.URL + "/migrations")
+	config.HTTPHeaders = []string{"Authorization"}
+
+	assert.Equal(t, "invalid HTTP header: Authorization", New(context.TODO(), config).HealthCheck().Error())
+}
+
+func TestHTTPArchiveGetSourceMigrations(t *testing.T) {
+	contents := newTarGz(t, archiveFiles)
+	server := newHTTPMigrationsServer("Bearer token", map[string]string{
+		"/releases/migrations.tar.gz":        string(contents),
+		"/releases/migrations.tar.gz.sha256": checkSum(contents),
+	})
+	defer server.Close()
+
+	config := &config.Config{
+		BaseLocation:        server.URL + "/releases/migrations.tar.gz",
+		SingleMigrations:    []string{"migrations/config"},
+		ArchiveVerification: config.ArchiveVerificationChecksum,
+		HTTPHeaders:         []string{"Authorization: Bearer token"},
+	}
+
+	migrations, err := New(context.TODO(), config).GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 1)
+	assert.Equal(t, server.URL+"/releases/migrations/config/201602160001.sql", migrations[0].File)
+}
+
+func TestCachingLoaderHTTPChangeDetection(t *testing.T) {
+	contents := "create schema config;"
+	downloads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/migrations/manifest.json" {
+			fmt.Fprintf(w, `{"directories": {"config": [{"name": "201602160001.sql", "sha256": "%v"}]}}`, checkSum([]byte(contents)))
+			return
+		}
+		downloads++
+		w.Write([]byte(contents))
+	}))
+	defer server.Close()
+
+	config := &config.Config{
+		BaseLocation:     server.URL + "/migrations",
+		SingleMigrations: []string{"config"},
+		// expires immediately, manifest is read every time
+		SourceCacheTTL: "1ns",
+	}
+
+	migrations, err := New(context.TODO(), config).GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 1)
+	assert.Equal(t, 1, downloads)
+
+	// checksum in manifest did not change, file is not downloaded again
+	migrations, err = New(context.TODO(), config).GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Equal(t, "create schema config;", migrations[0].Contents)
+	assert.Equal(t, 1, downloads)
+
+	contents = "create schema config2;"
+	migrations, err = New(context.TODO(), config).GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Equal(t, "create schema config2;", migrations[0].Contents)
+	assert.Equal(t, 2, downloads)
+}
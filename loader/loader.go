@@ -2,17 +2,22 @@ package loader
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
 	"regexp"
 	"sort"
 	"strings"
 
+	"github.com/lukaszbudnik/migrator/common"
 	"github.com/lukaszbudnik/migrator/config"
 	"github.com/lukaszbudnik/migrator/types"
 )
 
 // Loader interface abstracts all loading operations performed by migrator
 type Loader interface {
-	GetSourceMigrations() []types.Migration
+	GetSourceMigrations() ([]types.Migration, error)
 	HealthCheck() error
 }
 
@@ -20,20 +25,54 @@ type Loader interface {
 type Factory func(context.Context, *config.Config) Loader
 
 // New returns new instance of Loader
+// when sourceCacheTTL is set returned Loader caches source migrations, see Refresher
 func New(ctx context.Context, config *config.Config) Loader {
+	loader := newLoader(ctx, config)
+	if ttl := sourceCacheTTL(config); ttl > 0 {
+		return &cachingLoader{Loader: loader, key: cacheKey(config), ttl: ttl}
+	}
+	return loader
+}
+
+// newLoader returns Loader for git repository, archive, or storage location
+func newLoader(ctx context.Context, config *config.Config) Loader {
+	if isGitLocation(config.BaseLocation) {
+		return &gitLoader{baseLoader{ctx, config}}
+	}
+	if isArchiveLocation(config.BaseLocation) {
+		return &archiveLoader{
+			baseLoader: baseLoader{ctx, config},
+			storage:    newStorageLoader(ctx, config),
+		}
+	}
+	return newStorageLoader(ctx, config)
+}
+
+// newStorageLoader returns Loader for local disk, AWS S3, Google Cloud Storage, Azure Blob or HTTP(S) location
+func newStorageLoader(ctx context.Context, config *config.Config) storageLoader {
 	if strings.HasPrefix(config.BaseLocation, "s3://") {
 		return &s3Loader{
 			baseLoader:       baseLoader{ctx, config},
-			clientFactory:    &defaultS3ClientFactory{},
+			clientFactory:    &defaultS3ClientFactory{config},
 			paginatorFactory: &defaultS3PaginatorFactory{},
 		}
 	}
+	if strings.HasPrefix(config.BaseLocation, "gs://") {
+		return &gcsLoader{
+			baseLoader:       baseLoader{ctx, config},
+			clientFactory:    &defaultGCSClientFactory{},
+			paginatorFactory: &defaultGCSPaginatorFactory{},
+		}
+	}
 	if matched, _ := regexp.Match(`^https://.*\.blob\.core\.windows\.net/.*`, []byte(config.BaseLocation)); matched {
 		return &azureBlobLoader{
 			baseLoader:    baseLoader{ctx, config},
 			clientFactory: &defaultAzureBlobClientFactory{},
 		}
 	}
+	if isHTTPLocation(config.BaseLocation) {
+		return &httpLoader{baseLoader{ctx, config}}
+	}
 	return &diskLoader{baseLoader{ctx, config}}
 }
 
@@ -43,15 +82,144 @@ type baseLoader struct {
 	config *config.Config
 }
 
+// sortMigrations pairs down migrations with their up migrations
+// and then appends migrations to the passed slice sorted by name
+// names of migrations read from subdirectories are paths relative to source dir, they are compared segment by segment
+// so that all files in a subdirectory are sorted together, for example:
+// 2023/12/202312010001.sql, 2024/01/202401010001.sql, 2024/01/202401150001.sql, 2024/02/202402010001.sql, 202403010001.sql
+// migrations with the same name from different source dirs keep the order of source dirs in config
+// when signaturePublicKeys is set migrations are verified against their detached signatures before down migrations are paired
 func (bl *baseLoader) sortMigrations(migrationsMap map[string][]types.Migration, migrations *[]types.Migration) {
+	bl.verifySignatures(migrationsMap)
+	bl.pairDownMigrations(migrationsMap)
+
 	keys := make([]string, 0, len(migrationsMap))
 	for key := range migrationsMap {
 		keys = append(keys, key)
 	}
-	sort.Strings(keys)
+	sort.Slice(keys, func(i, j int) bool {
+		return comparePaths(keys[i], keys[j]) < 0
+	})
 
 	for _, key := range keys {
 		ms := migrationsMap[key]
 		*migrations = append(*migrations, ms...)
 	}
 }
+
+// pairDownMigrations sets DownContents of up migrations (for example 201602160002.sql)
+// to contents of the down migrations from the same source dir (for example 201602160002.down.sql)
+// down migrations are removed from the map as they are never applied on their own
+// signature error of down migration is set on its up migration as down migration is applied when version is rolled back
+func (bl *baseLoader) pairDownMigrations(migrationsMap map[string][]types.Migration) {
+	for name, downs := range migrationsMap {
+		upName, isDown := types.UpMigrationFile(name)
+		if !isDown {
+			continue
+		}
+		ups := migrationsMap[upName]
+		for _, down := range downs {
+			paired := false
+			for i := range ups {
+				if ups[i].SourceDir == down.SourceDir {
+					ups[i].DownContents = down.Contents
+					if down.SignatureError != "" && ups[i].SignatureError == "" {
+						ups[i].Signer = nil
+						ups[i].SignatureError = fmt.Sprintf("down migration: %v", down.SignatureError)
+					}
+					paired = true
+				}
+			}
+			if !paired {
+				common.LogError(bl.ctx, "Down migration %v has no corresponding up migration, skipping", down.File)
+			}
+		}
+		delete(migrationsMap, name)
+	}
+}
+
+// comparePaths compares slash-separated paths segment by segment, when all segments of the shorter path are equal the shorter path is first
+// paths without subdirectories are compared like strings
+func comparePaths(a, b string) int {
+	as := strings.Split(a, "/")
+	bs := strings.Split(b, "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if c := strings.Compare(as[i], bs[i]); c != 0 {
+			return c
+		}
+	}
+	return len(as) - len(bs)
+}
+
+// objectName returns name of object relative to dir prefix (ending with /), for example: 2024/01/202401010001.sql
+// the bool return value is false for objects outside of dir prefix, directory placeholders,
+// and objects placed in subdirectories when recursive is not enabled
+func (bl *baseLoader) objectName(dirPrefix, key string) (string, bool) {
+	if !strings.HasPrefix(key, dirPrefix) {
+		return "", false
+	}
+	name := strings.TrimPrefix(key, dirPrefix)
+	if name == "" || strings.HasSuffix(name, "/") {
+		return "", false
+	}
+	if strings.Contains(name, "/") && !bl.config.Recursive {
+		return "", false
+	}
+	return name, true
+}
+
+// prefixObjectName returns name of object relative to dir prefix (ending with /) for AWS S3 and Azure Blob Containers
+// which always listed all objects under dir prefix, when recursive is not enabled objects in subdirectories are read too,
+// like in migrator versions before recursive was added, and their name is the last segment of their key, for example: 202401010001.sql
+// recursive enables path-based names, see objectName
+func (bl *baseLoader) prefixObjectName(dirPrefix, key string) (string, bool) {
+	if bl.config.Recursive {
+		return bl.objectName(dirPrefix, key)
+	}
+	if !strings.HasPrefix(key, dirPrefix) || strings.HasSuffix(key, "/") {
+		return "", false
+	}
+	return path.Base(key), true
+}
+
+// defaultIncludePatterns are used when includePatterns is not set
+var defaultIncludePatterns = []string{"*.sql"}
+
+// includeFile returns true if file name matches any of includePatterns and none of excludePatterns
+// patterns are matched against file name using path.Match, skipped files are logged at debug level
+// when signaturePublicKeys is set detached signatures of included files are included too
+func (bl *baseLoader) includeFile(name, location string) bool {
+	if len(bl.config.SignaturePublicKeys) > 0 && strings.HasSuffix(name, signatureSuffix) {
+		name = strings.TrimSuffix(name, signatureSuffix)
+	}
+	includePatterns := bl.config.IncludePatterns
+	if len(includePatterns) == 0 {
+		includePatterns = defaultIncludePatterns
+	}
+	if !matchAny(includePatterns, name) {
+		common.LogDebug(bl.ctx, "Skipping file %v, it does not match include patterns %v", location, includePatterns)
+		return false
+	}
+	if matchAny(bl.config.ExcludePatterns, name) {
+		common.LogDebug(bl.ctx, "Skipping file %v, it matches exclude patterns %v", location, bl.config.ExcludePatterns)
+		return false
+	}
+	return true
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		// patterns are validated when config is loaded
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSum returns hex encoded SHA-256 of passed contents
+func checkSum(contents []byte) string {
+	hasher := sha256.New()
+	hasher.Write(contents)
+	return hex.EncodeToString(hasher.Sum(nil))
+}
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/lukaszbudnik/migrator/config"
+	"github.com/lukaszbudnik/migrator/types"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -31,3 +32,132 @@ func TestNewS3Loader(t *testing.T) {
 	loader := New(context.TODO(), config)
 	assert.IsType(t, &s3Loader{}, loader)
 }
+
+func TestNewGCSLoader(t *testing.T) {
+	config := &config.Config{
+		BaseLocation: "gs://lukaszbudniktest-bucket",
+	}
+	loader := New(context.TODO(), config)
+	assert.IsType(t, &gcsLoader{}, loader)
+}
+
+func TestSortMigrationsPairsDownMigrations(t *testing.T) {
+	up1 := types.Migration{Name: "201602160002.sql", SourceDir: "ref", File: "ref/201602160002.sql", MigrationType: types.MigrationTypeSingleMigration, Contents: "create table abc"}
+	up2 := types.Migration{Name: "201602160002.sql", SourceDir: "config", File: "config/201602160002.sql", MigrationType: types.MigrationTypeSingleMigration, Contents: "create table def"}
+	down1 := types.Migration{Name: "201602160002.down.sql", SourceDir: "ref", File: "ref/201602160002.down.sql", MigrationType: types.MigrationTypeSingleMigration, Contents: "drop table abc"}
+	// orphaned down migration is skipped
+	down2 := types.Migration{Name: "201602160003.down.sql", SourceDir: "ref", File: "ref/201602160003.down.sql", MigrationType: types.MigrationTypeSingleMigration, Contents: "drop table ghi"}
+
+	migrationsMap := map[string][]types.Migration{
+		up1.Name:   {up1, up2},
+		down1.Name: {down1},
+		down2.Name: {down2},
+	}
+
+	bl := &baseLoader{context.TODO(), &config.Config{}}
+	migrations := []types.Migration{}
+	bl.sortMigrations(migrationsMap, &migrations)
+
+	assert.Len(t, migrations, 2)
+	assert.Equal(t, "ref/201602160002.sql", migrations[0].File)
+	assert.Equal(t, "drop table abc", migrations[0].DownContents)
+	assert.Equal(t, "config/201602160002.sql", migrations[1].File)
+	assert.Equal(t, "", migrations[1].DownContents)
+}
+
+func TestNewGitLoader(t *testing.T) {
+	for _, baseLocation := range []string{"git+file:///srv/git/migrations.git", "git+https://github.com/lukaszbudnik/migrations.git#v1.0.0"} {
+		config := &config.Config{
+			BaseLocation: baseLocation,
+		}
+		loader := New(context.TODO(), config)
+		assert.IsType(t, &gitLoader{}, loader)
+	}
+}
+
+func TestNewArchiveLoader(t *testing.T) {
+	tests := []struct {
+		baseLocation string
+		storage      storageLoader
+	}{
+		{"/path/to/migrations.tar.gz", &diskLoader{}},
+		{"migrations.TGZ", &diskLoader{}},
+		{"s3://lukaszbudniktest-bucket/migrations.zip", &s3Loader{}},
+		{"https://lukaszbudniktest.blob.core.windows.net/mycontainer/migrations.zip", &azureBlobLoader{}},
+	}
+	for _, test := range tests {
+		config := &config.Config{
+			BaseLocation: test.baseLocation,
+		}
+		loader := New(context.TODO(), config)
+		assert.IsType(t, &archiveLoader{}, loader)
+		assert.IsType(t, test.storage, loader.(*archiveLoader).storage)
+	}
+}
+
+func TestIncludeFile(t *testing.T) {
+	tests := []struct {
+		include  []string
+		exclude  []string
+		name     string
+		included bool
+	}{
+		{nil, nil, "201602160001.sql", true},
+		{nil, nil, "201602160001.down.sql", true},
+		{nil, nil, "README.md", false},
+		{nil, nil, ".DS_Store", false},
+		{nil, nil, "201602160001.sql~", false},
+		{[]string{"*.sql", "*.cql"}, nil, "201602160001.cql", true},
+		{[]string{"*.cql"}, nil, "201602160001.sql", false},
+		{nil, []string{"*.down.sql", "draft-*"}, "201602160001.down.sql", false},
+		{nil, []string{"*.down.sql", "draft-*"}, "draft-201602160001.sql", false},
+		{nil, []string{"*.down.sql", "draft-*"}, "201602160001.sql", true},
+	}
+
+	for _, test := range tests {
+		config := &config.Config{IncludePatterns: test.include, ExcludePatterns: test.exclude}
+		loader := &baseLoader{context.TODO(), config}
+		assert.Equal(t, test.included, loader.includeFile(test.name, "migrations/"+test.name), test.name)
+	}
+}
+
+func TestSortMigrationsNestedPaths(t *testing.T) {
+	migrationsMap := map[string][]types.Migration{}
+	for _, name := range []string{"202403010001.sql", "2024/02/202402010001.sql", "2024/01/202401150001.sql", "2023/12/202312010001.sql", "2024/01/202401010001.sql", "2024/01/202401010001.down.sql"} {
+		migrationsMap[name] = []types.Migration{{Name: name, SourceDir: "ref", File: "ref/" + name, Contents: name}}
+	}
+
+	bl := &baseLoader{context.TODO(), &config.Config{}}
+	migrations := []types.Migration{}
+	bl.sortMigrations(migrationsMap, &migrations)
+
+	names := []string{}
+	for _, m := range migrations {
+		names = append(names, m.Name)
+	}
+	// all files in a subdirectory are sorted together, 2024/ is sorted before 202403010001.sql
+	assert.Equal(t, []string{"2023/12/202312010001.sql", "2024/01/202401010001.sql", "2024/01/202401150001.sql", "2024/02/202402010001.sql", "202403010001.sql"}, names)
+	assert.Equal(t, "2024/01/202401010001.down.sql", migrations[1].DownContents)
+}
+
+func TestObjectName(t *testing.T) {
+	tests := []struct {
+		recursive bool
+		key       string
+		name      string
+		ok        bool
+	}{
+		{false, "migrations/ref/201602160001.sql", "201602160001.sql", true},
+		{false, "migrations/ref/2024/01/202401010001.sql", "", false},
+		{true, "migrations/ref/2024/01/202401010001.sql", "2024/01/202401010001.sql", true},
+		{true, "migrations/ref/2024/", "", false},
+		{true, "migrations/ref-scripts/201602160001.sql", "", false},
+	}
+
+	for _, test := range tests {
+		bl := &baseLoader{context.TODO(), &config.Config{Recursive: test.recursive}}
+		name, ok := bl.objectName("migrations/ref/", test.key)
+		assert.Equal(t, test.name, name)
+		assert.Equal(t, test.ok, ok)
+	}
+}
@@ -2,18 +2,23 @@ package loader
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"fmt"
 	"io"
+	"path"
 	"strings"
+	"sync"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/lukaszbudnik/migrator/config"
 	"github.com/lukaszbudnik/migrator/types"
 )
 
+// defaultS3Concurrency is the number of objects downloaded concurrently when s3Concurrency is not set
+const defaultS3Concurrency = 10
+
 // S3APIClient interface for S3 operations
 type S3APIClient interface {
 	GetObject(context.Context, *s3.GetObjectInput, ...func(*s3.Options)) (*s3.GetObjectOutput, error)
@@ -28,7 +33,7 @@ type S3ListObjectsV2Paginator interface {
 
 // S3ClientFactory creates S3 clients
 type S3ClientFactory interface {
-	NewClient(ctx context.Context) S3APIClient
+	NewClient(ctx context.Context) (S3APIClient, error)
 }
 
 // S3PaginatorFactory creates paginators
@@ -44,14 +49,29 @@ type s3Loader struct {
 }
 
 // defaultS3ClientFactory implements S3ClientFactory
-type defaultS3ClientFactory struct{}
+// endpoint, region, path-style addressing and credentials profile are set using s3 config properties
+type defaultS3ClientFactory struct {
+	config *config.Config
+}
 
-func (f *defaultS3ClientFactory) NewClient(ctx context.Context) S3APIClient {
-	cfg, err := config.LoadDefaultConfig(ctx)
+func (f *defaultS3ClientFactory) NewClient(ctx context.Context) (S3APIClient, error) {
+	options := []func(*awsconfig.LoadOptions) error{}
+	if f.config.S3Region != "" {
+		options = append(options, awsconfig.WithRegion(f.config.S3Region))
+	}
+	if f.config.S3Profile != "" {
+		options = append(options, awsconfig.WithSharedConfigProfile(f.config.S3Profile))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, options...)
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
-	return s3.NewFromConfig(cfg)
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if f.config.S3Endpoint != "" {
+			o.BaseEndpoint = aws.String(f.config.S3Endpoint)
+		}
+		o.UsePathStyle = f.config.S3ForcePathStyle
+	}), nil
 }
 
 // defaultS3PaginatorFactory implements S3PaginatorFactory
@@ -72,20 +92,62 @@ func (s3l *s3Loader) getClientFactory() S3ClientFactory {
 	if s3l.clientFactory != nil {
 		return s3l.clientFactory
 	}
-	return &defaultS3ClientFactory{}
+	return &defaultS3ClientFactory{s3l.config}
+}
+
+// getConcurrency returns number of objects downloaded concurrently
+func (s3l *s3Loader) getConcurrency() int {
+	if s3l.config.S3Concurrency > 0 {
+		return s3l.config.S3Concurrency
+	}
+	return defaultS3Concurrency
 }
 
 // GetSourceMigrations returns all migrations from AWS S3 location
-func (s3l *s3Loader) GetSourceMigrations() []types.Migration {
-	client := s3l.getClientFactory().NewClient(s3l.ctx)
+func (s3l *s3Loader) GetSourceMigrations() ([]types.Migration, error) {
+	client, err := s3l.getClientFactory().NewClient(s3l.ctx)
+	if err != nil {
+		return nil, &types.SourceError{Location: s3l.config.BaseLocation, Err: err}
+	}
 	return s3l.doGetSourceMigrations(client)
 }
 
 func (s3l *s3Loader) HealthCheck() error {
-	client := s3l.getClientFactory().NewClient(s3l.ctx)
+	client, err := s3l.getClientFactory().NewClient(s3l.ctx)
+	if err != nil {
+		return err
+	}
 	return s3l.doHealthCheck(client)
 }
 
+// readFile reads a single object, location is s3://bucket/key
+func (s3l *s3Loader) readFile(location string) ([]byte, error) {
+	client, err := s3l.getClientFactory().NewClient(s3l.ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s3l.doReadFile(client, location)
+}
+
+func (s3l *s3Loader) doReadFile(client S3APIClient, location string) ([]byte, error) {
+	bucketWithKey := strings.SplitN(strings.Replace(location, "s3://", "", 1), "/", 2)
+	if len(bucketWithKey) != 2 {
+		return nil, fmt.Errorf("invalid S3 object location: %v", location)
+	}
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(bucketWithKey[0]),
+		Key:    aws.String(bucketWithKey[1]),
+	}
+	object, err := client.GetObject(s3l.ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	defer object.Body.Close()
+
+	return io.ReadAll(object.Body)
+}
+
 func (s3l *s3Loader) doHealthCheck(client S3APIClient) error {
 	bucketWithPrefixes := strings.Split(strings.Replace(strings.TrimRight(s3l.config.BaseLocation, "/"), "s3://", "", 1), "/")
 
@@ -106,7 +168,7 @@ func (s3l *s3Loader) doHealthCheck(client S3APIClient) error {
 	return err
 }
 
-func (s3l *s3Loader) doGetSourceMigrations(client S3APIClient) []types.Migration {
+func (s3l *s3Loader) doGetSourceMigrations(client S3APIClient) ([]types.Migration, error) {
 	migrations := []types.Migration{}
 
 	bucketWithPrefixes := strings.Split(strings.Replace(strings.TrimRight(s3l.config.BaseLocation, "/"), "s3://", "", 1), "/")
@@ -117,29 +179,56 @@ func (s3l *s3Loader) doGetSourceMigrations(client S3APIClient) []types.Migration
 		optionalPrefixes = strings.Join(bucketWithPrefixes[1:], "/")
 	}
 
-	singleMigrationsObjects := s3l.getObjectList(client, bucket, optionalPrefixes, s3l.config.SingleMigrations)
-	tenantMigrationsObjects := s3l.getObjectList(client, bucket, optionalPrefixes, s3l.config.TenantMigrations)
-	singleScriptsObjects := s3l.getObjectList(client, bucket, optionalPrefixes, s3l.config.SingleScripts)
-	tenantScriptsObjects := s3l.getObjectList(client, bucket, optionalPrefixes, s3l.config.TenantScripts)
+	singleMigrationsObjects, err := s3l.getObjectList(client, bucket, optionalPrefixes, s3l.config.SingleMigrations)
+	if err != nil {
+		return nil, err
+	}
+	tenantMigrationsObjects, err := s3l.getObjectList(client, bucket, optionalPrefixes, s3l.config.TenantMigrations)
+	if err != nil {
+		return nil, err
+	}
+	singleScriptsObjects, err := s3l.getObjectList(client, bucket, optionalPrefixes, s3l.config.SingleScripts)
+	if err != nil {
+		return nil, err
+	}
+	tenantScriptsObjects, err := s3l.getObjectList(client, bucket, optionalPrefixes, s3l.config.TenantScripts)
+	if err != nil {
+		return nil, err
+	}
 
 	migrationsMap := make(map[string][]types.Migration)
-	s3l.getObjects(client, bucket, migrationsMap, singleMigrationsObjects, types.MigrationTypeSingleMigration)
-	s3l.getObjects(client, bucket, migrationsMap, tenantMigrationsObjects, types.MigrationTypeTenantMigration)
+	if err := s3l.getObjects(client, bucket, migrationsMap, singleMigrationsObjects, types.MigrationTypeSingleMigration); err != nil {
+		return nil, err
+	}
+	if err := s3l.getObjects(client, bucket, migrationsMap, tenantMigrationsObjects, types.MigrationTypeTenantMigration); err != nil {
+		return nil, err
+	}
 	s3l.sortMigrations(migrationsMap, &migrations)
 
 	migrationsMap = make(map[string][]types.Migration)
-	s3l.getObjects(client, bucket, migrationsMap, singleScriptsObjects, types.MigrationTypeSingleScript)
+	if err := s3l.getObjects(client, bucket, migrationsMap, singleScriptsObjects, types.MigrationTypeSingleScript); err != nil {
+		return nil, err
+	}
 	s3l.sortMigrations(migrationsMap, &migrations)
 
 	migrationsMap = make(map[string][]types.Migration)
-	s3l.getObjects(client, bucket, migrationsMap, tenantScriptsObjects, types.MigrationTypeTenantScript)
+	if err := s3l.getObjects(client, bucket, migrationsMap, tenantScriptsObjects, types.MigrationTypeTenantScript); err != nil {
+		return nil, err
+	}
 	s3l.sortMigrations(migrationsMap, &migrations)
 
-	return migrations
+	return migrations, nil
+}
+
+// s3Object is an object listed in source dir, name is its key relative to source dir
+type s3Object struct {
+	s3types.Object
+	name string
 }
 
-func (s3l *s3Loader) getObjectList(client S3APIClient, bucket, optionalPrefixes string, prefixes []string) []*string {
-	objects := []*string{}
+// getObjectList lists objects placed in passed dirs and their subdirectories, see prefixObjectName
+func (s3l *s3Loader) getObjectList(client S3APIClient, bucket, optionalPrefixes string, prefixes []string) ([]s3Object, error) {
+	objects := []s3Object{}
 
 	for _, prefix := range prefixes {
 
@@ -160,41 +249,60 @@ func (s3l *s3Loader) getObjectList(client S3APIClient, bucket, optionalPrefixes
 		for paginator.HasMorePages() {
 			page, err := paginator.NextPage(s3l.ctx)
 			if err != nil {
-				panic(err.Error())
+				return nil, &types.SourceError{Location: fmt.Sprintf("s3://%s/%s", bucket, fullPrefix), Err: err}
 			}
 			for _, obj := range page.Contents {
-				objects = append(objects, obj.Key)
+				key := aws.ToString(obj.Key)
+				name, ok := s3l.prefixObjectName(fullPrefix+"/", key)
+				if ok && s3l.includeFile(path.Base(name), fmt.Sprintf("s3://%s/%s", bucket, key)) {
+					objects = append(objects, s3Object{obj, name})
+				}
 			}
 		}
 	}
 
-	return objects
+	return objects, nil
 }
 
-func (s3l *s3Loader) getObjects(client S3APIClient, bucket string, migrationsMap map[string][]types.Migration, objects []*string, migrationType types.MigrationType) {
+// getObjects downloads objects using a pool of s3Concurrency workers, objects which did not change are read from source cache
+// migrations are added to the map in the order of passed objects so that sortMigrations stays deterministic
+// when more than one download failed the error of the first object is returned
+func (s3l *s3Loader) getObjects(client S3APIClient, bucket string, migrationsMap map[string][]types.Migration, objects []s3Object, migrationType types.MigrationType) error {
+	contents := make([][]byte, len(objects))
+	errs := make([]error, len(objects))
+
+	var (
+		wg      sync.WaitGroup
+		indexes = make(chan int)
+	)
+
+	for w := 0; w < s3l.getConcurrency(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				location := fmt.Sprintf("s3://%s/%s", bucket, *objects[i].Key)
+				contents[i], errs[i] = s3l.readCached(location, aws.ToString(objects[i].ETag), func() ([]byte, error) {
+					return s3l.doReadFile(client, location)
+				})
+			}
+		}()
+	}
 
-	for _, o := range objects {
-		input := &s3.GetObjectInput{
-			Bucket: aws.String(bucket),
-			Key:    aws.String(*o),
-		}
-		object, err := client.GetObject(s3l.ctx, input)
-		if err != nil {
-			panic(err.Error())
-		}
+	for i := range objects {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
 
-		contents, err := io.ReadAll(object.Body)
-		if err != nil {
-			panic(err.Error())
+	for i, o := range objects {
+		if errs[i] != nil {
+			return &types.SourceError{Location: fmt.Sprintf("s3://%s/%s", bucket, *o.Key), Err: errs[i]}
 		}
 
-		hasher := sha256.New()
-		hasher.Write(contents)
-		file := fmt.Sprintf("%s/%s", s3l.config.BaseLocation, *o)
-		from := strings.LastIndex(file, "/")
-		sourceDir := file[0:from]
-		name := file[from+1:]
-		m := types.Migration{Name: name, SourceDir: sourceDir, File: file, MigrationType: migrationType, Contents: string(contents), CheckSum: hex.EncodeToString(hasher.Sum(nil))}
+		file := fmt.Sprintf("%s/%s", s3l.config.BaseLocation, *o.Key)
+		sourceDir := strings.TrimSuffix(file, "/"+o.name)
+		m := types.Migration{Name: o.name, SourceDir: sourceDir, File: file, MigrationType: migrationType, Contents: string(contents[i]), CheckSum: checkSum(contents[i])}
 
 		e, ok := migrationsMap[m.Name]
 		if ok {
@@ -204,4 +312,5 @@ func (s3l *s3Loader) getObjects(client S3APIClient, bucket string, migrationsMap
 		}
 		migrationsMap[m.Name] = e
 	}
+	return nil
 }
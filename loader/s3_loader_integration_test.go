@@ -25,15 +25,19 @@ func TestS3GetSourceMigrationsIntegration(t *testing.T) {
 		TenantMigrations: []string{"migrations/tenants"},
 		SingleScripts:    []string{"migrations/config-scripts"},
 		TenantScripts:    []string{"migrations/tenants-scripts"},
+		// optional, for example MinIO or LocalStack
+		S3Endpoint:       os.Getenv("S3_ENDPOINT"),
+		S3ForcePathStyle: os.Getenv("S3_ENDPOINT") != "",
 	}
 
 	loader := &s3Loader{
 		baseLoader:       baseLoader{context.TODO(), config},
-		clientFactory:    &defaultS3ClientFactory{},
+		clientFactory:    &defaultS3ClientFactory{config},
 		paginatorFactory: &defaultS3PaginatorFactory{},
 	}
 
-	migrations := loader.GetSourceMigrations()
+	migrations, err := loader.GetSourceMigrations()
+	assert.Nil(t, err)
 
 	assert.Len(t, migrations, 16)
 
@@ -70,15 +74,19 @@ func TestS3GetSourceMigrationsBucketWithPrefixIntegration(t *testing.T) {
 		TenantMigrations: []string{"migrations/tenants"},
 		SingleScripts:    []string{"migrations/config-scripts"},
 		TenantScripts:    []string{"migrations/tenants-scripts"},
+		// optional, for example MinIO or LocalStack
+		S3Endpoint:       os.Getenv("S3_ENDPOINT"),
+		S3ForcePathStyle: os.Getenv("S3_ENDPOINT") != "",
 	}
 
 	loader := &s3Loader{
 		baseLoader:       baseLoader{context.TODO(), config},
-		clientFactory:    &defaultS3ClientFactory{},
+		clientFactory:    &defaultS3ClientFactory{config},
 		paginatorFactory: &defaultS3PaginatorFactory{},
 	}
 
-	migrations := loader.GetSourceMigrations()
+	migrations, err := loader.GetSourceMigrations()
+	assert.Nil(t, err)
 
 	assert.Len(t, migrations, 16)
 
@@ -115,11 +123,14 @@ func TestS3HealthCheckIntegration(t *testing.T) {
 		TenantMigrations: []string{"migrations/tenants"},
 		SingleScripts:    []string{"migrations/config-scripts"},
 		TenantScripts:    []string{"migrations/tenants-scripts"},
+		// optional, for example MinIO or LocalStack
+		S3Endpoint:       os.Getenv("S3_ENDPOINT"),
+		S3ForcePathStyle: os.Getenv("S3_ENDPOINT") != "",
 	}
 
 	loader := &s3Loader{
 		baseLoader:       baseLoader{context.TODO(), config},
-		clientFactory:    &defaultS3ClientFactory{},
+		clientFactory:    &defaultS3ClientFactory{config},
 		paginatorFactory: &defaultS3PaginatorFactory{},
 	}
 
@@ -3,13 +3,25 @@ package loader
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/lukaszbudnik/migrator/config"
+	migratortypes "github.com/lukaszbudnik/migrator/types"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -29,8 +41,8 @@ type mockS3ClientFactory struct {
 	client S3APIClient
 }
 
-func (f *mockS3ClientFactory) NewClient(ctx context.Context) S3APIClient {
-	return f.client
+func (f *mockS3ClientFactory) NewClient(ctx context.Context) (S3APIClient, error) {
+	return f.client, nil
 }
 
 type mockS3PaginatorFactory struct{}
@@ -129,7 +141,8 @@ func TestS3GetSourceMigrations(t *testing.T) {
 		paginatorFactory: &mockS3PaginatorFactory{},
 	}
 
-	migrations := loader.doGetSourceMigrations(loader.getClientFactory().NewClient(context.TODO()))
+	migrations, err := loader.GetSourceMigrations()
+	assert.Nil(t, err)
 
 	assert.Len(t, migrations, 12)
 
@@ -164,7 +177,8 @@ func TestS3GetSourceMigrationsBucketWithPrefix(t *testing.T) {
 		clientFactory:    &mockS3ClientFactory{client: mock},
 		paginatorFactory: &mockS3PaginatorFactory{},
 	}
-	migrations := loader.doGetSourceMigrations(loader.getClientFactory().NewClient(context.TODO()))
+	migrations, err := loader.GetSourceMigrations()
+	assert.Nil(t, err)
 
 	assert.Len(t, migrations, 12)
 
@@ -198,7 +212,249 @@ func TestS3HealthCheck(t *testing.T) {
 		clientFactory:    &mockS3ClientFactory{client: mock},
 		paginatorFactory: &mockS3PaginatorFactory{},
 	}
-	err := loader.doHealthCheck(loader.getClientFactory().NewClient(context.TODO()))
+	err := loader.HealthCheck()
+
+	assert.Nil(t, err)
+}
+
+type mockS3ClientGetObjectError struct {
+	mockS3Client
+}
+
+func (m *mockS3ClientGetObjectError) GetObject(ctx context.Context, input *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	return nil, errors.New("access denied")
+}
+
+func TestS3GetSourceMigrationsGetObjectError(t *testing.T) {
+	mock := &mockS3ClientGetObjectError{}
+
+	config := &config.Config{
+		BaseLocation:     "s3://your-bucket-migrator",
+		SingleMigrations: []string{"migrations/config"},
+	}
+
+	loader := &s3Loader{
+		baseLoader:       baseLoader{context.TODO(), config},
+		clientFactory:    &mockS3ClientFactory{client: mock},
+		paginatorFactory: &mockS3PaginatorFactory{},
+	}
+
+	migrations, err := loader.GetSourceMigrations()
+	assert.Nil(t, migrations)
+
+	var sourceErr *migratortypes.SourceError
+	assert.True(t, errors.As(err, &sourceErr))
+	assert.Equal(t, "s3://your-bucket-migrator/migrations/config/201602160001.sql", sourceErr.Location)
+	assert.Equal(t, "could not read source migrations from s3://your-bucket-migrator/migrations/config/201602160001.sql: access denied", err.Error())
+}
+
+type mockS3ClientConcurrency struct {
+	mockS3Client
+	mutex    sync.Mutex
+	inFlight int
+	max      int
+}
+
+func (m *mockS3ClientConcurrency) GetObject(ctx context.Context, input *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	m.mutex.Lock()
+	m.inFlight++
+	if m.inFlight > m.max {
+		m.max = m.inFlight
+	}
+	m.mutex.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	m.mutex.Lock()
+	m.inFlight--
+	m.mutex.Unlock()
+
+	if strings.HasSuffix(*input.Key, "cleanup.sql") {
+		return nil, errors.New("access denied: " + *input.Key)
+	}
+	return m.mockS3Client.GetObject(ctx, input, optFns...)
+}
+
+func TestS3GetSourceMigrationsConcurrency(t *testing.T) {
+	mock := &mockS3ClientConcurrency{}
+
+	config := &config.Config{
+		BaseLocation:     "s3://your-bucket-migrator",
+		SingleMigrations: []string{"migrations/config", "migrations/ref"},
+		TenantMigrations: []string{"migrations/tenants"},
+		S3Concurrency:    2,
+	}
+
+	loader := &s3Loader{
+		baseLoader:       baseLoader{context.TODO(), config},
+		clientFactory:    &mockS3ClientFactory{client: mock},
+		paginatorFactory: &mockS3PaginatorFactory{},
+	}
+
+	migrations, err := loader.GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, mock.max)
+
+	// same order as when objects are downloaded one by one
+	assert.Len(t, migrations, 7)
+	assert.Equal(t, "s3://your-bucket-migrator/migrations/config/201602160001.sql", migrations[0].File)
+	assert.Equal(t, "migrations/config/201602160001.sql", migrations[0].Contents)
+	assert.Equal(t, "s3://your-bucket-migrator/migrations/config/201602160002.sql", migrations[1].File)
+	assert.Equal(t, "s3://your-bucket-migrator/migrations/tenants/201602160002.sql", migrations[2].File)
+	assert.Equal(t, "s3://your-bucket-migrator/migrations/ref/202001100003.sql", migrations[3].File)
+	assert.Equal(t, "s3://your-bucket-migrator/migrations/tenants/202001100004.sql", migrations[4].File)
+	assert.Equal(t, "s3://your-bucket-migrator/migrations/ref/202001100005.sql", migrations[5].File)
+	assert.Equal(t, "s3://your-bucket-migrator/migrations/tenants/202001100007.sql", migrations[6].File)
+
+	// first failed object is reported
+	config.SingleMigrations = []string{"migrations/config-scripts"}
+	config.TenantMigrations = nil
+	migrations, err = loader.GetSourceMigrations()
+	assert.Nil(t, migrations)
+	assert.Equal(t, "could not read source migrations from s3://your-bucket-migrator/migrations/config-scripts/cleanup.sql: access denied: migrations/config-scripts/cleanup.sql", err.Error())
+}
+
+func TestDefaultS3ClientFactoryOptions(t *testing.T) {
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+
+	config := &config.Config{
+		S3Endpoint:       "http://localhost:9000",
+		S3Region:         "eu-central-1",
+		S3ForcePathStyle: true,
+	}
+
+	client, err := (&defaultS3ClientFactory{config}).NewClient(context.TODO())
+	assert.Nil(t, err)
+	options := client.(*s3.Client).Options()
+	assert.Equal(t, "http://localhost:9000", *options.BaseEndpoint)
+	assert.Equal(t, "eu-central-1", options.Region)
+	assert.True(t, options.UsePathStyle)
+
+	config.S3Profile = "abcdef"
+	_, err = (&defaultS3ClientFactory{config}).NewClient(context.TODO())
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "abcdef")
+}
+
+// newFakeS3Server returns server implementing path-style ListObjectsV2 and GetObject, for example MinIO
+func newFakeS3Server(bucket string, objects map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/"+bucket && r.URL.Query().Get("list-type") == "2" {
+			prefix := r.URL.Query().Get("prefix")
+			keys := []string{}
+			for key := range objects {
+				if strings.HasPrefix(key, prefix) {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+			w.Header().Set("Content-Type", "application/xml")
+			fmt.Fprintf(w, "<ListBucketResult><Name>%v</Name><Prefix>%v</Prefix><KeyCount>%v</KeyCount><IsTruncated>false</IsTruncated>", bucket, prefix, len(keys))
+			for _, key := range keys {
+				fmt.Fprintf(w, "<Contents><Key>%v</Key></Contents>", key)
+			}
+			fmt.Fprint(w, "</ListBucketResult>")
+			return
+		}
+		if object, ok := objects[strings.TrimPrefix(r.URL.Path, "/"+bucket+"/")]; ok {
+			sum := sha256.Sum256([]byte(object))
+			w.Header().Set("x-amz-checksum-sha256", base64.StdEncoding.EncodeToString(sum[:]))
+			w.Write([]byte(object))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "<Error><Code>NoSuchKey</Code></Error>")
+	}))
+}
+
+func TestS3GetSourceMigrationsCustomEndpoint(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "minioadmin")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "minioadmin")
+
+	server := newFakeS3Server("your-bucket-migrator", map[string]string{
+		"migrations/config/201602160001.sql":  "create schema config;",
+		"migrations/tenants/201602160002.sql": "create table {schema}.users (id int);",
+	})
+	defer server.Close()
+
+	config := &config.Config{
+		BaseLocation:     "s3://your-bucket-migrator",
+		SingleMigrations: []string{"migrations/config"},
+		TenantMigrations: []string{"migrations/tenants"},
+		S3Endpoint:       server.URL,
+		S3Region:         "us-east-1",
+		S3ForcePathStyle: true,
+	}
+
+	loader := New(context.TODO(), config)
+	migrations, err := loader.GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 2)
+	assert.Equal(t, "s3://your-bucket-migrator/migrations/config/201602160001.sql", migrations[0].File)
+	assert.Equal(t, "create schema config;", migrations[0].Contents)
+	assert.Equal(t, "s3://your-bucket-migrator/migrations/tenants/201602160002.sql", migrations[1].File)
+
+	assert.Nil(t, loader.HealthCheck())
+}
+
+func TestS3GetSourceMigrationsIncludeExcludePatterns(t *testing.T) {
+	client := &mockS3ETagClient{objects: map[string]mockS3ETagObject{
+		"migrations/config/201602160001.sql":       {"etag-1", "create schema config;"},
+		"migrations/config/201602160002.sql":       {"etag-2", "create table config.settings (k text);"},
+		"migrations/config/README.md":              {"etag-3", "# config"},
+		"migrations/config/.DS_Store":              {"etag-4", ""},
+		"migrations/config/draft-201602160003.sql": {"etag-5", "select 1;"},
+	}}
+
+	config := &config.Config{
+		BaseLocation:     "s3://your-bucket-migrator",
+		SingleMigrations: []string{"migrations/config"},
+		ExcludePatterns:  []string{"draft-*"},
+	}
+
+	loader := &s3Loader{
+		baseLoader:    baseLoader{context.TODO(), config},
+		clientFactory: &mockS3ClientFactory{client: client},
+	}
+
+	migrations, err := loader.GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 2)
+	assert.Equal(t, "s3://your-bucket-migrator/migrations/config/201602160001.sql", migrations[0].File)
+	assert.Equal(t, "s3://your-bucket-migrator/migrations/config/201602160002.sql", migrations[1].File)
+	assert.Equal(t, 2, client.gets)
+}
+
+func TestS3GetSourceMigrationsRecursive(t *testing.T) {
+	client := &mockS3ETagClient{objects: map[string]mockS3ETagObject{
+		"migrations/ref/202403010001.sql":         {"etag-1", "select 1;"},
+		"migrations/ref/2024/01/202401010001.sql": {"etag-2", "select 2;"},
+		"migrations/ref/2024/02/":                 {"etag-3", ""},
+		"migrations/ref-scripts/cleanup.sql":      {"etag-4", "select 4;"},
+	}}
+
+	config := &config.Config{
+		BaseLocation:     "s3://your-bucket-migrator",
+		SingleMigrations: []string{"migrations/ref"},
+	}
+	loader := &s3Loader{baseLoader: baseLoader{context.TODO(), config}, clientFactory: &mockS3ClientFactory{client: client}}
+
+	// objects in subdirectories are read using their file names like in previous versions, objects in other dirs with the same prefix are skipped
+	migrations, err := loader.GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 2)
+	assert.Equal(t, "202401010001.sql", migrations[0].Name)
+	assert.Equal(t, "s3://your-bucket-migrator/migrations/ref/2024/01", migrations[0].SourceDir)
+	assert.Equal(t, "s3://your-bucket-migrator/migrations/ref/2024/01/202401010001.sql", migrations[0].File)
+	assert.Equal(t, "202403010001.sql", migrations[1].Name)
 
+	config.Recursive = true
+	migrations, err = loader.GetSourceMigrations()
 	assert.Nil(t, err)
+	assert.Len(t, migrations, 2)
+	assert.Equal(t, "2024/01/202401010001.sql", migrations[0].Name)
+	assert.Equal(t, "s3://your-bucket-migrator/migrations/ref", migrations[0].SourceDir)
+	assert.Equal(t, "s3://your-bucket-migrator/migrations/ref/2024/01/202401010001.sql", migrations[0].File)
+	assert.Equal(t, "202403010001.sql", migrations[1].Name)
 }
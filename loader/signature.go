@@ -0,0 +1,184 @@
+package loader
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+
+	"github.com/lukaszbudnik/migrator/common"
+	"github.com/lukaszbudnik/migrator/types"
+)
+
+const (
+	// signatureSuffix is added to migration file name to get its detached signature, for example: 201602160002.sql.sig
+	signatureSuffix = ".sig"
+	// minisignUntrustedComment is the first line of minisign signatures
+	minisignUntrustedComment = "untrusted comment:"
+	// minisignTrustedComment prefixes trusted comment line of minisign signatures
+	minisignTrustedComment = "trusted comment: "
+	// minisignAlgorithm is used by signatures of file contents
+	minisignAlgorithm = "Ed"
+	// minisignHashedAlgorithm is used by signatures of BLAKE2b-512 hash of file contents
+	minisignHashedAlgorithm = "ED"
+	minisignKeyIDLength     = 8
+)
+
+// signatureKey is a public key from signaturePublicKeys config property
+// keyID is set only for minisign public keys
+type signatureKey struct {
+	signer    string
+	keyID     []byte
+	publicKey ed25519.PublicKey
+}
+
+// detachedSignature is a parsed signature file, keyID, trustedComment and globalSignature are set only for minisign signatures
+type detachedSignature struct {
+	algorithm       string
+	keyID           []byte
+	signature       []byte
+	trustedComment  string
+	globalSignature []byte
+}
+
+// parseSignatureKey parses signer:publicKey entry, public key is base64 encoded raw ed25519 public key or minisign public key
+func parseSignatureKey(entry string) (*signatureKey, error) {
+	// base64 does not use colons so signer can contain them
+	i := strings.LastIndex(entry, ":")
+	if i < 1 {
+		return nil, fmt.Errorf("invalid signature public key, expected signer:publicKey: %v", entry)
+	}
+	signer := strings.TrimSpace(entry[:i])
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(entry[i+1:]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature public key of %v: %w", signer, err)
+	}
+	switch {
+	case len(decoded) == ed25519.PublicKeySize:
+		return &signatureKey{signer: signer, publicKey: decoded}, nil
+	case len(decoded) == 2+minisignKeyIDLength+ed25519.PublicKeySize && string(decoded[:2]) == minisignAlgorithm:
+		return &signatureKey{signer: signer, keyID: decoded[2 : 2+minisignKeyIDLength], publicKey: decoded[2+minisignKeyIDLength:]}, nil
+	}
+	return nil, fmt.Errorf("invalid signature public key of %v: unsupported key length %v", signer, len(decoded))
+}
+
+// signatureKeys returns public keys from signaturePublicKeys config property, invalid keys are logged and skipped
+func (bl *baseLoader) signatureKeys() []*signatureKey {
+	keys := []*signatureKey{}
+	for _, entry := range bl.config.SignaturePublicKeys {
+		key, err := parseSignatureKey(entry)
+		if err != nil {
+			common.LogError(bl.ctx, "Skipping signature public key: %v", err)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// parseSignature parses detached signature, both raw base64 encoded ed25519 signatures and minisign signatures are supported
+func parseSignature(contents string) (*detachedSignature, error) {
+	lines := strings.Split(strings.ReplaceAll(strings.TrimSpace(contents), "\r\n", "\n"), "\n")
+	if !strings.HasPrefix(lines[0], minisignUntrustedComment) {
+		if len(lines) != 1 {
+			return nil, errors.New("invalid signature format")
+		}
+		signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[0]))
+		if err != nil || len(signature) != ed25519.SignatureSize {
+			return nil, errors.New("invalid signature format")
+		}
+		return &detachedSignature{algorithm: minisignAlgorithm, signature: signature}, nil
+	}
+
+	if len(lines) < 2 {
+		return nil, errors.New("invalid minisign signature format")
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(decoded) != 2+minisignKeyIDLength+ed25519.SignatureSize {
+		return nil, errors.New("invalid minisign signature format")
+	}
+	algorithm := string(decoded[:2])
+	if algorithm != minisignAlgorithm && algorithm != minisignHashedAlgorithm {
+		return nil, fmt.Errorf("unsupported minisign signature algorithm %v", algorithm)
+	}
+	s := &detachedSignature{algorithm: algorithm, keyID: decoded[2 : 2+minisignKeyIDLength], signature: decoded[2+minisignKeyIDLength:]}
+	if len(lines) >= 4 && strings.HasPrefix(lines[2], minisignTrustedComment) {
+		globalSignature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+		if err != nil || len(globalSignature) != ed25519.SignatureSize {
+			return nil, errors.New("invalid minisign global signature format")
+		}
+		s.trustedComment = strings.TrimPrefix(lines[2], minisignTrustedComment)
+		s.globalSignature = globalSignature
+	}
+	return s, nil
+}
+
+// verify returns true if signature of passed contents was created using the key
+// global signature of minisign signatures (signature of the trusted comment) is verified too
+func (s *detachedSignature) verify(key *signatureKey, contents []byte) bool {
+	if s.keyID != nil && key.keyID != nil && !bytes.Equal(s.keyID, key.keyID) {
+		return false
+	}
+	message := contents
+	if s.algorithm == minisignHashedAlgorithm {
+		hash := blake2b.Sum512(contents)
+		message = hash[:]
+	}
+	if !ed25519.Verify(key.publicKey, message, s.signature) {
+		return false
+	}
+	if s.globalSignature != nil {
+		return ed25519.Verify(key.publicKey, append(append([]byte{}, s.signature...), s.trustedComment...), s.globalSignature)
+	}
+	return true
+}
+
+// verifySignatures verifies migrations against their detached signatures (files with .sig suffix placed next to them)
+// when signaturePublicKeys is set, Signer is set to the signer of the key which verified the signature
+// otherwise SignatureError describes why migration could not be verified, signature files are removed from the map
+func (bl *baseLoader) verifySignatures(migrationsMap map[string][]types.Migration) {
+	if len(bl.config.SignaturePublicKeys) == 0 {
+		return
+	}
+	keys := bl.signatureKeys()
+
+	// signatures are paired with migrations using files, same names can be used in different source dirs
+	signatures := map[string]string{}
+	for name, ms := range migrationsMap {
+		if !strings.HasSuffix(name, signatureSuffix) {
+			continue
+		}
+		for _, m := range ms {
+			signatures[strings.TrimSuffix(m.File, signatureSuffix)] = m.Contents
+		}
+		delete(migrationsMap, name)
+	}
+
+	for _, ms := range migrationsMap {
+		for i := range ms {
+			contents, ok := signatures[ms[i].File]
+			if !ok {
+				ms[i].SignatureError = "migration is not signed"
+				continue
+			}
+			signature, err := parseSignature(contents)
+			if err != nil {
+				ms[i].SignatureError = err.Error()
+				continue
+			}
+			ms[i].SignatureError = "signature does not match any of signature public keys"
+			for _, key := range keys {
+				if signature.verify(key, []byte(ms[i].Contents)) {
+					signer := key.signer
+					ms[i].Signer = &signer
+					ms[i].SignatureError = ""
+					break
+				}
+			}
+		}
+	}
+}
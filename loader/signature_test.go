@@ -0,0 +1,172 @@
+package loader
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/blake2b"
+
+	"github.com/lukaszbudnik/migrator/config"
+)
+
+var (
+	signingKey      = ed25519.NewKeyFromSeed([]byte("migrator-signing-key-seed-000001"))
+	otherSigningKey = ed25519.NewKeyFromSeed([]byte("migrator-signing-key-seed-000002"))
+	minisignKeyID   = []byte("keyid-01")
+)
+
+func rawSignature(key ed25519.PrivateKey, contents string) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(key, []byte(contents)))
+}
+
+func rawPublicKey(signer string, key ed25519.PrivateKey) string {
+	return fmt.Sprintf("%v:%v", signer, base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)))
+}
+
+func minisignPublicKey(signer string, key ed25519.PrivateKey) string {
+	decoded := append([]byte(minisignAlgorithm), minisignKeyID...)
+	decoded = append(decoded, key.Public().(ed25519.PublicKey)...)
+	return fmt.Sprintf("%v:%v", signer, base64.StdEncoding.EncodeToString(decoded))
+}
+
+// minisignSignature returns signature in minisign format, pre-hashed signature is created when algorithm is ED
+func minisignSignature(key ed25519.PrivateKey, algorithm, contents, trustedComment string) string {
+	message := []byte(contents)
+	if algorithm == minisignHashedAlgorithm {
+		hash := blake2b.Sum512(message)
+		message = hash[:]
+	}
+	signature := ed25519.Sign(key, message)
+	decoded := append([]byte(algorithm), minisignKeyID...)
+	decoded = append(decoded, signature...)
+	globalSignature := ed25519.Sign(key, append(append([]byte{}, signature...), trustedComment...))
+	return fmt.Sprintf("untrusted comment: signature from minisign secret key\n%v\ntrusted comment: %v\n%v\n",
+		base64.StdEncoding.EncodeToString(decoded), trustedComment, base64.StdEncoding.EncodeToString(globalSignature))
+}
+
+func TestSignedMigrations(t *testing.T) {
+	files := map[string]string{
+		"migrations/ref/201602160001.sql":      "create table ref.a (id int);",
+		"migrations/ref/201602160002.sql":      "create table ref.b (id int);",
+		"migrations/ref/201602160002.down.sql": "drop table ref.b;",
+		"migrations/ref/201602160003.sql":      "create table ref.c (id int);",
+		"migrations/ref/201602160004.sql":      "create table ref.d (id int);",
+		"migrations/ref/201602160005.sql":      "create table ref.e (id int);",
+		"migrations/ref/201602160006.sql":      "create table ref.f (id int);",
+		"migrations/ref/201602160007.sql":      "create table ref.g (id int);",
+	}
+	fsys := fstest.MapFS{}
+	for name, contents := range files {
+		fsys[name] = &fstest.MapFile{Data: []byte(contents)}
+	}
+	signatures := map[string]string{
+		// raw signature
+		"201602160001.sql": rawSignature(signingKey, files["migrations/ref/201602160001.sql"]),
+		// minisign signatures
+		"201602160002.sql":      minisignSignature(otherSigningKey, minisignAlgorithm, files["migrations/ref/201602160002.sql"], "timestamp:1556193335"),
+		"201602160002.down.sql": minisignSignature(otherSigningKey, minisignHashedAlgorithm, files["migrations/ref/201602160002.down.sql"], "timestamp:1556193335"),
+		"201602160003.sql":      minisignSignature(otherSigningKey, minisignHashedAlgorithm, files["migrations/ref/201602160003.sql"], "timestamp:1556193335"),
+		// signature of other contents
+		"201602160004.sql": rawSignature(signingKey, "drop table ref.a;"),
+		// tampered trusted comment
+		"201602160005.sql": strings.Replace(minisignSignature(otherSigningKey, minisignAlgorithm, files["migrations/ref/201602160005.sql"], "timestamp:1556193335"), "timestamp:1556193335", "timestamp:1556193336", 1),
+		"201602160006.sql": "not a signature",
+	}
+	for name, signature := range signatures {
+		fsys["migrations/ref/"+name+signatureSuffix] = &fstest.MapFile{Data: []byte(signature)}
+	}
+
+	config := &config.Config{
+		BaseLocation:        "migrations",
+		SingleMigrations:    []string{"ref"},
+		SignaturePublicKeys: []string{rawPublicKey("release-bot", signingKey), minisignPublicKey("jane:release", otherSigningKey)},
+	}
+
+	migrations, err := NewFS(context.TODO(), config, fsys).GetSourceMigrations()
+	assert.Nil(t, err)
+	// signatures are not returned as migrations
+	assert.Len(t, migrations, 7)
+
+	assert.Equal(t, "release-bot", *migrations[0].Signer)
+	assert.Equal(t, "", migrations[0].SignatureError)
+	assert.Equal(t, "jane:release", *migrations[1].Signer)
+	assert.Equal(t, "drop table ref.b;", migrations[1].DownContents)
+	assert.Equal(t, "jane:release", *migrations[2].Signer)
+
+	expectedErrors := []string{
+		"signature does not match any of signature public keys",
+		"signature does not match any of signature public keys",
+		"invalid signature format",
+		"migration is not signed",
+	}
+	for i, expected := range expectedErrors {
+		m := migrations[3+i]
+		assert.Nil(t, m.Signer, m.File)
+		assert.Equal(t, expected, m.SignatureError, m.File)
+	}
+
+	// signature files are skipped when signaturePublicKeys is not set
+	config.SignaturePublicKeys = nil
+	migrations, err = NewFS(context.TODO(), config, fsys).GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 7)
+	for _, m := range migrations {
+		assert.Nil(t, m.Signer)
+		assert.Equal(t, "", m.SignatureError)
+	}
+}
+
+func TestSignedMigrationsUnsignedDownMigration(t *testing.T) {
+	up := "create table ref.b (id int);"
+	fsys := fstest.MapFS{
+		"ref/201602160002.sql":                   {Data: []byte(up)},
+		"ref/201602160002.sql" + signatureSuffix: {Data: []byte(rawSignature(signingKey, up))},
+		"ref/201602160002.down.sql":              {Data: []byte("drop table ref.b;")},
+	}
+
+	config := &config.Config{
+		BaseLocation:        ".",
+		SingleMigrations:    []string{"ref"},
+		SignaturePublicKeys: []string{rawPublicKey("release-bot", signingKey)},
+	}
+
+	migrations, err := NewFS(context.TODO(), config, fsys).GetSourceMigrations()
+	assert.Nil(t, err)
+	assert.Len(t, migrations, 1)
+	assert.Nil(t, migrations[0].Signer)
+	assert.Equal(t, "down migration: migration is not signed", migrations[0].SignatureError)
+}
+
+func TestParseSignatureKey(t *testing.T) {
+	key, err := parseSignatureKey(minisignPublicKey("jane:release", signingKey))
+	assert.Nil(t, err)
+	assert.Equal(t, "jane:release", key.signer)
+	assert.Equal(t, minisignKeyID, key.keyID)
+
+	for _, entry := range []string{"release-bot", "release-bot:abc", "release-bot:" + base64.StdEncoding.EncodeToString([]byte("too short"))} {
+		_, err := parseSignatureKey(entry)
+		assert.NotNil(t, err, entry)
+	}
+}
+
+func TestSignatureKeyIDMismatch(t *testing.T) {
+	signature, err := parseSignature(minisignSignature(signingKey, minisignAlgorithm, "select 1;", "timestamp:1556193335"))
+	assert.Nil(t, err)
+
+	key, _ := parseSignatureKey(minisignPublicKey("release-bot", signingKey))
+	assert.True(t, signature.verify(key, []byte("select 1;")))
+	key.keyID = []byte("keyid-02")
+	assert.False(t, signature.verify(key, []byte("select 1;")))
+	// raw public keys do not have key ID
+	key.keyID = nil
+	assert.True(t, signature.verify(key, []byte("select 1;")))
+
+	_, err = parseSignature("untrusted comment: signature from minisign secret key\n" + base64.StdEncoding.EncodeToString(append([]byte("Xx"), make([]byte, 72)...)))
+	assert.Equal(t, "unsupported minisign signature algorithm Xx", err.Error())
+}
@@ -35,3 +35,27 @@ func (m *prometheusMetrics) AddGaugeValue(name string, labelValues []string, val
 func (m *prometheusMetrics) IncrementGaugeValue(name string, labelValues []string) error {
 	return m.prometheus.IncrementGaugeValue(name, labelValues)
 }
+
+// NewNoop returns new instance of Metrics which discards all values, it is used when migrator runs as a CLI
+func NewNoop() Metrics {
+	return &noopMetrics{}
+}
+
+// noopMetrics is struct for implementing Metrics which discards all values
+type noopMetrics struct {
+}
+
+// SetGaugeValue does nothing
+func (m *noopMetrics) SetGaugeValue(name string, labelValues []string, value float64) error {
+	return nil
+}
+
+// AddGaugeValue does nothing
+func (m *noopMetrics) AddGaugeValue(name string, labelValues []string, value float64) error {
+	return nil
+}
+
+// IncrementGaugeValue does nothing
+func (m *noopMetrics) IncrementGaugeValue(name string, labelValues []string) error {
+	return nil
+}
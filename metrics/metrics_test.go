@@ -45,3 +45,10 @@ func TestMetrics(t *testing.T) {
 	assert.Contains(t, w.Body.String(), `migrator_gin_gauge{type="first"} 2`)
 	assert.Contains(t, w.Body.String(), `migrator_gin_gauge{type="second"} 2`)
 }
+
+func TestNoopMetrics(t *testing.T) {
+	metrics := NewNoop()
+	assert.Nil(t, metrics.SetGaugeValue("gauge", []string{"first"}, 1))
+	assert.Nil(t, metrics.AddGaugeValue("gauge", []string{"first"}, 1))
+	assert.Nil(t, metrics.IncrementGaugeValue("gauge", []string{"second"}))
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lukaszbudnik/migrator/cli"
 	"github.com/lukaszbudnik/migrator/common"
 	"github.com/lukaszbudnik/migrator/config"
 	"github.com/lukaszbudnik/migrator/coordinator"
@@ -18,11 +19,6 @@ import (
 	"github.com/lukaszbudnik/migrator/types"
 )
 
-const (
-	// DefaultConfigFile defines default file name of migrator configuration file
-	DefaultConfigFile = "migrator.yaml"
-)
-
 // GitRef stores git branch/tag, value injected during production build
 var GitRef string
 
@@ -32,6 +28,16 @@ var GitSha string
 func main() {
 	versionInfo := &types.VersionInfo{Release: GitRef, Sha: GitSha, APIVersions: []types.APIVersion{types.APIV2}}
 
+	var createCoordinator = func(ctx context.Context, config *config.Config, metrics metrics.Metrics) coordinator.Coordinator {
+		coordinator := coordinator.New(ctx, config, metrics, db.New, loader.New, notifications.New)
+		return coordinator
+	}
+
+	// migrator apply|dry-run|create-tenant|status|verify runs a single command and exits
+	if len(os.Args) > 1 && cli.IsCommand(os.Args[1]) {
+		os.Exit(cli.Run(context.Background(), os.Args[1:], os.Stdout, os.Stderr, createCoordinator))
+	}
+
 	common.Log("INFO", "migrator %+v", versionInfo)
 
 	flag := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
@@ -39,7 +45,7 @@ func main() {
 	flag.SetOutput(buf)
 
 	var configFile string
-	flag.StringVar(&configFile, "configFile", DefaultConfigFile, "path to migrator configuration yaml file")
+	flag.StringVar(&configFile, "configFile", cli.DefaultConfigFile, "path to migrator configuration yaml file")
 
 	if err := flag.Parse(os.Args[1:]); err != nil {
 		common.Log("ERROR", "%v", buf.String())
@@ -52,11 +58,6 @@ func main() {
 		os.Exit(1)
 	}
 
-	var createCoordinator = func(ctx context.Context, config *config.Config, metrics metrics.Metrics) coordinator.Coordinator {
-		coordinator := coordinator.New(ctx, config, metrics, db.New, loader.New, notifications.New)
-		return coordinator
-	}
-
 	gin.SetMode(gin.ReleaseMode)
 	g := server.CreateRouterAndPrometheus(versionInfo, cfg, createCoordinator)
 	if err := g.Run(":" + server.GetPort(cfg)); err != nil {
@@ -16,6 +16,7 @@ import (
 	"github.com/lukaszbudnik/migrator/config"
 	"github.com/lukaszbudnik/migrator/coordinator"
 	"github.com/lukaszbudnik/migrator/data"
+	"github.com/lukaszbudnik/migrator/jobs"
 	"github.com/lukaszbudnik/migrator/metrics"
 	"github.com/lukaszbudnik/migrator/types"
 )
@@ -69,7 +70,8 @@ func recovery() gin.HandlerFunc {
 				if gin.IsDebugging() {
 					debug.PrintStack()
 				}
-				errorMsg := errorMessage{err.(string)}
+				// panics can carry any value, for example runtime errors
+				errorMsg := errorMessage{fmt.Sprint(err)}
 				c.AbortWithStatusJSON(http.StatusInternalServerError, errorResponse{Errors: []errorMessage{errorMsg}})
 			}
 		}()
@@ -111,7 +113,14 @@ func healthHandler(c *gin.Context, config *config.Config, metrics metrics.Metric
 }
 
 // GraphQL endpoint
-func serviceHandler(c *gin.Context, config *config.Config, metrics metrics.Metrics, newCoordinator coordinator.Factory) {
+// job manager is shared by all requests so that asynchronous jobs can be polled by subsequent requests
+func makeServiceHandler(jobManager jobs.Manager) func(*gin.Context, *config.Config, metrics.Metrics, coordinator.Factory) {
+	return func(c *gin.Context, config *config.Config, metrics metrics.Metrics, newCoordinator coordinator.Factory) {
+		serviceHandler(c, config, metrics, newCoordinator, jobManager)
+	}
+}
+
+func serviceHandler(c *gin.Context, config *config.Config, metrics metrics.Metrics, newCoordinator coordinator.Factory, jobManager jobs.Manager) {
 	var params struct {
 		Query         string                 `json:"query"`
 		OperationName string                 `json:"operationName"`
@@ -127,7 +136,7 @@ func serviceHandler(c *gin.Context, config *config.Config, metrics metrics.Metri
 	coordinator := newCoordinator(c.Request.Context(), config, metrics)
 	defer coordinator.Dispose()
 	opts := []graphql.SchemaOpt{graphql.UseFieldResolvers()}
-	schema := graphql.MustParseSchema(data.SchemaDefinition, &data.RootResolver{Coordinator: coordinator}, opts...)
+	schema := graphql.MustParseSchema(data.SchemaDefinition, &data.RootResolver{Coordinator: coordinator, JobManager: jobManager}, opts...)
 
 	response := schema.Exec(c.Request.Context(), params.Query, params.OperationName, params.Variables)
 	if response.Errors == nil {
@@ -149,6 +158,7 @@ func CreateRouterAndPrometheus(versionInfo *types.VersionInfo, config *config.Co
 	)
 	p.AddCustomGauge("info", "Information about migrator app", []string{"version"})
 	p.AddCustomGauge("versions_created", "Number of versions created by migrator", []string{})
+	p.AddCustomGauge("versions_rolled_back", "Number of versions rolled back by migrator", []string{})
 	p.AddCustomGauge("tenants_created", "Number of migrations applied by migrator", []string{})
 	p.AddCustomGauge("migrations_applied", "Number of migrations applied by migrator", []string{"type"})
 
@@ -179,7 +189,8 @@ func SetupRouter(r *gin.Engine, versionInfo *types.VersionInfo, config *config.C
 	v2 := r.Group(config.PathPrefix + "/v2")
 	v2.GET("/config", makeHandler(config, metrics, newCoordinator, configHandler))
 	v2.GET("/schema", makeHandler(config, metrics, newCoordinator, schemaHandler))
-	v2.POST("/service", makeHandler(config, metrics, newCoordinator, serviceHandler))
+	jobManager := jobs.New(config, metrics, newCoordinator)
+	v2.POST("/service", makeHandler(config, metrics, newCoordinator, makeServiceHandler(jobManager)))
 
 	return r
 }
@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -32,15 +33,27 @@ func newMockedErrorCoordinator(errorThreshold int) func(context.Context, *config
 func (m *mockedCoordinator) Dispose() {
 }
 
-func (m *mockedCoordinator) CreateTenant(string, types.Action, bool, string) *types.CreateResults {
-	return &types.CreateResults{Summary: &types.Summary{}, Version: &types.Version{}}
+func (m *mockedCoordinator) CreateTenant(string, types.Action, bool, types.Tenant) (*types.CreateResults, error) {
+	return &types.CreateResults{Summary: &types.Summary{}, Version: &types.Version{}}, nil
 }
 
-func (m *mockedCoordinator) CreateVersion(string, types.Action, bool) *types.CreateResults {
-	return &types.CreateResults{Summary: &types.Summary{}, Version: &types.Version{}}
+func (m *mockedCoordinator) CreateVersion(string, types.Action, bool, *types.TenantSelector) (*types.CreateResults, error) {
+	return &types.CreateResults{Summary: &types.Summary{}, Version: &types.Version{}}, nil
 }
 
-func (m *mockedCoordinator) GetSourceMigrations(_ *coordinator.SourceMigrationFilters) []types.Migration {
+func (m *mockedCoordinator) RollbackVersion(int32, string, bool) (*types.CreateResults, error) {
+	return &types.CreateResults{Summary: &types.Summary{}, Version: &types.Version{}}, nil
+}
+
+func (m *mockedCoordinator) Plan(*types.TenantSelector) (*types.Plan, error) {
+	return &types.Plan{Migrations: []types.PlannedMigration{}}, nil
+}
+
+func (m *mockedCoordinator) GetTenantStatus(name string) (*types.TenantStatus, error) {
+	return &types.TenantStatus{Name: name, PendingMigrations: []types.Migration{}}, nil
+}
+
+func (m *mockedCoordinator) GetSourceMigrations(_ *coordinator.SourceMigrationFilters) ([]types.Migration, error) {
 	if m.errorThreshold == m.counter {
 		panic(fmt.Sprintf("Mocked Coordinator: threshold %v reached", m.errorThreshold))
 	}
@@ -49,7 +62,7 @@ func (m *mockedCoordinator) GetSourceMigrations(_ *coordinator.SourceMigrationFi
 	}
 	m1 := types.Migration{Name: "201602220000.sql", SourceDir: "source", File: "source/201602220000.sql", MigrationType: types.MigrationTypeSingleMigration, Contents: "select abc"}
 	m2 := types.Migration{Name: "201602220001.sql", SourceDir: "source", File: "source/201602220001.sql", MigrationType: types.MigrationTypeTenantMigration, Contents: "select def"}
-	return []types.Migration{m1, m2}
+	return []types.Migration{m1, m2}, nil
 }
 
 func (m *mockedCoordinator) GetSourceMigrationByFile(file string) (*types.Migration, error) {
@@ -66,11 +79,15 @@ func (m *mockedCoordinator) GetSourceMigrationByFile(file string) (*types.Migrat
 	return &m1, nil
 }
 
-func (m *mockedCoordinator) GetAppliedMigrations() []types.DBMigration {
+func (m *mockedCoordinator) RefreshSourceMigrations() ([]types.Migration, error) {
+	return m.GetSourceMigrations(nil)
+}
+
+func (m *mockedCoordinator) GetAppliedMigrations() ([]types.DBMigration, error) {
 	m1 := types.Migration{Name: "201602220000.sql", SourceDir: "source", File: "source/201602220000.sql", MigrationType: types.MigrationTypeSingleMigration, Contents: "select abc", CheckSum: "sha256"}
 	d1 := time.Date(2016, 02, 22, 16, 41, 1, 123, time.UTC)
 	ms := []types.DBMigration{{Migration: m1, Schema: "source", Created: graphql.Time{Time: d1}}}
-	return ms
+	return ms, nil
 }
 
 // part of interface but not used in server tests - tested in data package
@@ -78,21 +95,21 @@ func (m *mockedCoordinator) GetDBMigrationByID(ID int32) (*types.DBMigration, er
 	return nil, nil
 }
 
-func (m *mockedCoordinator) GetTenants() []types.Tenant {
+func (m *mockedCoordinator) GetTenants() ([]types.Tenant, error) {
 	a := types.Tenant{Name: "a"}
 	b := types.Tenant{Name: "b"}
 	c := types.Tenant{Name: "c"}
-	return []types.Tenant{a, b, c}
+	return []types.Tenant{a, b, c}, nil
 }
 
 // part of interface but not used in server tests - tested in data package
-func (m *mockedCoordinator) GetVersions() []types.Version {
-	return []types.Version{}
+func (m *mockedCoordinator) GetVersions() ([]types.Version, error) {
+	return []types.Version{}, nil
 }
 
 // part of interface but not used in server tests - tested in data package
-func (m *mockedCoordinator) GetVersionsByFile(file string) []types.Version {
-	return []types.Version{}
+func (m *mockedCoordinator) GetVersionsByFile(file string) ([]types.Version, error) {
+	return []types.Version{}, nil
 }
 
 // part of interface but not used in server tests - tested in data package
@@ -100,13 +117,13 @@ func (m *mockedCoordinator) GetVersionByID(ID int32) (*types.Version, error) {
 	return nil, nil
 }
 
-func (m *mockedCoordinator) VerifySourceMigrationsCheckSums() (bool, []types.Migration) {
+func (m *mockedCoordinator) VerifySourceMigrationsCheckSums() (bool, []types.Migration, error) {
 	if m.errorThreshold == m.counter {
 		m1 := types.Migration{Name: "201602220000.sql", SourceDir: "source", File: "source/201602220000.sql", MigrationType: types.MigrationTypeSingleMigration, Contents: "select abc", CheckSum: "123"}
-		return false, []types.Migration{m1}
+		return false, []types.Migration{m1}, nil
 	}
 	m.counter++
-	return true, nil
+	return true, nil, nil
 }
 
 func (m *mockedCoordinator) HealthCheck() types.HealthResponse {
@@ -131,6 +148,18 @@ func newMockedCoordinatorHealthCheckError(ctx context.Context, config *config.Co
 	return &mockedCoordinatorHealthCheckError{}
 }
 
+type mockedCoordinatorHealthCheckPanicError struct {
+	mockedCoordinator
+}
+
+func (m *mockedCoordinatorHealthCheckPanicError) HealthCheck() types.HealthResponse {
+	panic(errors.New("Mocked Coordinator: panic with error value"))
+}
+
+func newMockedCoordinatorHealthCheckPanicError(ctx context.Context, config *config.Config, metrics metrics.Metrics) coordinator.Coordinator {
+	return &mockedCoordinatorHealthCheckPanicError{}
+}
+
 func newNoopMetrics() metrics.Metrics {
 	return &noopMetrics{}
 }
@@ -1,11 +1,14 @@
 package server
 
 import (
+	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/lukaszbudnik/migrator/config"
@@ -232,6 +235,45 @@ func TestGraphQLQueryError(t *testing.T) {
 	assert.Equal(t, `{"errors":[{"message":"Invalid request, please see documentation for valid JSON payload"}]}`, strings.TrimSpace(w.Body.String()))
 }
 
+func TestGraphQLAsyncCreateVersion(t *testing.T) {
+	config, err := config.FromFile(configFile)
+	assert.Nil(t, err)
+
+	router := testSetupRouter(config, newMockedCoordinator)
+
+	w := httptest.NewRecorder()
+	req, _ := newTestRequestV2("POST", "/service", strings.NewReader(`
+    {
+      "query": "mutation CreateVersion($input: VersionInput!) { createVersion(input: $input) { job { id, state } } }",
+      "operationName": "CreateVersion",
+      "variables": { "input": { "versionName": "commit-sha", "async": true } }
+    }
+  `))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var created struct {
+		Data struct {
+			CreateVersion struct {
+				Job struct {
+					ID string
+				}
+			}
+		}
+	}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &created))
+	id := created.Data.CreateVersion.Job.ID
+	assert.NotEmpty(t, id)
+
+	// job is shared between requests and can be polled until it is finished
+	assert.Eventually(t, func() bool {
+		w := httptest.NewRecorder()
+		req, _ := newTestRequestV2("POST", "/service", strings.NewReader(fmt.Sprintf(`{"query": "query { job(id: \"%v\") { state } }"}`, id)))
+		router.ServeHTTP(w, req)
+		return strings.TrimSpace(w.Body.String()) == `{"data":{"job":{"state":"Succeeded"}}}`
+	}, time.Second, 10*time.Millisecond)
+}
+
 func TestPanicHandlerGlobal(t *testing.T) {
 	config, err := config.FromFile(configFile)
 	assert.Nil(t, err)
@@ -247,6 +289,21 @@ func TestPanicHandlerGlobal(t *testing.T) {
 	assert.Equal(t, `{"errors":[{"message":"Mocked Coordinator: threshold 0 reached"}]}`, strings.TrimSpace(w.Body.String()))
 }
 
+func TestPanicHandlerGlobalNonStringValue(t *testing.T) {
+	config, err := config.FromFile(configFile)
+	assert.Nil(t, err)
+
+	router := testSetupRouter(config, newMockedCoordinatorHealthCheckPanicError)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/health", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "application/json; charset=utf-8", w.Result().Header.Get("Content-Type"))
+	assert.Equal(t, `{"errors":[{"message":"Mocked Coordinator: panic with error value"}]}`, strings.TrimSpace(w.Body.String()))
+}
+
 func TestPanicHandlerGraphql(t *testing.T) {
 	config, err := config.FromFile(configFile)
 	assert.Nil(t, err)
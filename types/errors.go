@@ -0,0 +1,239 @@
+package types
+
+import (
+	"fmt"
+	"time"
+)
+
+// ErrorCode identifies the kind of migrator error, it is returned to GraphQL clients as code in error extensions
+type ErrorCode string
+
+const (
+	// ErrorCodeDBUnreachable is used when migrator cannot connect to DB
+	ErrorCodeDBUnreachable ErrorCode = "DB_UNREACHABLE"
+	// ErrorCodeDBError is used when DB operation performed by migrator failed
+	ErrorCodeDBError ErrorCode = "DB_ERROR"
+	// ErrorCodeMigrationFailed is used when SQL migration failed
+	ErrorCodeMigrationFailed ErrorCode = "MIGRATION_FAILED"
+	// ErrorCodeSourceUnreadable is used when source migrations cannot be read
+	ErrorCodeSourceUnreadable ErrorCode = "SOURCE_UNREADABLE"
+	// ErrorCodeLockTimeout is used when migrator's DB-level lock could not be acquired
+	ErrorCodeLockTimeout ErrorCode = "LOCK_TIMEOUT"
+	// ErrorCodeSignatureInvalid is used when migration to apply is not signed or its signature is invalid
+	ErrorCodeSignatureInvalid ErrorCode = "SIGNATURE_INVALID"
+	// ErrorCodeTemplateInvalid is used when migration template cannot be rendered
+	ErrorCodeTemplateInvalid ErrorCode = "TEMPLATE_INVALID"
+	// ErrorCodeTenantSelectorInvalid is used when tenant selector is invalid or does not select any tenant
+	ErrorCodeTenantSelectorInvalid ErrorCode = "TENANT_SELECTOR_INVALID"
+	// ErrorCodeRollbackRefused is used when version cannot be rolled back
+	ErrorCodeRollbackRefused ErrorCode = "ROLLBACK_REFUSED"
+)
+
+// DBUnreachableError is returned when migrator cannot open connection to DB
+type DBUnreachableError struct {
+	Err error
+}
+
+func (e *DBUnreachableError) Error() string {
+	return fmt.Sprintf("failed to connect to database: %v", e.Err)
+}
+
+func (e *DBUnreachableError) Unwrap() error {
+	return e.Err
+}
+
+// Extensions returns error details which are added to GraphQL error response
+func (e *DBUnreachableError) Extensions() map[string]interface{} {
+	return map[string]interface{}{
+		"code": ErrorCodeDBUnreachable,
+	}
+}
+
+// DBError is returned when DB operation performed by migrator (other than SQL migration) failed
+// DBErrorCode is a DB-specific error code, for example SQLSTATE for PostgreSQL or error number for MySQL and MS SQL
+type DBError struct {
+	Message     string
+	DBErrorCode string
+	Err         error
+}
+
+func (e *DBError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Message, e.Err)
+}
+
+func (e *DBError) Unwrap() error {
+	return e.Err
+}
+
+// Extensions returns error details which are added to GraphQL error response
+func (e *DBError) Extensions() map[string]interface{} {
+	extensions := map[string]interface{}{
+		"code": ErrorCodeDBError,
+	}
+	if e.DBErrorCode != "" {
+		extensions["dbErrorCode"] = e.DBErrorCode
+	}
+	return extensions
+}
+
+// MigrationError is returned when SQL migration failed for given schema
+// DBErrorCode is a DB-specific error code, for example SQLSTATE for PostgreSQL or error number for MySQL and MS SQL
+// Statement is 1-based index of the failed statement and Line is the line at which it starts, both are set only for migrations with multiple statements
+type MigrationError struct {
+	File        string
+	Schema      string
+	DBErrorCode string
+	Statement   int
+	Line        int
+	Err         error
+}
+
+func (e *MigrationError) Error() string {
+	if e.Statement > 0 {
+		return fmt.Sprintf("SQL migration %v failed for schema %v at statement %v (line %v) with error: %v", e.File, e.Schema, e.Statement, e.Line, e.Err)
+	}
+	return fmt.Sprintf("SQL migration %v failed for schema %v with error: %v", e.File, e.Schema, e.Err)
+}
+
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
+
+// Extensions returns error details which are added to GraphQL error response
+func (e *MigrationError) Extensions() map[string]interface{} {
+	extensions := map[string]interface{}{
+		"code":   ErrorCodeMigrationFailed,
+		"file":   e.File,
+		"schema": e.Schema,
+	}
+	if e.DBErrorCode != "" {
+		extensions["dbErrorCode"] = e.DBErrorCode
+	}
+	if e.Statement > 0 {
+		extensions["statement"] = e.Statement
+		extensions["line"] = e.Line
+	}
+	return extensions
+}
+
+// SourceError is returned when source migrations cannot be read from given location
+type SourceError struct {
+	Location string
+	Err      error
+}
+
+func (e *SourceError) Error() string {
+	return fmt.Sprintf("could not read source migrations from %v: %v", e.Location, e.Err)
+}
+
+func (e *SourceError) Unwrap() error {
+	return e.Err
+}
+
+// Extensions returns error details which are added to GraphQL error response
+func (e *SourceError) Extensions() map[string]interface{} {
+	return map[string]interface{}{
+		"code":     ErrorCodeSourceUnreadable,
+		"location": e.Location,
+	}
+}
+
+// LockTimeoutError is returned when migrator's DB-level lock could not be acquired within configured timeout
+type LockTimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *LockTimeoutError) Error() string {
+	return fmt.Sprintf("could not acquire migrator lock within %v, another migrator operation is in progress", e.Timeout)
+}
+
+// Extensions returns error details which are added to GraphQL error response
+func (e *LockTimeoutError) Extensions() map[string]interface{} {
+	return map[string]interface{}{
+		"code":    ErrorCodeLockTimeout,
+		"timeout": e.Timeout.String(),
+	}
+}
+
+// UnverifiedMigrationError is returned when signature public keys are configured and migration to apply could not be verified
+// Reason describes why migration could not be verified, for example: migration is not signed
+type UnverifiedMigrationError struct {
+	File   string
+	Reason string
+}
+
+func (e *UnverifiedMigrationError) Error() string {
+	return fmt.Sprintf("signature verification of migration %v failed: %v", e.File, e.Reason)
+}
+
+// Extensions returns error details which are added to GraphQL error response
+func (e *UnverifiedMigrationError) Extensions() map[string]interface{} {
+	return map[string]interface{}{
+		"code": ErrorCodeSignatureInvalid,
+		"file": e.File,
+	}
+}
+
+// TemplateError is returned when template rendering is enabled and migration cannot be rendered for given schema
+type TemplateError struct {
+	File   string
+	Schema string
+	Err    error
+}
+
+func (e *TemplateError) Error() string {
+	return fmt.Sprintf("rendering template of migration %v failed for schema %v with error: %v", e.File, e.Schema, e.Err)
+}
+
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
+
+// Extensions returns error details which are added to GraphQL error response
+func (e *TemplateError) Extensions() map[string]interface{} {
+	return map[string]interface{}{
+		"code":   ErrorCodeTemplateInvalid,
+		"file":   e.File,
+		"schema": e.Schema,
+	}
+}
+
+// TenantSelectorError is returned when tenant selector passed to createVersion is invalid or does not select any tenant
+type TenantSelectorError struct {
+	Reason string
+}
+
+func (e *TenantSelectorError) Error() string {
+	return fmt.Sprintf("invalid tenant selector: %v", e.Reason)
+}
+
+// Extensions returns error details which are added to GraphQL error response
+func (e *TenantSelectorError) Extensions() map[string]interface{} {
+	return map[string]interface{}{
+		"code": ErrorCodeTenantSelectorInvalid,
+	}
+}
+
+// RollbackRefusedError is returned when version cannot be rolled back, Reason describes why the rollback was refused
+// File is set when migration of the version does not have a down migration
+type RollbackRefusedError struct {
+	VersionID int32
+	File      string
+	Reason    string
+}
+
+func (e *RollbackRefusedError) Error() string {
+	return e.Reason
+}
+
+// Extensions returns error details which are added to GraphQL error response
+func (e *RollbackRefusedError) Extensions() map[string]interface{} {
+	extensions := map[string]interface{}{
+		"code":      ErrorCodeRollbackRefused,
+		"versionId": e.VersionID,
+	}
+	if e.File != "" {
+		extensions["file"] = e.File
+	}
+	return extensions
+}
@@ -1,7 +1,10 @@
 package types
 
 import (
+	"encoding/json"
 	"fmt"
+	"path"
+	"strings"
 
 	"github.com/graph-gophers/graphql-go"
 )
@@ -63,8 +66,45 @@ func (t *MigrationType) UnmarshalGraphQL(input interface{}) error {
 }
 
 // Tenant contains basic information about tenant
+// Metadata contains additional columns of tenants table, for example region or tier
 type Tenant struct {
-	Name string `json:"name"`
+	Name     string         `json:"name"`
+	Metadata TenantMetadata `json:"metadata,omitempty"`
+}
+
+// TenantMetadata is a key/value map of tenant metadata, keys are names of tenants table columns
+type TenantMetadata map[string]string
+
+// ImplementsGraphQLType maps TenantMetadata Go type
+// to the graphql scalar type in the schema
+func (TenantMetadata) ImplementsGraphQLType(name string) bool {
+	return name == "TenantMetadata"
+}
+
+// UnmarshalGraphQL converts GraphQL object to TenantMetadata Go type, all values must be strings
+func (m *TenantMetadata) UnmarshalGraphQL(input interface{}) error {
+	object, ok := input.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("wrong type for TenantMetadata: %T", input)
+	}
+	metadata := TenantMetadata{}
+	for k, v := range object {
+		value, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("wrong type for TenantMetadata value of %v: %T", k, v)
+		}
+		metadata[k] = value
+	}
+	*m = metadata
+	return nil
+}
+
+// MarshalJSON returns JSON object, tenants without metadata are marshalled as empty object
+func (m TenantMetadata) MarshalJSON() ([]byte, error) {
+	if m == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(map[string]string(m))
 }
 
 // Version contains information about migrator versions
@@ -73,6 +113,8 @@ type Version struct {
 	Name         string        `json:"name"`
 	Created      graphql.Time  `json:"created"`
 	DBMigrations []DBMigration `json:"dbMigrations"`
+	// SourceCommit is the SHA of the commit migrations of the version were loaded from, set only by git loader
+	SourceCommit *string `json:"sourceCommit,omitempty"`
 }
 
 // Migration contains basic information about migration
@@ -83,6 +125,58 @@ type Migration struct {
 	MigrationType MigrationType `json:"migrationType"`
 	Contents      string        `json:"contents,omitempty"`
 	CheckSum      string        `json:"checkSum"`
+	DownContents  string        `json:"downContents,omitempty"`
+	// SourceCommit is the SHA of the commit migration was loaded from, set only by git loader
+	SourceCommit *string `json:"sourceCommit,omitempty"`
+	// Signer is the signer of the public key which verified detached signature of migration, set only when signaturePublicKeys is set
+	Signer *string `json:"signer,omitempty"`
+	// SignatureError describes why migration could not be verified, set only when signaturePublicKeys is set
+	SignatureError string `json:"signatureError,omitempty"`
+	// Tenants are names of tenants tenant migration or script is applied to, nil means all tenants
+	// set when creating new version for selected tenants or when migration is pending only for some tenants
+	Tenants []string `json:"-"`
+}
+
+// noTransactionDirective placed in migration header marks migrations which must be applied outside of a transaction
+const noTransactionDirective = "-- migrator:no-transaction"
+
+// NoTransaction returns true if migration must be applied outside of a transaction
+// migration header consists of leading comment lines, for example:
+// -- migrator:no-transaction
+// create index concurrently idx_abc on {schema}.abc (id);
+func (m Migration) NoTransaction() bool {
+	for _, line := range strings.Split(m.Contents, "\n") {
+		line = strings.TrimSpace(line)
+		if line == noTransactionDirective {
+			return true
+		}
+		if line != "" && !strings.HasPrefix(line, "--") {
+			return false
+		}
+	}
+	return false
+}
+
+// downMigrationSuffix is added before file extension to mark down migrations, for example: 201602160002.down.sql
+const downMigrationSuffix = ".down"
+
+// DownMigrationFile returns the name of the down migration file paired with passed up migration file
+// for example: 201602160002.sql -> 201602160002.down.sql
+func DownMigrationFile(file string) string {
+	ext := path.Ext(file)
+	return strings.TrimSuffix(file, ext) + downMigrationSuffix + ext
+}
+
+// UpMigrationFile returns the name of the up migration file paired with passed down migration file
+// for example: 201602160002.down.sql -> 201602160002.sql
+// the bool return value is false when passed file is not a down migration
+func UpMigrationFile(file string) (string, bool) {
+	ext := path.Ext(file)
+	name := strings.TrimSuffix(file, ext)
+	if !strings.HasSuffix(name, downMigrationSuffix) {
+		return file, false
+	}
+	return strings.TrimSuffix(name, downMigrationSuffix) + ext, true
 }
 
 // DBMigration embeds Migration and adds DB-specific fields
@@ -107,12 +201,154 @@ type Summary struct {
 	TenantScripts         int32        `json:"tenantScripts"`
 	TenantScriptsTotal    int32        `json:"tenantScriptsTotal"` // tenant scripts for all tenants
 	ScriptsGrandTotal     int32        `json:"scriptsGrandTotal"`  // total number of all scripts applied
+	// set only when transaction scope is tenant or migration, each tenant is migrated in its own transactions
+	TenantResults []TenantResult `json:"tenantResults,omitempty"`
+}
+
+// PlannedStatement contains SQL of a pending migration rendered for a given schema
+type PlannedStatement struct {
+	Schema string `json:"schema"`
+	SQL    string `json:"sql"`
+}
+
+// PlannedMigration embeds pending Migration and adds schemas it would be applied to and its SQL rendered for each of them
+type PlannedMigration struct {
+	Migration
+	Schemas    []string           `json:"schemas"`
+	Statements []PlannedStatement `json:"statements"`
+}
+
+// Plan contains migrations which would be applied when creating new version, counters are the same as in Summary
+type Plan struct {
+	Tenants               int32              `json:"tenants"`
+	SingleMigrations      int32              `json:"singleMigrations"`
+	TenantMigrations      int32              `json:"tenantMigrations"`
+	TenantMigrationsTotal int32              `json:"tenantMigrationsTotal"`
+	MigrationsGrandTotal  int32              `json:"migrationsGrandTotal"`
+	SingleScripts         int32              `json:"singleScripts"`
+	TenantScripts         int32              `json:"tenantScripts"`
+	TenantScriptsTotal    int32              `json:"tenantScriptsTotal"`
+	ScriptsGrandTotal     int32              `json:"scriptsGrandTotal"`
+	Migrations            []PlannedMigration `json:"migrations"`
+}
+
+// TenantStatus contains tenant migrations applied to a tenant and tenant migrations pending for it
+// Lag is the number of pending tenant migrations, tenants with non-zero lag are brought up to date by CreateVersion
+type TenantStatus struct {
+	Name              string         `json:"name"`
+	Metadata          TenantMetadata `json:"metadata"`
+	AppliedMigrations int32          `json:"appliedMigrations"`
+	PendingMigrations []Migration    `json:"pendingMigrations"`
+	Lag               int32          `json:"lag"`
+}
+
+// TenantOutcome stores information about outcome of migrating a tenant
+type TenantOutcome uint32
+
+const (
+	// TenantOutcomeSucceeded is used when all tenant migrations were applied
+	TenantOutcomeSucceeded TenantOutcome = iota + 1
+	// TenantOutcomeFailed is used when tenant migrations failed and the failed transaction was rolled back
+	TenantOutcomeFailed
+	// TenantOutcomeSkipped is used when tenant was not migrated because migrating another tenant failed
+	TenantOutcomeSkipped
+)
+
+// ImplementsGraphQLType maps TenantOutcome Go type
+// to the graphql scalar type in the schema
+func (TenantOutcome) ImplementsGraphQLType(name string) bool {
+	return name == "TenantOutcome"
+}
+
+// String converts TenantOutcome Go type to string literal
+func (o TenantOutcome) String() string {
+	switch o {
+	case TenantOutcomeSucceeded:
+		return "Succeeded"
+	case TenantOutcomeFailed:
+		return "Failed"
+	case TenantOutcomeSkipped:
+		return "Skipped"
+	default:
+		panic(fmt.Sprintf("Unknown TenantOutcome value: %v", uint32(o)))
+	}
+}
+
+// MarshalJSON converts TenantOutcome Go type to JSON string literal
+func (o TenantOutcome) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf("%q", o.String())), nil
+}
+
+// TenantResult contains outcome of migrating a single tenant
+type TenantResult struct {
+	Name    string        `json:"name"`
+	Outcome TenantOutcome `json:"outcome"`
+	Error   *string       `json:"error,omitempty"`
 }
 
 // CreateResults contains results of CreateVersion or CreateTenant
+// when operation is run asynchronously only Job is set
 type CreateResults struct {
-	Summary *Summary
-	Version *Version
+	Summary *Summary `json:"summary"`
+	Version *Version `json:"version"`
+	Job     *Job     `json:"job,omitempty"`
+}
+
+// JobState stores information about state of asynchronous job
+type JobState uint32
+
+const (
+	// JobStateQueued is used when job is waiting for other jobs to finish
+	JobStateQueued JobState = iota + 1
+	// JobStateRunning is used when job is running
+	JobStateRunning
+	// JobStateSucceeded is used when job finished successfully
+	JobStateSucceeded
+	// JobStateFailed is used when job finished with an error
+	JobStateFailed
+)
+
+// ImplementsGraphQLType maps JobState Go type
+// to the graphql scalar type in the schema
+func (JobState) ImplementsGraphQLType(name string) bool {
+	return name == "JobState"
+}
+
+// String converts JobState Go type to string literal
+func (s JobState) String() string {
+	switch s {
+	case JobStateQueued:
+		return "Queued"
+	case JobStateRunning:
+		return "Running"
+	case JobStateSucceeded:
+		return "Succeeded"
+	case JobStateFailed:
+		return "Failed"
+	default:
+		panic(fmt.Sprintf("Unknown JobState value: %v", uint32(s)))
+	}
+}
+
+// MarshalJSON converts JobState Go type to JSON string literal
+func (s JobState) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf("%q", s.String())), nil
+}
+
+// Job contains information about asynchronous createVersion or createTenant operation
+// MigrationsApplied and MigrationsTotal count migrations and scripts applied to all schemas, they are set once job is running
+type Job struct {
+	ID                graphql.ID     `json:"id"`
+	Operation         string         `json:"operation"`
+	State             JobState       `json:"state"`
+	Created           graphql.Time   `json:"created"`
+	Started           *graphql.Time  `json:"started,omitempty"`
+	Finished          *graphql.Time  `json:"finished,omitempty"`
+	MigrationsApplied int32          `json:"migrationsApplied"`
+	MigrationsTotal   int32          `json:"migrationsTotal"`
+	Results           *CreateResults `json:"results,omitempty"`
+	Error             *string        `json:"error,omitempty"`
+	ErrorCode         *string        `json:"errorCode,omitempty"`
 }
 
 // Action stores information about migrator action
@@ -164,6 +400,21 @@ type VersionInput struct {
 	VersionName string
 	Action      Action
 	DryRun      bool
+	Tenants     *TenantSelector
+	Async       bool
+}
+
+// TenantSelector selects tenants to which tenant migrations and scripts are applied when creating new version
+// all set criteria must match, nil selector selects all tenants
+type TenantSelector struct {
+	// Names are names of selected tenants
+	Names *[]string
+	// Glob is a pattern matched against tenant name, see path.Match
+	Glob *string
+	// Regex is a regular expression matched against tenant name
+	Regex *string
+	// Metadata must be contained in tenant metadata
+	Metadata *TenantMetadata
 }
 
 // TenantInput is used by GraphQL to create a new tenant in DB
@@ -172,6 +423,15 @@ type TenantInput struct {
 	Action      Action
 	DryRun      bool
 	TenantName  string
+	Metadata    *TenantMetadata
+	Async       bool
+}
+
+// RollbackVersionInput is used by GraphQL to rollback a version in DB
+type RollbackVersionInput struct {
+	ID          int32
+	VersionName string
+	DryRun      bool
 }
 
 // APIVersion represents migrator API versions